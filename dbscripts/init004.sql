@@ -0,0 +1,15 @@
+create table refresh_tokens
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    family_id varchar(64) NOT NULL,
+    token_hash varchar(64) unique NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz
+);
+
+alter table refresh_tokens
+add constraint refreshtokenuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
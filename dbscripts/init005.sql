@@ -0,0 +1,17 @@
+create table revoked_tokens
+(
+    id bigint primary key generated always as identity,
+    revoked_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    token_id varchar(64),
+    expires_at timestamptz NOT NULL
+);
+
+alter table revoked_tokens
+add constraint revokedtokenuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index revoked_tokens_token_id_idx on revoked_tokens (token_id);
+
+create index revoked_tokens_user_id_idx on revoked_tokens (user_id);
+
+create index revoked_tokens_expires_at_idx on revoked_tokens (expires_at);
@@ -0,0 +1,5 @@
+alter table users
+add column role varchar(16) default 'editor' NOT NULL;
+
+alter table users
+add constraint userrolecheck check (role in ('reader', 'editor', 'admin'));
@@ -0,0 +1,8 @@
+alter table books
+drop constraint bookcreatedbyuserfk;
+
+alter table books
+add constraint bookcreatedbyuserfk foreign key (created_by) references users(id) on delete cascade;
+
+alter table revoked_tokens
+drop constraint revokedtokenuserfk;
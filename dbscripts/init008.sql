@@ -0,0 +1,16 @@
+create table user_tokens
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    purpose varchar(32) NOT NULL,
+    token_hash varchar(64) unique NOT NULL,
+    payload varchar(255) default '' NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz
+);
+
+alter table user_tokens
+add constraint usertokenuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index user_tokens_user_id_purpose_idx on user_tokens (user_id, purpose);
@@ -0,0 +1,5 @@
+alter table users
+add column verified_at timestamptz,
+add column verification_sent_at timestamptz;
+
+update users set verified_at = created_at;
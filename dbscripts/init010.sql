@@ -0,0 +1,18 @@
+alter table users
+add column totp_secret varchar(64) default '' NOT NULL,
+add column totp_enabled_at timestamptz,
+add column totp_last_used_step bigint default 0 NOT NULL;
+
+create table recovery_codes
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    code_hash varchar(64) NOT NULL,
+    used_at timestamptz
+);
+
+alter table recovery_codes
+add constraint recoverycodeuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index recovery_codes_user_id_idx on recovery_codes (user_id);
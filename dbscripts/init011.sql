@@ -0,0 +1,8 @@
+create table login_attempts
+(
+    key varchar(320) primary key,
+    failures integer NOT NULL,
+    last_failure_at timestamptz NOT NULL
+);
+
+create index login_attempts_last_failure_at_idx on login_attempts (last_failure_at);
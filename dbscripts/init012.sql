@@ -0,0 +1,18 @@
+create table api_keys
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash varchar(64) NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+
+alter table api_keys
+add constraint apikeyuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index api_keys_user_id_idx on api_keys (user_id);
@@ -0,0 +1,17 @@
+alter table users
+add column oidc_issuer varchar(256) default '' NOT NULL,
+add column oidc_subject varchar(256) default '' NOT NULL;
+
+create unique index users_oidc_identity_idx on users (oidc_issuer, oidc_subject) where oidc_subject <> '';
+
+create table oidc_login_requests
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    state_hash varchar(64) NOT NULL UNIQUE,
+    nonce varchar(64) NOT NULL,
+    code_verifier varchar(128) NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+
+create index oidc_login_requests_expires_at_idx on oidc_login_requests (expires_at);
@@ -0,0 +1,17 @@
+create table sessions
+(
+    id bigint primary key generated always as identity,
+    created_at timestamptz default NOW() NOT NULL,
+    user_id bigint NOT NULL,
+    family_id varchar(64) NOT NULL UNIQUE,
+    ip_address varchar(64) NOT NULL,
+    user_agent varchar(512) NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    terminated_at timestamptz
+);
+
+alter table sessions
+add constraint sessionuserfk foreign key (user_id) references users(id) on delete cascade;
+
+create index sessions_user_id_idx on sessions (user_id);
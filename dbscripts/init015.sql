@@ -0,0 +1,2 @@
+alter table users
+add column disabled_at timestamptz;
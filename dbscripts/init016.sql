@@ -0,0 +1 @@
+create index books_created_at_id_idx on books (created_at, id);
@@ -0,0 +1,5 @@
+create index books_author_id_idx on books (author, id);
+
+create index books_title_id_idx on books (title, id);
+
+create index books_created_by_id_idx on books (created_by, id);
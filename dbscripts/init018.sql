@@ -0,0 +1,6 @@
+alter table books
+add column search_vector tsvector generated always as (
+    setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', author), 'B')
+) stored;
+
+create index books_search_vector_idx on books using gin (search_vector);
@@ -0,0 +1,5 @@
+create extension if not exists pg_trgm;
+
+create index books_author_trgm_idx on books using gin (author gin_trgm_ops);
+
+create index books_title_trgm_idx on books using gin (title gin_trgm_ops);
@@ -0,0 +1,7 @@
+alter table books
+add column isbn varchar(13) default '' NOT NULL,
+add column publisher varchar(100) default '' NOT NULL,
+add column publication_year smallint default 0 NOT NULL,
+add column language varchar(35) default '' NOT NULL,
+add column page_count integer default 0 NOT NULL,
+add column description varchar(5000) default '' NOT NULL;
@@ -0,0 +1,2 @@
+alter table users
+add column password_reset_sent_at timestamptz;
@@ -0,0 +1,12 @@
+alter table books
+alter column created_by drop not null;
+
+alter table books
+drop constraint bookcreatedbyuserfk;
+
+alter table books
+add constraint bookcreatedbyuserfk foreign key (created_by) references users(id) on delete set null;
+
+drop index books_created_by_id_idx;
+
+create index books_created_by_id_idx on books (coalesce(created_by, 0), id);
@@ -0,0 +1,3 @@
+alter table login_attempts
+add column pending integer NOT NULL default 0,
+add column last_reserved_at timestamptz NOT NULL default to_timestamp(0);
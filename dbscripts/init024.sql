@@ -0,0 +1,50 @@
+-- book_search_config returns the text search configuration of a book by the primary subtag of its language.
+-- Books without a language are searched in English, and books in a language without a configuration
+-- in the simple one, which does not reduce words to their stems.
+create function book_search_config(language varchar) returns regconfig
+language sql immutable parallel safe
+as $$
+    select case lower(split_part(language, '-', 1))
+        when '' then 'english'::regconfig
+        when 'ar' then 'arabic'::regconfig
+        when 'ca' then 'catalan'::regconfig
+        when 'da' then 'danish'::regconfig
+        when 'de' then 'german'::regconfig
+        when 'el' then 'greek'::regconfig
+        when 'en' then 'english'::regconfig
+        when 'es' then 'spanish'::regconfig
+        when 'eu' then 'basque'::regconfig
+        when 'fi' then 'finnish'::regconfig
+        when 'fr' then 'french'::regconfig
+        when 'ga' then 'irish'::regconfig
+        when 'hi' then 'hindi'::regconfig
+        when 'hu' then 'hungarian'::regconfig
+        when 'hy' then 'armenian'::regconfig
+        when 'id' then 'indonesian'::regconfig
+        when 'it' then 'italian'::regconfig
+        when 'lt' then 'lithuanian'::regconfig
+        when 'nb' then 'norwegian'::regconfig
+        when 'ne' then 'nepali'::regconfig
+        when 'nl' then 'dutch'::regconfig
+        when 'nn' then 'norwegian'::regconfig
+        when 'no' then 'norwegian'::regconfig
+        when 'pt' then 'portuguese'::regconfig
+        when 'ro' then 'romanian'::regconfig
+        when 'ru' then 'russian'::regconfig
+        when 'sr' then 'serbian'::regconfig
+        when 'sv' then 'swedish'::regconfig
+        when 'ta' then 'tamil'::regconfig
+        when 'tr' then 'turkish'::regconfig
+        when 'yi' then 'yiddish'::regconfig
+        else 'simple'::regconfig
+    end
+$$;
+
+alter table books drop column search_vector;
+
+alter table books
+add column search_vector tsvector generated always as (
+    setweight(to_tsvector(book_search_config(language), title), 'A') || setweight(to_tsvector(book_search_config(language), author), 'B')
+) stored;
+
+create index books_search_vector_idx on books using gin (search_vector);
@@ -4,13 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/internal/services"
 	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
 	"github.com/gorilla/mux"
@@ -21,6 +27,14 @@ type contextKey string
 const (
 	// contextKeyUserID is a context key for user id.
 	contextKeyUserID = contextKey("user_id")
+	// contextKeyToken is a context key for the token the request has been authenticated with.
+	contextKeyToken = contextKey("token")
+	// contextKeyRole is a context key for the role of the user.
+	contextKeyRole = contextKey("role")
+	// contextKeySessionID is a context key for the ID of the session the token the request has been authenticated with has been issued in.
+	contextKeySessionID = contextKey("session_id")
+	// contextKeyScopes is a context key for the scopes of the API key the request has been authenticated with.
+	contextKeyScopes = contextKey("scopes")
 
 	// DefaultAddress is the default server address.
 	DefaultAddress = "127.0.0.1:8080"
@@ -29,22 +43,73 @@ const (
 	// DefaultReadTimeout is the default read timeout for incoming requests.
 	DefaultReadTimeout = 15 * time.Second
 
+	// oidcStateCookieName is the name of the cookie binding an OpenID Connect login to the user agent that has started it.
+	oidcStateCookieName = "oidc_state"
+	// oidcStateCookiePath is the path the OpenID Connect state cookie is sent to.
+	oidcStateCookiePath = "/auth/oidc"
+
 	// ErrMsgBadRequestInvalidRequestBody is a message for bad request with invalid request body.
 	ErrMsgBadRequestInvalidRequestBody = "invalid request body"
 	// ErrMsgBadRequestUserAlreadyExists is a message for bad request with user already exists.
 	ErrMsgBadRequestUserAlreadyExists = "user already exists"
 	// ErrMsgBadRequestInvalidBookID is a message for bad request with invalid book id.
 	ErrMsgBadRequestInvalidBookID = "invalid book id"
+	// ErrMsgBadRequestInvalidUserID is a message for bad request with invalid user id.
+	ErrMsgBadRequestInvalidUserID = "invalid user id"
+	// ErrMsgBadRequestInvalidAPIKeyID is a message for bad request with invalid api key id.
+	ErrMsgBadRequestInvalidAPIKeyID = "invalid api key id"
+	// ErrMsgBadRequestInvalidSessionID is a message for bad request with invalid session id.
+	ErrMsgBadRequestInvalidSessionID = "invalid session id"
+	// ErrMsgBadRequestInvalidOIDCState is a message for bad request with invalid oidc login state.
+	ErrMsgBadRequestInvalidOIDCState = "invalid oidc state"
+	// ErrMsgBadRequestInvalidQuery is a message for bad request with invalid query parameters.
+	ErrMsgBadRequestInvalidQuery = "invalid query parameters"
 	// ErrMsgUnauthorized is a message for unauthorized.
 	ErrMsgUnauthorized = "unauthorized"
 	// ErrMsgUnauthorizedExpiredToken is a message for unauthorized with expired token.
 	ErrMsgUnauthorizedExpiredToken = "expired token"
 	// ErrMsgUnauthorizedInvalidToken is a message for unauthorized with invalid token.
 	ErrMsgUnauthorizedInvalidToken = "unauthorized"
+	// ErrMsgUnauthorizedRevokedToken is a message for unauthorized with revoked token.
+	ErrMsgUnauthorizedRevokedToken = "revoked token"
+	// ErrMsgUnauthorizedTerminatedSession is a message for unauthorized with token of terminated session.
+	ErrMsgUnauthorizedTerminatedSession = "terminated session"
 	// ErrMsgUnauthorizedInvalidCredentials is a message for unauthorized with invalid credentials.
 	ErrMsgUnauthorizedInvalidCredentials = "invalid credentials"
+	// ErrMsgUnauthorizedInvalidRefreshToken is a message for unauthorized with invalid refresh token.
+	ErrMsgUnauthorizedInvalidRefreshToken = "invalid refresh token"
+	// ErrMsgUnauthorizedExpiredRefreshToken is a message for unauthorized with expired refresh token.
+	ErrMsgUnauthorizedExpiredRefreshToken = "expired refresh token"
+	// ErrMsgUnauthorizedInvalidChallengeToken is a message for unauthorized with invalid two-factor authentication challenge token.
+	ErrMsgUnauthorizedInvalidChallengeToken = "invalid challenge token"
+	// ErrMsgUnauthorizedInvalidTwoFactorCode is a message for unauthorized with invalid two-factor authentication code.
+	ErrMsgUnauthorizedInvalidTwoFactorCode = "invalid two-factor authentication code"
+	// ErrMsgUnauthorizedInvalidAPIKey is a message for unauthorized with invalid api key.
+	ErrMsgUnauthorizedInvalidAPIKey = "invalid api key"
+	// ErrMsgUnauthorizedExpiredAPIKey is a message for unauthorized with expired api key.
+	ErrMsgUnauthorizedExpiredAPIKey = "expired api key"
+	// ErrMsgUnauthorizedOIDCLoginFailed is a message for unauthorized with failed oidc login.
+	ErrMsgUnauthorizedOIDCLoginFailed = "oidc login failed"
+	// ErrMsgForbidden is a message for forbidden.
+	ErrMsgForbidden = "forbidden"
+	// ErrMsgForbiddenEmailNotVerified is a message for forbidden with email address not verified.
+	ErrMsgForbiddenEmailNotVerified = "email address not verified"
+	// ErrMsgForbiddenOIDCEmailNotVerified is a message for forbidden with email address not verified by the oidc provider.
+	ErrMsgForbiddenOIDCEmailNotVerified = "email address not verified by oidc provider"
+	// ErrMsgForbiddenAccountDisabled is a message for forbidden with account disabled.
+	ErrMsgForbiddenAccountDisabled = "account disabled"
 	// ErrMsgNotFound is a message for not found.
 	ErrMsgNotFound = "not found"
+	// ErrMsgConflictOIDCAccountLinked is a message for conflict with account linked to another oidc identity.
+	ErrMsgConflictOIDCAccountLinked = "account linked to another oidc identity"
+	// ErrMsgConflictOwnAccount is a message for conflict with an administrator managing their own account.
+	ErrMsgConflictOwnAccount = "cannot disable, demote or delete own account"
+	// ErrMsgLocked is a message for account locked.
+	ErrMsgLocked = "account locked"
+	// ErrMsgTooManyRequests is a message for too many requests.
+	ErrMsgTooManyRequests = "too many requests"
+	// ErrMsgServiceUnavailable is a message for service unavailable error.
+	ErrMsgServiceUnavailable = "service unavailable"
 	// ErrMsgInternalError is a message for internal error.
 	ErrMsgInternalError = "internal server error"
 )
@@ -123,14 +188,64 @@ func (s *Server) initRoutes() {
 
 	r.HandleFunc("/register", makeHTTPHandlerFunc(s.handleRegister)).Methods("POST")
 	r.HandleFunc("/login", makeHTTPHandlerFunc(s.handleLogin)).Methods("POST")
+	r.HandleFunc("/login/2fa", makeHTTPHandlerFunc(s.handleLoginTwoFactor)).Methods("POST")
+	r.HandleFunc("/token/refresh", makeHTTPHandlerFunc(s.handleRefreshToken)).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", makeHTTPHandlerFunc(s.handleGetJWKS)).Methods("GET")
+	r.HandleFunc("/email/confirm", makeHTTPHandlerFunc(s.handleConfirmEmailChange)).Methods("POST")
+	r.HandleFunc("/password/forgot", makeHTTPHandlerFunc(s.handleForgotPassword)).Methods("POST")
+	r.HandleFunc("/password/reset", makeHTTPHandlerFunc(s.handleResetPassword)).Methods("POST")
+	r.HandleFunc("/verify-email", makeHTTPHandlerFunc(s.handleVerifyEmail)).Methods("GET")
+	r.HandleFunc("/verify-email/resend", makeHTTPHandlerFunc(s.handleResendVerificationEmail)).Methods("POST")
+	r.HandleFunc("/auth/oidc/login", makeHTTPHandlerFunc(s.handleOIDCLogin)).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", makeHTTPHandlerFunc(s.handleOIDCCallback)).Methods("GET")
+
+	logoutRouter := r.PathPrefix("/logout").Subrouter()
+	logoutRouter.Use(s.validateJWT)
+	logoutRouter.HandleFunc("", makeHTTPHandlerFunc(s.handleLogout)).Methods("POST")
+	logoutRouter.HandleFunc("/all", makeHTTPHandlerFunc(s.handleLogoutAll)).Methods("POST")
+
+	userRouter := r.PathPrefix("/users").Subrouter()
+
+	// The account of the current user, including its API keys, cannot be managed with an API key.
+	currentUserRouter := userRouter.PathPrefix("/me").Subrouter()
+	currentUserRouter.Use(s.validateJWT)
+	currentUserRouter.HandleFunc("", makeHTTPHandlerFunc(s.handleGetCurrentUser)).Methods("GET")
+	currentUserRouter.HandleFunc("", makeHTTPHandlerFunc(s.handlePatchCurrentUser)).Methods("PATCH")
+	currentUserRouter.HandleFunc("", makeHTTPHandlerFunc(s.handleDeleteCurrentUser)).Methods("DELETE")
+	currentUserRouter.HandleFunc("/password", makeHTTPHandlerFunc(s.handleChangePassword)).Methods("POST")
+	currentUserRouter.HandleFunc("/email", makeHTTPHandlerFunc(s.handleRequestEmailChange)).Methods("POST")
+	currentUserRouter.HandleFunc("/2fa", makeHTTPHandlerFunc(s.handleEnrollTwoFactor)).Methods("POST")
+	currentUserRouter.HandleFunc("/2fa/confirm", makeHTTPHandlerFunc(s.handleConfirmTwoFactor)).Methods("POST")
+	currentUserRouter.HandleFunc("/2fa/disable", makeHTTPHandlerFunc(s.handleDisableTwoFactor)).Methods("POST")
+	currentUserRouter.HandleFunc("/api-keys", makeHTTPHandlerFunc(s.handleGetAPIKeys)).Methods("GET")
+	currentUserRouter.HandleFunc("/api-keys", makeHTTPHandlerFunc(s.handleCreateAPIKey)).Methods("POST")
+	currentUserRouter.HandleFunc("/api-keys/{id}", makeHTTPHandlerFunc(s.handleRevokeAPIKey)).Methods("DELETE")
+	currentUserRouter.HandleFunc("/sessions", makeHTTPHandlerFunc(s.handleGetSessions)).Methods("GET")
+	currentUserRouter.HandleFunc("/sessions/{id}", makeHTTPHandlerFunc(s.handleTerminateSession)).Methods("DELETE")
+
+	manageUserRouter := userRouter.NewRoute().Subrouter()
+	manageUserRouter.Use(s.authenticate)
+	manageUserRouter.Handle("", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleGetUsers))).Methods("GET")
+	manageUserRouter.Handle("/{id}", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleGetUserByID))).Methods("GET")
+	manageUserRouter.Handle("/{id}", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleDeleteUserByID))).Methods("DELETE")
+	manageUserRouter.Handle("/{id}/disable", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleDisableUser))).Methods("POST")
+	manageUserRouter.Handle("/{id}/enable", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleEnableUser))).Methods("POST")
+	manageUserRouter.Handle("/{id}/password-reset", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleForcePasswordReset))).Methods("POST")
+	manageUserRouter.Handle("/{id}/role", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleChangeUserRole))).Methods("PUT")
+	manageUserRouter.Handle("/{id}/unlock", s.requirePermission(models.PermissionManageUsers, makeHTTPHandlerFunc(s.handleUnlockUser))).Methods("POST")
 
 	bookRouter := r.PathPrefix("/books").Subrouter()
-	bookRouter.Use(s.validateJWT)
-	bookRouter.HandleFunc("", makeHTTPHandlerFunc(s.handleGetBooks)).Methods("GET")
-	bookRouter.HandleFunc("", makeHTTPHandlerFunc(s.handlePostBook)).Methods("POST")
-	bookRouter.HandleFunc("/{id}", makeHTTPHandlerFunc(s.handleGetBookByID)).Methods("GET")
-	bookRouter.HandleFunc("/{id}", makeHTTPHandlerFunc(s.handlePutBookByID)).Methods("PUT")
-	bookRouter.HandleFunc("/{id}", makeHTTPHandlerFunc(s.handleDeleteBookByID)).Methods("DELETE")
+	bookRouter.Use(s.authenticate)
+	bookRouter.Handle("", s.requirePermission(models.PermissionReadBooks, makeHTTPHandlerFunc(s.handleGetBooks))).Methods("GET")
+	bookRouter.Handle("", s.requirePermission(models.PermissionWriteBooks, makeHTTPHandlerFunc(s.handlePostBook))).Methods("POST")
+	bookRouter.Handle("/search", s.requirePermission(models.PermissionReadBooks, makeHTTPHandlerFunc(s.handleSearchBooks))).Methods("GET")
+	bookRouter.Handle("/{id}", s.requirePermission(models.PermissionReadBooks, makeHTTPHandlerFunc(s.handleGetBookByID))).Methods("GET")
+	bookRouter.Handle("/{id}", s.requirePermission(models.PermissionWriteBooks, makeHTTPHandlerFunc(s.handlePutBookByID))).Methods("PUT")
+	bookRouter.Handle("/{id}", s.requirePermission(models.PermissionWriteBooks, makeHTTPHandlerFunc(s.handleDeleteBookByID))).Methods("DELETE")
+
+	metricsRouter := r.PathPrefix("/debug").Subrouter()
+	metricsRouter.Use(s.authenticate)
+	metricsRouter.Handle("/vars", s.requirePermission(models.PermissionReadMetrics, expvar.Handler())).Methods("GET")
 
 	s.Handler = r
 }
@@ -144,7 +259,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	userDTO, err := s.userService.RegisterUser(accountCreateDTO)
+	userDTO, err := s.userService.RegisterUser(r.Context(), accountCreateDTO)
 	if err != nil {
 		if errors.Is(err, services.ErrInvalidEmail) {
 			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
@@ -171,6 +286,12 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
+		}
+
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
 		return fmt.Errorf("register user: %w", err)
 	}
@@ -188,8 +309,10 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
 		return nil
 	}
+	userLoginDTO.ClientIP = s.clientIP(r)
+	userLoginDTO.UserAgent = r.UserAgent()
 
-	tokenDTO, err := s.userService.LoginUser(userLoginDTO)
+	tokenDTO, err := s.userService.LoginUser(r.Context(), userLoginDTO)
 	if err != nil {
 		if errors.Is(err, services.ErrInvalidEmail) {
 			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
@@ -203,6 +326,30 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidCredentials)
 			return nil
 		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenEmailNotVerified)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenAccountDisabled)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountLocked) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusLocked, ErrMsgLocked)
+			return nil
+		}
+		if errors.Is(err, services.ErrTooManyLoginAttempts) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusTooManyRequests, ErrMsgTooManyRequests)
+			return nil
+		}
+
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
+		}
 
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
 		return fmt.Errorf("login user: %w", err)
@@ -213,229 +360,1646 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func (s *Server) handleGetBooks(w http.ResponseWriter, r *http.Request) error {
-	logger.Infof("Received GET /books from %s", r.RemoteAddr)
+func (s *Server) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /login/2fa from %s", r.RemoteAddr)
+
+	twoFactorLoginDTO := &dtos.TwoFactorLoginDTO{}
+	if err := json.NewDecoder(r.Body).Decode(twoFactorLoginDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+	twoFactorLoginDTO.ClientIP = s.clientIP(r)
+	twoFactorLoginDTO.UserAgent = r.UserAgent()
+
+	tokenDTO, err := s.userService.LoginUserTwoFactor(twoFactorLoginDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidChallengeToken) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidChallengeToken)
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidTwoFactorCode)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenAccountDisabled)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountLocked) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusLocked, ErrMsgLocked)
+			return nil
+		}
+		if errors.Is(err, services.ErrTooManyLoginAttempts) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusTooManyRequests, ErrMsgTooManyRequests)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("login user with two-factor authentication: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, tokenDTO)
+
+	return nil
+}
+
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /auth/oidc/login from %s", r.RemoteAddr)
 
-	booksDTO, err := s.bookService.GetBooks()
+	oidcLoginDTO, err := s.userService.StartOIDCLogin()
 	if err != nil {
+		if errors.Is(err, services.ErrOIDCNotConfigured) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return fmt.Errorf("get books: %w", err)
+		return fmt.Errorf("start oidc login: %w", err)
 	}
 
-	s.respondWithJSON(w, http.StatusOK, booksDTO)
+	// The state is bound to the user agent, so a login started by someone else cannot be completed in it.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    oidcLoginDTO.State,
+		Path:     oidcStateCookiePath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, oidcLoginDTO.URL, http.StatusFound)
 
 	return nil
 }
 
-func (s *Server) handlePostBook(w http.ResponseWriter, r *http.Request) error {
-	logger.Infof("Received POST /books from %s", r.RemoteAddr)
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /auth/oidc/callback from %s", r.RemoteAddr)
+
+	// The state is single use, so the cookie is not needed anymore whether the login succeeds or not.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Path:     oidcStateCookiePath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
 
-	bookCreateDTO := &dtos.BookCreateDTO{}
-	if err := json.NewDecoder(r.Body).Decode(bookCreateDTO); err != nil {
-		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+	query := r.URL.Query()
+
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || query.Get("state") == "" || cookie.Value != query.Get("state") {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidOIDCState)
 		return nil
 	}
 
-	userID := r.Context().Value(contextKeyUserID).(int)
-	if userID == 0 {
-		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return ErrUserIDNotSetInContext
+	if providerErr := query.Get("error"); providerErr != "" {
+		logger.Errorf("OIDC provider returned error (%s) to %s", providerErr, r.RemoteAddr)
+
+		s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedOIDCLoginFailed)
+		return nil
 	}
 
-	bookDTO, err := s.bookService.AddBook(userID, bookCreateDTO)
+	tokenDTO, err := s.userService.LoginUserOIDC(r.Context(), &dtos.OIDCCallbackDTO{
+		Code:      query.Get("code"),
+		State:     query.Get("state"),
+		ClientIP:  s.clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
 	if err != nil {
-		if errors.Is(err, services.ErrInvalidAuthor) {
-			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		if errors.Is(err, services.ErrOIDCNotConfigured) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidOIDCState) {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidOIDCState)
+			return nil
+		}
+		if errors.Is(err, services.ErrOIDCLoginFailed) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedOIDCLoginFailed)
+			return nil
+		}
+		if errors.Is(err, services.ErrOIDCEmailNotVerified) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenOIDCEmailNotVerified)
+			return nil
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenEmailNotVerified)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenAccountDisabled)
 			return nil
 		}
-		if errors.Is(err, services.ErrInvalidTitle) {
-			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		if errors.Is(err, services.ErrOIDCAccountLinked) {
+			s.respondWithError(w, http.StatusConflict, ErrMsgConflictOIDCAccountLinked)
 			return nil
 		}
 
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return fmt.Errorf("add book: %w", err)
+		return fmt.Errorf("login user with oidc: %w", err)
 	}
 
-	s.respondWithJSON(w, http.StatusOK, bookDTO)
+	s.respondWithJSON(w, http.StatusOK, tokenDTO)
 
 	return nil
 }
 
-func (s *Server) handleGetBookByID(w http.ResponseWriter, r *http.Request) error {
-	logger.Infof("Received GET /books/{id} from %s", r.RemoteAddr)
-
-	idString := mux.Vars(r)["id"]
-	defer r.Body.Close()
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /token/refresh from %s", r.RemoteAddr)
 
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+	refreshTokenDTO := &dtos.RefreshTokenDTO{}
+	if err := json.NewDecoder(r.Body).Decode(refreshTokenDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
 		return nil
 	}
+	refreshTokenDTO.ClientIP = s.clientIP(r)
+	refreshTokenDTO.UserAgent = r.UserAgent()
 
-	bookDTO, err := s.bookService.GetBook(id)
+	tokenDTO, err := s.userService.RefreshToken(refreshTokenDTO)
 	if err != nil {
-		if errors.Is(err, services.ErrInvalidID) {
-			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidRefreshToken)
 			return nil
 		}
-		if errors.Is(err, services.ErrBookNotFound) {
-			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+		if errors.Is(err, services.ErrExpiredRefreshToken) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedExpiredRefreshToken)
+			return nil
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenAccountDisabled)
 			return nil
 		}
 
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return fmt.Errorf("get book: %w", err)
+		return fmt.Errorf("refresh token: %w", err)
 	}
 
-	s.respondWithJSON(w, http.StatusOK, bookDTO)
+	s.respondWithJSON(w, http.StatusOK, tokenDTO)
 
 	return nil
 }
 
-func (s *Server) handlePutBookByID(w http.ResponseWriter, r *http.Request) error {
-	logger.Infof("Received PUT /books/{id} from %s", r.RemoteAddr)
+func (s *Server) handleGetJWKS(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /.well-known/jwks.json from %s", r.RemoteAddr)
 
-	idString := mux.Vars(r)["id"]
-	defer r.Body.Close()
+	s.respondWithJSON(w, http.StatusOK, s.tokenService.GetJWKS())
 
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+	return nil
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /logout from %s", r.RemoteAddr)
+
+	refreshTokenDTO := &dtos.RefreshTokenDTO{}
+	if err := json.NewDecoder(r.Body).Decode(refreshTokenDTO); err != nil && !errors.Is(err, io.EOF) {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
 		return nil
 	}
 
-	bookDTO := &dtos.BookDTO{}
-	if err := json.NewDecoder(r.Body).Decode(bookDTO); err != nil {
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	tokenString := r.Context().Value(contextKeyToken).(string)
+	sessionID := r.Context().Value(contextKeySessionID).(int)
+
+	if err := s.userService.LogoutUser(userID, tokenString, sessionID, refreshTokenDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("logout user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleLogoutAll(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /logout/all from %s", r.RemoteAddr)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.LogoutUserEverywhere(userID); err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("logout user everywhere: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleGetCurrentUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /users/me from %s", r.RemoteAddr)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	userDTO, err := s.userService.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, userDTO)
+
+	return nil
+}
+
+func (s *Server) handlePatchCurrentUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received PATCH /users/me from %s", r.RemoteAddr)
+
+	userUpdateDTO := &dtos.UserUpdateDTO{}
+	if err := json.NewDecoder(r.Body).Decode(userUpdateDTO); err != nil {
 		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
 		return nil
 	}
 
-	updatedBookDTO, err := s.bookService.UpdateBook(id, bookDTO)
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	userDTO, err := s.userService.UpdateUser(userID, userUpdateDTO)
 	if err != nil {
-		if errors.Is(err, services.ErrInvalidID) {
+		if errors.Is(err, services.ErrInvalidFirstName) {
 			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
 			return nil
 		}
-		if errors.Is(err, services.ErrInvalidAuthor) {
+		if errors.Is(err, services.ErrInvalidLastName) {
 			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
 			return nil
 		}
-		if errors.Is(err, services.ErrInvalidTitle) {
+		if errors.Is(err, services.ErrInvalidAge) {
 			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
 			return nil
 		}
-		if errors.Is(err, services.ErrBookNotFound) {
+		if errors.Is(err, services.ErrUserNotFound) {
 			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
 			return nil
 		}
 
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return fmt.Errorf("update book: %w", err)
+		return fmt.Errorf("update user: %w", err)
 	}
 
-	s.respondWithJSON(w, http.StatusOK, updatedBookDTO)
+	s.respondWithJSON(w, http.StatusOK, userDTO)
 
 	return nil
 }
 
-func (s *Server) handleDeleteBookByID(w http.ResponseWriter, r *http.Request) error {
-	logger.Infof("Received DELETE /books{id} from %s", r.RemoteAddr)
-
-	idString := mux.Vars(r)["id"]
-	defer r.Body.Close()
+func (s *Server) handleDeleteCurrentUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received DELETE /users/me from %s", r.RemoteAddr)
 
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
-		return nil
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
 	}
 
-	if err := s.bookService.DeleteBook(id); err != nil {
-		if errors.Is(err, services.ErrInvalidID) {
-			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
-			return nil
-		}
-		if errors.Is(err, services.ErrBookNotFound) {
+	if err := s.userService.DeleteUser(userID); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
 			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
 			return nil
 		}
 
 		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-		return fmt.Errorf("delete book: %w", err)
+		return fmt.Errorf("delete user: %w", err)
 	}
 
 	s.respondWithJSON(w, http.StatusOK, nil)
+
 	return nil
 }
 
-func (s *Server) validateJWT(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientIP := r.RemoteAddr
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/password from %s", r.RemoteAddr)
 
-		logger.Infof("Validating JWT for client with IP address: %s", clientIP)
+	passwordChangeDTO := &dtos.PasswordChangeDTO{}
+	if err := json.NewDecoder(r.Body).Decode(passwordChangeDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+	passwordChangeDTO.ClientIP = s.clientIP(r)
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			logger.Infof("Authorization header missing for client with IP address: %s", clientIP)
-			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorized)
-			return
-		}
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
 
-		authHeaderParts := strings.Split(authHeader, " ")
-		if len(authHeaderParts) != 2 || authHeaderParts[0] != "Bearer" {
-			logger.Infof("Invalid authorization format for client with IP address: %s", clientIP)
-			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorized)
-			return
+	if err := s.userService.ChangePassword(r.Context(), userID, passwordChangeDTO); err != nil {
+		if errors.Is(err, services.ErrEmptyPassword) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidPassword) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidCredentials)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
 		}
 
-		tokenString := authHeaderParts[1]
-		if err := s.tokenService.ValidateToken(tokenString); err != nil {
-			if errors.Is(err, services.ErrExpiredToken) {
-				logger.Infof("Expired JWT detected for client with IP address: %s", clientIP)
-				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedExpiredToken)
-				return
-			}
-			if errors.Is(err, services.ErrInvalidToken) {
-				logger.Infof("Invalid JWT detected for client with IP address: %s", clientIP)
-				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidToken)
-				return
-			}
+		if errors.Is(err, services.ErrAccountLocked) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusLocked, ErrMsgLocked)
+			return nil
+		}
+		if errors.Is(err, services.ErrTooManyLoginAttempts) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusTooManyRequests, ErrMsgTooManyRequests)
+			return nil
+		}
 
-			logger.Errorf("Error (%s) encountered during JWT validation for client with IP address: %s", err, clientIP)
-			s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
-			return
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
 		}
 
-		logger.Infof("JWT validation successful for client with IP address: %s", clientIP)
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("change password: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleRequestEmailChange(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/email from %s", r.RemoteAddr)
+
+	emailChangeDTO := &dtos.EmailChangeDTO{}
+	if err := json.NewDecoder(r.Body).Decode(emailChangeDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+	emailChangeDTO.ClientIP = s.clientIP(r)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.RequestEmailChange(r.Context(), userID, emailChangeDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrEmptyPassword) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestUserAlreadyExists)
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidCredentials)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		if errors.Is(err, services.ErrAccountLocked) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusLocked, ErrMsgLocked)
+			return nil
+		}
+		if errors.Is(err, services.ErrTooManyLoginAttempts) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusTooManyRequests, ErrMsgTooManyRequests)
+			return nil
+		}
+
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("request email change: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/2fa from %s", r.RemoteAddr)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	twoFactorEnrollmentDTO, err := s.userService.EnrollTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("enroll two-factor authentication: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, twoFactorEnrollmentDTO)
+
+	return nil
+}
+
+func (s *Server) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/2fa/confirm from %s", r.RemoteAddr)
+
+	twoFactorConfirmDTO := &dtos.TwoFactorConfirmDTO{}
+	if err := json.NewDecoder(r.Body).Decode(twoFactorConfirmDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	recoveryCodesDTO, err := s.userService.ConfirmTwoFactor(userID, twoFactorConfirmDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrTwoFactorNotEnrolled) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("confirm two-factor authentication: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, recoveryCodesDTO)
+
+	return nil
+}
+
+func (s *Server) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/2fa/disable from %s", r.RemoteAddr)
+
+	twoFactorDisableDTO := &dtos.TwoFactorDisableDTO{}
+	if err := json.NewDecoder(r.Body).Decode(twoFactorDisableDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+	twoFactorDisableDTO.ClientIP = s.clientIP(r)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.DisableTwoFactor(r.Context(), userID, twoFactorDisableDTO); err != nil {
+		if errors.Is(err, services.ErrEmptyPassword) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrTwoFactorNotEnabled) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidCredentials)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		if errors.Is(err, services.ErrAccountLocked) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusLocked, ErrMsgLocked)
+			return nil
+		}
+		if errors.Is(err, services.ErrTooManyLoginAttempts) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusTooManyRequests, ErrMsgTooManyRequests)
+			return nil
+		}
+
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("disable two-factor authentication: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleGetUsers(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /users from %s", r.RemoteAddr)
+
+	query := r.URL.Query()
+
+	limit, offset := services.DefaultUserPageLimit, 0
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidQuery)
+			return nil
+		}
+	}
+	if offsetString := query.Get("offset"); offsetString != "" {
+		var err error
+		if offset, err = strconv.Atoi(offsetString); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidQuery)
+			return nil
+		}
+	}
+
+	userPageDTO, err := s.userService.GetUsers(query.Get("q"), limit, offset)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPagination) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get users: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, userPageDTO)
+
+	return nil
+}
+
+func (s *Server) handleGetUserByID(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /users/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	userDTO, err := s.userService.GetUser(id)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, userDTO)
+
+	return nil
+}
+
+func (s *Server) handleDeleteUserByID(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received DELETE /users/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	adminID := r.Context().Value(contextKeyUserID).(int)
+	if adminID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.DeleteUserByAdmin(adminID, id); err != nil {
+		if errors.Is(err, services.ErrOwnAccount) {
+			s.respondWithError(w, http.StatusConflict, ErrMsgConflictOwnAccount)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("delete user by admin: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleDisableUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/{id}/disable from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	adminID := r.Context().Value(contextKeyUserID).(int)
+	if adminID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.DisableUser(adminID, id); err != nil {
+		if errors.Is(err, services.ErrOwnAccount) {
+			s.respondWithError(w, http.StatusConflict, ErrMsgConflictOwnAccount)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("disable user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleEnableUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/{id}/enable from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	if err := s.userService.EnableUser(id); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("enable user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleForcePasswordReset(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/{id}/password-reset from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	if err := s.userService.ForcePasswordReset(id); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("force password reset: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleChangeUserRole(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received PUT /users/{id}/role from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	userRoleUpdateDTO := &dtos.UserRoleUpdateDTO{}
+	if err := json.NewDecoder(r.Body).Decode(userRoleUpdateDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	adminID := r.Context().Value(contextKeyUserID).(int)
+	if adminID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	userDTO, err := s.userService.ChangeUserRole(adminID, id, userRoleUpdateDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRole) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrOwnAccount) {
+			s.respondWithError(w, http.StatusConflict, ErrMsgConflictOwnAccount)
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("change user role: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, userDTO)
+
+	return nil
+}
+
+func (s *Server) handleUnlockUser(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/{id}/unlock from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidUserID)
+		return nil
+	}
+
+	if err := s.userService.UnlockUser(id); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("unlock user: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /users/me/api-keys from %s", r.RemoteAddr)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	apiKeyDTOs, err := s.userService.GetAPIKeys(userID)
+	if err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get api keys: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, apiKeyDTOs)
+
+	return nil
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /users/me/api-keys from %s", r.RemoteAddr)
+
+	apiKeyCreateDTO := &dtos.APIKeyCreateDTO{}
+	if err := json.NewDecoder(r.Body).Decode(apiKeyCreateDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	apiKeyCreatedDTO, err := s.userService.CreateAPIKey(userID, apiKeyCreateDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKeyName) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidAPIKeyScopes) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidAPIKeyExpiration) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("create api key: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, apiKeyCreatedDTO)
+
+	return nil
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received DELETE /users/me/api-keys/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidAPIKeyID)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.RevokeAPIKey(userID, id); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleGetSessions(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /users/me/sessions from %s", r.RemoteAddr)
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	sessionID := r.Context().Value(contextKeySessionID).(int)
+
+	sessionDTOs, err := s.userService.GetSessions(userID, sessionID)
+	if err != nil {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get sessions: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, sessionDTOs)
+
+	return nil
+}
+
+func (s *Server) handleTerminateSession(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received DELETE /users/me/sessions/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidSessionID)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.userService.TerminateSession(userID, id); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("terminate session: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /email/confirm from %s", r.RemoteAddr)
+
+	emailChangeConfirmDTO := &dtos.EmailChangeConfirmDTO{}
+	if err := json.NewDecoder(r.Body).Decode(emailChangeConfirmDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	if err := s.userService.ConfirmEmailChange(emailChangeConfirmDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidEmailChangeToken) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestUserAlreadyExists)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("confirm email change: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /password/forgot from %s", r.RemoteAddr)
+
+	passwordForgotDTO := &dtos.PasswordForgotDTO{}
+	if err := json.NewDecoder(r.Body).Decode(passwordForgotDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	if err := s.userService.RequestPasswordReset(passwordForgotDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("request password reset: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /password/reset from %s", r.RemoteAddr)
+
+	passwordResetDTO := &dtos.PasswordResetDTO{}
+	if err := json.NewDecoder(r.Body).Decode(passwordResetDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	if err := s.userService.ResetPassword(r.Context(), passwordResetDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidPasswordResetToken) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidPassword) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+
+		if errors.Is(err, services.ErrPasswordHashingBusy) {
+			s.setRetryAfter(w, err)
+			s.respondWithError(w, http.StatusServiceUnavailable, ErrMsgServiceUnavailable)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /verify-email from %s", r.RemoteAddr)
+
+	if err := s.userService.VerifyEmail(r.URL.Query().Get("token")); err != nil {
+		if errors.Is(err, services.ErrInvalidEmailVerificationToken) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("verify email: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleResendVerificationEmail(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /verify-email/resend from %s", r.RemoteAddr)
+
+	verificationResendDTO := &dtos.VerificationResendDTO{}
+	if err := json.NewDecoder(r.Body).Decode(verificationResendDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	if err := s.userService.ResendVerificationEmail(verificationResendDTO); err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("resend verification email: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+
+	return nil
+}
+
+func (s *Server) handleGetBooks(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /books from %s", r.RemoteAddr)
+
+	bookQueryDTO, err := s.parseBookQuery(r.URL.Query())
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+		return nil
+	}
+
+	bookPageDTO, err := s.bookService.GetBooks(bookQueryDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPagination) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCursor) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidBookSort) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCreatedByFilter) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidCreatedRange) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get books: %w", err)
+	}
+
+	if bookPageDTO.NextCursor != "" {
+		bookPageDTO.Next = s.pageLink(r, bookPageDTO.NextCursor)
+	}
+	if bookPageDTO.PrevCursor != "" {
+		bookPageDTO.Prev = s.pageLink(r, bookPageDTO.PrevCursor)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, bookPageDTO)
+
+	return nil
+}
+
+func (s *Server) handleSearchBooks(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /books/search from %s", r.RemoteAddr)
+
+	query := r.URL.Query()
+	if err := s.checkQueryParameters(query, bookSearchParameters); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+		return nil
+	}
+
+	bookSearchDTO := &dtos.BookSearchDTO{Query: query.Get("q"), Limit: services.DefaultBookPageLimit}
+	for parameter, value := range map[string]*int64{
+		"limit":  &bookSearchDTO.Limit,
+		"offset": &bookSearchDTO.Offset,
+	} {
+		if query.Get(parameter) == "" {
+			continue
+		}
+
+		var err error
+		if *value, err = strconv.ParseInt(query.Get(parameter), 10, 64); err != nil {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s must be an integer", ErrMsgBadRequestInvalidQuery, parameter))
+			return nil
+		}
+	}
+
+	bookSearchPageDTO, err := s.bookService.SearchBooks(bookSearchDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPagination) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrInvalidSearchQuery) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, err))
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("search books: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, bookSearchPageDTO)
+
+	return nil
+}
+
+func (s *Server) handlePostBook(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received POST /books from %s", r.RemoteAddr)
 
-		userID, err := s.tokenService.GetUserIDFromToken(tokenString)
-		if err != nil {
-			logger.Errorf("Error (%s) encountered while retrieving user ID from JWT for client with IP address: %s", err, clientIP)
+	bookCreateDTO := &dtos.BookCreateDTO{}
+	if err := json.NewDecoder(r.Body).Decode(bookCreateDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	bookDTO, err := s.bookService.AddBook(userID, bookCreateDTO)
+	if err != nil {
+		bookValidationErr := &services.BookValidationError{}
+		if errors.As(err, &bookValidationErr) {
+			s.respondWithBookValidationError(w, bookValidationErr)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("add book: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, bookDTO)
+
+	return nil
+}
+
+func (s *Server) handleGetBookByID(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received GET /books/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+	defer r.Body.Close()
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+		return nil
+	}
+
+	bookDTO, err := s.bookService.GetBook(id)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidID) {
+			s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+			return nil
+		}
+		if errors.Is(err, services.ErrBookNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("get book: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, bookDTO)
+
+	return nil
+}
+
+func (s *Server) handlePutBookByID(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received PUT /books/{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+	defer r.Body.Close()
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+		return nil
+	}
+
+	bookDTO := &dtos.BookDTO{}
+	if err := json.NewDecoder(r.Body).Decode(bookDTO); err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidRequestBody)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	updatedBookDTO, err := s.bookService.UpdateBook(userID, id, bookDTO)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidID) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		bookValidationErr := &services.BookValidationError{}
+		if errors.As(err, &bookValidationErr) {
+			s.respondWithBookValidationError(w, bookValidationErr)
+			return nil
+		}
+		if errors.Is(err, services.ErrBookNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+		if errors.Is(err, services.ErrForbidden) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbidden)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("update book: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, updatedBookDTO)
+
+	return nil
+}
+
+func (s *Server) handleDeleteBookByID(w http.ResponseWriter, r *http.Request) error {
+	logger.Infof("Received DELETE /books{id} from %s", r.RemoteAddr)
+
+	idString := mux.Vars(r)["id"]
+	defer r.Body.Close()
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		s.respondWithError(w, http.StatusBadRequest, ErrMsgBadRequestInvalidBookID)
+		return nil
+	}
+
+	userID := r.Context().Value(contextKeyUserID).(int)
+	if userID == 0 {
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return ErrUserIDNotSetInContext
+	}
+
+	if err := s.bookService.DeleteBook(userID, id); err != nil {
+		if errors.Is(err, services.ErrInvalidID) {
+			s.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err))
+			return nil
+		}
+		if errors.Is(err, services.ErrBookNotFound) {
+			s.respondWithError(w, http.StatusNotFound, ErrMsgNotFound)
+			return nil
+		}
+		if errors.Is(err, services.ErrForbidden) {
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbidden)
+			return nil
+		}
+
+		s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+		return fmt.Errorf("delete book: %w", err)
+	}
+
+	s.respondWithJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (s *Server) validateJWT(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := r.RemoteAddr
+
+		logger.Infof("Validating JWT for client with IP address: %s", clientIP)
+
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			logger.Infof("Authorization header missing for client with IP address: %s", clientIP)
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorized)
+			return
+		}
+
+		authHeaderParts := strings.Split(authHeader, " ")
+		if len(authHeaderParts) != 2 || authHeaderParts[0] != "Bearer" {
+			logger.Infof("Invalid authorization format for client with IP address: %s", clientIP)
+			s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorized)
+			return
+		}
 
+		tokenString := authHeaderParts[1]
+		claims, err := s.tokenService.ValidateToken(tokenString)
+		if err != nil {
+			if errors.Is(err, services.ErrExpiredToken) {
+				logger.Infof("Expired JWT detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedExpiredToken)
+				return
+			}
 			if errors.Is(err, services.ErrInvalidToken) {
+				logger.Infof("Invalid JWT detected for client with IP address: %s", clientIP)
 				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidToken)
 				return
 			}
+			if errors.Is(err, services.ErrRevokedToken) {
+				logger.Infof("Revoked JWT detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedRevokedToken)
+				return
+			}
+			if errors.Is(err, services.ErrTerminatedSession) {
+				logger.Infof("JWT of terminated session detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedTerminatedSession)
+				return
+			}
+
+			logger.Errorf("Error (%s) encountered during JWT validation for client with IP address: %s", err, clientIP)
+			s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
+			return
+		}
+
+		logger.Infof("JWT validation successful for user with ID (%d) for client with IP address: %s", claims.UserID, clientIP)
+
+		ctx := context.WithValue(r.Context(), contextKeyUserID, claims.UserID)
+		ctx = context.WithValue(ctx, contextKeyToken, tokenString)
+		ctx = context.WithValue(ctx, contextKeyRole, claims.Role)
+		ctx = context.WithValue(ctx, contextKeySessionID, claims.SessionID)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate authenticates the request like validateJWT, or with an API key set in the "Authorization: ApiKey <key>" header.
+// For an API key it sets the current role of its owner and its scopes in the context, which requirePermission checks both.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	validateJWT := s.validateJWT(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, key, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if scheme != "ApiKey" {
+			validateJWT.ServeHTTP(w, r)
+			return
+		}
+
+		clientIP := r.RemoteAddr
+
+		apiKey, role, err := s.userService.AuthenticateAPIKey(key)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidAPIKey) {
+				logger.Infof("Invalid API key detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedInvalidAPIKey)
+				return
+			}
+			if errors.Is(err, services.ErrExpiredAPIKey) {
+				logger.Infof("Expired API key detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusUnauthorized, ErrMsgUnauthorizedExpiredAPIKey)
+				return
+			}
+			if errors.Is(err, services.ErrAccountDisabled) {
+				logger.Infof("API key of disabled account detected for client with IP address: %s", clientIP)
+				s.respondWithError(w, http.StatusForbidden, ErrMsgForbiddenAccountDisabled)
+				return
+			}
 
+			logger.Errorf("Error (%s) encountered during API key validation for client with IP address: %s", err, clientIP)
 			s.respondWithError(w, http.StatusInternalServerError, ErrMsgInternalError)
 			return
 		}
 
-		logger.Infof("User ID (%d) retrieved from JWT for client with IP address: %s", userID, clientIP)
+		logger.Infof("API key (%d) of user with ID (%d) validated for client with IP address: %s", apiKey.ID, apiKey.UserID, clientIP)
 
-		ctx := context.WithValue(r.Context(), contextKeyUserID, userID)
+		ctx := context.WithValue(r.Context(), contextKeyUserID, apiKey.UserID)
+		ctx = context.WithValue(ctx, contextKeyRole, role)
+		ctx = context.WithValue(ctx, contextKeyScopes, apiKey.Scopes)
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requirePermission allows the request only if the role of the user, set in the context by validateJWT or authenticate,
+// grants the given permission. For a request authenticated with an API key the permission must be in its scopes as well.
+func (s *Server) requirePermission(permission models.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value(contextKeyRole).(models.Role)
+		scopes, isAPIKey := r.Context().Value(contextKeyScopes).([]models.Permission)
+		if !role.HasPermission(permission) || isAPIKey && !slices.Contains(scopes, permission) {
+			logger.Infof("Permission (%s) denied for role (%s) of client with IP address: %s", permission, role, r.RemoteAddr)
+			s.respondWithError(w, http.StatusForbidden, ErrMsgForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRetryAfter sets the Retry-After header to the number of seconds, rounded up,
+// after which an operation refused with a services.RetryAfterError can be retried.
+func (s *Server) setRetryAfter(w http.ResponseWriter, err error) {
+	retryAfterErr := &services.RetryAfterError{}
+	if !errors.As(err, &retryAfterErr) {
+		return
+	}
+
+	seconds := int((retryAfterErr.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// bookQueryParameters are the query parameters accepted by GET /books.
+var bookQueryParameters = map[string]bool{
+	"author":       true,
+	"title":        true,
+	"created_by":   true,
+	"created_from": true,
+	"created_to":   true,
+	"sort":         true,
+	"order":        true,
+	"limit":        true,
+	"offset":       true,
+	"cursor":       true,
+}
+
+// bookSearchParameters are the query parameters accepted by GET /books/search.
+var bookSearchParameters = map[string]bool{
+	"q":      true,
+	"limit":  true,
+	"offset": true,
+}
+
+// checkQueryParameters returns an error naming a query parameter which is not one of the accepted parameters, if there is any.
+func (s *Server) checkQueryParameters(query url.Values, parameters map[string]bool) error {
+	for parameter := range query {
+		if !parameters[parameter] {
+			return fmt.Errorf("unknown query parameter %s", parameter)
+		}
+	}
+
+	return nil
+}
+
+// parseBookQuery parses the query parameters of GET /books, rejecting unknown parameters and malformed values.
+// The values are validated further by the book service.
+func (s *Server) parseBookQuery(query url.Values) (*dtos.BookQueryDTO, error) {
+	if err := s.checkQueryParameters(query, bookQueryParameters); err != nil {
+		return nil, err
+	}
+
+	bookQueryDTO := &dtos.BookQueryDTO{
+		Author: query.Get("author"),
+		Title:  query.Get("title"),
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Limit:  services.DefaultBookPageLimit,
+		Cursor: query.Get("cursor"),
+	}
+
+	for parameter, value := range map[string]*int64{
+		"created_by": &bookQueryDTO.CreatedBy,
+		"limit":      &bookQueryDTO.Limit,
+		"offset":     &bookQueryDTO.Offset,
+	} {
+		if query.Get(parameter) == "" {
+			continue
+		}
+
+		var err error
+		if *value, err = strconv.ParseInt(query.Get(parameter), 10, 64); err != nil {
+			return nil, fmt.Errorf("%s must be an integer", parameter)
+		}
+	}
+
+	for parameter, value := range map[string]**time.Time{
+		"created_from": &bookQueryDTO.CreatedFrom,
+		"created_to":   &bookQueryDTO.CreatedTo,
+	} {
+		if query.Get(parameter) == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, query.Get(parameter))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time", parameter)
+		}
+		*value = &t
+	}
+
+	return bookQueryDTO, nil
+}
+
+// pageLink returns a link to the page the given cursor points to, with the other query parameters of the request kept.
+func (s *Server) pageLink(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Del("offset")
+	query.Set("cursor", cursor)
+
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// clientIP returns the IP address of the client the request has been received from.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func (s *Server) respondWithError(w http.ResponseWriter, errCode int, errMessage string) {
 	s.respondWithJSON(w, errCode, dtos.ErrorDTO{Error: errMessage})
 }
 
+// respondWithBookValidationError responds with the errors of the invalid fields of a book in the request body.
+func (s *Server) respondWithBookValidationError(w http.ResponseWriter, err *services.BookValidationError) {
+	fields := make(map[string]string, len(err.Fields))
+	for field, fieldErr := range err.Fields {
+		fields[field] = fieldErr.Error()
+	}
+
+	s.respondWithJSON(w, http.StatusBadRequest, dtos.ErrorDTO{
+		Error:  fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, err),
+		Fields: fields,
+	})
+}
+
 func (s *Server) respondWithJSON(w http.ResponseWriter, code int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 
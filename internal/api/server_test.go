@@ -2,20 +2,35 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/database"
 	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/internal/services"
+	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
+	"github.com/MSSkowron/BookRESTAPI/pkg/mailer"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc/oidctest"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
+	"github.com/MSSkowron/BookRESTAPI/pkg/totp"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -26,7 +41,7 @@ const (
 func TestHandleRegister(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -60,6 +75,7 @@ func TestHandleRegister(t *testing.T) {
 				FirstName: "test",
 				LastName:  "test",
 				Age:       30,
+				Role:      "editor",
 			},
 		},
 		{
@@ -93,7 +109,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must have at least 6 characters",
 			},
 		},
 		{
@@ -107,7 +123,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must contain an uppercase letter",
 			},
 		},
 		{
@@ -121,7 +137,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must contain an uppercase letter",
 			},
 		},
 		{
@@ -135,7 +151,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must contain a lowercase letter",
 			},
 		},
 		{
@@ -149,7 +165,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must contain a lowercase letter, must contain a digit",
 			},
 		},
 		{
@@ -163,7 +179,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit",
+				Error: "invalid request body:password must have at least 6 characters, must contain an uppercase letter, must contain a lowercase letter, must contain a digit",
 			},
 		},
 		{
@@ -205,7 +221,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:first name must must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:first name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -219,7 +235,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:first name must must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:first name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -233,7 +249,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:first name must must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:first name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -247,7 +263,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:last name must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:last name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -261,7 +277,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:last name must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:last name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -275,7 +291,7 @@ func TestHandleRegister(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body:last name must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters",
+				Error: "invalid request body:last name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
 			},
 		},
 		{
@@ -337,13 +353,14 @@ func TestHandleRegister(t *testing.T) {
 				err = json.NewDecoder(resp.Body).Decode(&responseBody)
 				require.NoError(t, err)
 
-				require.NotEmpty(t, responseBody.Password)
+				require.Empty(t, responseBody.Password)
 				require.Equal(t, d.expectedResponse.(dtos.UserDTO).ID, responseBody.ID)
 				require.LessOrEqual(t, d.expectedResponse.(dtos.UserDTO).CreatedAt, time.Now())
 				require.Equal(t, d.expectedResponse.(dtos.UserDTO).Email, responseBody.Email)
 				require.Equal(t, d.expectedResponse.(dtos.UserDTO).FirstName, responseBody.FirstName)
 				require.Equal(t, d.expectedResponse.(dtos.UserDTO).LastName, responseBody.LastName)
 				require.Equal(t, d.expectedResponse.(dtos.UserDTO).Age, responseBody.Age)
+				require.Equal(t, d.expectedResponse.(dtos.UserDTO).Role, responseBody.Role)
 			case http.StatusBadRequest:
 				responseError := dtos.ErrorDTO{}
 				err = json.NewDecoder(resp.Body).Decode(&responseError)
@@ -385,7 +402,7 @@ func TestHandleRegister(t *testing.T) {
 func TestHandleLogin(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -536,10 +553,1976 @@ func TestHandleLogin(t *testing.T) {
 	}
 }
 
+func TestHandleRefreshToken(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/register", makeHTTPHandlerFunc(server.handleRegister)).Methods("POST")
+	router.HandleFunc("/login", makeHTTPHandlerFunc(server.handleLogin)).Methods("POST")
+	router.HandleFunc("/token/refresh", makeHTTPHandlerFunc(server.handleRefreshToken)).Methods("POST")
+
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	loginResponse := registerAndLoginWithRefreshToken(t, testServer)
+
+	refresh := func(refreshToken string) *http.Response {
+		refreshRequestJSON, err := json.Marshal(dtos.RefreshTokenDTO{RefreshToken: refreshToken})
+		require.NoError(t, err)
+
+		resp, err := http.Post(testServer.URL+"/token/refresh", "application/json", bytes.NewReader(refreshRequestJSON))
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// valid refresh token
+	resp := refresh(loginResponse.RefreshToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	refreshResponse := dtos.TokenDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&refreshResponse)
+	require.NoError(t, err)
+	require.NotEmpty(t, refreshResponse.Token)
+	require.NotEmpty(t, refreshResponse.RefreshToken)
+	require.NotEqual(t, loginResponse.RefreshToken, refreshResponse.RefreshToken)
+
+	// reused refresh token revokes the whole family
+	for _, refreshToken := range []string{loginResponse.RefreshToken, refreshResponse.RefreshToken, "invalid_token"} {
+		resp := refresh(refreshToken)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		responseError := dtos.ErrorDTO{}
+		err = json.NewDecoder(resp.Body).Decode(&responseError)
+		require.NoError(t, err)
+
+		require.Equal(t, "invalid refresh token", responseError.Error)
+	}
+
+	// invalid request body
+	resp, err = http.Post(testServer.URL+"/token/refresh", "application/json", bytes.NewReader([]byte(`{"refresh_token":123}`)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHandleLogout(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	post := func(path, token string, body any) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			bodyJSON, err := json.Marshal(body)
+			require.NoError(t, err)
+
+			reader = bytes.NewReader(bodyJSON)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+path, reader)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	getBooks := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/books", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	first := registerAndLoginWithRefreshToken(t, testServer)
+	second := login(t, testServer, "test@test.com", "Test123@#")
+
+	// logout revokes the access token and the refresh token family
+	resp := post("/logout", first.Token, dtos.RefreshTokenDTO{RefreshToken: first.RefreshToken})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = getBooks(first.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, "revoked token", responseError.Error)
+
+	_, err = userService.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: first.RefreshToken})
+	require.ErrorIs(t, err, services.ErrInvalidRefreshToken)
+
+	// other sessions are not affected
+	resp = getBooks(second.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// logout with a foreign refresh token
+	resp = post("/logout", second.Token, dtos.RefreshTokenDTO{RefreshToken: "invalid_token"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// logout from all devices revokes all tokens
+	third := login(t, testServer, "test@test.com", "Test123@#")
+
+	resp = post("/logout/all", third.Token, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = getBooks(third.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, err = userService.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: third.RefreshToken})
+	require.ErrorIs(t, err, services.ErrInvalidRefreshToken)
+
+	// logout without a token
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/logout", nil)
+	require.NoError(t, err)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestHandleSessions(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	do := func(method, path, token string) *http.Response {
+		req, err := http.NewRequest(method, testServer.URL+path, nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "test-agent")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	first := registerAndLoginWithRefreshToken(t, testServer)
+	second := login(t, testServer, "test@test.com", "Test123@#")
+
+	resp := do(http.MethodGet, "/users/me/sessions", first.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sessionDTOs := []dtos.SessionDTO{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionDTOs))
+	require.Len(t, sessionDTOs, 2)
+	require.True(t, sessionDTOs[0].Current)
+	require.False(t, sessionDTOs[1].Current)
+	require.Equal(t, "127.0.0.1", sessionDTOs[0].IPAddress)
+	require.NotEmpty(t, sessionDTOs[0].UserAgent)
+
+	data := []struct {
+		name               string
+		path               string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{
+			name:               "invalid session id",
+			path:               "/users/me/sessions/invalid",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidSessionID,
+		},
+		{
+			name:               "session not found",
+			path:               "/users/me/sessions/100",
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      ErrMsgNotFound,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := do(http.MethodDelete, d.path, first.Token)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			responseError := dtos.ErrorDTO{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&responseError))
+			require.Equal(t, d.expectedError, responseError.Error)
+		})
+	}
+
+	// terminating the other session
+	resp = do(http.MethodDelete, fmt.Sprintf("/users/me/sessions/%d", sessionDTOs[1].ID), first.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/books", second.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&responseError))
+	require.Equal(t, ErrMsgUnauthorizedTerminatedSession, responseError.Error)
+
+	_, err := userService.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: second.RefreshToken})
+	require.ErrorIs(t, err, services.ErrInvalidRefreshToken)
+
+	// the current session is not affected
+	resp = do(http.MethodGet, "/books", first.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/users/me/sessions", first.Token)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sessionDTOs = []dtos.SessionDTO{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessionDTOs))
+	require.Len(t, sessionDTOs, 1)
+}
+
+func TestHandleGetJWKS(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, err := token.NewKeyFromPEM("", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+	require.NoError(t, err)
+
+	tokenService := services.NewTokenService(mockDB, token.NewKeyRing(key), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	jwks := dtos.JWKSDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	require.NoError(t, err)
+
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, key.ID, jwks.Keys[0].KeyID)
+	require.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	require.Equal(t, "RS256", jwks.Keys[0].Algorithm)
+	require.NotEmpty(t, jwks.Keys[0].N)
+	require.NotEmpty(t, jwks.Keys[0].E)
+
+	// tokens signed with the published key are accepted
+	accessToken := registerAndLogin(t, testServer)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/books", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestBookPermissions(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService, services.WithDefaultRole(models.RoleReader))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	data := []struct {
+		name               string
+		method             string
+		path               string
+		body               any
+		expectedStatusCode int
+	}{
+		{
+			name:               "reader can get books",
+			method:             http.MethodGet,
+			path:               "/books",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "reader can get book by id",
+			method:             http.MethodGet,
+			path:               "/books/1",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:   "reader cannot add book",
+			method: http.MethodPost,
+			path:   "/books",
+			body: dtos.BookCreateDTO{
+				Author: "Author",
+				Title:  "Title",
+			},
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:   "reader cannot update book",
+			method: http.MethodPut,
+			path:   "/books/1",
+			body: dtos.BookDTO{
+				Author: "Author",
+				Title:  "Title",
+			},
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "reader cannot delete book",
+			method:             http.MethodDelete,
+			path:               "/books/1",
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			body, err := json.Marshal(d.body)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(d.method, testServer.URL+d.path, bytes.NewReader(body))
+			require.NoError(t, err)
+
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			if d.expectedStatusCode == http.StatusForbidden {
+				responseError := dtos.ErrorDTO{}
+				err = json.NewDecoder(resp.Body).Decode(&responseError)
+				require.NoError(t, err)
+				require.Equal(t, ErrMsgForbidden, responseError.Error)
+			}
+		})
+	}
+}
+
+func TestHandleCurrentUser(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	doRequest := func(method string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(method, testServer.URL+"/users/me", bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// get the current user
+	resp := doRequest(http.MethodGet, nil)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	user := dtos.UserDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&user)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), user.ID)
+	require.Equal(t, "test@test.com", user.Email)
+	require.Empty(t, user.Password)
+	require.Equal(t, "test", user.FirstName)
+	require.Equal(t, "test", user.LastName)
+	require.Equal(t, int64(30), user.Age)
+	require.Equal(t, "editor", user.Role)
+
+	// update the current user
+	data := []struct {
+		name               string
+		input              any
+		expectedStatusCode int
+		expectedResponse   any
+	}{
+		{
+			name:               "valid",
+			input:              map[string]any{"first_name": "John", "age": 31},
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: dtos.UserDTO{
+				ID:        4,
+				Email:     "test@test.com",
+				FirstName: "John",
+				LastName:  "test",
+				Age:       31,
+				Role:      "editor",
+			},
+		},
+		{
+			name:               "invalid first name",
+			input:              map[string]any{"first_name": "J0hn"},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse: dtos.ErrorDTO{
+				Error: "invalid request body:first name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
+			},
+		},
+		{
+			name:               "invalid last name",
+			input:              map[string]any{"last_name": ""},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse: dtos.ErrorDTO{
+				Error: "invalid request body:last name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes",
+			},
+		},
+		{
+			name:               "invalid age",
+			input:              map[string]any{"age": 121},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse: dtos.ErrorDTO{
+				Error: "invalid request body:age must must not be empty and must be between 18 and 120",
+			},
+		},
+		{
+			name:               "invalid request body",
+			input:              map[string]any{"age": "thirty"},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse: dtos.ErrorDTO{
+				Error: "invalid request body",
+			},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := doRequest(http.MethodPatch, d.input)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			switch d.expectedStatusCode {
+			case http.StatusOK:
+				responseBody := dtos.UserDTO{}
+				err := json.NewDecoder(resp.Body).Decode(&responseBody)
+				require.NoError(t, err)
+
+				responseBody.CreatedAt = time.Time{}
+				require.Equal(t, d.expectedResponse, responseBody)
+			case http.StatusBadRequest:
+				responseError := dtos.ErrorDTO{}
+				err := json.NewDecoder(resp.Body).Decode(&responseError)
+				require.NoError(t, err)
+
+				require.Equal(t, d.expectedResponse, responseError)
+			default:
+				t.Fatalf("unexpected status code: %d", d.expectedStatusCode)
+			}
+		})
+	}
+
+	// delete the current user
+	resp = doRequest(http.MethodDelete, nil)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the token of the deleted user is revoked
+	resp = doRequest(http.MethodGet, nil)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the deleted user can register again
+	registerAndLogin(t, testServer)
+}
+
+func TestHandleChangePassword(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	data := []struct {
+		name               string
+		input              any
+		expectedStatusCode int
+		expectedResponse   any
+	}{
+		{
+			name:               "invalid request body",
+			input:              map[string]any{"new_password": 123},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   dtos.ErrorDTO{Error: "invalid request body"},
+		},
+		{
+			name:               "invalid new password",
+			input:              dtos.PasswordChangeDTO{CurrentPassword: "Test123@#", NewPassword: "weak"},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   dtos.ErrorDTO{Error: "invalid request body:password must have at least 6 characters, must contain an uppercase letter, must contain a digit"},
+		},
+		{
+			name:               "wrong current password",
+			input:              dtos.PasswordChangeDTO{CurrentPassword: "Wrong123@#", NewPassword: "NewTest123@#"},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   dtos.ErrorDTO{Error: "invalid credentials"},
+		},
+		{
+			name:               "valid",
+			input:              dtos.PasswordChangeDTO{CurrentPassword: "Test123@#", NewPassword: "NewTest123@#"},
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   "null",
+		},
+		{
+			name:               "token revoked after the change",
+			input:              dtos.PasswordChangeDTO{CurrentPassword: "NewTest123@#", NewPassword: "Test123@#"},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   dtos.ErrorDTO{Error: "revoked token"},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			requestBody, err := json.Marshal(d.input)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, testServer.URL+"/users/me/password", bytes.NewReader(requestBody))
+			require.NoError(t, err)
+
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			switch d.expectedStatusCode {
+			case http.StatusOK:
+				responseBody, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				require.Equal(t, d.expectedResponse, string(responseBody))
+			case http.StatusBadRequest, http.StatusUnauthorized:
+				responseError := dtos.ErrorDTO{}
+				err = json.NewDecoder(resp.Body).Decode(&responseError)
+				require.NoError(t, err)
+
+				require.Equal(t, d.expectedResponse, responseError)
+			default:
+				t.Fatalf("unexpected status code: %d", d.expectedStatusCode)
+			}
+		})
+	}
+
+	login(t, testServer, "test@test.com", "NewTest123@#")
+}
+
+func TestHandleChangeEmail(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService, services.WithMailer(memoryMailer))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	post := func(path string, body any, authorized bool) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+path, bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		if authorized {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// the new email address is already taken
+	resp := post("/users/me/email", dtos.EmailChangeDTO{NewEmail: "janedoe@net.eu", Password: "Test123@#"}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// wrong password
+	resp = post("/users/me/email", dtos.EmailChangeDTO{NewEmail: "new@test.com", Password: "Wrong123@#"}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// not authenticated
+	resp = post("/users/me/email", dtos.EmailChangeDTO{NewEmail: "new@test.com", Password: "Test123@#"}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// valid request
+	resp = post("/users/me/email", dtos.EmailChangeDTO{NewEmail: "new@test.com", Password: "Test123@#"}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	message := memoryMailer.LastMessage("new@test.com")
+	require.NotNil(t, message)
+
+	matches := regexp.MustCompile(`email address: (\S+)`).FindStringSubmatch(message.Body)
+	require.Len(t, matches, 2)
+
+	// invalid confirmation token
+	resp = post("/email/confirm", dtos.EmailChangeConfirmDTO{Token: "invalid"}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, "invalid request body:invalid or expired email change token", responseError.Error)
+
+	// valid confirmation token
+	resp = post("/email/confirm", dtos.EmailChangeConfirmDTO{Token: matches[1]}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the token issued before the change is revoked
+	resp = post("/users/me/email", dtos.EmailChangeDTO{NewEmail: "other@test.com", Password: "Test123@#"}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	login(t, testServer, "new@test.com", "Test123@#")
+}
+
+func TestHandlePasswordReset(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService, services.WithMailer(memoryMailer))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	post := func(path string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		resp, err := http.Post(testServer.URL+path, "application/json", bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// invalid email
+	resp := post("/password/forgot", dtos.PasswordForgotDTO{Email: "invalid"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// unknown email responds the same as a registered one
+	resp = post("/password/forgot", dtos.PasswordForgotDTO{Email: "unknown@test.com"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, memoryMailer.Messages(), 1) // the verification email sent on registration
+
+	// registered email
+	resp = post("/password/forgot", dtos.PasswordForgotDTO{Email: "test@test.com"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	message := memoryMailer.LastMessage("test@test.com")
+	require.NotNil(t, message)
+
+	matches := regexp.MustCompile(`reset your password: (\S+)`).FindStringSubmatch(message.Body)
+	require.Len(t, matches, 2)
+
+	// invalid reset token
+	resp = post("/password/reset", dtos.PasswordResetDTO{Token: "invalid", NewPassword: "New123@#"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, "invalid request body:invalid or expired password reset token", responseError.Error)
+
+	// invalid new password
+	resp = post("/password/reset", dtos.PasswordResetDTO{Token: matches[1], NewPassword: "weak"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// valid request
+	resp = post("/password/reset", dtos.PasswordResetDTO{Token: matches[1], NewPassword: "New123@#"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the token can be used only once
+	resp = post("/password/reset", dtos.PasswordResetDTO{Token: matches[1], NewPassword: "Other123@#"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the token issued before the reset is revoked
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/users/me", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	login(t, testServer, "test@test.com", "New123@#")
+}
+
+func TestHandleVerifyEmail(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService, services.WithMailer(memoryMailer), services.WithEmailVerificationRequired(true))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	post := func(path string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		resp, err := http.Post(testServer.URL+path, "application/json", bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := post("/register", dtos.AccountCreateDTO{Email: "test@test.com", Password: "Test123@#", FirstName: "test", LastName: "test", Age: 30})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// unverified users cannot log in
+	resp = post("/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgForbiddenEmailNotVerified, responseError.Error)
+
+	// resending is rate limited the same way for registered, unknown and verified email addresses
+	for _, email := range []string{"test@test.com", "unknown@test.com", "johndoe@net.eu"} {
+		resp = post("/verify-email/resend", dtos.VerificationResendDTO{Email: email})
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	require.Len(t, memoryMailer.Messages(), 1)
+
+	// invalid email
+	resp = post("/verify-email/resend", dtos.VerificationResendDTO{Email: "invalid"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// invalid verification token
+	resp, err = http.Get(testServer.URL + "/verify-email?token=invalid")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	responseError = dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, "invalid request body:invalid or expired email verification token", responseError.Error)
+
+	// the link from the verification email
+	message := memoryMailer.LastMessage("test@test.com")
+	require.NotNil(t, message)
+
+	matches := regexp.MustCompile(`verify your email address: (\S+)`).FindStringSubmatch(message.Body)
+	require.Len(t, matches, 2)
+
+	verificationURL, err := url.Parse(matches[1])
+	require.NoError(t, err)
+	require.Equal(t, "/verify-email", verificationURL.Path)
+
+	resp, err = http.Get(testServer.URL + "/verify-email?" + verificationURL.RawQuery)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	login(t, testServer, "test@test.com", "Test123@#")
+}
+
+func TestHandleTwoFactor(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	post := func(path string, body any, authorized bool) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+path, bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		if authorized {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// not authenticated
+	resp := post("/users/me/2fa", nil, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// confirming without enrollment
+	resp = post("/users/me/2fa/confirm", dtos.TwoFactorConfirmDTO{Code: "123456"}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// enrollment
+	resp = post("/users/me/2fa", nil, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	enrollmentDTO := dtos.TwoFactorEnrollmentDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&enrollmentDTO)
+	require.NoError(t, err)
+	require.NotEmpty(t, enrollmentDTO.Secret)
+	require.Contains(t, enrollmentDTO.URI, "otpauth://totp/")
+
+	// confirmation
+	code, err := totp.GenerateCode(enrollmentDTO.Secret, time.Now())
+	require.NoError(t, err)
+
+	resp = post("/users/me/2fa/confirm", dtos.TwoFactorConfirmDTO{Code: code}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	recoveryCodesDTO := dtos.RecoveryCodesDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&recoveryCodesDTO)
+	require.NoError(t, err)
+	require.NotEmpty(t, recoveryCodesDTO.RecoveryCodes)
+
+	// logging in with the password returns only a challenge token
+	loginWithPassword := func() string {
+		resp := post("/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"}, false)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		response := map[string]any{}
+		err := json.NewDecoder(resp.Body).Decode(&response)
+		require.NoError(t, err)
+		require.Equal(t, true, response["two_factor_required"])
+		require.NotContains(t, response, "token")
+		require.NotContains(t, response, "refresh_token")
+		require.NotEmpty(t, response["challenge_token"])
+
+		return response["challenge_token"].(string)
+	}
+
+	// invalid code
+	resp = post("/login/2fa", dtos.TwoFactorLoginDTO{ChallengeToken: loginWithPassword(), Code: "invalid"}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgUnauthorizedInvalidTwoFactorCode, responseError.Error)
+
+	// invalid challenge token
+	resp = post("/login/2fa", dtos.TwoFactorLoginDTO{ChallengeToken: "invalid", Code: recoveryCodesDTO.RecoveryCodes[0]}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	responseError = dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgUnauthorizedInvalidChallengeToken, responseError.Error)
+
+	// recovery code
+	resp = post("/login/2fa", dtos.TwoFactorLoginDTO{ChallengeToken: loginWithPassword(), Code: recoveryCodesDTO.RecoveryCodes[0]}, false)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tokenDTO := dtos.TokenDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&tokenDTO)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokenDTO.Token)
+	require.NotEmpty(t, tokenDTO.RefreshToken)
+
+	// disabling with a wrong password
+	resp = post("/users/me/2fa/disable", dtos.TwoFactorDisableDTO{Password: "Wrong123@#", Code: recoveryCodesDTO.RecoveryCodes[1]}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// disabling
+	resp = post("/users/me/2fa/disable", dtos.TwoFactorDisableDTO{Password: "Test123@#", Code: recoveryCodesDTO.RecoveryCodes[1]}, true)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	login(t, testServer, "test@test.com", "Test123@#")
+}
+
+func TestHandleLoginLimiter(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	loginLimiterService := services.NewLoginLimiterService(mockDB, services.WithLoginBackoff(100*time.Millisecond, 100*time.Millisecond), services.WithAccountLockoutThreshold(2))
+	userService := services.NewUserService(mockDB, tokenService, services.WithLoginLimiter(loginLimiterService))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	readerToken := registerAndLogin(t, testServer)
+
+	adminToken, err := tokenService.GenerateToken(1, "johndoe@net.eu", models.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	post := func(path string, body any, accessToken string) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+path, bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// invalid credentials
+	resp := post("/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Wrong123@#"}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// too soon after a failed attempt
+	resp = post("/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	responseError := dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgTooManyRequests, responseError.Error)
+
+	// locked after reaching the threshold
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, loginLimiterService.RecordFailure("test@test.com", ""))
+
+	resp = post("/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusLocked, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	responseError = dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgLocked, responseError.Error)
+
+	data := []struct {
+		name               string
+		path               string
+		accessToken        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "not authenticated",
+			path:               "/users/4/unlock",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "not an admin",
+			path:               "/users/4/unlock",
+			accessToken:        readerToken,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "invalid id",
+			path:               "/users/invalid/unlock",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "user not found",
+			path:               "/users/100/unlock",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "unlocked",
+			path:               "/users/4/unlock",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := post(d.path, nil, d.accessToken)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+		})
+	}
+
+	// failed attempts from the IP address still require waiting for the backoff
+	time.Sleep(100 * time.Millisecond)
+
+	login(t, testServer, "test@test.com", "Test123@#")
+}
+
+func TestHandleManageUsers(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	editorTokens := registerAndLoginWithRefreshToken(t, testServer)
+
+	adminToken, err := tokenService.GenerateToken(1, "johndoe@net.eu", models.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	do := func(method, path string, body any, accessToken string) *http.Response {
+		var requestBody io.Reader
+		if body != nil {
+			requestBodyJSON, err := json.Marshal(body)
+			require.NoError(t, err)
+			requestBody = bytes.NewReader(requestBodyJSON)
+		}
+
+		req, err := http.NewRequest(method, testServer.URL+path, requestBody)
+		require.NoError(t, err)
+
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	data := []struct {
+		name               string
+		method             string
+		path               string
+		body               any
+		accessToken        string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{
+			name:               "list not authenticated",
+			method:             http.MethodGet,
+			path:               "/users",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedError:      ErrMsgUnauthorized,
+		},
+		{
+			name:               "list not an admin",
+			method:             http.MethodGet,
+			path:               "/users",
+			accessToken:        editorTokens.Token,
+			expectedStatusCode: http.StatusForbidden,
+			expectedError:      ErrMsgForbidden,
+		},
+		{
+			name:               "list with invalid limit",
+			method:             http.MethodGet,
+			path:               "/users?limit=abc",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidQuery,
+		},
+		{
+			name:               "list with too high limit",
+			method:             http.MethodGet,
+			path:               "/users?limit=1000",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidPagination),
+		},
+		{
+			name:               "view not an admin",
+			method:             http.MethodGet,
+			path:               "/users/1",
+			accessToken:        editorTokens.Token,
+			expectedStatusCode: http.StatusForbidden,
+			expectedError:      ErrMsgForbidden,
+		},
+		{
+			name:               "view invalid id",
+			method:             http.MethodGet,
+			path:               "/users/invalid",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidUserID,
+		},
+		{
+			name:               "view user not found",
+			method:             http.MethodGet,
+			path:               "/users/100",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      ErrMsgNotFound,
+		},
+		{
+			name:               "disable own account",
+			method:             http.MethodPost,
+			path:               "/users/1/disable",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusConflict,
+			expectedError:      ErrMsgConflictOwnAccount,
+		},
+		{
+			name:               "disable user not found",
+			method:             http.MethodPost,
+			path:               "/users/100/disable",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      ErrMsgNotFound,
+		},
+		{
+			name:               "enable not an admin",
+			method:             http.MethodPost,
+			path:               "/users/4/enable",
+			accessToken:        editorTokens.Token,
+			expectedStatusCode: http.StatusForbidden,
+			expectedError:      ErrMsgForbidden,
+		},
+		{
+			name:               "force password reset user not found",
+			method:             http.MethodPost,
+			path:               "/users/100/password-reset",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      ErrMsgNotFound,
+		},
+		{
+			name:               "change role invalid role",
+			method:             http.MethodPut,
+			path:               "/users/3/role",
+			body:               dtos.UserRoleUpdateDTO{Role: "owner"},
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidRequestBody, services.ErrInvalidRole),
+		},
+		{
+			name:               "demote own account",
+			method:             http.MethodPut,
+			path:               "/users/1/role",
+			body:               dtos.UserRoleUpdateDTO{Role: "reader"},
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusConflict,
+			expectedError:      ErrMsgConflictOwnAccount,
+		},
+		{
+			name:               "delete own account",
+			method:             http.MethodDelete,
+			path:               "/users/1",
+			accessToken:        adminToken,
+			expectedStatusCode: http.StatusConflict,
+			expectedError:      ErrMsgConflictOwnAccount,
+		},
+		{
+			name:               "delete not an admin",
+			method:             http.MethodDelete,
+			path:               "/users/3",
+			accessToken:        editorTokens.Token,
+			expectedStatusCode: http.StatusForbidden,
+			expectedError:      ErrMsgForbidden,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := do(d.method, d.path, d.body, d.accessToken)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			responseError := dtos.ErrorDTO{}
+			err := json.NewDecoder(resp.Body).Decode(&responseError)
+			require.NoError(t, err)
+			require.Equal(t, d.expectedError, responseError.Error)
+		})
+	}
+
+	// list and search users
+	resp := do(http.MethodGet, "/users?q=doe&limit=1&offset=1", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	userPage := dtos.UserPageDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&userPage)
+	require.NoError(t, err)
+	require.Len(t, userPage.Users, 1)
+	require.Equal(t, int64(2), userPage.Users[0].ID)
+	require.Equal(t, int64(2), userPage.Total)
+	require.Equal(t, int64(1), userPage.Limit)
+	require.Equal(t, int64(1), userPage.Offset)
+
+	// view a user
+	resp = do(http.MethodGet, "/users/4", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	user := dtos.UserDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	require.NoError(t, err)
+	require.Equal(t, "test@test.com", user.Email)
+	require.Empty(t, user.Password)
+	require.Nil(t, user.DisabledAt)
+
+	// a disabled user is logged out and cannot log in or refresh tokens
+	resp = do(http.MethodPost, "/users/4/disable", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/users/me", nil, editorTokens.Token)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	responseError := dtos.ErrorDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseError)
+	require.NoError(t, err)
+	require.Equal(t, ErrMsgForbiddenAccountDisabled, responseError.Error)
+
+	resp = do(http.MethodPost, "/token/refresh", dtos.RefreshTokenDTO{RefreshToken: editorTokens.RefreshToken}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// an enabled user can log in again
+	resp = do(http.MethodPost, "/users/4/enable", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	editorTokens = login(t, testServer, "test@test.com", "Test123@#")
+
+	// change the role, which logs the user out
+	resp = do(http.MethodPut, "/users/4/role", dtos.UserRoleUpdateDTO{Role: "reader"}, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	user = dtos.UserDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	require.NoError(t, err)
+	require.Equal(t, "reader", user.Role)
+
+	resp = do(http.MethodGet, "/users/me", nil, editorTokens.Token)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// force a password reset, after which the current password is not accepted
+	resp = do(http.MethodPost, "/users/4/password-reset", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/login", dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"}, "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// delete the user
+	resp = do(http.MethodDelete, "/users/4", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/users/4", nil, adminToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandlePasswordHashingBusy(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	hasher := &blockingHasher{PasswordHasher: crypto.NewBcryptHasher(bcrypt.MinCost), blocked: make(chan struct{}), unblock: make(chan struct{})}
+	hashingPool := crypto.NewHashingPool(hasher, 1, 1, 50*time.Millisecond)
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService, services.WithPasswordHasher(hashingPool), services.WithMailer(memoryMailer))
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	registerAndLogin(t, testServer)
+
+	post := func(path string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		resp, err := http.Post(testServer.URL+path, "application/json", bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := post("/password/forgot", dtos.PasswordForgotDTO{Email: "test@test.com"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	message := memoryMailer.LastMessage("test@test.com")
+	require.NotNil(t, message)
+
+	matches := regexp.MustCompile(`reset your password: (\S+)`).FindStringSubmatch(message.Body)
+	require.Len(t, matches, 2)
+
+	// The only worker is busy until unblocked
+	hasher.block = true
+	done := make(chan error)
+	go func() {
+		_, err := hashingPool.Hash(context.Background(), "Test123@#")
+		done <- err
+	}()
+	<-hasher.blocked
+
+	data := []struct {
+		name string
+		path string
+		body any
+	}{
+		{
+			name: "login",
+			path: "/login",
+			body: dtos.UserLoginDTO{Email: "test@test.com", Password: "Test123@#"},
+		},
+		{
+			name: "register",
+			path: "/register",
+			body: dtos.AccountCreateDTO{Email: "other@test.com", Password: "Test123@#", FirstName: "test", LastName: "test", Age: 30},
+		},
+		{
+			name: "password reset",
+			path: "/password/reset",
+			body: dtos.PasswordResetDTO{Token: matches[1], NewPassword: "New123@#"},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := post(d.path, d.body)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+			responseError := dtos.ErrorDTO{}
+			err := json.NewDecoder(resp.Body).Decode(&responseError)
+			require.NoError(t, err)
+			require.Equal(t, ErrMsgServiceUnavailable, responseError.Error)
+		})
+	}
+
+	require.Equal(t, 3, hashingPool.Stats().Rejected)
+
+	hasher.block = false
+	close(hasher.unblock)
+	require.NoError(t, <-done)
+
+	// The reset token is not used up by the rejected request
+	resp = post("/password/reset", dtos.PasswordResetDTO{Token: matches[1], NewPassword: "New123@#"})
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	login(t, testServer, "test@test.com", "New123@#")
+}
+
+func TestHandleMetrics(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	editorToken := registerAndLogin(t, testServer)
+
+	adminToken, err := tokenService.GenerateToken(1, "johndoe@net.eu", models.RoleAdmin, 0)
+	require.NoError(t, err)
+
+	data := []struct {
+		name               string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{
+			name:               "admin",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "editor",
+			authorization:      "Bearer " + editorToken,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "unauthenticated",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, testServer.URL+"/debug/vars", nil)
+			require.NoError(t, err)
+
+			if d.authorization != "" {
+				req.Header.Set("Authorization", d.authorization)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			if d.expectedStatusCode == http.StatusOK {
+				vars := map[string]any{}
+				err = json.NewDecoder(resp.Body).Decode(&vars)
+				require.NoError(t, err)
+				require.Contains(t, vars, "memstats")
+			}
+		})
+	}
+}
+
+func TestHandleAPIKeys(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	accessToken := registerAndLogin(t, testServer)
+
+	do := func(method, path string, body any, authorization string) *http.Response {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(method, testServer.URL+path, bytes.NewReader(requestBody))
+		require.NoError(t, err)
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	createAPIKey := func(scopes ...string) dtos.APIKeyCreatedDTO {
+		resp := do(http.MethodPost, "/users/me/api-keys", dtos.APIKeyCreateDTO{Name: "batch", Scopes: scopes}, "Bearer "+accessToken)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		apiKeyCreatedDTO := dtos.APIKeyCreatedDTO{}
+		err := json.NewDecoder(resp.Body).Decode(&apiKeyCreatedDTO)
+		require.NoError(t, err)
+		require.NotEmpty(t, apiKeyCreatedDTO.Key)
+
+		return apiKeyCreatedDTO
+	}
+
+	// invalid scopes
+	resp := do(http.MethodPost, "/users/me/api-keys", dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"users:manage"}}, "Bearer "+accessToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	readKey := createAPIKey("books:read")
+	writeKey := createAPIKey("books:read", "books:write")
+
+	data := []struct {
+		name               string
+		method             string
+		path               string
+		body               any
+		authorization      string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{
+			name:               "read scope can get books",
+			method:             http.MethodGet,
+			path:               "/books",
+			authorization:      "ApiKey " + readKey.Key,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "read scope cannot add book",
+			method:             http.MethodPost,
+			path:               "/books",
+			body:               dtos.BookCreateDTO{Author: "Author", Title: "Title"},
+			authorization:      "ApiKey " + readKey.Key,
+			expectedStatusCode: http.StatusForbidden,
+			expectedError:      ErrMsgForbidden,
+		},
+		{
+			name:               "write scope can add book",
+			method:             http.MethodPost,
+			path:               "/books",
+			body:               dtos.BookCreateDTO{Author: "Author", Title: "Title"},
+			authorization:      "ApiKey " + writeKey.Key,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid api key",
+			method:             http.MethodGet,
+			path:               "/books",
+			authorization:      "ApiKey invalid",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedError:      ErrMsgUnauthorizedInvalidAPIKey,
+		},
+		{
+			name:               "api key cannot access account",
+			method:             http.MethodGet,
+			path:               "/users/me",
+			authorization:      "ApiKey " + writeKey.Key,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedError:      ErrMsgUnauthorized,
+		},
+		{
+			name:               "api key cannot create api keys",
+			method:             http.MethodPost,
+			path:               "/users/me/api-keys",
+			body:               dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read"}},
+			authorization:      "ApiKey " + writeKey.Key,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedError:      ErrMsgUnauthorized,
+		},
+		{
+			name:               "invalid api key id",
+			method:             http.MethodDelete,
+			path:               "/users/me/api-keys/invalid",
+			authorization:      "Bearer " + accessToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidAPIKeyID,
+		},
+		{
+			name:               "api key not found",
+			method:             http.MethodDelete,
+			path:               "/users/me/api-keys/100",
+			authorization:      "Bearer " + accessToken,
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      ErrMsgNotFound,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := do(d.method, d.path, d.body, d.authorization)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			if d.expectedError != "" {
+				responseError := dtos.ErrorDTO{}
+				err := json.NewDecoder(resp.Body).Decode(&responseError)
+				require.NoError(t, err)
+				require.Equal(t, d.expectedError, responseError.Error)
+			}
+		})
+	}
+
+	// listing does not return the keys
+	resp = do(http.MethodGet, "/users/me/api-keys", nil, "Bearer "+accessToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	apiKeys := []map[string]any{}
+	err := json.NewDecoder(resp.Body).Decode(&apiKeys)
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 2)
+	for _, apiKey := range apiKeys {
+		require.NotContains(t, apiKey, "key")
+		require.NotEmpty(t, apiKey["prefix"])
+	}
+
+	// revoking
+	resp = do(http.MethodDelete, fmt.Sprintf("/users/me/api-keys/%d", readKey.ID), nil, "Bearer "+accessToken)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/books", nil, "ApiKey "+readKey.Key)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// scopes are limited by the current role of the owner
+	adminKey, err := userService.CreateAPIKey(1, &dtos.APIKeyCreateDTO{Name: "admin", Scopes: []string{"users:manage"}})
+	require.NoError(t, err)
+
+	resp = do(http.MethodPost, "/users/4/unlock", nil, "ApiKey "+adminKey.Key)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the mock database returns the stored user, so changing the role takes effect immediately
+	admin, err := mockDB.SelectUserByID(1)
+	require.NoError(t, err)
+	admin.Role = models.RoleEditor
+
+	resp = do(http.MethodPost, "/users/4/unlock", nil, "ApiKey "+adminKey.Key)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestHandleOIDC(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	bookService := services.NewBookService(mockDB)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	get := func(url string, cookie *http.Cookie) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// not configured
+	notConfiguredServer := httptest.NewServer(NewServer(services.NewUserService(mockDB, tokenService), bookService, tokenService).Handler)
+	defer notConfiguredServer.Close()
+
+	resp := get(notConfiguredServer.URL+"/auth/oidc/login", nil)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	stub, err := oidctest.NewProvider("bookrestapi", "secret")
+	require.NoError(t, err)
+	defer stub.Close()
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		IssuerURL:    stub.Issuer(),
+		ClientID:     "bookrestapi",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+	})
+	require.NoError(t, err)
+
+	userService := services.NewUserService(mockDB, tokenService, services.WithOIDCProvider(provider))
+
+	testServer := httptest.NewServer(NewServer(userService, bookService, tokenService).Handler)
+	defer testServer.Close()
+
+	startLogin := func(user oidctest.User) (*url.URL, *http.Cookie) {
+		resp := get(testServer.URL+"/auth/oidc/login", nil)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		var stateCookie *http.Cookie
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == oidcStateCookieName {
+				stateCookie = cookie
+			}
+		}
+		require.NotNil(t, stateCookie)
+		require.True(t, stateCookie.HttpOnly)
+		require.Equal(t, oidcStateCookiePath, stateCookie.Path)
+
+		redirectURL, err := stub.Login(resp.Header.Get("Location"), user)
+		require.NoError(t, err)
+		require.Equal(t, stateCookie.Value, redirectURL.Query().Get("state"))
+
+		return redirectURL, stateCookie
+	}
+
+	user := oidctest.User{Subject: "alice", Email: "alice@corp.example.com", EmailVerified: true, GivenName: "Alice", FamilyName: "Smith"}
+
+	redirectURL, stateCookie := startLogin(user)
+
+	data := []struct {
+		name               string
+		query              string
+		cookie             *http.Cookie
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{
+			name:               "missing state cookie",
+			query:              redirectURL.RawQuery,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidOIDCState,
+		},
+		{
+			name:               "state cookie of another login",
+			query:              redirectURL.RawQuery,
+			cookie:             &http.Cookie{Name: oidcStateCookieName, Value: "other"},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidOIDCState,
+		},
+		{
+			name:               "unknown state",
+			query:              "code=code&state=other",
+			cookie:             &http.Cookie{Name: oidcStateCookieName, Value: "other"},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      ErrMsgBadRequestInvalidOIDCState,
+		},
+		{
+			name:               "error returned by the provider",
+			query:              "error=access_denied&state=" + stateCookie.Value,
+			cookie:             stateCookie,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedError:      ErrMsgUnauthorizedOIDCLoginFailed,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := get(testServer.URL+"/auth/oidc/callback?"+d.query, d.cookie)
+			defer resp.Body.Close()
+
+			require.Equal(t, d.expectedStatusCode, resp.StatusCode)
+
+			responseError := dtos.ErrorDTO{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&responseError))
+			require.Equal(t, d.expectedError, responseError.Error)
+		})
+	}
+
+	// successful login
+	resp = get(testServer.URL+"/auth/oidc/callback?"+redirectURL.RawQuery, stateCookie)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tokenDTO := dtos.TokenDTO{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokenDTO))
+	require.NotEmpty(t, tokenDTO.Token)
+	require.NotEmpty(t, tokenDTO.RefreshToken)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/users/me", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokenDTO.Token)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	userDTO := dtos.UserDTO{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&userDTO))
+	require.Equal(t, "alice@corp.example.com", userDTO.Email)
+	require.Equal(t, "Alice", userDTO.FirstName)
+
+	// the state cannot be used again
+	resp = get(testServer.URL+"/auth/oidc/callback?"+redirectURL.RawQuery, stateCookie)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// email address not verified by the provider
+	redirectURL, stateCookie = startLogin(oidctest.User{Subject: "bob", Email: "bob@corp.example.com"})
+
+	resp = get(testServer.URL+"/auth/oidc/callback?"+redirectURL.RawQuery, stateCookie)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
 func TestHandlePostBook(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -575,6 +2558,50 @@ func TestHandlePostBook(t *testing.T) {
 				Title:  "test",
 			},
 		},
+		{
+			name: "valid - with metadata",
+			input: dtos.BookCreateDTO{
+				Author:          "Ursula K. Le Guin",
+				Title:           "A Wizard of Earthsea",
+				ISBN:            "0-306-40615-2",
+				Publisher:       "Parnassus Press",
+				PublicationYear: 1968,
+				Language:        "en-us",
+				PageCount:       205,
+				Description:     "A young wizard learns the cost of power.",
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: dtos.BookDTO{
+				ID:              5,
+				Author:          "Ursula K. Le Guin",
+				Title:           "A Wizard of Earthsea",
+				ISBN:            "9780306406157",
+				Publisher:       "Parnassus Press",
+				PublicationYear: 1968,
+				Language:        "en-US",
+				PageCount:       205,
+				Description:     "A young wizard learns the cost of power.",
+			},
+		},
+		{
+			name: "invalid metadata",
+			input: dtos.BookCreateDTO{
+				Author:    "test",
+				Title:     "test",
+				ISBN:      "0-306-40615-3",
+				Language:  "not a language",
+				PageCount: -1,
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse: dtos.ErrorDTO{
+				Error: fmt.Sprintf("invalid request body:%s, %s, %s", services.ErrInvalidISBN, services.ErrInvalidLanguage, services.ErrInvalidPageCount),
+				Fields: map[string]string{
+					"isbn":       services.ErrInvalidISBN.Error(),
+					"language":   services.ErrInvalidLanguage.Error(),
+					"page_count": services.ErrInvalidPageCount.Error(),
+				},
+			},
+		},
 		{
 			name: "invalid author - empty",
 			input: dtos.BookCreateDTO{
@@ -583,7 +2610,8 @@ func TestHandlePostBook(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body",
+				Error:  "invalid request body:author must not be empty or longer than 100 characters",
+				Fields: map[string]string{"author": "author must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -594,7 +2622,8 @@ func TestHandlePostBook(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body",
+				Error:  "invalid request body:title must not be empty or longer than 100 characters",
+				Fields: map[string]string{"title": "title must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -604,7 +2633,8 @@ func TestHandlePostBook(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body",
+				Error:  "invalid request body:title must not be empty or longer than 100 characters",
+				Fields: map[string]string{"title": "title must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -614,7 +2644,8 @@ func TestHandlePostBook(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body",
+				Error:  "invalid request body:author must not be empty or longer than 100 characters",
+				Fields: map[string]string{"author": "author must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -622,7 +2653,11 @@ func TestHandlePostBook(t *testing.T) {
 			input:              dtos.BookCreateDTO{},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponse: dtos.ErrorDTO{
-				Error: "invalid request body",
+				Error: "invalid request body:author must not be empty or longer than 100 characters, title must not be empty or longer than 100 characters",
+				Fields: map[string]string{
+					"author": "author must not be empty or longer than 100 characters",
+					"title":  "title must not be empty or longer than 100 characters",
+				},
 			},
 		},
 		{
@@ -668,6 +2703,12 @@ func TestHandlePostBook(t *testing.T) {
 				require.LessOrEqual(t, d.expectedResponse.(dtos.BookDTO).CreatedAt, time.Now())
 				require.Equal(t, d.expectedResponse.(dtos.BookDTO).Author, responseBodyBook.Author)
 				require.Equal(t, d.expectedResponse.(dtos.BookDTO).Title, responseBodyBook.Title)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).ISBN, responseBodyBook.ISBN)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).Publisher, responseBodyBook.Publisher)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).PublicationYear, responseBodyBook.PublicationYear)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).Language, responseBodyBook.Language)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).PageCount, responseBodyBook.PageCount)
+				require.Equal(t, d.expectedResponse.(dtos.BookDTO).Description, responseBodyBook.Description)
 			case http.StatusBadRequest:
 				responseError := dtos.ErrorDTO{}
 				err = json.NewDecoder(resp.Body).Decode(&responseError)
@@ -675,6 +2716,7 @@ func TestHandlePostBook(t *testing.T) {
 
 				require.NotEmpty(t, responseError.Error)
 				require.Equal(t, d.expectedResponse.(dtos.ErrorDTO).Error, responseError.Error)
+				require.Equal(t, d.expectedResponse.(dtos.ErrorDTO).Fields, responseError.Fields)
 			default:
 				t.Fatalf("unexpected status code: %d", d.expectedStatusCode)
 			}
@@ -717,10 +2759,103 @@ func TestHandlePostBook(t *testing.T) {
 	require.Equal(t, "unauthorized", responseError.Error)
 }
 
+func TestHandleSearchBooks(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
+	userService := services.NewUserService(mockDB, tokenService)
+	bookService := services.NewBookService(mockDB)
+
+	server := NewServer(userService, bookService, tokenService)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/register", makeHTTPHandlerFunc(server.handleRegister)).Methods("POST")
+	router.HandleFunc("/login", makeHTTPHandlerFunc(server.handleLogin)).Methods("POST")
+
+	bookRouter := router.PathPrefix("/books").Subrouter()
+	bookRouter.Use(server.validateJWT)
+	bookRouter.HandleFunc("/search", makeHTTPHandlerFunc(server.handleSearchBooks)).Methods("GET")
+	bookRouter.HandleFunc("/{id}", makeHTTPHandlerFunc(server.handleGetBookByID)).Methods("GET")
+
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	token := registerAndLogin(t, testServer)
+
+	get := func(path string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+path, nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := get("/books/search?q=" + url.QueryEscape("shin king"))
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBody := dtos.BookSearchPageDTO{}
+	err := json.NewDecoder(resp.Body).Decode(&responseBody)
+	require.NoError(t, err)
+
+	require.Len(t, responseBody.Results, 1)
+	require.Equal(t, int64(3), responseBody.Results[0].Book.ID)
+	require.Equal(t, "The <mark>Shining</mark>", responseBody.Results[0].TitleHighlight)
+	require.Equal(t, "Stephen <mark>King</mark>", responseBody.Results[0].AuthorHighlight)
+	require.Equal(t, int64(1), responseBody.Total)
+	require.Equal(t, int64(services.DefaultBookPageLimit), responseBody.Limit)
+
+	data := []struct {
+		name          string
+		path          string
+		expectedError string
+	}{
+		{
+			name:          "missing query",
+			path:          "/books/search",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidSearchQuery),
+		},
+		{
+			name:          "invalid limit",
+			path:          "/books/search?q=king&limit=invalid",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":limit must be an integer",
+		},
+		{
+			name:          "negative offset",
+			path:          "/books/search?q=king&offset=-1",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidPagination),
+		},
+		{
+			name:          "unknown parameter",
+			path:          "/books/search?q=king&sort=title",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":unknown query parameter sort",
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := get(d.path)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			responseError := dtos.ErrorDTO{}
+			err := json.NewDecoder(resp.Body).Decode(&responseError)
+			require.NoError(t, err)
+			require.Equal(t, d.expectedError, responseError.Error)
+		})
+	}
+}
+
 func TestHandleGetBookByID(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -868,7 +3003,7 @@ func TestHandleGetBookByID(t *testing.T) {
 func TestHandleGetBooks(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -897,11 +3032,162 @@ func TestHandleGetBooks(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
-	responseBodyBooks := []dtos.BookDTO{}
+	responseBodyBooks := dtos.BookPageDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseBodyBooks)
+	require.NoError(t, err)
+
+	require.Len(t, responseBodyBooks.Books, 3)
+	require.Equal(t, int64(3), responseBodyBooks.Total)
+	require.Empty(t, responseBodyBooks.Next)
+	require.Empty(t, responseBodyBooks.Prev)
+
+	// test pagination, following the links keeps the other query parameters
+	get := func(path string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+path, nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp = get("/books?limit=2&offset=1")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBodyBooks = dtos.BookPageDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&responseBodyBooks)
+	require.NoError(t, err)
+
+	require.Len(t, responseBodyBooks.Books, 2)
+	require.Equal(t, int64(2), responseBodyBooks.Books[0].ID)
+	require.Equal(t, int64(2), responseBodyBooks.TotalPages)
+	require.Empty(t, responseBodyBooks.Next)
+	require.Equal(t, "/books?cursor="+url.QueryEscape(responseBodyBooks.PrevCursor)+"&limit=2", responseBodyBooks.Prev)
+
+	resp = get(responseBodyBooks.Prev)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	responseBodyBooks = dtos.BookPageDTO{}
 	err = json.NewDecoder(resp.Body).Decode(&responseBodyBooks)
 	require.NoError(t, err)
 
-	require.Len(t, responseBodyBooks, 3)
+	require.Len(t, responseBodyBooks.Books, 1)
+	require.Equal(t, int64(1), responseBodyBooks.Books[0].ID)
+	require.Empty(t, responseBodyBooks.Prev)
+	require.NotEmpty(t, responseBodyBooks.Next)
+
+	// test filtering and sorting
+	resp = get("/books?title=the&sort=author&order=desc")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	filteredBooks := dtos.BookPageDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&filteredBooks)
+	require.NoError(t, err)
+
+	require.Len(t, filteredBooks.Books, 2)
+	require.Equal(t, "Stephen King", filteredBooks.Books[0].Author)
+	require.Equal(t, "J.R.R. Tolkien", filteredBooks.Books[1].Author)
+	require.Equal(t, int64(2), filteredBooks.Total)
+
+	// test fuzzy matching
+	resp = get("/books?author=Tolkein")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	fuzzyBooks := dtos.BookPageDTO{}
+	err = json.NewDecoder(resp.Body).Decode(&fuzzyBooks)
+	require.NoError(t, err)
+
+	require.Len(t, fuzzyBooks.Books, 1)
+	require.Equal(t, "J.R.R. Tolkien", fuzzyBooks.Books[0].Author)
+	require.NotNil(t, fuzzyBooks.DidYouMean)
+	require.Equal(t, []string{"J.R.R. Tolkien"}, fuzzyBooks.DidYouMean.Author)
+
+	data := []struct {
+		name          string
+		path          string
+		expectedError string
+	}{
+		{
+			name:          "invalid limit",
+			path:          "/books?limit=invalid",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":limit must be an integer",
+		},
+		{
+			name:          "zero limit",
+			path:          "/books?limit=0",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidPagination),
+		},
+		{
+			name:          "negative offset",
+			path:          "/books?offset=-1",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidPagination),
+		},
+		{
+			name:          "invalid cursor",
+			path:          "/books?cursor=invalid",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidCursor),
+		},
+		{
+			name:          "cursor with an offset",
+			path:          responseBodyBooks.Next + "&offset=1",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidCursor),
+		},
+		{
+			name:          "unknown parameter",
+			path:          "/books?genre=fantasy",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":unknown query parameter genre",
+		},
+		{
+			name:          "unknown sort field",
+			path:          "/books?sort=id",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidBookSort),
+		},
+		{
+			name:          "unknown order",
+			path:          "/books?order=random",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidBookSort),
+		},
+		{
+			name:          "invalid creator",
+			path:          "/books?created_by=john",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":created_by must be an integer",
+		},
+		{
+			name:          "invalid creation time",
+			path:          "/books?created_from=yesterday",
+			expectedError: ErrMsgBadRequestInvalidQuery + ":created_from must be an RFC 3339 time",
+		},
+		{
+			name:          "empty creation range",
+			path:          "/books?created_from=2024-01-02T00:00:00Z&created_to=2024-01-01T00:00:00Z",
+			expectedError: fmt.Sprintf("%s:%s", ErrMsgBadRequestInvalidQuery, services.ErrInvalidCreatedRange),
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			resp := get(d.path)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			responseError := dtos.ErrorDTO{}
+			err := json.NewDecoder(resp.Body).Decode(&responseError)
+			require.NoError(t, err)
+			require.Equal(t, d.expectedError, responseError.Error)
+		})
+	}
 
 	// test invalid token
 	req, err = http.NewRequest(http.MethodGet, testServer.URL+"/books", nil)
@@ -942,7 +3228,7 @@ func TestHandleGetBooks(t *testing.T) {
 func TestHandleDeleteBookByID(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -959,15 +3245,34 @@ func TestHandleDeleteBookByID(t *testing.T) {
 	testServer := httptest.NewServer(router)
 	defer testServer.Close()
 
+	token := registerAndLogin(t, testServer)
+
+	// the book is owned by the registered user
+	_, err := mockDB.InsertBook(&models.Book{
+		CreatedBy: 4,
+		CreatedAt: time.Now(),
+		Author:    "Stephen King",
+		Title:     "It",
+	})
+	require.NoError(t, err)
+
 	data := []struct {
 		name                 string
 		inputID              int
 		expectedStatusCode   int
 		expectedResponseBody any
 	}{
+		{
+			name:               "not the owner",
+			inputID:            1,
+			expectedStatusCode: http.StatusForbidden,
+			expectedResponseBody: dtos.ErrorDTO{
+				Error: "forbidden",
+			},
+		},
 		{
 			name:                 "valid",
-			inputID:              1,
+			inputID:              4,
 			expectedStatusCode:   http.StatusOK,
 			expectedResponseBody: "null",
 		},
@@ -989,7 +3294,6 @@ func TestHandleDeleteBookByID(t *testing.T) {
 		},
 	}
 
-	token := registerAndLogin(t, testServer)
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
 			req, err := http.NewRequest(http.MethodDelete, testServer.URL+"/books/"+strconv.Itoa(d.inputID), nil)
@@ -1009,7 +3313,7 @@ func TestHandleDeleteBookByID(t *testing.T) {
 				require.NoError(t, err)
 
 				require.Equal(t, d.expectedResponseBody, string(responseBody))
-			case http.StatusBadRequest, http.StatusNotFound:
+			case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
 				responseError := dtos.ErrorDTO{}
 				err = json.NewDecoder(resp.Body).Decode(&responseError)
 				require.NoError(t, err)
@@ -1080,7 +3384,7 @@ func TestHandleDeleteBookByID(t *testing.T) {
 func TestHandlePutBookByID(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	tokenService := services.NewTokenService(testTokenSecret, testTokenDuration)
+	tokenService := services.NewTokenService(mockDB, token.NewHMACKeyRing(testTokenSecret), testTokenDuration)
 	userService := services.NewUserService(mockDB, tokenService)
 	bookService := services.NewBookService(mockDB)
 
@@ -1097,6 +3401,17 @@ func TestHandlePutBookByID(t *testing.T) {
 	testServer := httptest.NewServer(router)
 	defer testServer.Close()
 
+	token := registerAndLogin(t, testServer)
+
+	// the book is owned by the registered user
+	_, err := mockDB.InsertBook(&models.Book{
+		CreatedBy: 4,
+		CreatedAt: time.Now(),
+		Author:    "J. K. Rowling",
+		Title:     "Harry Potter",
+	})
+	require.NoError(t, err)
+
 	data := []struct {
 		name                 string
 		input                any
@@ -1106,16 +3421,28 @@ func TestHandlePutBookByID(t *testing.T) {
 		{
 			name: "valid",
 			input: dtos.BookDTO{
-				ID:     1,
+				ID:     4,
 				Author: "J. K. Rowling",
 				Title:  "Harry Potter and the Philosopher's Stone",
 			},
 			expectedStatusCode: http.StatusOK,
 			expectedResponseBody: dtos.BookDTO{
-				ID:     1,
+				ID:     4,
+				Author: "J. K. Rowling",
+				Title:  "Harry Potter and the Philosopher's Stone",
+			},
+		},
+		{
+			name: "not the owner",
+			input: dtos.BookDTO{
+				ID:     2,
 				Author: "J. K. Rowling",
 				Title:  "Harry Potter and the Philosopher's Stone",
 			},
+			expectedStatusCode: http.StatusForbidden,
+			expectedResponseBody: dtos.ErrorDTO{
+				Error: "forbidden",
+			},
 		},
 		{
 			name: "invalid id - not existing",
@@ -1162,7 +3489,8 @@ func TestHandlePutBookByID(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponseBody: dtos.ErrorDTO{
-				Error: "invalid request body:author must not be empty",
+				Error:  "invalid request body:author must not be empty or longer than 100 characters",
+				Fields: map[string]string{"author": "author must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -1174,7 +3502,8 @@ func TestHandlePutBookByID(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusBadRequest,
 			expectedResponseBody: dtos.ErrorDTO{
-				Error: "invalid request body:title must not be empty",
+				Error:  "invalid request body:title must not be empty or longer than 100 characters",
+				Fields: map[string]string{"title": "title must not be empty or longer than 100 characters"},
 			},
 		},
 		{
@@ -1195,7 +3524,6 @@ func TestHandlePutBookByID(t *testing.T) {
 		},
 	}
 
-	token := registerAndLogin(t, testServer)
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
 			var (
@@ -1232,7 +3560,7 @@ func TestHandlePutBookByID(t *testing.T) {
 				require.Equal(t, d.expectedResponseBody.(dtos.BookDTO).Author, responseBody.Author)
 				require.Equal(t, d.expectedResponseBody.(dtos.BookDTO).Title, responseBody.Title)
 
-			case http.StatusBadRequest, http.StatusNotFound:
+			case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
 				responseError := dtos.ErrorDTO{}
 				err = json.NewDecoder(resp.Body).Decode(&responseError)
 				require.NoError(t, err)
@@ -1315,6 +3643,10 @@ func TestHandlePutBookByID(t *testing.T) {
 }
 
 func registerAndLogin(t *testing.T, testServer *httptest.Server) string {
+	return registerAndLoginWithRefreshToken(t, testServer).Token
+}
+
+func registerAndLoginWithRefreshToken(t *testing.T, testServer *httptest.Server) dtos.TokenDTO {
 	const (
 		email     = "test@test.com"
 		password  = "Test123@#"
@@ -1340,6 +3672,10 @@ func registerAndLogin(t *testing.T, testServer *httptest.Server) string {
 
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
+	return login(t, testServer, email, password)
+}
+
+func login(t *testing.T, testServer *httptest.Server, email, password string) dtos.TokenDTO {
 	loginRequest := dtos.UserLoginDTO{
 		Email:    email,
 		Password: password,
@@ -1348,7 +3684,7 @@ func registerAndLogin(t *testing.T, testServer *httptest.Server) string {
 	loginRequestJSON, err := json.Marshal(loginRequest)
 	require.NoError(t, err)
 
-	resp, err = http.Post(testServer.URL+"/login", "application/json", bytes.NewReader(loginRequestJSON))
+	resp, err := http.Post(testServer.URL+"/login", "application/json", bytes.NewReader(loginRequestJSON))
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
@@ -1358,6 +3694,32 @@ func registerAndLogin(t *testing.T, testServer *httptest.Server) string {
 	err = json.NewDecoder(resp.Body).Decode(&loginResponse)
 	require.NoError(t, err)
 	require.NotEmpty(t, loginResponse.Token)
+	require.NotEmpty(t, loginResponse.RefreshToken)
+
+	return loginResponse
+}
+
+// blockingHasher is a password hasher, which blocks hashing and checking until unblocked while block is set.
+type blockingHasher struct {
+	crypto.PasswordHasher
+	block   bool
+	blocked chan struct{}
+	unblock chan struct{}
+}
+
+func (h *blockingHasher) Hash(ctx context.Context, password string) (string, error) {
+	h.wait()
+	return h.PasswordHasher.Hash(ctx, password)
+}
+
+func (h *blockingHasher) Check(ctx context.Context, password, hash string) error {
+	h.wait()
+	return h.PasswordHasher.Check(ctx, password, hash)
+}
 
-	return loginResponse.Token
+func (h *blockingHasher) wait() {
+	if h.block {
+		h.blocked <- struct{}{}
+		<-h.unblock
+	}
 }
@@ -1,13 +1,28 @@
 package app
 
 import (
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/api"
 	"github.com/MSSkowron/BookRESTAPI/internal/config"
 	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/internal/services"
+	"github.com/MSSkowron/BookRESTAPI/pkg/breach"
+	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
+	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
+	"github.com/MSSkowron/BookRESTAPI/pkg/mailer"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Run runs the BookRESTAPI application.
@@ -28,9 +43,114 @@ func Run() error {
 	}
 	defer database.Close()
 
-	tokenService := services.NewTokenService(config.TokenSecret, config.TokenDuration)
-	userService := services.NewUserService(database, tokenService)
-	bookService := services.NewBookService(database)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keyRing, err := loadKeyRing(config.TokenSigningKeyFiles, config.TokenSecret, config.TokenDuration)
+	if err != nil {
+		return fmt.Errorf("failed to load token signing keys: %w", err)
+	}
+	if len(config.TokenSigningKeyFiles) > 0 {
+		go reloadKeyRingOnSignal(ctx, keyRing, config.TokenSigningKeyFiles, config.TokenDuration)
+	}
+
+	tokenService := services.NewTokenService(database, keyRing, config.TokenDuration,
+		services.WithTokenIssuer(config.TokenIssuer),
+		services.WithTokenAudience(config.TokenAudience...),
+		services.WithTokenLeeway(config.TokenLeeway),
+		services.WithLegacyTokenFormat(config.TokenAcceptLegacyFormat),
+	)
+	go tokenService.RunRevocationListCleanup(ctx, config.TokenRevocationCleanupInterval)
+
+	defaultRole := services.DefaultRole
+	if config.UserDefaultRole != "" {
+		defaultRole = models.Role(config.UserDefaultRole)
+		if !defaultRole.IsValid() {
+			return fmt.Errorf("invalid default user role: %s", config.UserDefaultRole)
+		}
+	}
+
+	mailer, err := newMailer(config)
+	if err != nil {
+		return fmt.Errorf("failed to create mailer: %w", err)
+	}
+
+	loginAttemptStore, err := newLoginAttemptStore(config, database)
+	if err != nil {
+		return fmt.Errorf("failed to create login attempt store: %w", err)
+	}
+
+	loginLimiterService := services.NewLoginLimiterService(loginAttemptStore,
+		services.WithLoginBackoff(config.LoginBackoff, config.LoginMaxBackoff),
+		services.WithAccountLockoutThreshold(config.LoginAccountLockoutThreshold),
+		services.WithIPLockoutThreshold(config.LoginIPLockoutThreshold),
+		services.WithLockoutDuration(config.LoginLockoutDuration),
+	)
+	go loginLimiterService.RunCleanup(ctx, services.DefaultLoginAttemptCleanupInterval)
+
+	oidcProvider, err := newOIDCProvider(ctx, config)
+	if err != nil {
+		return fmt.Errorf("failed to create oidc provider: %w", err)
+	}
+
+	passwordHasher, err := newPasswordHasher(config)
+	if err != nil {
+		return fmt.Errorf("failed to create password hasher: %w", err)
+	}
+
+	hashingPool := crypto.NewHashingPool(passwordHasher, config.PasswordHashingWorkers, config.PasswordHashingQueueSize, config.PasswordHashingMaxWait)
+	expvar.Publish("password_hashing", expvar.Func(func() any {
+		return hashingPool.Stats()
+	}))
+
+	breachedPasswords, err := openBreachedPasswordList(config)
+	if err != nil {
+		return fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	if breachedPasswords != nil {
+		defer breachedPasswords.Close()
+	}
+
+	passwordPolicy := services.PasswordPolicy{
+		MinLength:            config.PasswordMinLength,
+		MaxLength:            config.PasswordMaxLength,
+		RequireUppercase:     config.PasswordRequireUppercase,
+		RequireLowercase:     config.PasswordRequireLowercase,
+		RequireDigit:         config.PasswordRequireDigit,
+		RequireSymbol:        config.PasswordRequireSymbol,
+		DisallowPersonalInfo: config.PasswordDisallowPersonalInfo,
+	}
+	// bcrypt does not hash passwords longer than 72 bytes, even if they have fewer characters than the maximum
+	if config.PasswordHashAlgorithm == "" || config.PasswordHashAlgorithm == crypto.AlgorithmBcrypt {
+		passwordPolicy.MaxBytes = crypto.BcryptMaxPasswordLength
+	}
+	if breachedPasswords != nil {
+		passwordPolicy.BreachedPasswords = breachedPasswords
+	}
+
+	userService := services.NewUserService(database, tokenService,
+		services.WithRefreshTokenDuration(config.RefreshTokenDuration),
+		services.WithDefaultRole(defaultRole),
+		services.WithMailer(mailer),
+		services.WithEmailChangeTokenDuration(config.EmailChangeTokenDuration),
+		services.WithPasswordResetTokenDuration(config.PasswordResetTokenDuration),
+		services.WithPasswordResetResendInterval(config.PasswordResetResendInterval),
+		services.WithEmailVerificationRequired(config.EmailVerificationRequired),
+		services.WithEmailVerificationURL(config.EmailVerificationURL),
+		services.WithEmailVerificationTokenDuration(config.EmailVerificationTokenDuration),
+		services.WithEmailVerificationResendInterval(config.EmailVerificationResendInterval),
+		services.WithTwoFactorChallengeDuration(config.TwoFactorChallengeDuration),
+		services.WithTwoFactorIssuer(config.TwoFactorIssuer),
+		services.WithLoginLimiter(loginLimiterService),
+		services.WithOIDCProvider(oidcProvider),
+		services.WithPasswordHasher(hashingPool),
+		services.WithPasswordPolicy(passwordPolicy),
+	)
+
+	if config.BookFuzzyMatchThreshold < 0 || config.BookFuzzyMatchThreshold > 1 {
+		return fmt.Errorf("invalid book fuzzy match threshold: %v", config.BookFuzzyMatchThreshold)
+	}
+	bookService := services.NewBookService(database, services.WithFuzzyMatchThreshold(config.BookFuzzyMatchThreshold))
 
 	if err := api.NewServer(userService, bookService, tokenService, api.WithAddress(config.HTTPServerListenAddress)).ListenAndServe(); err != nil {
 		return fmt.Errorf("failed to run server: %w", err)
@@ -38,3 +158,153 @@ func Run() error {
 
 	return nil
 }
+
+// newMailer creates the mailer of the configured type.
+func newMailer(config config.Config) (mailer.Mailer, error) {
+	switch config.Mailer {
+	case "", "log":
+		return mailer.NewLogMailer(), nil
+	case "file":
+		if config.MailerFilePath == "" {
+			return nil, fmt.Errorf("file mailer requires a file path")
+		}
+
+		return mailer.NewFileMailer(config.MailerFilePath), nil
+	case "smtp":
+		if config.SMTPHost == "" || config.MailerFrom == "" {
+			return nil, fmt.Errorf("smtp mailer requires a host and a sender address")
+		}
+
+		return mailer.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailerFrom), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer: %s", config.Mailer)
+	}
+}
+
+// newLoginAttemptStore creates the store of failed login attempts of the configured type.
+func newLoginAttemptStore(config config.Config, db database.Database) (database.LoginAttemptStore, error) {
+	switch config.LoginAttemptStore {
+	case "", "memory":
+		return database.NewMemoryLoginAttemptStore(), nil
+	case "database":
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown login attempt store: %s", config.LoginAttemptStore)
+	}
+}
+
+// newOIDCProvider discovers the configured OpenID Connect provider.
+// It returns nil without an error if no provider is configured.
+func newOIDCProvider(ctx context.Context, config config.Config) (*oidc.Provider, error) {
+	if config.OIDCIssuerURL == "" {
+		return nil, nil
+	}
+	if config.OIDCClientID == "" || config.OIDCRedirectURL == "" {
+		return nil, fmt.Errorf("oidc login requires a client id and a redirect url")
+	}
+
+	return oidc.Discover(ctx, oidc.Config{
+		IssuerURL:    config.OIDCIssuerURL,
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		Scopes:       config.OIDCScopes,
+	})
+}
+
+// openBreachedPasswordList opens the configured list of breached passwords.
+// It returns nil without an error if no list is configured.
+func openBreachedPasswordList(config config.Config) (*breach.List, error) {
+	if config.PasswordBreachedListFile == "" {
+		return nil, nil
+	}
+
+	return breach.Open(config.PasswordBreachedListFile)
+}
+
+// newPasswordHasher creates the password hasher of the configured algorithm.
+func newPasswordHasher(config config.Config) (crypto.PasswordHasher, error) {
+	switch config.PasswordHashAlgorithm {
+	case "", crypto.AlgorithmBcrypt:
+		if config.PasswordBcryptCost != 0 && (config.PasswordBcryptCost < bcrypt.MinCost || config.PasswordBcryptCost > bcrypt.MaxCost) {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+
+		return crypto.NewBcryptHasher(config.PasswordBcryptCost), nil
+	case crypto.AlgorithmArgon2id:
+		return crypto.NewArgon2idHasher(crypto.Argon2idParams{
+			Memory:  config.PasswordArgon2Memory,
+			Time:    config.PasswordArgon2Time,
+			Threads: config.PasswordArgon2Threads,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %s", config.PasswordHashAlgorithm)
+	}
+}
+
+// loadKeyRing creates a key ring from the signing key files.
+// Without signing key files, tokens are signed with the HMAC secret.
+// Otherwise the HMAC secret, if set, keeps verifying tokens signed with it until they expire.
+func loadKeyRing(keyFiles []string, secret string, tokenDuration time.Duration) (*token.KeyRing, error) {
+	if len(keyFiles) == 0 {
+		return token.NewHMACKeyRing(secret), nil
+	}
+
+	keys, err := loadKeys(keyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRing := token.NewKeyRing(keys[0], keys[1:]...)
+	if secret != "" {
+		hmacKey := token.NewHMACKey("", []byte(secret))
+		hmacKey.ExpiresAt = time.Now().Add(tokenDuration)
+
+		keyRing.AddVerificationKey(hmacKey)
+	}
+
+	return keyRing, nil
+}
+
+// reloadKeyRingOnSignal reloads the signing key files whenever the process receives SIGHUP.
+// If the first file holds a new key, the key ring is rotated, so the previous signing key
+// keeps verifying tokens until the tokens it has signed expire.
+func reloadKeyRingOnSignal(ctx context.Context, keyRing *token.KeyRing, keyFiles []string, tokenDuration time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			keys, err := loadKeys(keyFiles)
+			if err != nil {
+				logger.Errorf("Error (%s) while reloading token signing keys", err)
+				continue
+			}
+
+			for _, key := range keys[1:] {
+				keyRing.AddVerificationKey(key)
+			}
+			keyRing.Rotate(keys[0], tokenDuration)
+
+			logger.Infof("Reloaded token signing keys, signing with key: %s", keys[0].ID)
+		}
+	}
+}
+
+func loadKeys(keyFiles []string) ([]*token.Key, error) {
+	keys := []*token.Key{}
+	for _, keyFile := range keyFiles {
+		key, err := token.LoadKeyFromFile("", keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load key from %s: %w", keyFile, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
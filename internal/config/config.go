@@ -13,10 +13,124 @@ type Config struct {
 	DatabaseURL string `mapstructure:"DATABASE_URL"`
 	// HTTPServerListenAddress is an address on which the HTTP server will listen.
 	HTTPServerListenAddress string `mapstructure:"HTTP_SERVER_LISTEN_ADDRESS"`
-	// TokenSecret is a secret used to sign JWT tokens.
+	// TokenSecret is a secret used to sign JWT tokens with HMAC when no signing key files are configured.
+	// When signing key files are configured, it is used only to verify tokens signed before switching to them.
 	TokenSecret string `mapstructure:"TOKEN_SECRET"`
+	// TokenSigningKeyFiles is a comma separated list of PEM files with RSA or Ed25519 private keys used to sign JWT tokens.
+	// The key from the first file signs new tokens, the remaining keys only verify tokens signed before a rotation.
+	TokenSigningKeyFiles []string `mapstructure:"TOKEN_SIGNING_KEY_FILES"`
 	// TokenDuration is a duration for which the JWT token is valid.
 	TokenDuration time.Duration `mapstructure:"TOKEN_DURATION"`
+	// RefreshTokenDuration is a duration for which the refresh token is valid.
+	RefreshTokenDuration time.Duration `mapstructure:"REFRESH_TOKEN_DURATION"`
+	// TokenIssuer is an issuer (iss) put into JWT tokens and required from validated tokens.
+	TokenIssuer string `mapstructure:"TOKEN_ISSUER"`
+	// TokenAudience is a comma separated list of recipients (aud) JWT tokens are intended for.
+	TokenAudience []string `mapstructure:"TOKEN_AUDIENCE"`
+	// TokenLeeway is a leeway allowed when validating JWT token times to account for clock skew.
+	TokenLeeway time.Duration `mapstructure:"TOKEN_LEEWAY"`
+	// TokenAcceptLegacyFormat enables accepting JWT tokens issued in the legacy format, with custom id and expiresAt claims.
+	// It should be disabled once all legacy tokens have expired.
+	TokenAcceptLegacyFormat bool `mapstructure:"TOKEN_ACCEPT_LEGACY_FORMAT"`
+	// TokenRevocationCleanupInterval is an interval between removals of expired entries from the token revocation list.
+	TokenRevocationCleanupInterval time.Duration `mapstructure:"TOKEN_REVOCATION_CLEANUP_INTERVAL"`
+	// EmailChangeTokenDuration is a duration for which the token confirming a change of the email address is valid.
+	EmailChangeTokenDuration time.Duration `mapstructure:"EMAIL_CHANGE_TOKEN_DURATION"`
+	// PasswordResetTokenDuration is a duration for which the token allowing to reset a forgotten password is valid.
+	PasswordResetTokenDuration time.Duration `mapstructure:"PASSWORD_RESET_TOKEN_DURATION"`
+	// PasswordResetResendInterval is a minimum interval between password reset emails sent to a user.
+	PasswordResetResendInterval time.Duration `mapstructure:"PASSWORD_RESET_RESEND_INTERVAL"`
+	// EmailVerificationRequired enables refusing to log in users who have not verified the email address.
+	EmailVerificationRequired bool `mapstructure:"EMAIL_VERIFICATION_REQUIRED"`
+	// EmailVerificationURL is a URL of the email verification endpoint put into verification emails.
+	EmailVerificationURL string `mapstructure:"EMAIL_VERIFICATION_URL"`
+	// EmailVerificationTokenDuration is a duration for which the token verifying the email address is valid.
+	EmailVerificationTokenDuration time.Duration `mapstructure:"EMAIL_VERIFICATION_TOKEN_DURATION"`
+	// EmailVerificationResendInterval is a minimum interval between verification emails sent to a user.
+	EmailVerificationResendInterval time.Duration `mapstructure:"EMAIL_VERIFICATION_RESEND_INTERVAL"`
+	// TwoFactorChallengeDuration is a duration for which the challenge token returned by logging in with the password
+	// is valid, if the user has two-factor authentication enabled.
+	TwoFactorChallengeDuration time.Duration `mapstructure:"TWO_FACTOR_CHALLENGE_DURATION"`
+	// TwoFactorIssuer is an issuer shown by authenticator apps next to TOTP codes.
+	TwoFactorIssuer string `mapstructure:"TWO_FACTOR_ISSUER"`
+	// LoginAttemptStore is a store of failed login attempts. It is one of:
+	// memory - keeps attempts in memory of a single instance, database - keeps attempts in the database shared by all instances.
+	LoginAttemptStore string `mapstructure:"LOGIN_ATTEMPT_STORE"`
+	// LoginBackoff is a delay required after the first failed login attempt, doubling with every subsequent one.
+	LoginBackoff time.Duration `mapstructure:"LOGIN_BACKOFF"`
+	// LoginMaxBackoff is a maximum delay required after a failed login attempt, unless the lockout threshold is reached.
+	LoginMaxBackoff time.Duration `mapstructure:"LOGIN_MAX_BACKOFF"`
+	// LoginAccountLockoutThreshold is a number of failed login attempts to an account after which it is locked.
+	LoginAccountLockoutThreshold int `mapstructure:"LOGIN_ACCOUNT_LOCKOUT_THRESHOLD"`
+	// LoginIPLockoutThreshold is a number of failed login attempts from an IP address after which it is locked.
+	LoginIPLockoutThreshold int `mapstructure:"LOGIN_IP_LOCKOUT_THRESHOLD"`
+	// LoginLockoutDuration is a duration of a lockout. Failed login attempts older than it are forgotten.
+	LoginLockoutDuration time.Duration `mapstructure:"LOGIN_LOCKOUT_DURATION"`
+	// OIDCIssuerURL is an issuer URL of the OpenID Connect provider users can log in with. Such login is disabled if it is empty.
+	OIDCIssuerURL string `mapstructure:"OIDC_ISSUER_URL"`
+	// OIDCClientID is a client ID registered at the OpenID Connect provider.
+	OIDCClientID string `mapstructure:"OIDC_CLIENT_ID"`
+	// OIDCClientSecret is a client secret registered at the OpenID Connect provider.
+	OIDCClientSecret string `mapstructure:"OIDC_CLIENT_SECRET"`
+	// OIDCRedirectURL is a URL of the OpenID Connect callback endpoint, registered at the provider as a redirect URI.
+	OIDCRedirectURL string `mapstructure:"OIDC_REDIRECT_URL"`
+	// OIDCScopes is a comma separated list of scopes requested from the OpenID Connect provider.
+	OIDCScopes []string `mapstructure:"OIDC_SCOPES"`
+	// PasswordMinLength is a minimum number of characters of a password.
+	PasswordMinLength int `mapstructure:"PASSWORD_MIN_LENGTH"`
+	// PasswordMaxLength is a maximum number of characters of a password. It is not limited if it is 0.
+	PasswordMaxLength int `mapstructure:"PASSWORD_MAX_LENGTH"`
+	// PasswordRequireUppercase requires passwords to contain an uppercase letter.
+	PasswordRequireUppercase bool `mapstructure:"PASSWORD_REQUIRE_UPPERCASE"`
+	// PasswordRequireLowercase requires passwords to contain a lowercase letter.
+	PasswordRequireLowercase bool `mapstructure:"PASSWORD_REQUIRE_LOWERCASE"`
+	// PasswordRequireDigit requires passwords to contain a digit.
+	PasswordRequireDigit bool `mapstructure:"PASSWORD_REQUIRE_DIGIT"`
+	// PasswordRequireSymbol requires passwords to contain a character which is neither a letter nor a digit.
+	PasswordRequireSymbol bool `mapstructure:"PASSWORD_REQUIRE_SYMBOL"`
+	// PasswordDisallowPersonalInfo disallows passwords containing the email address or a name of the user.
+	PasswordDisallowPersonalInfo bool `mapstructure:"PASSWORD_DISALLOW_PERSONAL_INFO"`
+	// PasswordBreachedListFile is a path to a file of SHA-1 hashes of passwords exposed in data breaches, ordered by hash,
+	// in the format of the Pwned Passwords dataset. Such passwords are disallowed. No password is disallowed if it is empty.
+	PasswordBreachedListFile string `mapstructure:"PASSWORD_BREACHED_LIST_FILE"`
+	// PasswordHashAlgorithm is an algorithm new passwords are hashed with. It is one of bcrypt or argon2id.
+	// Passwords hashed with another algorithm or other parameters are rehashed when their users log in.
+	PasswordHashAlgorithm string `mapstructure:"PASSWORD_HASH_ALGORITHM"`
+	// PasswordBcryptCost is a cost of the bcrypt algorithm.
+	PasswordBcryptCost int `mapstructure:"PASSWORD_BCRYPT_COST"`
+	// PasswordArgon2Memory is an amount of memory in KiB used by the argon2id algorithm.
+	PasswordArgon2Memory uint32 `mapstructure:"PASSWORD_ARGON2_MEMORY"`
+	// PasswordArgon2Time is a number of passes over the memory made by the argon2id algorithm.
+	PasswordArgon2Time uint32 `mapstructure:"PASSWORD_ARGON2_TIME"`
+	// PasswordArgon2Threads is a number of threads used by the argon2id algorithm.
+	PasswordArgon2Threads uint8 `mapstructure:"PASSWORD_ARGON2_THREADS"`
+	// PasswordHashingWorkers is a number of passwords hashed or checked concurrently. The number of CPUs is used if it is 0.
+	PasswordHashingWorkers int `mapstructure:"PASSWORD_HASHING_WORKERS"`
+	// PasswordHashingQueueSize is a maximum number of passwords waiting to be hashed or checked.
+	// Requests are refused with the 503 Service Unavailable status code when the queue is full.
+	PasswordHashingQueueSize int `mapstructure:"PASSWORD_HASHING_QUEUE_SIZE"`
+	// PasswordHashingMaxWait is a maximum time a password waits to be hashed or checked before the request is refused.
+	PasswordHashingMaxWait time.Duration `mapstructure:"PASSWORD_HASHING_MAX_WAIT"`
+	// Mailer is a type of the mailer used to send emails to users. It is one of:
+	// log - writes emails to the log, file - appends emails to MAILER_FILE_PATH, smtp - sends emails through the SMTP server.
+	Mailer string `mapstructure:"MAILER"`
+	// MailerFilePath is a path to the file emails are appended to by the file mailer.
+	MailerFilePath string `mapstructure:"MAILER_FILE_PATH"`
+	// MailerFrom is an email address emails are sent from by the smtp mailer.
+	MailerFrom string `mapstructure:"MAILER_FROM"`
+	// SMTPHost is a host of the SMTP server used by the smtp mailer.
+	SMTPHost string `mapstructure:"SMTP_HOST"`
+	// SMTPPort is a port of the SMTP server used by the smtp mailer.
+	SMTPPort int `mapstructure:"SMTP_PORT"`
+	// SMTPUsername is a username used to authenticate to the SMTP server. Authentication is skipped if it is empty.
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	// SMTPPassword is a password used to authenticate to the SMTP server.
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	// UserDefaultRole is a role assigned to newly registered users. It is one of reader, editor or admin.
+	UserDefaultRole string `mapstructure:"USER_DEFAULT_ROLE"`
+	// BookFuzzyMatchThreshold is a minimum word similarity, from 0 to 1, of an author or a title to the one books are filtered by
+	// for the book to match it even though it does not contain it. Fuzzy matching and suggestions are disabled if it is 0.
+	BookFuzzyMatchThreshold float64 `mapstructure:"BOOK_FUZZY_MATCH_THRESHOLD"`
 }
 
 // LoadConfig reads configuration from file or environment variables.
@@ -18,7 +18,59 @@ func TestLoadConfigValid(t *testing.T) {
 	require.Equal(t, "test_db", cfg.DatabaseURL)
 	require.Equal(t, "127.0.0.1:8080", cfg.HTTPServerListenAddress)
 	require.Equal(t, "test_secret", cfg.TokenSecret)
+	require.Equal(t, []string{"keys/new.pem", "keys/old.pem"}, cfg.TokenSigningKeyFiles)
 	require.Equal(t, time.Hour, cfg.TokenDuration)
+	require.Equal(t, 24*time.Hour, cfg.RefreshTokenDuration)
+	require.Equal(t, "test_issuer", cfg.TokenIssuer)
+	require.Equal(t, []string{"test_audience", "other_audience"}, cfg.TokenAudience)
+	require.Equal(t, 30*time.Second, cfg.TokenLeeway)
+	require.True(t, cfg.TokenAcceptLegacyFormat)
+	require.Equal(t, 30*time.Minute, cfg.TokenRevocationCleanupInterval)
+	require.Equal(t, 2*time.Hour, cfg.EmailChangeTokenDuration)
+	require.Equal(t, 30*time.Minute, cfg.PasswordResetTokenDuration)
+	require.Equal(t, 15*time.Minute, cfg.PasswordResetResendInterval)
+	require.True(t, cfg.EmailVerificationRequired)
+	require.Equal(t, "https://test.com/verify-email", cfg.EmailVerificationURL)
+	require.Equal(t, 48*time.Hour, cfg.EmailVerificationTokenDuration)
+	require.Equal(t, 10*time.Minute, cfg.EmailVerificationResendInterval)
+	require.Equal(t, 3*time.Minute, cfg.TwoFactorChallengeDuration)
+	require.Equal(t, "test_issuer_2fa", cfg.TwoFactorIssuer)
+	require.Equal(t, "database", cfg.LoginAttemptStore)
+	require.Equal(t, 2*time.Second, cfg.LoginBackoff)
+	require.Equal(t, 2*time.Minute, cfg.LoginMaxBackoff)
+	require.Equal(t, 5, cfg.LoginAccountLockoutThreshold)
+	require.Equal(t, 50, cfg.LoginIPLockoutThreshold)
+	require.Equal(t, 30*time.Minute, cfg.LoginLockoutDuration)
+	require.Equal(t, "https://accounts.test.com", cfg.OIDCIssuerURL)
+	require.Equal(t, "test_client", cfg.OIDCClientID)
+	require.Equal(t, "test_client_secret", cfg.OIDCClientSecret)
+	require.Equal(t, "https://test.com/auth/oidc/callback", cfg.OIDCRedirectURL)
+	require.Equal(t, []string{"openid", "email"}, cfg.OIDCScopes)
+	require.Equal(t, 12, cfg.PasswordMinLength)
+	require.Equal(t, 64, cfg.PasswordMaxLength)
+	require.True(t, cfg.PasswordRequireUppercase)
+	require.True(t, cfg.PasswordRequireLowercase)
+	require.False(t, cfg.PasswordRequireDigit)
+	require.True(t, cfg.PasswordRequireSymbol)
+	require.True(t, cfg.PasswordDisallowPersonalInfo)
+	require.Equal(t, "breached.txt", cfg.PasswordBreachedListFile)
+	require.Equal(t, "argon2id", cfg.PasswordHashAlgorithm)
+	require.Equal(t, 12, cfg.PasswordBcryptCost)
+	require.Equal(t, uint32(32768), cfg.PasswordArgon2Memory)
+	require.Equal(t, uint32(2), cfg.PasswordArgon2Time)
+	require.Equal(t, uint8(2), cfg.PasswordArgon2Threads)
+	require.Equal(t, 2, cfg.PasswordHashingWorkers)
+	require.Equal(t, 16, cfg.PasswordHashingQueueSize)
+	require.Equal(t, 3*time.Second, cfg.PasswordHashingMaxWait)
+	require.Equal(t, "reader", cfg.UserDefaultRole)
+	require.Equal(t, 0.5, cfg.BookFuzzyMatchThreshold)
+	require.Equal(t, "smtp", cfg.Mailer)
+	require.Equal(t, "mail.txt", cfg.MailerFilePath)
+	require.Equal(t, "noreply@test.com", cfg.MailerFrom)
+	require.Equal(t, "smtp.test.com", cfg.SMTPHost)
+	require.Equal(t, 2525, cfg.SMTPPort)
+	require.Equal(t, "test_user", cfg.SMTPUsername)
+	require.Equal(t, "test_password", cfg.SMTPPassword)
 }
 
 func TestLoadConfigInvalidPath(t *testing.T) {
@@ -44,8 +96,164 @@ func createTempConfigFile(t *testing.T) string {
 	_, err = file.WriteString("TOKEN_SECRET=test_secret\n")
 	require.NoError(t, err)
 
+	_, err = file.WriteString("TOKEN_SIGNING_KEY_FILES=keys/new.pem,keys/old.pem\n")
+	require.NoError(t, err)
+
 	_, err = file.WriteString("TOKEN_DURATION=1h\n")
 	require.NoError(t, err)
 
+	_, err = file.WriteString("REFRESH_TOKEN_DURATION=24h\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TOKEN_ISSUER=test_issuer\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TOKEN_AUDIENCE=test_audience,other_audience\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TOKEN_LEEWAY=30s\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TOKEN_ACCEPT_LEGACY_FORMAT=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TOKEN_REVOCATION_CLEANUP_INTERVAL=30m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("EMAIL_CHANGE_TOKEN_DURATION=2h\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_RESET_TOKEN_DURATION=30m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_RESET_RESEND_INTERVAL=15m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("EMAIL_VERIFICATION_REQUIRED=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("EMAIL_VERIFICATION_URL=https://test.com/verify-email\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("EMAIL_VERIFICATION_TOKEN_DURATION=48h\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("EMAIL_VERIFICATION_RESEND_INTERVAL=10m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TWO_FACTOR_CHALLENGE_DURATION=3m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("TWO_FACTOR_ISSUER=test_issuer_2fa\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_ATTEMPT_STORE=database\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_BACKOFF=2s\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_MAX_BACKOFF=2m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_ACCOUNT_LOCKOUT_THRESHOLD=5\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_IP_LOCKOUT_THRESHOLD=50\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("LOGIN_LOCKOUT_DURATION=30m\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("OIDC_ISSUER_URL=https://accounts.test.com\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("OIDC_CLIENT_ID=test_client\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("OIDC_CLIENT_SECRET=test_client_secret\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("OIDC_REDIRECT_URL=https://test.com/auth/oidc/callback\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("OIDC_SCOPES=openid,email\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_MIN_LENGTH=12\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_MAX_LENGTH=64\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_REQUIRE_UPPERCASE=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_REQUIRE_LOWERCASE=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_REQUIRE_DIGIT=false\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_REQUIRE_SYMBOL=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_DISALLOW_PERSONAL_INFO=true\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_BREACHED_LIST_FILE=breached.txt\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_HASH_ALGORITHM=argon2id\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_BCRYPT_COST=12\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_ARGON2_MEMORY=32768\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_ARGON2_TIME=2\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_ARGON2_THREADS=2\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_HASHING_WORKERS=2\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_HASHING_QUEUE_SIZE=16\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("PASSWORD_HASHING_MAX_WAIT=3s\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("USER_DEFAULT_ROLE=reader\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("BOOK_FUZZY_MATCH_THRESHOLD=0.5\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("MAILER=smtp\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("MAILER_FILE_PATH=mail.txt\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("MAILER_FROM=noreply@test.com\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("SMTP_HOST=smtp.test.com\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("SMTP_PORT=2525\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("SMTP_USERNAME=test_user\n")
+	require.NoError(t, err)
+
+	_, err = file.WriteString("SMTP_PASSWORD=test_password\n")
+	require.NoError(t, err)
+
 	return configFile
 }
@@ -1,18 +1,71 @@
 package database
 
 import (
+	"time"
+
 	"github.com/MSSkowron/BookRESTAPI/internal/models"
 )
 
 // Database is an interface for database operations.
 type Database interface {
+	LoginAttemptStore
 	InsertUser(*models.User) (int, error)
 	SelectUserByID(int) (*models.User, error)
 	SelectUserByEmail(string) (*models.User, error)
+	SelectUserByOIDCIdentity(string, string) (*models.User, error)
+	SelectUsers(string, int, int) ([]*models.User, error)
+	CountUsers(string) (int, error)
+	UpdateUser(int, *models.User) error
+	UpdateUserPassword(int, string) error
+	UpdateUserEmail(int, string) error
+	UpdateUserVerifiedAt(int, time.Time) error
+	UpdateUserVerificationSentAt(int, time.Time, time.Time) (bool, error)
+	UpdateUserPasswordResetSentAt(int, time.Time, time.Time) (bool, error)
+	UpdateUserTOTP(int, string, *time.Time) error
+	UpdateUserTOTPLastUsedStep(int, int64) (bool, error)
+	UpdateUserOIDCIdentity(int, string, string) error
+	UpdateUserDisabledAt(int, *time.Time) error
+	UpdateUserRole(int, models.Role) error
+	DeleteUser(int) error
 	InsertBook(*models.Book) (int, error)
 	SelectBookByID(int) (*models.Book, error)
-	SelectAllBooks() ([]*models.Book, error)
+	SelectBooks(*models.BookQuery) ([]*models.Book, error)
+	CountBooks(*models.BookFilter) (int, error)
+	SelectSimilarAuthors(string, float64, int) ([]string, error)
+	SelectSimilarTitles(string, float64, int) ([]string, error)
+	SearchBooks(*models.BookSearch) ([]*models.BookSearchResult, error)
+	CountSearchedBooks([]string) (int, error)
 	DeleteBook(int) error
 	UpdateBook(int, *models.Book) error
+	InsertRefreshToken(*models.RefreshToken) (int, error)
+	SelectRefreshTokenByHash(string) (*models.RefreshToken, error)
+	RevokeRefreshToken(int) (bool, error)
+	RevokeRefreshTokenFamily(string) error
+	RevokeUserRefreshTokens(int) error
+	InsertRevokedToken(*models.RevokedToken) error
+	IsTokenRevoked(string, int, time.Time) (bool, error)
+	DeleteExpiredRevokedTokens(time.Time) (int, error)
+	InsertSession(*models.Session) (int, error)
+	SelectSessionByID(int) (*models.Session, error)
+	SelectSessionByFamilyID(string) (*models.Session, error)
+	SelectSessionsByUserID(int) ([]*models.Session, error)
+	UpdateSessionActivity(int, time.Time, string, string, time.Time) error
+	UpdateSessionLastUsedAt(int, time.Time) error
+	TerminateSession(int, int) (bool, error)
+	TerminateUserSessions(int) error
+	InsertUserToken(*models.UserToken) (int, error)
+	SelectUserTokenByHash(string) (*models.UserToken, error)
+	UseUserToken(int) (bool, error)
+	InvalidateUserTokens(int, string) error
+	ReplaceRecoveryCodes(int, []*models.RecoveryCode) error
+	UseRecoveryCode(int, string) (bool, error)
+	InsertAPIKey(*models.APIKey) (int, error)
+	SelectAPIKeyByHash(string) (*models.APIKey, error)
+	SelectAPIKeysByUserID(int) ([]*models.APIKey, error)
+	RevokeAPIKey(int, int) (bool, error)
+	UpdateAPIKeyLastUsedAt(int, time.Time) error
+	InsertOIDCLoginRequest(*models.OIDCLoginRequest) (int, error)
+	TakeOIDCLoginRequest(string) (*models.OIDCLoginRequest, error)
+	DeleteExpiredOIDCLoginRequests(time.Time) (int, error)
 	Close()
 }
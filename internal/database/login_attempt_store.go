@@ -0,0 +1,139 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
+)
+
+// LoginAttemptStore is an interface for storing failed and pending login attempts.
+type LoginAttemptStore interface {
+	SelectLoginAttempt(string) (*models.LoginAttempt, error)
+	ReserveLoginAttempt(string, time.Time, time.Time, time.Time) (*models.LoginAttempt, error)
+	ReleaseLoginAttempt(string) error
+	FailLoginAttempt(string, time.Time, time.Time) (*models.LoginAttempt, error)
+	DeleteLoginAttempt(string) error
+	DeleteExpiredLoginAttempts(time.Time) (int, error)
+}
+
+// MemoryLoginAttemptStore is an in-memory implementation of LoginAttemptStore.
+// The attempts are not shared between instances, so it is suitable only for a single instance deployment.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+}
+
+// NewMemoryLoginAttemptStore creates a new MemoryLoginAttemptStore.
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: map[string]*models.LoginAttempt{},
+	}
+}
+
+// SelectLoginAttempt selects failed login attempts tracked for the given key.
+func (s *MemoryLoginAttemptStore) SelectLoginAttempt(key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	attemptCopy := *attempt
+
+	return &attemptCopy, nil
+}
+
+// ReserveLoginAttempt records a pending login attempt for the given key and returns the updated attempts.
+// Failures recorded before failuresResetBefore are forgotten, so counting starts over,
+// and so are pending attempts if the last one has been reserved before pendingResetBefore, as they have been abandoned.
+func (s *MemoryLoginAttemptStore) ReserveLoginAttempt(key string, reservedAt, failuresResetBefore, pendingResetBefore time.Time) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	if attempt.LastFailureAt.Before(failuresResetBefore) {
+		attempt.Failures = 0
+	}
+	if attempt.LastReservedAt.Before(pendingResetBefore) {
+		attempt.Pending = 0
+	}
+
+	attempt.Pending++
+	attempt.LastReservedAt = reservedAt
+
+	attemptCopy := *attempt
+
+	return &attemptCopy, nil
+}
+
+// ReleaseLoginAttempt takes back a pending login attempt recorded for the given key, leaving failures untouched.
+func (s *MemoryLoginAttemptStore) ReleaseLoginAttempt(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok && attempt.Pending > 0 {
+		attempt.Pending--
+	}
+
+	return nil
+}
+
+// FailLoginAttempt records a pending login attempt for the given key as failed and returns the updated attempts.
+// Failures recorded before resetBefore are forgotten, so counting starts over.
+func (s *MemoryLoginAttemptStore) FailLoginAttempt(key string, failedAt, resetBefore time.Time) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	if attempt.LastFailureAt.Before(resetBefore) {
+		attempt.Failures = 0
+	}
+	if attempt.Pending > 0 {
+		attempt.Pending--
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = failedAt
+
+	attemptCopy := *attempt
+
+	return &attemptCopy, nil
+}
+
+// DeleteLoginAttempt deletes failed login attempts tracked for the given key.
+func (s *MemoryLoginAttemptStore) DeleteLoginAttempt(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+// DeleteExpiredLoginAttempts deletes login attempts whose last failure has been recorded and whose last attempt has been reserved
+// before the given time. It returns the number of deleted entries.
+func (s *MemoryLoginAttemptStore) DeleteExpiredLoginAttempts(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for key, attempt := range s.attempts {
+		if attempt.LastFailureAt.Before(before) && attempt.LastReservedAt.Before(before) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -2,50 +2,79 @@ package database
 
 import (
 	"fmt"
+	"html"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/models"
 )
 
 // MockDatabase is a mock implementation of Database interface.
+// Login attempts are stored by the embedded MemoryLoginAttemptStore.
 type MockDatabase struct {
-	userMu sync.RWMutex
-	bookMu sync.RWMutex
-	users  []*models.User
-	books  []*models.Book
+	*MemoryLoginAttemptStore
+	userMu         sync.RWMutex
+	bookMu         sync.RWMutex
+	refreshTokenMu sync.RWMutex
+	revokedTokenMu sync.RWMutex
+	sessionMu      sync.RWMutex
+	userTokenMu    sync.RWMutex
+	recoveryCodeMu sync.RWMutex
+	apiKeyMu       sync.RWMutex
+	oidcRequestMu  sync.RWMutex
+	users          []*models.User
+	books          []*models.Book
+	refreshTokens  []*models.RefreshToken
+	revokedTokens  []*models.RevokedToken
+	sessions       []*models.Session
+	userTokens     []*models.UserToken
+	recoveryCodes  []*models.RecoveryCode
+	apiKeys        []*models.APIKey
+	oidcRequests   []*models.OIDCLoginRequest
 }
 
 // NewMockDatabase creates a new MockDatabase.
 func NewMockDatabase() Database {
+	verifiedAt := time.Now()
+
 	return &MockDatabase{
+		MemoryLoginAttemptStore: NewMemoryLoginAttemptStore(),
 		users: []*models.User{
 			{
-				ID:        1,
-				CreatedAt: time.Now(),
-				Email:     "johndoe@net.eu",
-				Password:  "johnpassword",
-				FirstName: "John",
-				LastName:  "Doe",
-				Age:       30,
+				ID:         1,
+				CreatedAt:  time.Now(),
+				Email:      "johndoe@net.eu",
+				Password:   "johnpassword",
+				FirstName:  "John",
+				LastName:   "Doe",
+				Age:        30,
+				Role:       models.RoleAdmin,
+				VerifiedAt: &verifiedAt,
 			},
 			{
-				ID:        2,
-				CreatedAt: time.Now(),
-				Email:     "janedoe@net.eu",
-				Password:  "janepassword",
-				FirstName: "Jane",
-				LastName:  "Doe",
-				Age:       25,
+				ID:         2,
+				CreatedAt:  time.Now(),
+				Email:      "janedoe@net.eu",
+				Password:   "janepassword",
+				FirstName:  "Jane",
+				LastName:   "Doe",
+				Age:        25,
+				Role:       models.RoleEditor,
+				VerifiedAt: &verifiedAt,
 			},
 			{
-				ID:        3,
-				CreatedAt: time.Now(),
-				Email:     "jankowalski@net.pl",
-				Password:  "janpassword",
-				FirstName: "Jan",
-				LastName:  "Kowalski",
-				Age:       30,
+				ID:         3,
+				CreatedAt:  time.Now(),
+				Email:      "jankowalski@net.pl",
+				Password:   "janpassword",
+				FirstName:  "Jan",
+				LastName:   "Kowalski",
+				Age:        30,
+				Role:       models.RoleReader,
+				VerifiedAt: &verifiedAt,
 			},
 		},
 		books: []*models.Book{
@@ -82,7 +111,11 @@ func (db *MockDatabase) InsertUser(user *models.User) (int, error) {
 	db.userMu.Lock()
 	defer db.userMu.Unlock()
 
-	user.ID = len(db.users) + 1
+	user.ID = 1
+	if len(db.users) > 0 {
+		// IDs follow the last one, so they do not collide after deleting users.
+		user.ID = db.users[len(db.users)-1].ID + 1
+	}
 
 	for _, u := range db.users {
 		if u.Email == user.Email {
@@ -92,7 +125,7 @@ func (db *MockDatabase) InsertUser(user *models.User) (int, error) {
 
 	db.users = append(db.users, user)
 
-	return len(db.users), nil
+	return user.ID, nil
 }
 
 // SelectUserByID selects a user with given ID from the database.
@@ -123,16 +156,358 @@ func (db *MockDatabase) SelectUserByEmail(email string) (*models.User, error) {
 	return nil, nil
 }
 
+// SelectUserByOIDCIdentity selects a user linked to the identity with given subject at the OpenID Connect provider with given issuer.
+func (db *MockDatabase) SelectUserByOIDCIdentity(issuer, subject string) (*models.User, error) {
+	db.userMu.RLock()
+	defer db.userMu.RUnlock()
+
+	for _, user := range db.users {
+		if user.OIDCSubject != "" && user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return user, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SelectUsers selects at most limit users, skipping the first offset ones, ordered by ID from the database.
+// If the query is not empty, only users whose email address, first name or last name contains it, ignoring case, are selected.
+func (db *MockDatabase) SelectUsers(query string, limit, offset int) ([]*models.User, error) {
+	db.userMu.RLock()
+	defer db.userMu.RUnlock()
+
+	users := []*models.User{}
+	for _, user := range db.users {
+		if !userMatches(user, query) {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(users) == limit {
+			break
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// CountUsers counts users whose email address, first name or last name contains the query, ignoring case, in the database.
+// If the query is empty, all users are counted.
+func (db *MockDatabase) CountUsers(query string) (int, error) {
+	db.userMu.RLock()
+	defer db.userMu.RUnlock()
+
+	count := 0
+	for _, user := range db.users {
+		if userMatches(user, query) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// userMatches reports whether the email address, first name or last name of the user contains the query, ignoring case.
+func userMatches(user *models.User, query string) bool {
+	query = strings.ToLower(query)
+
+	return strings.Contains(strings.ToLower(user.Email), query) ||
+		strings.Contains(strings.ToLower(user.FirstName), query) ||
+		strings.Contains(strings.ToLower(user.LastName), query)
+}
+
+// UpdateUser updates the first name, last name and age of a user with given ID in the database.
+func (db *MockDatabase) UpdateUser(id int, user *models.User) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for i, u := range db.users {
+		if u.ID == id {
+			db.users[i].FirstName = user.FirstName
+			db.users[i].LastName = user.LastName
+			db.users[i].Age = user.Age
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserPassword updates the password hash of a user with given ID in the database.
+func (db *MockDatabase) UpdateUserPassword(id int, password string) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.Password = password
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserEmail updates the email address of a user with given ID in the database.
+func (db *MockDatabase) UpdateUserEmail(id int, email string) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.Email == email && user.ID != id {
+			return fmt.Errorf("user with email %s already exists", email)
+		}
+	}
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.Email = email
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserVerifiedAt sets the time a user with given ID has verified the email address at in the database.
+func (db *MockDatabase) UpdateUserVerifiedAt(id int, verifiedAt time.Time) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.VerifiedAt = &verifiedAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserVerificationSentAt sets the time the last verification email has been sent to a user with given ID at,
+// if no verification email has been sent to the user after sentBefore.
+// It reports whether the time has been set by this call.
+func (db *MockDatabase) UpdateUserVerificationSentAt(id int, sentAt, sentBefore time.Time) (bool, error) {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			if user.VerificationSentAt != nil && user.VerificationSentAt.After(sentBefore) {
+				return false, nil
+			}
+
+			user.VerificationSentAt = &sentAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UpdateUserPasswordResetSentAt sets the time the last password reset email has been sent to a user with given ID at,
+// if no password reset email has been sent to the user after sentBefore.
+// It reports whether the time has been set by this call.
+func (db *MockDatabase) UpdateUserPasswordResetSentAt(id int, sentAt, sentBefore time.Time) (bool, error) {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			if user.PasswordResetSentAt != nil && user.PasswordResetSentAt.After(sentBefore) {
+				return false, nil
+			}
+
+			user.PasswordResetSentAt = &sentAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UpdateUserTOTP sets the TOTP secret of a user with given ID and the time two-factor authentication has been enabled at,
+// nil if it is not enabled yet, in the database. The last used TOTP step is reset.
+func (db *MockDatabase) UpdateUserTOTP(id int, secret string, enabledAt *time.Time) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.TOTPSecret = secret
+			user.TOTPEnabledAt = enabledAt
+			user.TOTPLastUsedStep = 0
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserTOTPLastUsedStep sets the last TOTP step a user with given ID has logged in with, if it is after the current one.
+// It reports whether the step has been set by this call, so a code cannot be used twice.
+func (db *MockDatabase) UpdateUserTOTPLastUsedStep(id int, step int64) (bool, error) {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id && user.TOTPLastUsedStep < step {
+			user.TOTPLastUsedStep = step
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UpdateUserOIDCIdentity links a user with given ID to the identity with given subject at the OpenID Connect provider with given issuer.
+func (db *MockDatabase) UpdateUserOIDCIdentity(id int, issuer, subject string) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID != id && user.OIDCSubject != "" && user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return fmt.Errorf("OIDC identity %s is already linked to user with ID %d", subject, user.ID)
+		}
+	}
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.OIDCIssuer = issuer
+			user.OIDCSubject = subject
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserDisabledAt sets the time a user with given ID has been disabled at, nil to enable the user, in the database.
+func (db *MockDatabase) UpdateUserDisabledAt(id int, disabledAt *time.Time) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.DisabledAt = disabledAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateUserRole updates the role of a user with given ID in the database.
+func (db *MockDatabase) UpdateUserRole(id int, role models.Role) error {
+	db.userMu.Lock()
+	defer db.userMu.Unlock()
+
+	for _, user := range db.users {
+		if user.ID == id {
+			user.Role = role
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user with given ID from the database.
+// Refresh tokens, sessions, user tokens and API keys of the user are deleted as well, while the books the user has created are kept without a creator.
+func (db *MockDatabase) DeleteUser(id int) error {
+	db.userMu.Lock()
+	for i, user := range db.users {
+		if user.ID == id {
+			db.users = append(db.users[:i], db.users[i+1:]...)
+			break
+		}
+	}
+	db.userMu.Unlock()
+
+	db.bookMu.Lock()
+	for _, book := range db.books {
+		if book.CreatedBy == id {
+			book.CreatedBy = 0
+		}
+	}
+	db.bookMu.Unlock()
+
+	db.refreshTokenMu.Lock()
+	refreshTokens := []*models.RefreshToken{}
+	for _, token := range db.refreshTokens {
+		if token.UserID != id {
+			refreshTokens = append(refreshTokens, token)
+		}
+	}
+	db.refreshTokens = refreshTokens
+	db.refreshTokenMu.Unlock()
+
+	db.sessionMu.Lock()
+	sessions := []*models.Session{}
+	for _, session := range db.sessions {
+		if session.UserID != id {
+			sessions = append(sessions, session)
+		}
+	}
+	db.sessions = sessions
+	db.sessionMu.Unlock()
+
+	db.userTokenMu.Lock()
+	userTokens := []*models.UserToken{}
+	for _, token := range db.userTokens {
+		if token.UserID != id {
+			userTokens = append(userTokens, token)
+		}
+	}
+	db.userTokens = userTokens
+	db.userTokenMu.Unlock()
+
+	db.recoveryCodeMu.Lock()
+	recoveryCodes := []*models.RecoveryCode{}
+	for _, code := range db.recoveryCodes {
+		if code.UserID != id {
+			recoveryCodes = append(recoveryCodes, code)
+		}
+	}
+	db.recoveryCodes = recoveryCodes
+	db.recoveryCodeMu.Unlock()
+
+	db.apiKeyMu.Lock()
+	apiKeys := []*models.APIKey{}
+	for _, key := range db.apiKeys {
+		if key.UserID != id {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	db.apiKeys = apiKeys
+	db.apiKeyMu.Unlock()
+
+	return nil
+}
+
 // InsertBook inserts a new book into the database.
 func (db *MockDatabase) InsertBook(book *models.Book) (int, error) {
 	db.bookMu.Lock()
 	defer db.bookMu.Unlock()
 
-	book.ID = len(db.books) + 1
+	book.ID = 1
+	if len(db.books) > 0 {
+		// IDs follow the last one, so they do not collide after deleting books.
+		book.ID = db.books[len(db.books)-1].ID + 1
+	}
 
 	db.books = append(db.books, book)
 
-	return len(db.books), nil
+	return book.ID, nil
 }
 
 // SelectBookByID selects a book with given ID from the database.
@@ -149,42 +524,896 @@ func (db *MockDatabase) SelectBookByID(id int) (*models.Book, error) {
 	return nil, nil
 }
 
-// SelectAllBooks selects all books from the database.
-func (db *MockDatabase) SelectAllBooks() ([]*models.Book, error) {
+// SelectBooks selects a page of the books matching the filter of the query, sorted by the sort of the query, from the database.
+func (db *MockDatabase) SelectBooks(query *models.BookQuery) ([]*models.Book, error) {
+	if !query.Sort.Field.IsValid() {
+		return nil, fmt.Errorf("unknown book sort field: %s", query.Sort.Field)
+	}
+
 	db.bookMu.RLock()
-	defer db.bookMu.RUnlock()
+	books := slices.DeleteFunc(slices.Clone(db.books), func(book *models.Book) bool {
+		return !bookMatches(book, &query.Filter)
+	})
+	db.bookMu.RUnlock()
+
+	slices.SortFunc(books, func(a, b *models.Book) int {
+		return compareBookCursor(a, bookCursor(b), query.Sort)
+	})
+
+	page := &query.Page
+	switch {
+	case page.After != nil:
+		books = slices.DeleteFunc(books, func(book *models.Book) bool {
+			return compareBookCursor(book, page.After, query.Sort) <= 0
+		})
+	case page.Before != nil:
+		books = slices.DeleteFunc(books, func(book *models.Book) bool {
+			return compareBookCursor(book, page.Before, query.Sort) >= 0
+		})
+		// The closest books preceding the cursor are kept.
+		books = books[max(len(books)-page.Limit, 0):]
+	default:
+		books = books[min(page.Offset, len(books)):]
+	}
 
-	return db.books, nil
+	return books[:min(page.Limit, len(books))], nil
 }
 
-// DeleteBook deletes a book with given ID from the database.
-func (db *MockDatabase) DeleteBook(id int) error {
-	db.bookMu.Lock()
-	defer db.bookMu.Unlock()
+// CountBooks counts the books matching the filter in the database.
+func (db *MockDatabase) CountBooks(filter *models.BookFilter) (int, error) {
+	db.bookMu.RLock()
+	defer db.bookMu.RUnlock()
 
-	for i, book := range db.books {
-		if book.ID == id {
-			db.books = append(db.books[:i], db.books[i+1:]...)
-			return nil
+	count := 0
+	for _, book := range db.books {
+		if bookMatches(book, filter) {
+			count++
 		}
 	}
 
-	return nil
+	return count, nil
 }
 
-// UpdateBook updates a book with given ID in the database.
-func (db *MockDatabase) UpdateBook(id int, book *models.Book) error {
-	db.bookMu.Lock()
-	defer db.bookMu.Unlock()
+// bookMatches reports whether the book matches all fields of the filter which are set.
+func bookMatches(book *models.Book, filter *models.BookFilter) bool {
+	if filter.Author != "" && !valueMatches(book.Author, filter.Author, filter.Similarity) {
+		return false
+	}
+	if filter.Title != "" && !valueMatches(book.Title, filter.Title, filter.Similarity) {
+		return false
+	}
+	if filter.CreatedBy != 0 && book.CreatedBy != filter.CreatedBy {
+		return false
+	}
+	if filter.CreatedFrom != nil && book.CreatedAt.Before(*filter.CreatedFrom) {
+		return false
+	}
+	if filter.CreatedTo != nil && !book.CreatedAt.Before(*filter.CreatedTo) {
+		return false
+	}
 
-	for i, b := range db.books {
-		if b.ID == id {
-			db.books[i].Author = book.Author
-			db.books[i].Title = book.Title
+	return true
+}
 
-			return nil
-		}
+// valueMatches reports whether the text contains the value, ignoring case, or has a word similarity to it of at least the similarity, if it is positive.
+func valueMatches(text, value string, similarity float64) bool {
+	if strings.Contains(strings.ToLower(text), strings.ToLower(value)) {
+		return true
 	}
 
-	return nil
+	return similarity > 0 && wordSimilarity(value, text) >= similarity
+}
+
+// SelectSimilarAuthors selects at most limit distinct authors of books from the database,
+// which have a word similarity to the value of at least the given similarity but do not contain it, the most similar first.
+func (db *MockDatabase) SelectSimilarAuthors(value string, similarity float64, limit int) ([]string, error) {
+	db.bookMu.RLock()
+	defer db.bookMu.RUnlock()
+
+	authors := make([]string, 0, len(db.books))
+	for _, book := range db.books {
+		authors = append(authors, book.Author)
+	}
+
+	return similarValues(authors, value, similarity, limit), nil
+}
+
+// SelectSimilarTitles selects at most limit distinct titles of books from the database,
+// which have a word similarity to the value of at least the given similarity but do not contain it, the most similar first.
+func (db *MockDatabase) SelectSimilarTitles(value string, similarity float64, limit int) ([]string, error) {
+	db.bookMu.RLock()
+	defer db.bookMu.RUnlock()
+
+	titles := make([]string, 0, len(db.books))
+	for _, book := range db.books {
+		titles = append(titles, book.Title)
+	}
+
+	return similarValues(titles, value, similarity, limit), nil
+}
+
+// similarValues returns at most limit distinct texts similar to the value, the most similar first.
+func similarValues(texts []string, value string, similarity float64, limit int) []string {
+	similarities := map[string]float64{}
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), strings.ToLower(value)) {
+			continue
+		}
+
+		if textSimilarity := wordSimilarity(value, text); textSimilarity >= similarity {
+			similarities[text] = textSimilarity
+		}
+	}
+
+	values := make([]string, 0, len(similarities))
+	for text := range similarities {
+		values = append(values, text)
+	}
+
+	slices.SortFunc(values, func(a, b string) int {
+		if similarities[a] != similarities[b] {
+			if similarities[a] > similarities[b] {
+				return -1
+			}
+			return 1
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	return values[:min(limit, len(values))]
+}
+
+// wordSimilarity returns the greatest similarity of the trigrams of the value to the trigrams of a continuous extent of the text,
+// which is the number of trigrams they have in common divided by the number of all of their distinct trigrams, as word_similarity of pg_trgm.
+func wordSimilarity(value, text string) float64 {
+	valueTrigrams := map[string]bool{}
+	for _, trigram := range trigrams(value) {
+		valueTrigrams[trigram] = true
+	}
+	if len(valueTrigrams) == 0 {
+		return 0
+	}
+
+	textTrigrams := trigrams(text)
+
+	similarity := 0.0
+	for i := range textTrigrams {
+		extentTrigrams, common := map[string]bool{}, 0
+		for _, trigram := range textTrigrams[i:] {
+			if extentTrigrams[trigram] {
+				continue
+			}
+
+			extentTrigrams[trigram] = true
+			if valueTrigrams[trigram] {
+				common++
+			}
+
+			similarity = max(similarity, float64(common)/float64(len(valueTrigrams)+len(extentTrigrams)-common))
+		}
+	}
+
+	return similarity
+}
+
+// trigrams returns the trigrams of the lowercase words of letters and digits of the text in order,
+// with each word padded by two spaces in front and one space behind, as pg_trgm extracts them.
+func trigrams(text string) []string {
+	result := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result = append(result, string(padded[i:i+3]))
+		}
+	}
+
+	return result
+}
+
+// bookCursor returns the position of the book.
+func bookCursor(book *models.Book) *models.BookCursor {
+	return &models.BookCursor{
+		Author:    book.Author,
+		Title:     book.Title,
+		CreatedBy: book.CreatedBy,
+		CreatedAt: book.CreatedAt,
+		ID:        book.ID,
+	}
+}
+
+// compareBookCursor compares the position of the book in the list of books sorted by the sort with the cursor.
+func compareBookCursor(book *models.Book, cursor *models.BookCursor, sort models.BookSort) int {
+	c := 0
+	switch sort.Field {
+	case models.BookSortFieldAuthor:
+		c = strings.Compare(book.Author, cursor.Author)
+	case models.BookSortFieldTitle:
+		c = strings.Compare(book.Title, cursor.Title)
+	case models.BookSortFieldCreatedBy:
+		c = book.CreatedBy - cursor.CreatedBy
+	case models.BookSortFieldCreatedAt:
+		c = book.CreatedAt.Compare(cursor.CreatedAt)
+	}
+	if c == 0 {
+		c = book.ID - cursor.ID
+	}
+
+	if sort.Descending {
+		return -c
+	}
+
+	return c
+}
+
+// SearchBooks selects a page of the books matching the search from the database, the most relevant first.
+// Unlike PostgreSQL, it reduces the words to their stems by trimming a few common English suffixes only.
+func (db *MockDatabase) SearchBooks(search *models.BookSearch) ([]*models.BookSearchResult, error) {
+	db.bookMu.RLock()
+	defer db.bookMu.RUnlock()
+
+	results := []*models.BookSearchResult{}
+	for _, book := range db.books {
+		if result := searchBook(book, search.Terms); result != nil {
+			results = append(results, result)
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b *models.BookSearchResult) int {
+		if a.Rank != b.Rank {
+			if a.Rank > b.Rank {
+				return -1
+			}
+			return 1
+		}
+
+		return a.Book.ID - b.Book.ID
+	})
+
+	results = results[min(search.Offset, len(results)):]
+	results = results[:min(search.Limit, len(results))]
+
+	return results, nil
+}
+
+// CountSearchedBooks counts the books matching all of the search terms in the database.
+func (db *MockDatabase) CountSearchedBooks(terms []string) (int, error) {
+	db.bookMu.RLock()
+	defer db.bookMu.RUnlock()
+
+	count := 0
+	for _, book := range db.books {
+		if searchBook(book, terms) != nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// searchBook matches the book against all of the search terms, returning nil if any of them does not match.
+// Matching words weigh 1 in the title and 0.4 in the author, like the default weights of ts_rank in PostgreSQL.
+func searchBook(book *models.Book, terms []string) *models.BookSearchResult {
+	matched := make([]bool, len(terms))
+
+	titleHighlight, titleMatches := highlightWords(book.Title, terms, matched)
+	authorHighlight, authorMatches := highlightWords(book.Author, terms, matched)
+
+	if slices.Contains(matched, false) {
+		return nil
+	}
+
+	return &models.BookSearchResult{
+		Book:            book,
+		Rank:            float64(titleMatches) + 0.4*float64(authorMatches),
+		TitleHighlight:  titleHighlight,
+		AuthorHighlight: authorHighlight,
+	}
+}
+
+// highlightWords marks the words of the text whose stems start with the stem of any of the terms, setting the matched terms.
+// The text is HTML escaped like by the PostgreSQL database. It returns the highlighted text and the number of matching words.
+func highlightWords(text string, terms []string, matched []bool) (string, int) {
+	highlight, word, matches := strings.Builder{}, strings.Builder{}, 0
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+
+		match := false
+		for i, term := range terms {
+			if strings.HasPrefix(stemWord(word.String()), stemWord(term)) {
+				matched[i], match = true, true
+			}
+		}
+
+		if match {
+			matches++
+			highlight.WriteString("<mark>" + html.EscapeString(word.String()) + "</mark>")
+		} else {
+			highlight.WriteString(html.EscapeString(word.String()))
+		}
+		word.Reset()
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+
+		flush()
+		highlight.WriteString(html.EscapeString(string(r)))
+	}
+	flush()
+
+	return highlight.String(), matches
+}
+
+// stemWord reduces the word to its stem, lowercasing it and trimming a common English suffix if enough of the word remains.
+func stemWord(word string) string {
+	word = strings.ToLower(word)
+	for _, suffix := range []string{"ing", "es", "ed", "s"} {
+		if stem, ok := strings.CutSuffix(word, suffix); ok && len(stem) >= 3 {
+			return stem
+		}
+	}
+
+	return word
+}
+
+// DeleteBook deletes a book with given ID from the database.
+func (db *MockDatabase) DeleteBook(id int) error {
+	db.bookMu.Lock()
+	defer db.bookMu.Unlock()
+
+	for i, book := range db.books {
+		if book.ID == id {
+			db.books = append(db.books[:i], db.books[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateBook updates a book with given ID in the database.
+func (db *MockDatabase) UpdateBook(id int, book *models.Book) error {
+	db.bookMu.Lock()
+	defer db.bookMu.Unlock()
+
+	for i, b := range db.books {
+		if b.ID == id {
+			db.books[i].Author = book.Author
+			db.books[i].Title = book.Title
+			db.books[i].ISBN = book.ISBN
+			db.books[i].Publisher = book.Publisher
+			db.books[i].PublicationYear = book.PublicationYear
+			db.books[i].Language = book.Language
+			db.books[i].PageCount = book.PageCount
+			db.books[i].Description = book.Description
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// InsertRefreshToken inserts a new refresh token into the database.
+func (db *MockDatabase) InsertRefreshToken(token *models.RefreshToken) (int, error) {
+	db.refreshTokenMu.Lock()
+	defer db.refreshTokenMu.Unlock()
+
+	token.ID = len(db.refreshTokens) + 1
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	db.refreshTokens = append(db.refreshTokens, token)
+
+	return token.ID, nil
+}
+
+// SelectRefreshTokenByHash selects a refresh token with given hash from the database.
+func (db *MockDatabase) SelectRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	db.refreshTokenMu.RLock()
+	defer db.refreshTokenMu.RUnlock()
+
+	for _, token := range db.refreshTokens {
+		if token.TokenHash == hash {
+			t := *token
+			return &t, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RevokeRefreshToken revokes a refresh token with given ID if it has not been revoked yet.
+// It reports whether the token has been revoked by this call.
+func (db *MockDatabase) RevokeRefreshToken(id int) (bool, error) {
+	db.refreshTokenMu.Lock()
+	defer db.refreshTokenMu.Unlock()
+
+	for _, token := range db.refreshTokens {
+		if token.ID == id && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens belonging to the family with given ID.
+func (db *MockDatabase) RevokeRefreshTokenFamily(familyID string) error {
+	db.refreshTokenMu.Lock()
+	defer db.refreshTokenMu.Unlock()
+
+	now := time.Now()
+	for _, token := range db.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of the user with given ID.
+func (db *MockDatabase) RevokeUserRefreshTokens(userID int) error {
+	db.refreshTokenMu.Lock()
+	defer db.refreshTokenMu.Unlock()
+
+	now := time.Now()
+	for _, token := range db.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// InsertRevokedToken inserts a new entry into the token revocation list.
+func (db *MockDatabase) InsertRevokedToken(token *models.RevokedToken) error {
+	db.revokedTokenMu.Lock()
+	defer db.revokedTokenMu.Unlock()
+
+	token.ID = len(db.revokedTokens) + 1
+	if token.RevokedAt.IsZero() {
+		token.RevokedAt = time.Now()
+	}
+
+	db.revokedTokens = append(db.revokedTokens, token)
+
+	return nil
+}
+
+// IsTokenRevoked checks whether the token with given ID, issued to the user with given ID at the given time, has been revoked.
+func (db *MockDatabase) IsTokenRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	db.revokedTokenMu.RLock()
+	defer db.revokedTokenMu.RUnlock()
+
+	now := time.Now()
+	for _, token := range db.revokedTokens {
+		if !token.ExpiresAt.After(now) {
+			continue
+		}
+
+		if token.TokenID == "" && token.UserID == userID && !token.RevokedAt.Before(issuedAt) {
+			return true, nil
+		}
+		if token.TokenID != "" && token.TokenID == tokenID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DeleteExpiredRevokedTokens deletes entries of the token revocation list that expired before the given time.
+// It returns the number of deleted entries.
+func (db *MockDatabase) DeleteExpiredRevokedTokens(before time.Time) (int, error) {
+	db.revokedTokenMu.Lock()
+	defer db.revokedTokenMu.Unlock()
+
+	revokedTokens := []*models.RevokedToken{}
+	for _, token := range db.revokedTokens {
+		if token.ExpiresAt.After(before) {
+			revokedTokens = append(revokedTokens, token)
+		}
+	}
+
+	deleted := len(db.revokedTokens) - len(revokedTokens)
+	db.revokedTokens = revokedTokens
+
+	return deleted, nil
+}
+
+// InsertSession inserts a new session into the database.
+func (db *MockDatabase) InsertSession(session *models.Session) (int, error) {
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+
+	session.ID = 1
+	if len(db.sessions) > 0 {
+		session.ID = db.sessions[len(db.sessions)-1].ID + 1
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+
+	s := *session
+	db.sessions = append(db.sessions, &s)
+
+	return session.ID, nil
+}
+
+// SelectSessionByID selects a session with given ID from the database.
+func (db *MockDatabase) SelectSessionByID(id int) (*models.Session, error) {
+	db.sessionMu.RLock()
+	defer db.sessionMu.RUnlock()
+
+	for _, session := range db.sessions {
+		if session.ID == id {
+			s := *session
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SelectSessionByFamilyID selects a session backed by the refresh token family with given ID from the database.
+func (db *MockDatabase) SelectSessionByFamilyID(familyID string) (*models.Session, error) {
+	db.sessionMu.RLock()
+	defer db.sessionMu.RUnlock()
+
+	for _, session := range db.sessions {
+		if session.FamilyID == familyID {
+			s := *session
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SelectSessionsByUserID selects sessions of the user with given ID, which have not been terminated, from the database.
+func (db *MockDatabase) SelectSessionsByUserID(userID int) ([]*models.Session, error) {
+	db.sessionMu.RLock()
+	defer db.sessionMu.RUnlock()
+
+	sessions := []*models.Session{}
+	for _, session := range db.sessions {
+		if session.UserID == userID && session.TerminatedAt == nil {
+			s := *session
+			sessions = append(sessions, &s)
+		}
+	}
+
+	return sessions, nil
+}
+
+// UpdateSessionActivity records the refresh of the tokens of a session with given ID by the client with given IP address and user agent.
+// The session is extended until the given expiration time.
+func (db *MockDatabase) UpdateSessionActivity(id int, usedAt time.Time, ipAddress, userAgent string, expiresAt time.Time) error {
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+
+	for _, session := range db.sessions {
+		if session.ID == id {
+			session.LastUsedAt = usedAt
+			session.IPAddress = ipAddress
+			session.UserAgent = userAgent
+			session.ExpiresAt = expiresAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// UpdateSessionLastUsedAt updates the time a session with given ID has been last used at in the database.
+func (db *MockDatabase) UpdateSessionLastUsedAt(id int, usedAt time.Time) error {
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+
+	for _, session := range db.sessions {
+		if session.ID == id {
+			session.LastUsedAt = usedAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// TerminateSession terminates a session with given ID of the user with given ID if it has not been terminated yet.
+// It reports whether the session has been terminated by this call.
+func (db *MockDatabase) TerminateSession(id, userID int) (bool, error) {
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+
+	for _, session := range db.sessions {
+		if session.ID == id && session.UserID == userID && session.TerminatedAt == nil {
+			now := time.Now()
+			session.TerminatedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// TerminateUserSessions terminates all sessions of the user with given ID.
+func (db *MockDatabase) TerminateUserSessions(userID int) error {
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+
+	now := time.Now()
+	for _, session := range db.sessions {
+		if session.UserID == userID && session.TerminatedAt == nil {
+			session.TerminatedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// InsertUserToken inserts a new user token into the database.
+func (db *MockDatabase) InsertUserToken(token *models.UserToken) (int, error) {
+	db.userTokenMu.Lock()
+	defer db.userTokenMu.Unlock()
+
+	token.ID = len(db.userTokens) + 1
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	db.userTokens = append(db.userTokens, token)
+
+	return token.ID, nil
+}
+
+// SelectUserTokenByHash selects a user token with given hash from the database.
+func (db *MockDatabase) SelectUserTokenByHash(hash string) (*models.UserToken, error) {
+	db.userTokenMu.RLock()
+	defer db.userTokenMu.RUnlock()
+
+	for _, token := range db.userTokens {
+		if token.TokenHash == hash {
+			t := *token
+			return &t, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// UseUserToken marks a user token with given ID as used if it has not been used yet.
+// It reports whether the token has been marked as used by this call.
+func (db *MockDatabase) UseUserToken(id int) (bool, error) {
+	db.userTokenMu.Lock()
+	defer db.userTokenMu.Unlock()
+
+	for _, token := range db.userTokens {
+		if token.ID == id && token.UsedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// InvalidateUserTokens marks all unused tokens of the user with given ID issued for the given purpose as used.
+func (db *MockDatabase) InvalidateUserTokens(userID int, purpose string) error {
+	db.userTokenMu.Lock()
+	defer db.userTokenMu.Unlock()
+
+	now := time.Now()
+	for _, token := range db.userTokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of the user with given ID with the given ones in the database.
+func (db *MockDatabase) ReplaceRecoveryCodes(userID int, codes []*models.RecoveryCode) error {
+	db.recoveryCodeMu.Lock()
+	defer db.recoveryCodeMu.Unlock()
+
+	recoveryCodes := []*models.RecoveryCode{}
+	for _, code := range db.recoveryCodes {
+		if code.UserID != userID {
+			recoveryCodes = append(recoveryCodes, code)
+		}
+	}
+
+	id := 1
+	if len(db.recoveryCodes) > 0 {
+		id = db.recoveryCodes[len(db.recoveryCodes)-1].ID + 1
+	}
+
+	for _, code := range codes {
+		recoveryCodes = append(recoveryCodes, &models.RecoveryCode{
+			ID:        id,
+			CreatedAt: time.Now(),
+			UserID:    userID,
+			CodeHash:  code.CodeHash,
+		})
+		id++
+	}
+
+	db.recoveryCodes = recoveryCodes
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code with given hash of the user with given ID as used.
+// It reports whether the code has been marked as used by this call.
+func (db *MockDatabase) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	db.recoveryCodeMu.Lock()
+	defer db.recoveryCodeMu.Unlock()
+
+	for _, code := range db.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// InsertAPIKey inserts a new API key into the database.
+func (db *MockDatabase) InsertAPIKey(key *models.APIKey) (int, error) {
+	db.apiKeyMu.Lock()
+	defer db.apiKeyMu.Unlock()
+
+	key.ID = 1
+	if len(db.apiKeys) > 0 {
+		key.ID = db.apiKeys[len(db.apiKeys)-1].ID + 1
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	db.apiKeys = append(db.apiKeys, key)
+
+	return key.ID, nil
+}
+
+// SelectAPIKeyByHash selects an API key with given hash from the database.
+func (db *MockDatabase) SelectAPIKeyByHash(hash string) (*models.APIKey, error) {
+	db.apiKeyMu.RLock()
+	defer db.apiKeyMu.RUnlock()
+
+	for _, key := range db.apiKeys {
+		if key.KeyHash == hash {
+			k := *key
+			return &k, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SelectAPIKeysByUserID selects API keys of the user with given ID, which have not been revoked, from the database.
+func (db *MockDatabase) SelectAPIKeysByUserID(userID int) ([]*models.APIKey, error) {
+	db.apiKeyMu.RLock()
+	defer db.apiKeyMu.RUnlock()
+
+	keys := []*models.APIKey{}
+	for _, key := range db.apiKeys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			k := *key
+			keys = append(keys, &k)
+		}
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key with given ID of the user with given ID if it has not been revoked yet.
+// It reports whether the key has been revoked by this call.
+func (db *MockDatabase) RevokeAPIKey(id, userID int) (bool, error) {
+	db.apiKeyMu.Lock()
+	defer db.apiKeyMu.Unlock()
+
+	for _, key := range db.apiKeys {
+		if key.ID == id && key.UserID == userID && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UpdateAPIKeyLastUsedAt updates the time an API key with given ID has been last used at in the database.
+func (db *MockDatabase) UpdateAPIKeyLastUsedAt(id int, usedAt time.Time) error {
+	db.apiKeyMu.Lock()
+	defer db.apiKeyMu.Unlock()
+
+	for _, key := range db.apiKeys {
+		if key.ID == id {
+			key.LastUsedAt = &usedAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// InsertOIDCLoginRequest inserts a new OIDC login request into the database.
+func (db *MockDatabase) InsertOIDCLoginRequest(request *models.OIDCLoginRequest) (int, error) {
+	db.oidcRequestMu.Lock()
+	defer db.oidcRequestMu.Unlock()
+
+	request.ID = 1
+	if len(db.oidcRequests) > 0 {
+		request.ID = db.oidcRequests[len(db.oidcRequests)-1].ID + 1
+	}
+	if request.CreatedAt.IsZero() {
+		request.CreatedAt = time.Now()
+	}
+
+	r := *request
+	db.oidcRequests = append(db.oidcRequests, &r)
+
+	return request.ID, nil
+}
+
+// TakeOIDCLoginRequest deletes an OIDC login request with given state hash from the database and returns it,
+// so each request can be completed only once.
+func (db *MockDatabase) TakeOIDCLoginRequest(stateHash string) (*models.OIDCLoginRequest, error) {
+	db.oidcRequestMu.Lock()
+	defer db.oidcRequestMu.Unlock()
+
+	for i, request := range db.oidcRequests {
+		if request.StateHash == stateHash {
+			db.oidcRequests = append(db.oidcRequests[:i], db.oidcRequests[i+1:]...)
+			return request, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteExpiredOIDCLoginRequests deletes OIDC login requests which have expired before the given time.
+// It returns the number of deleted requests.
+func (db *MockDatabase) DeleteExpiredOIDCLoginRequests(before time.Time) (int, error) {
+	db.oidcRequestMu.Lock()
+	defer db.oidcRequestMu.Unlock()
+
+	requests := []*models.OIDCLoginRequest{}
+	for _, request := range db.oidcRequests {
+		if !request.ExpiresAt.Before(before) {
+			requests = append(requests, request)
+		}
+	}
+
+	deleted := len(db.oidcRequests) - len(requests)
+	db.oidcRequests = requests
+
+	return deleted, nil
 }
@@ -3,6 +3,10 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
@@ -10,6 +14,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// likeEscaper escapes the wildcards and the escape character of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // PostgresqlDatabase is a Postgresql implementation of Database interface.
 // It uses pgx as a Postgresql driver.
 type PostgresqlDatabase struct {
@@ -36,11 +43,11 @@ func (db *PostgresqlDatabase) Close() {
 // InsertUser inserts a new user into the database.
 func (db *PostgresqlDatabase) InsertUser(user *models.User) (int, error) {
 	var (
-		query string = "INSERT INTO users (email, password, first_name, last_name, age) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+		query string = "INSERT INTO users (email, password, first_name, last_name, age, role, verified_at, verification_sent_at, oidc_issuer, oidc_subject) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id"
 		id    int    = -1
 	)
 
-	if err := db.connPool.QueryRow(context.Background(), query, user.Email, user.Password, user.FirstName, user.LastName, user.Age).Scan(&id); err != nil {
+	if err := db.connPool.QueryRow(context.Background(), query, user.Email, user.Password, user.FirstName, user.LastName, user.Age, user.Role, user.VerifiedAt, user.VerificationSentAt, user.OIDCIssuer, user.OIDCSubject).Scan(&id); err != nil {
 		logger.Errorf("Error (%s) while inserting new user", err)
 
 		return id, err
@@ -53,10 +60,10 @@ func (db *PostgresqlDatabase) InsertUser(user *models.User) (int, error) {
 
 // SelectUserByID selects a user with given ID from the database.
 func (db *PostgresqlDatabase) SelectUserByID(id int) (*models.User, error) {
-	query := "SELECT * FROM users WHERE id=$1"
+	query := "SELECT id, created_at, email, password, first_name, last_name, age, role, verified_at, verification_sent_at, password_reset_sent_at, totp_secret, totp_enabled_at, totp_last_used_step, oidc_issuer, oidc_subject, disabled_at FROM users WHERE id=$1"
 
-	user := &models.User{}
-	if err := db.connPool.QueryRow(context.Background(), query, id).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.FirstName, &user.LastName, &user.Age); err != nil {
+	user, err := scanUser(db.connPool.QueryRow(context.Background(), query, id))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -73,10 +80,10 @@ func (db *PostgresqlDatabase) SelectUserByID(id int) (*models.User, error) {
 
 // SelectUserByEmail selects a user with given email
 func (db *PostgresqlDatabase) SelectUserByEmail(email string) (*models.User, error) {
-	query := "SELECT * FROM users WHERE email=$1"
+	query := "SELECT id, created_at, email, password, first_name, last_name, age, role, verified_at, verification_sent_at, password_reset_sent_at, totp_secret, totp_enabled_at, totp_last_used_step, oidc_issuer, oidc_subject, disabled_at FROM users WHERE email=$1"
 
-	user := &models.User{}
-	if err := db.connPool.QueryRow(context.Background(), query, email).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.FirstName, &user.LastName, &user.Age); err != nil {
+	user, err := scanUser(db.connPool.QueryRow(context.Background(), query, email))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -91,14 +98,293 @@ func (db *PostgresqlDatabase) SelectUserByEmail(email string) (*models.User, err
 	return user, nil
 }
 
+// SelectUserByOIDCIdentity selects a user linked to the identity with given subject at the OpenID Connect provider with given issuer.
+func (db *PostgresqlDatabase) SelectUserByOIDCIdentity(issuer, subject string) (*models.User, error) {
+	query := "SELECT id, created_at, email, password, first_name, last_name, age, role, verified_at, verification_sent_at, password_reset_sent_at, totp_secret, totp_enabled_at, totp_last_used_step, oidc_issuer, oidc_subject, disabled_at FROM users WHERE oidc_issuer=$1 AND oidc_subject=$2"
+
+	user, err := scanUser(db.connPool.QueryRow(context.Background(), query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting user with OIDC subject: %s", err, subject)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected user with OIDC subject: %s", subject)
+
+	return user, nil
+}
+
+// SelectUsers selects at most limit users, skipping the first offset ones, ordered by ID from the database.
+// If the query is not empty, only users whose email address, first name or last name contains it, ignoring case, are selected.
+func (db *PostgresqlDatabase) SelectUsers(query string, limit, offset int) ([]*models.User, error) {
+	sql := "SELECT id, created_at, email, password, first_name, last_name, age, role, verified_at, verification_sent_at, password_reset_sent_at, totp_secret, totp_enabled_at, totp_last_used_step, oidc_issuer, oidc_subject, disabled_at FROM users WHERE $1::text = '' OR email ILIKE $2 OR first_name ILIKE $2 OR last_name ILIKE $2 ORDER BY id LIMIT $3 OFFSET $4"
+
+	rows, err := db.connPool.Query(context.Background(), sql, query, containsPattern(query), limit, offset)
+	if err != nil {
+		logger.Errorf("Error (%s) while selecting users matching query: %s", err, query)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			logger.Errorf("Error (%s) while scanning user matching query: %s", err, query)
+
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Errorf("Error (%s) while selecting users matching query: %s", err, query)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected %d users matching query: %s", len(users), query)
+
+	return users, nil
+}
+
+// CountUsers counts users whose email address, first name or last name contains the query, ignoring case, in the database.
+// If the query is empty, all users are counted.
+func (db *PostgresqlDatabase) CountUsers(query string) (int, error) {
+	sql := "SELECT COUNT(*) FROM users WHERE $1::text = '' OR email ILIKE $2 OR first_name ILIKE $2 OR last_name ILIKE $2"
+
+	count := 0
+	if err := db.connPool.QueryRow(context.Background(), sql, query, containsPattern(query)).Scan(&count); err != nil {
+		logger.Errorf("Error (%s) while counting users matching query: %s", err, query)
+
+		return 0, err
+	}
+
+	logger.Infof("Counted %d users matching query: %s", count, query)
+
+	return count, nil
+}
+
+// scanUser scans a row of the users table.
+func scanUser(row pgx.Row) (*models.User, error) {
+	user := &models.User{}
+	if err := row.Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Password, &user.FirstName, &user.LastName, &user.Age, &user.Role, &user.VerifiedAt, &user.VerificationSentAt, &user.PasswordResetSentAt, &user.TOTPSecret, &user.TOTPEnabledAt, &user.TOTPLastUsedStep, &user.OIDCIssuer, &user.OIDCSubject, &user.DisabledAt); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// containsPattern returns an ILIKE pattern matching strings that contain s, with the wildcards in s escaped.
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// UpdateUser updates the first name, last name and age of a user with given ID in the database.
+func (db *PostgresqlDatabase) UpdateUser(id int, user *models.User) error {
+	query := "UPDATE users SET first_name = $1, last_name = $2, age = $3 WHERE id = $4"
+
+	if _, err := db.connPool.Exec(context.Background(), query, user.FirstName, user.LastName, user.Age, id); err != nil {
+		logger.Errorf("Error (%s) while updating user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserPassword updates the password hash of a user with given ID in the database.
+func (db *PostgresqlDatabase) UpdateUserPassword(id int, password string) error {
+	query := "UPDATE users SET password = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, password, id); err != nil {
+		logger.Errorf("Error (%s) while updating password of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated password of user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserEmail updates the email address of a user with given ID in the database.
+func (db *PostgresqlDatabase) UpdateUserEmail(id int, email string) error {
+	query := "UPDATE users SET email = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, email, id); err != nil {
+		logger.Errorf("Error (%s) while updating email of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated email of user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserVerifiedAt sets the time a user with given ID has verified the email address at in the database.
+func (db *PostgresqlDatabase) UpdateUserVerifiedAt(id int, verifiedAt time.Time) error {
+	query := "UPDATE users SET verified_at = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, verifiedAt, id); err != nil {
+		logger.Errorf("Error (%s) while verifying user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Verified user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserVerificationSentAt sets the time the last verification email has been sent to a user with given ID at,
+// if no verification email has been sent to the user after sentBefore.
+// It reports whether the time has been set by this call.
+func (db *PostgresqlDatabase) UpdateUserVerificationSentAt(id int, sentAt, sentBefore time.Time) (bool, error) {
+	query := "UPDATE users SET verification_sent_at = $1 WHERE id = $2 AND (verification_sent_at IS NULL OR verification_sent_at <= $3)"
+
+	tag, err := db.connPool.Exec(context.Background(), query, sentAt, id, sentBefore)
+	if err != nil {
+		logger.Errorf("Error (%s) while updating verification sent time of user with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Updated verification sent time of user with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdateUserPasswordResetSentAt sets the time the last password reset email has been sent to a user with given ID at,
+// if no password reset email has been sent to the user after sentBefore.
+// It reports whether the time has been set by this call.
+func (db *PostgresqlDatabase) UpdateUserPasswordResetSentAt(id int, sentAt, sentBefore time.Time) (bool, error) {
+	query := "UPDATE users SET password_reset_sent_at = $1 WHERE id = $2 AND (password_reset_sent_at IS NULL OR password_reset_sent_at <= $3)"
+
+	tag, err := db.connPool.Exec(context.Background(), query, sentAt, id, sentBefore)
+	if err != nil {
+		logger.Errorf("Error (%s) while updating password reset sent time of user with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Updated password reset sent time of user with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdateUserTOTP sets the TOTP secret of a user with given ID and the time two-factor authentication has been enabled at,
+// nil if it is not enabled yet, in the database. The last used TOTP step is reset.
+func (db *PostgresqlDatabase) UpdateUserTOTP(id int, secret string, enabledAt *time.Time) error {
+	query := "UPDATE users SET totp_secret = $1, totp_enabled_at = $2, totp_last_used_step = 0 WHERE id = $3"
+
+	if _, err := db.connPool.Exec(context.Background(), query, secret, enabledAt, id); err != nil {
+		logger.Errorf("Error (%s) while updating TOTP of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated TOTP of user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserTOTPLastUsedStep sets the last TOTP step a user with given ID has logged in with, if it is after the current one.
+// It reports whether the step has been set by this call, so a code cannot be used twice.
+func (db *PostgresqlDatabase) UpdateUserTOTPLastUsedStep(id int, step int64) (bool, error) {
+	query := "UPDATE users SET totp_last_used_step = $1 WHERE id = $2 AND totp_last_used_step < $1"
+
+	tag, err := db.connPool.Exec(context.Background(), query, step, id)
+	if err != nil {
+		logger.Errorf("Error (%s) while updating last used TOTP step of user with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Updated last used TOTP step of user with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdateUserOIDCIdentity links a user with given ID to the identity with given subject at the OpenID Connect provider with given issuer.
+func (db *PostgresqlDatabase) UpdateUserOIDCIdentity(id int, issuer, subject string) error {
+	query := "UPDATE users SET oidc_issuer = $1, oidc_subject = $2 WHERE id = $3"
+
+	if _, err := db.connPool.Exec(context.Background(), query, issuer, subject, id); err != nil {
+		logger.Errorf("Error (%s) while updating OIDC identity of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated OIDC identity of user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserDisabledAt sets the time a user with given ID has been disabled at, nil to enable the user, in the database.
+func (db *PostgresqlDatabase) UpdateUserDisabledAt(id int, disabledAt *time.Time) error {
+	query := "UPDATE users SET disabled_at = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, disabledAt, id); err != nil {
+		logger.Errorf("Error (%s) while updating disabled at of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated disabled at of user with ID: %d", id)
+
+	return nil
+}
+
+// UpdateUserRole updates the role of a user with given ID in the database.
+func (db *PostgresqlDatabase) UpdateUserRole(id int, role models.Role) error {
+	query := "UPDATE users SET role = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, role, id); err != nil {
+		logger.Errorf("Error (%s) while updating role of user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated role of user with ID: %d", id)
+
+	return nil
+}
+
+// DeleteUser deletes a user with given ID from the database.
+// Refresh tokens, sessions, user tokens and API keys of the user are deleted as well, while the books the user has created are kept without a creator.
+func (db *PostgresqlDatabase) DeleteUser(id int) error {
+	query := "DELETE FROM users WHERE id=$1"
+
+	if _, err := db.connPool.Exec(context.Background(), query, id); err != nil {
+		logger.Errorf("Error (%s) while deleting user with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Deleted user with ID: %d", id)
+
+	return nil
+}
+
 // InsertBook inserts a new book into the database.
 func (db *PostgresqlDatabase) InsertBook(book *models.Book) (int, error) {
 	var (
-		query string = "INSERT INTO books (author, title, created_by) VALUES ($1, $2, $3) RETURNING id"
+		query string = "INSERT INTO books (author, title, created_by, isbn, publisher, publication_year, language, page_count, description) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
 		id    int    = -1
 	)
 
-	if err := db.connPool.QueryRow(context.Background(), query, book.Author, book.Title, book.CreatedBy).Scan(&id); err != nil {
+	if err := db.connPool.QueryRow(context.Background(), query, book.Author, book.Title, book.CreatedBy,
+		book.ISBN, book.Publisher, book.PublicationYear, book.Language, book.PageCount, book.Description).Scan(&id); err != nil {
 		logger.Errorf("Error (%s) while inserting new book", err)
 
 		return id, err
@@ -109,80 +395,1075 @@ func (db *PostgresqlDatabase) InsertBook(book *models.Book) (int, error) {
 	return id, nil
 }
 
-// SelectAllBooks selects all books from the database.
-func (db *PostgresqlDatabase) SelectAllBooks() ([]*models.Book, error) {
-	query := "SELECT * FROM books"
+// SelectBooks selects a page of the books matching the filter of the query, sorted by the sort of the query, from the database.
+// The query is compiled into a parameterized statement, with the sort field mapped to a column of the books table.
+func (db *PostgresqlDatabase) SelectBooks(query *models.BookQuery) ([]*models.Book, error) {
+	column, ok := bookSortColumns[query.Sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown book sort field: %s", query.Sort.Field)
+	}
 
-	rows, err := db.connPool.Query(context.Background(), query)
-	if err != nil {
-		return nil, err
+	direction, reverseDirection := "ASC", "DESC"
+	afterOperator, beforeOperator := ">", "<"
+	if query.Sort.Descending {
+		direction, reverseDirection = reverseDirection, direction
+		afterOperator, beforeOperator = beforeOperator, afterOperator
+	}
+
+	conditions, args := bookFilterConditions(&query.Filter)
+
+	page := &query.Page
+	order := direction
+	switch {
+	case page.After != nil:
+		args = append(args, bookCursorValue(page.After, query.Sort.Field), page.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, afterOperator, len(args)-1, len(args)))
+	case page.Before != nil:
+		args = append(args, bookCursorValue(page.Before, query.Sort.Field), page.Before.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, beforeOperator, len(args)-1, len(args)))
+		// The books preceding the cursor are selected in the reverse order, so the limit keeps the closest ones.
+		order = reverseDirection
+	}
+
+	sql := "SELECT id, created_by, created_at, author, title, isbn, publisher, publication_year, language, page_count, description FROM books"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, page.Limit)
+	sql += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", column, order, order, len(args))
+
+	switch {
+	case page.After == nil && page.Before == nil:
+		args = append(args, page.Offset)
+		sql += fmt.Sprintf(" OFFSET $%d", len(args))
+	case page.Before != nil:
+		sql = fmt.Sprintf("SELECT id, created_by, created_at, author, title, isbn, publisher, publication_year, language, page_count, description FROM (%s) AS page ORDER BY %s %s, id %s", sql, column, direction, direction)
 	}
-	defer rows.Close()
 
 	books := []*models.Book{}
-	for rows.Next() {
-		book := &models.Book{}
-		if err := rows.Scan(&book.ID, &book.CreatedAt, &book.Title, &book.Author, &book.CreatedBy); err != nil {
-			logger.Errorf("Error (%s) while selecting all books", err)
+	if err := db.withWordSimilarityThreshold(query.Filter.Similarity, func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), sql, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
 
-			return nil, err
+		for rows.Next() {
+			book, err := scanBook(rows)
+			if err != nil {
+				return err
+			}
+
+			books = append(books, book)
 		}
 
-		books = append(books, book)
+		return rows.Err()
+	}); err != nil {
+		logger.Errorf("Error (%s) while selecting page of books", err)
+
+		return nil, err
 	}
 
-	logger.Infoln("Selected all books")
+	logger.Infof("Selected page of %d books", len(books))
 
 	return books, nil
 }
 
-// SelectBookByID selects a book with given ID from the database.
-func (db *PostgresqlDatabase) SelectBookByID(id int) (*models.Book, error) {
-	query := "SELECT * FROM books WHERE id=$1"
+// CountBooks counts the books matching the filter in the database.
+func (db *PostgresqlDatabase) CountBooks(filter *models.BookFilter) (int, error) {
+	conditions, args := bookFilterConditions(filter)
 
-	row := db.connPool.QueryRow(context.Background(), query, id)
-	book := &models.Book{}
-	if err := row.Scan(&book.ID, &book.CreatedAt, &book.Title, &book.Author, &book.CreatedBy); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+	sql := "SELECT COUNT(*) FROM books"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	count := 0
+	if err := db.withWordSimilarityThreshold(filter.Similarity, func(tx pgx.Tx) error {
+		return tx.QueryRow(context.Background(), sql, args...).Scan(&count)
+	}); err != nil {
+		logger.Errorf("Error (%s) while counting books", err)
+
+		return 0, err
+	}
+
+	logger.Infof("Counted %d books", count)
+
+	return count, nil
+}
+
+// SearchBooks selects a page of the books matching the search from the database, the most relevant first.
+// The titles and the authors are matched by the search_vector column, which holds their stems in the language of the book with the titles weighted higher.
+func (db *PostgresqlDatabase) SearchBooks(search *models.BookSearch) ([]*models.BookSearchResult, error) {
+	// The highlights are made only for the books on the page, as they need the whole text of the books.
+	// The text is HTML escaped before, so the highlights can be displayed as HTML with only the marks being tags.
+	query := `SELECT id, created_by, created_at, author, title, isbn, publisher, publication_year, language, page_count, description, rank,
+		ts_headline(config, ` + htmlEscaped("title") + `, query, $4), ts_headline(config, ` + htmlEscaped("author") + `, query, $4)
+		FROM (
+			SELECT id, created_by, created_at, author, title, isbn, publisher, publication_year, language, page_count, description, config, query, ts_rank_cd(search_vector, query) AS rank
+			FROM books JOIN ` + bookSearchQueries + `
+			ORDER BY rank DESC, id
+			LIMIT $2 OFFSET $3
+		) AS page
+		ORDER BY rank DESC, id`
+
+	rows, err := db.connPool.Query(context.Background(), query, bookSearchQuery(search.Terms), search.Limit, search.Offset, bookSearchHeadlineOptions)
+	if err != nil {
+		logger.Errorf("Error (%s) while searching books", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*models.BookSearchResult{}
+	for rows.Next() {
+		result := &models.BookSearchResult{}
+
+		book, err := scanBook(rows, &result.Rank, &result.TitleHighlight, &result.AuthorHighlight)
+		if err != nil {
+			logger.Errorf("Error (%s) while scanning book search result", err)
+
+			return nil, err
 		}
+		result.Book = book
 
-		logger.Errorf("Error (%s) while selecting book with ID: %d", err, id)
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Errorf("Error (%s) while searching books", err)
 
 		return nil, err
 	}
 
-	logger.Infof("Selected book with ID: %d", id)
+	logger.Infof("Found page of %d books", len(results))
 
-	return book, nil
+	return results, nil
 }
 
-// DeleteBook deletes a book with given ID from the database.
-func (db *PostgresqlDatabase) DeleteBook(id int) error {
-	query := "DELETE FROM books WHERE id=$1"
+// CountSearchedBooks counts the books matching all of the search terms in the database.
+func (db *PostgresqlDatabase) CountSearchedBooks(terms []string) (int, error) {
+	query := "SELECT COUNT(*) FROM books JOIN " + bookSearchQueries
 
-	if _, err := db.connPool.Exec(context.Background(), query, id); err != nil {
-		logger.Errorf("Error (%s) while deleting book with ID: %d", err, id)
+	count := 0
+	if err := db.connPool.QueryRow(context.Background(), query, bookSearchQuery(terms)).Scan(&count); err != nil {
+		logger.Errorf("Error (%s) while counting searched books", err)
 
-		return err
+		return 0, err
 	}
 
-	logger.Infof("Deleted book with ID: %d", id)
+	logger.Infof("Counted %d searched books", count)
 
-	return nil
+	return count, nil
 }
 
-// UpdateBook updates a book with given ID in the database.
-func (db *PostgresqlDatabase) UpdateBook(id int, book *models.Book) error {
-	query := "UPDATE books SET author = $1, title = $2 WHERE id = $3"
+// bookSearchQueries is an SQL join of the books with the search query $1 compiled by the text search configuration of their language,
+// chosen by the book_search_config function like for the search_vector column. The query is compiled by every configuration
+// up front rather than for each book, so the books are matched using the index of the search_vector column.
+const bookSearchQueries = `(SELECT config, to_tsquery(config, $1) AS query FROM (SELECT oid::regconfig AS config FROM pg_ts_config) AS configs) AS queries
+	ON search_vector @@ query AND book_search_config(language) = config`
 
-	if _, err := db.connPool.Exec(context.Background(), query, book.Author, book.Title, id); err != nil {
-		logger.Errorf("Error (%s) while updating book with ID: %d", err, id)
+// bookSearchHeadlineOptions are the options of the highlights of the searched books, which mark all of the matching words in the whole text.
+const bookSearchHeadlineOptions = "HighlightAll=true, StartSel=<mark>, StopSel=</mark>"
 
-		return err
+// htmlEscaped returns an SQL expression escaping the HTML special characters of the column the same way as html.EscapeString.
+func htmlEscaped(column string) string {
+	return "replace(replace(replace(replace(replace(" + column + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+// bookSearchQuery compiles the search terms into a text search query, which matches the words starting with all of the terms.
+// The terms are quoted, so they cannot be interpreted as operators of the query.
+func bookSearchQuery(terms []string) string {
+	lexemes := make([]string, 0, len(terms))
+	for _, term := range terms {
+		lexemes = append(lexemes, "'"+strings.ReplaceAll(term, "'", "''")+"':*")
 	}
 
-	logger.Infof("Updated book with ID: %d", id)
+	return strings.Join(lexemes, " & ")
+}
 
-	return nil
+// SelectSimilarAuthors selects at most limit distinct authors of books from the database,
+// which have a word similarity to the value of at least the given similarity but do not contain it, the most similar first.
+func (db *PostgresqlDatabase) SelectSimilarAuthors(value string, similarity float64, limit int) ([]string, error) {
+	return db.selectSimilarBookValues("author", value, similarity, limit)
+}
+
+// SelectSimilarTitles selects at most limit distinct titles of books from the database,
+// which have a word similarity to the value of at least the given similarity but do not contain it, the most similar first.
+func (db *PostgresqlDatabase) SelectSimilarTitles(value string, similarity float64, limit int) ([]string, error) {
+	return db.selectSimilarBookValues("title", value, similarity, limit)
+}
+
+// selectSimilarBookValues selects the distinct values of the column of the books table similar to the value.
+// The column must be one of the constant column names, as it is not a parameter of the query.
+// The candidates are matched with the <% operator, which uses the trigram index of the column, and then ranked by their word similarity.
+func (db *PostgresqlDatabase) selectSimilarBookValues(column, value string, similarity float64, limit int) ([]string, error) {
+	query := fmt.Sprintf(`SELECT %s, similarity FROM (
+			SELECT DISTINCT %s, word_similarity($1, %s) AS similarity FROM books WHERE $1 <%% %s AND %s NOT ILIKE $2
+		) AS similar_books
+		ORDER BY similarity DESC, %s
+		LIMIT $3`, column, column, column, column, column, column)
+
+	values := []string{}
+	if err := db.withWordSimilarityThreshold(similarity, func(tx pgx.Tx) error {
+		rows, err := tx.Query(context.Background(), query, value, containsPattern(value), limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var similarValue string
+			var valueSimilarity float64
+			if err := rows.Scan(&similarValue, &valueSimilarity); err != nil {
+				return err
+			}
+
+			values = append(values, similarValue)
+		}
+
+		return rows.Err()
+	}); err != nil {
+		logger.Errorf("Error (%s) while selecting %ss similar to: %s", err, column, value)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected %d %ss similar to: %s", len(values), column, value)
+
+	return values, nil
+}
+
+// withWordSimilarityThreshold runs the function in a transaction, in which the word similarity threshold of pg_trgm
+// is set to the given similarity, if it is positive. The <% operator matches the values with at least this word similarity
+// and, unlike comparing the word_similarity function, can use the trigram indexes.
+// The threshold is set with set_config local to the transaction, which is SET LOCAL accepting a parameter.
+func (db *PostgresqlDatabase) withWordSimilarityThreshold(similarity float64, fn func(pgx.Tx) error) error {
+	return pgx.BeginFunc(context.Background(), db.connPool, func(tx pgx.Tx) error {
+		if similarity > 0 {
+			if _, err := tx.Exec(context.Background(), "SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)",
+				strconv.FormatFloat(similarity, 'f', -1, 64)); err != nil {
+				return err
+			}
+		}
+
+		return fn(tx)
+	})
+}
+
+// bookSortColumns maps the fields books can be sorted by to the columns of the books table.
+var bookSortColumns = map[models.BookSortField]string{
+	models.BookSortFieldAuthor:    "author",
+	models.BookSortFieldTitle:     "title",
+	models.BookSortFieldCreatedBy: bookCreatedByColumn,
+	models.BookSortFieldCreatedAt: "created_at",
+}
+
+// bookCreatedByColumn is the ID of the user who has created a book, which is 0 if the user has been deleted.
+// It is the expression of the index of the books by their creators, so the books are filtered and sorted by it the same way.
+const bookCreatedByColumn = "COALESCE(created_by, 0)"
+
+// bookFilterConditions compiles the filter into conditions of a WHERE clause, which reference the returned arguments.
+// Similar authors and titles are matched with the <% operator, so the conditions have to be run with withWordSimilarityThreshold.
+func bookFilterConditions(filter *models.BookFilter) ([]string, []any) {
+	conditions, args := []string{}, []any{}
+
+	for _, match := range []struct{ column, value string }{{"author", filter.Author}, {"title", filter.Title}} {
+		if match.value == "" {
+			continue
+		}
+
+		args = append(args, containsPattern(match.value))
+		if filter.Similarity <= 0 {
+			conditions = append(conditions, fmt.Sprintf("%s ILIKE $%d", match.column, len(args)))
+			continue
+		}
+
+		// The threshold of the <% operator is set to the similarity by withWordSimilarityThreshold.
+		args = append(args, match.value)
+		conditions = append(conditions, fmt.Sprintf("(%s ILIKE $%d OR $%d <%% %s)", match.column, len(args)-1, len(args), match.column))
+	}
+	if filter.CreatedBy != 0 {
+		args = append(args, filter.CreatedBy)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", bookCreatedByColumn, len(args)))
+	}
+	if filter.CreatedFrom != nil {
+		args = append(args, *filter.CreatedFrom)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.CreatedTo != nil {
+		args = append(args, *filter.CreatedTo)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+// bookCursorValue returns the value of the field books are sorted by at the position of the cursor.
+func bookCursorValue(cursor *models.BookCursor, field models.BookSortField) any {
+	switch field {
+	case models.BookSortFieldAuthor:
+		return cursor.Author
+	case models.BookSortFieldTitle:
+		return cursor.Title
+	case models.BookSortFieldCreatedBy:
+		return cursor.CreatedBy
+	default:
+		return cursor.CreatedAt
+	}
+}
+
+// scanBook scans a row of the books table, followed by the columns scanned into the extra destinations, if there are any.
+// The creator of a book is 0 if the user who has created it has been deleted.
+func scanBook(row pgx.Row, extra ...any) (*models.Book, error) {
+	book := &models.Book{}
+
+	var createdBy *int
+	dest := []any{&book.ID, &createdBy, &book.CreatedAt, &book.Author, &book.Title,
+		&book.ISBN, &book.Publisher, &book.PublicationYear, &book.Language, &book.PageCount, &book.Description}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if createdBy != nil {
+		book.CreatedBy = *createdBy
+	}
+
+	return book, nil
+}
+
+// SelectBookByID selects a book with given ID from the database.
+func (db *PostgresqlDatabase) SelectBookByID(id int) (*models.Book, error) {
+	query := "SELECT id, created_by, created_at, author, title, isbn, publisher, publication_year, language, page_count, description FROM books WHERE id=$1"
+
+	book, err := scanBook(db.connPool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting book with ID: %d", err, id)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected book with ID: %d", id)
+
+	return book, nil
+}
+
+// DeleteBook deletes a book with given ID from the database.
+func (db *PostgresqlDatabase) DeleteBook(id int) error {
+	query := "DELETE FROM books WHERE id=$1"
+
+	if _, err := db.connPool.Exec(context.Background(), query, id); err != nil {
+		logger.Errorf("Error (%s) while deleting book with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Deleted book with ID: %d", id)
+
+	return nil
+}
+
+// UpdateBook updates a book with given ID in the database.
+func (db *PostgresqlDatabase) UpdateBook(id int, book *models.Book) error {
+	query := "UPDATE books SET author = $1, title = $2, isbn = $3, publisher = $4, publication_year = $5, language = $6, page_count = $7, description = $8 WHERE id = $9"
+
+	if _, err := db.connPool.Exec(context.Background(), query, book.Author, book.Title,
+		book.ISBN, book.Publisher, book.PublicationYear, book.Language, book.PageCount, book.Description, id); err != nil {
+		logger.Errorf("Error (%s) while updating book with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated book with ID: %d", id)
+
+	return nil
+}
+
+// InsertRefreshToken inserts a new refresh token into the database.
+func (db *PostgresqlDatabase) InsertRefreshToken(token *models.RefreshToken) (int, error) {
+	var (
+		query string = "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id"
+		id    int    = -1
+	)
+
+	if err := db.connPool.QueryRow(context.Background(), query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).Scan(&id); err != nil {
+		logger.Errorf("Error (%s) while inserting new refresh token", err)
+
+		return id, err
+	}
+
+	logger.Infof("Inserted new refresh token with ID: %d", id)
+
+	return id, nil
+}
+
+// SelectRefreshTokenByHash selects a refresh token with given hash from the database.
+func (db *PostgresqlDatabase) SelectRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	query := "SELECT id, created_at, user_id, family_id, token_hash, expires_at, revoked_at FROM refresh_tokens WHERE token_hash=$1"
+
+	token := &models.RefreshToken{}
+	if err := db.connPool.QueryRow(context.Background(), query, hash).Scan(&token.ID, &token.CreatedAt, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.RevokedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting refresh token by hash", err)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected refresh token with ID: %d", token.ID)
+
+	return token, nil
+}
+
+// RevokeRefreshToken revokes a refresh token with given ID if it has not been revoked yet.
+// It reports whether the token has been revoked by this call.
+func (db *PostgresqlDatabase) RevokeRefreshToken(id int) (bool, error) {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL"
+
+	tag, err := db.connPool.Exec(context.Background(), query, id)
+	if err != nil {
+		logger.Errorf("Error (%s) while revoking refresh token with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Revoked refresh token with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens belonging to the family with given ID.
+func (db *PostgresqlDatabase) RevokeRefreshTokenFamily(familyID string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL"
+
+	if _, err := db.connPool.Exec(context.Background(), query, familyID); err != nil {
+		logger.Errorf("Error (%s) while revoking refresh token family with ID: %s", err, familyID)
+
+		return err
+	}
+
+	logger.Infof("Revoked refresh token family with ID: %s", familyID)
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of the user with given ID.
+func (db *PostgresqlDatabase) RevokeUserRefreshTokens(userID int) error {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL"
+
+	if _, err := db.connPool.Exec(context.Background(), query, userID); err != nil {
+		logger.Errorf("Error (%s) while revoking refresh tokens of user with ID: %d", err, userID)
+
+		return err
+	}
+
+	logger.Infof("Revoked refresh tokens of user with ID: %d", userID)
+
+	return nil
+}
+
+// InsertSession inserts a new session into the database.
+func (db *PostgresqlDatabase) InsertSession(session *models.Session) (int, error) {
+	var (
+		query string = "INSERT INTO sessions (user_id, family_id, ip_address, user_agent, last_used_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+		id    int    = -1
+	)
+
+	if err := db.connPool.QueryRow(context.Background(), query, session.UserID, session.FamilyID, session.IPAddress, session.UserAgent, session.LastUsedAt, session.ExpiresAt).Scan(&id); err != nil {
+		logger.Errorf("Error (%s) while inserting new session", err)
+
+		return id, err
+	}
+
+	logger.Infof("Inserted new session with ID: %d", id)
+
+	return id, nil
+}
+
+// SelectSessionByID selects a session with given ID from the database.
+func (db *PostgresqlDatabase) SelectSessionByID(id int) (*models.Session, error) {
+	query := "SELECT id, created_at, user_id, family_id, ip_address, user_agent, last_used_at, expires_at, terminated_at FROM sessions WHERE id=$1"
+
+	session, err := scanSession(db.connPool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting session with ID: %d", err, id)
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// SelectSessionByFamilyID selects a session backed by the refresh token family with given ID from the database.
+func (db *PostgresqlDatabase) SelectSessionByFamilyID(familyID string) (*models.Session, error) {
+	query := "SELECT id, created_at, user_id, family_id, ip_address, user_agent, last_used_at, expires_at, terminated_at FROM sessions WHERE family_id=$1"
+
+	session, err := scanSession(db.connPool.QueryRow(context.Background(), query, familyID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting session with family ID: %s", err, familyID)
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// SelectSessionsByUserID selects sessions of the user with given ID, which have not been terminated, from the database.
+func (db *PostgresqlDatabase) SelectSessionsByUserID(userID int) ([]*models.Session, error) {
+	query := "SELECT id, created_at, user_id, family_id, ip_address, user_agent, last_used_at, expires_at, terminated_at FROM sessions WHERE user_id=$1 AND terminated_at IS NULL ORDER BY id"
+
+	rows, err := db.connPool.Query(context.Background(), query, userID)
+	if err != nil {
+		logger.Errorf("Error (%s) while selecting sessions of user with ID: %d", err, userID)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			logger.Errorf("Error (%s) while scanning session of user with ID: %d", err, userID)
+
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Errorf("Error (%s) while selecting sessions of user with ID: %d", err, userID)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected sessions of user with ID: %d", userID)
+
+	return sessions, nil
+}
+
+// UpdateSessionActivity records the refresh of the tokens of a session with given ID by the client with given IP address and user agent.
+// The session is extended until the given expiration time.
+func (db *PostgresqlDatabase) UpdateSessionActivity(id int, usedAt time.Time, ipAddress, userAgent string, expiresAt time.Time) error {
+	query := "UPDATE sessions SET last_used_at = $1, ip_address = $2, user_agent = $3, expires_at = $4 WHERE id = $5"
+
+	if _, err := db.connPool.Exec(context.Background(), query, usedAt, ipAddress, userAgent, expiresAt, id); err != nil {
+		logger.Errorf("Error (%s) while updating activity of session with ID: %d", err, id)
+
+		return err
+	}
+
+	logger.Infof("Updated activity of session with ID: %d", id)
+
+	return nil
+}
+
+// UpdateSessionLastUsedAt updates the time a session with given ID has been last used at in the database.
+func (db *PostgresqlDatabase) UpdateSessionLastUsedAt(id int, usedAt time.Time) error {
+	query := "UPDATE sessions SET last_used_at = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, usedAt, id); err != nil {
+		logger.Errorf("Error (%s) while updating last use of session with ID: %d", err, id)
+
+		return err
+	}
+
+	return nil
+}
+
+// TerminateSession terminates a session with given ID of the user with given ID if it has not been terminated yet.
+// It reports whether the session has been terminated by this call.
+func (db *PostgresqlDatabase) TerminateSession(id, userID int) (bool, error) {
+	query := "UPDATE sessions SET terminated_at = NOW() WHERE id = $1 AND user_id = $2 AND terminated_at IS NULL"
+
+	tag, err := db.connPool.Exec(context.Background(), query, id, userID)
+	if err != nil {
+		logger.Errorf("Error (%s) while terminating session with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Terminated session with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// TerminateUserSessions terminates all sessions of the user with given ID.
+func (db *PostgresqlDatabase) TerminateUserSessions(userID int) error {
+	query := "UPDATE sessions SET terminated_at = NOW() WHERE user_id = $1 AND terminated_at IS NULL"
+
+	if _, err := db.connPool.Exec(context.Background(), query, userID); err != nil {
+		logger.Errorf("Error (%s) while terminating sessions of user with ID: %d", err, userID)
+
+		return err
+	}
+
+	logger.Infof("Terminated sessions of user with ID: %d", userID)
+
+	return nil
+}
+
+// scanSession scans a session from the given row.
+func scanSession(row pgx.Row) (*models.Session, error) {
+	session := &models.Session{}
+	if err := row.Scan(&session.ID, &session.CreatedAt, &session.UserID, &session.FamilyID, &session.IPAddress, &session.UserAgent, &session.LastUsedAt, &session.ExpiresAt, &session.TerminatedAt); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// InsertRevokedToken inserts a new entry into the token revocation list.
+func (db *PostgresqlDatabase) InsertRevokedToken(token *models.RevokedToken) error {
+	query := "INSERT INTO revoked_tokens (revoked_at, user_id, token_id, expires_at) VALUES ($1, $2, NULLIF($3, ''), $4)"
+
+	if _, err := db.connPool.Exec(context.Background(), query, token.RevokedAt, token.UserID, token.TokenID, token.ExpiresAt); err != nil {
+		logger.Errorf("Error (%s) while inserting new revoked token", err)
+
+		return err
+	}
+
+	logger.Infof("Inserted new revoked token for user with ID: %d", token.UserID)
+
+	return nil
+}
+
+// IsTokenRevoked checks whether the token with given ID, issued to the user with given ID at the given time, has been revoked.
+func (db *PostgresqlDatabase) IsTokenRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE expires_at > NOW() AND (token_id = $1 OR (token_id IS NULL AND user_id = $2 AND revoked_at >= $3)))"
+
+	revoked := false
+	if err := db.connPool.QueryRow(context.Background(), query, tokenID, userID, issuedAt).Scan(&revoked); err != nil {
+		logger.Errorf("Error (%s) while checking whether token with ID: %s is revoked", err, tokenID)
+
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedTokens deletes entries of the token revocation list that expired before the given time.
+// It returns the number of deleted entries.
+func (db *PostgresqlDatabase) DeleteExpiredRevokedTokens(before time.Time) (int, error) {
+	query := "DELETE FROM revoked_tokens WHERE expires_at <= $1"
+
+	tag, err := db.connPool.Exec(context.Background(), query, before)
+	if err != nil {
+		logger.Errorf("Error (%s) while deleting expired revoked tokens", err)
+
+		return 0, err
+	}
+
+	logger.Infof("Deleted %d expired revoked tokens", tag.RowsAffected())
+
+	return int(tag.RowsAffected()), nil
+}
+
+// InsertUserToken inserts a new user token into the database.
+func (db *PostgresqlDatabase) InsertUserToken(token *models.UserToken) (int, error) {
+	var (
+		query string = "INSERT INTO user_tokens (user_id, purpose, token_hash, payload, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+		id    int    = -1
+	)
+
+	if err := db.connPool.QueryRow(context.Background(), query, token.UserID, token.Purpose, token.TokenHash, token.Payload, token.ExpiresAt).Scan(&id); err != nil {
+		logger.Errorf("Error (%s) while inserting new user token", err)
+
+		return id, err
+	}
+
+	logger.Infof("Inserted new user token with ID: %d", id)
+
+	return id, nil
+}
+
+// SelectUserTokenByHash selects a user token with given hash from the database.
+func (db *PostgresqlDatabase) SelectUserTokenByHash(hash string) (*models.UserToken, error) {
+	query := "SELECT id, created_at, user_id, purpose, token_hash, payload, expires_at, used_at FROM user_tokens WHERE token_hash=$1"
+
+	token := &models.UserToken{}
+	if err := db.connPool.QueryRow(context.Background(), query, hash).Scan(&token.ID, &token.CreatedAt, &token.UserID, &token.Purpose, &token.TokenHash, &token.Payload, &token.ExpiresAt, &token.UsedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting user token by hash", err)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected user token with ID: %d", token.ID)
+
+	return token, nil
+}
+
+// UseUserToken marks a user token with given ID as used if it has not been used yet.
+// It reports whether the token has been marked as used by this call.
+func (db *PostgresqlDatabase) UseUserToken(id int) (bool, error) {
+	query := "UPDATE user_tokens SET used_at = NOW() WHERE id = $1 AND used_at IS NULL"
+
+	tag, err := db.connPool.Exec(context.Background(), query, id)
+	if err != nil {
+		logger.Errorf("Error (%s) while using user token with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Used user token with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// InvalidateUserTokens marks all unused tokens of the user with given ID issued for the given purpose as used.
+func (db *PostgresqlDatabase) InvalidateUserTokens(userID int, purpose string) error {
+	query := "UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL"
+
+	if _, err := db.connPool.Exec(context.Background(), query, userID, purpose); err != nil {
+		logger.Errorf("Error (%s) while invalidating %s tokens of user with ID: %d", err, purpose, userID)
+
+		return err
+	}
+
+	logger.Infof("Invalidated %s tokens of user with ID: %d", purpose, userID)
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of the user with given ID with the given ones in the database.
+func (db *PostgresqlDatabase) ReplaceRecoveryCodes(userID int, codes []*models.RecoveryCode) error {
+	if err := pgx.BeginFunc(context.Background(), db.connPool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+
+		for _, code := range codes {
+			if _, err := tx.Exec(context.Background(), "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, code.CodeHash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		logger.Errorf("Error (%s) while replacing recovery codes of user with ID: %d", err, userID)
+
+		return err
+	}
+
+	logger.Infof("Replaced recovery codes of user with ID: %d", userID)
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code with given hash of the user with given ID as used.
+// It reports whether the code has been marked as used by this call.
+func (db *PostgresqlDatabase) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+
+	tag, err := db.connPool.Exec(context.Background(), query, userID, codeHash)
+	if err != nil {
+		logger.Errorf("Error (%s) while using recovery code of user with ID: %d", err, userID)
+
+		return false, err
+	}
+
+	logger.Infof("Used recovery code of user with ID: %d", userID)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// InsertAPIKey inserts a new API key into the database.
+func (db *PostgresqlDatabase) InsertAPIKey(key *models.APIKey) (int, error) {
+	var (
+		query string = "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+		id    int    = -1
+	)
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	if err := db.connPool.QueryRow(context.Background(), query, key.UserID, key.Name, key.Prefix, key.KeyHash, scopes, key.ExpiresAt).Scan(&id); err != nil {
+		logger.Errorf("Error (%s) while inserting new API key", err)
+
+		return id, err
+	}
+
+	logger.Infof("Inserted new API key with ID: %d", id)
+
+	return id, nil
+}
+
+// SelectAPIKeyByHash selects an API key with given hash from the database.
+func (db *PostgresqlDatabase) SelectAPIKeyByHash(hash string) (*models.APIKey, error) {
+	query := "SELECT id, created_at, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at FROM api_keys WHERE key_hash=$1"
+
+	key, err := scanAPIKey(db.connPool.QueryRow(context.Background(), query, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting API key by hash", err)
+
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// SelectAPIKeysByUserID selects API keys of the user with given ID, which have not been revoked, from the database.
+func (db *PostgresqlDatabase) SelectAPIKeysByUserID(userID int) ([]*models.APIKey, error) {
+	query := "SELECT id, created_at, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at FROM api_keys WHERE user_id=$1 AND revoked_at IS NULL ORDER BY id"
+
+	rows, err := db.connPool.Query(context.Background(), query, userID)
+	if err != nil {
+		logger.Errorf("Error (%s) while selecting API keys of user with ID: %d", err, userID)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			logger.Errorf("Error (%s) while selecting API keys of user with ID: %d", err, userID)
+
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Errorf("Error (%s) while selecting API keys of user with ID: %d", err, userID)
+
+		return nil, err
+	}
+
+	logger.Infof("Selected API keys of user with ID: %d", userID)
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key with given ID of the user with given ID if it has not been revoked yet.
+// It reports whether the key has been revoked by this call.
+func (db *PostgresqlDatabase) RevokeAPIKey(id, userID int) (bool, error) {
+	query := "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+
+	tag, err := db.connPool.Exec(context.Background(), query, id, userID)
+	if err != nil {
+		logger.Errorf("Error (%s) while revoking API key with ID: %d", err, id)
+
+		return false, err
+	}
+
+	logger.Infof("Revoked API key with ID: %d", id)
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdateAPIKeyLastUsedAt updates the time an API key with given ID has been last used at in the database.
+func (db *PostgresqlDatabase) UpdateAPIKeyLastUsedAt(id int, usedAt time.Time) error {
+	query := "UPDATE api_keys SET last_used_at = $1 WHERE id = $2"
+
+	if _, err := db.connPool.Exec(context.Background(), query, usedAt, id); err != nil {
+		logger.Errorf("Error (%s) while updating last use of API key with ID: %d", err, id)
+
+		return err
+	}
+
+	return nil
+}
+
+// scanAPIKey scans an API key from the given row selected with columns in the order of the APIKey fields.
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var (
+		key    = &models.APIKey{}
+		scopes []string
+	)
+
+	if err := row.Scan(&key.ID, &key.CreatedAt, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, models.Permission(scope))
+	}
+
+	return key, nil
+}
+
+// InsertOIDCLoginRequest inserts a new OIDC login request into the database.
+func (db *PostgresqlDatabase) InsertOIDCLoginRequest(request *models.OIDCLoginRequest) (int, error) {
+	var (
+		query string = "INSERT INTO oidc_login_requests (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4) RETURNING id"
+		id    int    = -1
+	)
+
+	if err := db.connPool.QueryRow(context.Background(), query, request.StateHash, request.Nonce, request.CodeVerifier, request.ExpiresAt).Scan(&id); err != nil {
+		logger.Errorf("Error (%s) while inserting new OIDC login request", err)
+
+		return id, err
+	}
+
+	logger.Infof("Inserted new OIDC login request with ID: %d", id)
+
+	return id, nil
+}
+
+// TakeOIDCLoginRequest deletes an OIDC login request with given state hash from the database and returns it,
+// so each request can be completed only once.
+func (db *PostgresqlDatabase) TakeOIDCLoginRequest(stateHash string) (*models.OIDCLoginRequest, error) {
+	query := "DELETE FROM oidc_login_requests WHERE state_hash=$1 RETURNING id, created_at, state_hash, nonce, code_verifier, expires_at"
+
+	request := &models.OIDCLoginRequest{}
+	if err := db.connPool.QueryRow(context.Background(), query, stateHash).Scan(&request.ID, &request.CreatedAt, &request.StateHash, &request.Nonce, &request.CodeVerifier, &request.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while taking OIDC login request by state hash", err)
+
+		return nil, err
+	}
+
+	logger.Infof("Took OIDC login request with ID: %d", request.ID)
+
+	return request, nil
+}
+
+// DeleteExpiredOIDCLoginRequests deletes OIDC login requests which have expired before the given time.
+// It returns the number of deleted requests.
+func (db *PostgresqlDatabase) DeleteExpiredOIDCLoginRequests(before time.Time) (int, error) {
+	query := "DELETE FROM oidc_login_requests WHERE expires_at < $1"
+
+	tag, err := db.connPool.Exec(context.Background(), query, before)
+	if err != nil {
+		logger.Errorf("Error (%s) while deleting expired OIDC login requests", err)
+
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// SelectLoginAttempt selects failed login attempts tracked for the given key from the database.
+func (db *PostgresqlDatabase) SelectLoginAttempt(key string) (*models.LoginAttempt, error) {
+	query := "SELECT key, failures, last_failure_at, pending, last_reserved_at FROM login_attempts WHERE key=$1"
+
+	attempt := &models.LoginAttempt{}
+	if err := db.connPool.QueryRow(context.Background(), query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.Pending, &attempt.LastReservedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		logger.Errorf("Error (%s) while selecting login attempts for key: %s", err, key)
+
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// ReserveLoginAttempt records a pending login attempt for the given key in the database and returns the updated attempts.
+// Failures recorded before failuresResetBefore are forgotten, so counting starts over,
+// and so are pending attempts if the last one has been reserved before pendingResetBefore, as they have been abandoned.
+func (db *PostgresqlDatabase) ReserveLoginAttempt(key string, reservedAt, failuresResetBefore, pendingResetBefore time.Time) (*models.LoginAttempt, error) {
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at, pending, last_reserved_at) VALUES ($1, 0, to_timestamp(0), 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 0 ELSE login_attempts.failures END,
+			pending = CASE WHEN login_attempts.last_reserved_at < $4 THEN 1 ELSE login_attempts.pending + 1 END,
+			last_reserved_at = $2
+		RETURNING key, failures, last_failure_at, pending, last_reserved_at`
+
+	attempt := &models.LoginAttempt{}
+	if err := db.connPool.QueryRow(context.Background(), query, key, reservedAt, failuresResetBefore, pendingResetBefore).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.Pending, &attempt.LastReservedAt); err != nil {
+		logger.Errorf("Error (%s) while reserving login attempt for key: %s", err, key)
+
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// ReleaseLoginAttempt takes back a pending login attempt recorded for the given key in the database, leaving failures untouched.
+func (db *PostgresqlDatabase) ReleaseLoginAttempt(key string) error {
+	query := "UPDATE login_attempts SET pending = pending - 1 WHERE key=$1 AND pending > 0"
+
+	if _, err := db.connPool.Exec(context.Background(), query, key); err != nil {
+		logger.Errorf("Error (%s) while releasing login attempt for key: %s", err, key)
+
+		return err
+	}
+
+	return nil
+}
+
+// FailLoginAttempt records a pending login attempt for the given key in the database as failed and returns the updated attempts.
+// Failures recorded before resetBefore are forgotten, so counting starts over.
+func (db *PostgresqlDatabase) FailLoginAttempt(key string, failedAt, resetBefore time.Time) (*models.LoginAttempt, error) {
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = $2,
+			pending = GREATEST(login_attempts.pending - 1, 0)
+		RETURNING key, failures, last_failure_at, pending, last_reserved_at`
+
+	attempt := &models.LoginAttempt{}
+	if err := db.connPool.QueryRow(context.Background(), query, key, failedAt, resetBefore).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.Pending, &attempt.LastReservedAt); err != nil {
+		logger.Errorf("Error (%s) while recording failed login attempt for key: %s", err, key)
+
+		return nil, err
+	}
+
+	logger.Infof("Recorded failed login attempt %d for key: %s", attempt.Failures, key)
+
+	return attempt, nil
+}
+
+// DeleteLoginAttempt deletes failed login attempts tracked for the given key from the database.
+func (db *PostgresqlDatabase) DeleteLoginAttempt(key string) error {
+	query := "DELETE FROM login_attempts WHERE key=$1"
+
+	if _, err := db.connPool.Exec(context.Background(), query, key); err != nil {
+		logger.Errorf("Error (%s) while deleting login attempts for key: %s", err, key)
+
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredLoginAttempts deletes login attempts whose last failure has been recorded and whose last attempt has been reserved
+// before the given time. It returns the number of deleted entries.
+func (db *PostgresqlDatabase) DeleteExpiredLoginAttempts(before time.Time) (int, error) {
+	query := "DELETE FROM login_attempts WHERE last_failure_at < $1 AND last_reserved_at < $1"
+
+	tag, err := db.connPool.Exec(context.Background(), query, before)
+	if err != nil {
+		logger.Errorf("Error (%s) while deleting expired login attempts", err)
+
+		return 0, err
+	}
+
+	logger.Infof("Deleted %d expired login attempts", tag.RowsAffected())
+
+	return int(tag.RowsAffected()), nil
 }
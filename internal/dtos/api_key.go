@@ -0,0 +1,30 @@
+package dtos
+
+import "time"
+
+// APIKeyDTO represents a data transfer object (DTO) for an API key.
+// The key itself is never returned, only its prefix, which identifies it.
+type APIKeyDTO struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// APIKeyCreateDTO represents a data transfer object (DTO) for creating an API key request.
+// A key without an expiration time is valid until it is revoked.
+type APIKeyCreateDTO struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreatedDTO represents a data transfer object (DTO) for a created API key.
+// It is the only response the key is returned in, as only a hash of it is stored.
+type APIKeyCreatedDTO struct {
+	APIKeyDTO
+	Key string `json:"key"`
+}
@@ -3,15 +3,93 @@ package dtos
 import "time"
 
 // BookDTO represents a data transfer object (DTO) for a book.
+// The metadata following the title is optional and omitted if it is unknown.
 type BookDTO struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Author    string    `json:"author"`
-	Title     string    `json:"title"`
+	ID              int64     `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	Author          string    `json:"author"`
+	Title           string    `json:"title"`
+	ISBN            string    `json:"isbn,omitempty"`
+	Publisher       string    `json:"publisher,omitempty"`
+	PublicationYear int64     `json:"publication_year,omitempty"`
+	Language        string    `json:"language,omitempty"`
+	PageCount       int64     `json:"page_count,omitempty"`
+	Description     string    `json:"description,omitempty"`
 }
 
 // BookCreateDTO represents a data transfer object (DTO) for creating a book request.
+// ISBN is an ISBN-10 or ISBN-13, with or without hyphens, and Language a BCP 47 language tag.
 type BookCreateDTO struct {
-	Author string `json:"author"`
-	Title  string `json:"title"`
+	Author          string `json:"author"`
+	Title           string `json:"title"`
+	ISBN            string `json:"isbn"`
+	Publisher       string `json:"publisher"`
+	PublicationYear int64  `json:"publication_year"`
+	Language        string `json:"language"`
+	PageCount       int64  `json:"page_count"`
+	Description     string `json:"description"`
+}
+
+// BookQueryDTO represents a data transfer object (DTO) for querying a page of books.
+// Books are filtered by the fields which are set, the same way as by models.BookFilter, and sorted by the Sort field in the Order, ascending or descending.
+// A page follows either the cursor returned with another page, or the first Offset books.
+type BookQueryDTO struct {
+	Author      string     `json:"author"`
+	Title       string     `json:"title"`
+	CreatedBy   int64      `json:"created_by"`
+	CreatedFrom *time.Time `json:"created_from"`
+	CreatedTo   *time.Time `json:"created_to"`
+	Sort        string     `json:"sort"`
+	Order       string     `json:"order"`
+	Limit       int64      `json:"limit"`
+	Offset      int64      `json:"offset"`
+	Cursor      string     `json:"cursor"`
+}
+
+// BookSuggestionsDTO represents a data transfer object (DTO) for authors and titles similar to the ones books are filtered by,
+// which the user might have meant.
+type BookSuggestionsDTO struct {
+	Author []string `json:"author,omitempty"`
+	Title  []string `json:"title,omitempty"`
+}
+
+// BookPageDTO represents a data transfer object (DTO) for a page of books.
+// NextCursor and PrevCursor are cursors of the next and the previous page, empty if there is no such page.
+// Next and Prev are links to these pages, set by the server.
+// DidYouMean holds suggestions of authors and titles similar to the ones books are filtered by, if there are any.
+type BookPageDTO struct {
+	Books      []*BookDTO          `json:"books"`
+	Total      int64               `json:"total"`
+	TotalPages int64               `json:"total_pages"`
+	Limit      int64               `json:"limit"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	PrevCursor string              `json:"prev_cursor,omitempty"`
+	Next       string              `json:"next,omitempty"`
+	Prev       string              `json:"prev,omitempty"`
+	DidYouMean *BookSuggestionsDTO `json:"did_you_mean,omitempty"`
+}
+
+// BookSearchDTO represents a data transfer object (DTO) for a full-text search of books.
+// The query is split into words, and books match if their title or author has words starting with all of them.
+type BookSearchDTO struct {
+	Query  string `json:"q"`
+	Limit  int64  `json:"limit"`
+	Offset int64  `json:"offset"`
+}
+
+// BookSearchResultDTO represents a data transfer object (DTO) for a book found by a search.
+// TitleHighlight and AuthorHighlight are the HTML escaped title and author with the matching words wrapped in <mark> tags.
+type BookSearchResultDTO struct {
+	Book            *BookDTO `json:"book"`
+	Rank            float64  `json:"rank"`
+	TitleHighlight  string   `json:"title_highlight"`
+	AuthorHighlight string   `json:"author_highlight"`
+}
+
+// BookSearchPageDTO represents a data transfer object (DTO) for a page of books found by a search, the most relevant first.
+type BookSearchPageDTO struct {
+	Results []*BookSearchResultDTO `json:"results"`
+	Total   int64                  `json:"total"`
+	Limit   int64                  `json:"limit"`
+	Offset  int64                  `json:"offset"`
 }
@@ -1,6 +1,8 @@
 package dtos
 
 // ErrorDTO represents a data transfer object (DTO) for an error.
+// Fields maps the invalid fields of the request body to the errors describing them, if the error is caused by them.
 type ErrorDTO struct {
-	Error string `json:"error"`
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
 }
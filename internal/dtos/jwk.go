@@ -0,0 +1,18 @@
+package dtos
+
+// JWKDTO represents a data transfer object (DTO) for a public JSON Web Key.
+type JWKDTO struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSDTO represents a data transfer object (DTO) for a JSON Web Key Set.
+type JWKSDTO struct {
+	Keys []*JWKDTO `json:"keys"`
+}
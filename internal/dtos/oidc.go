@@ -0,0 +1,17 @@
+package dtos
+
+// OIDCLoginDTO represents a data transfer object (DTO) for a started login with an OpenID Connect provider.
+// The user agent has to be redirected to URL, and State has to be bound to it, e.g. in a cookie.
+type OIDCLoginDTO struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// OIDCCallbackDTO represents a data transfer object (DTO) for completing a login with an OpenID Connect provider.
+// ClientIP and UserAgent are the IP address and the user agent of the client, set by the server rather than decoded from the request.
+type OIDCCallbackDTO struct {
+	Code      string `json:"code"`
+	State     string `json:"state"`
+	ClientIP  string `json:"-"`
+	UserAgent string `json:"-"`
+}
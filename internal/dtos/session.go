@@ -0,0 +1,15 @@
+package dtos
+
+import "time"
+
+// SessionDTO represents a data transfer object (DTO) for a session of a user on a device.
+// Current reports whether the request has been authenticated with a token issued in the session.
+type SessionDTO struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
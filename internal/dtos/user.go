@@ -3,14 +3,33 @@ package dtos
 import "time"
 
 // UserDTO represents a data transfer object (DTO) for a user.
+// The age is 0 when the user has not provided it.
 type UserDTO struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Age       int64     `json:"age"`
+	ID               int64      `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Email            string     `json:"email"`
+	Password         string     `json:"password,omitempty"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	Age              int64      `json:"age"`
+	Role             string     `json:"role"`
+	VerifiedAt       *time.Time `json:"verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	DisabledAt       *time.Time `json:"disabled_at"`
+}
+
+// UserPageDTO represents a data transfer object (DTO) for a page of users.
+// Total is the number of all users matching the query, not only the ones on the page.
+type UserPageDTO struct {
+	Users  []*UserDTO `json:"users"`
+	Total  int64      `json:"total"`
+	Limit  int64      `json:"limit"`
+	Offset int64      `json:"offset"`
+}
+
+// UserRoleUpdateDTO represents a data transfer object (DTO) for changing the role of a user request.
+type UserRoleUpdateDTO struct {
+	Role string `json:"role"`
 }
 
 // AccountCreateDTO represents a data transfer object (DTO) for creating a user account request.
@@ -22,13 +41,110 @@ type AccountCreateDTO struct {
 	Age       int64  `json:"age"`
 }
 
+// UserUpdateDTO represents a data transfer object (DTO) for updating a user account request.
+// Fields that are not set are left unchanged.
+type UserUpdateDTO struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Age       *int64  `json:"age"`
+}
+
+// PasswordChangeDTO represents a data transfer object (DTO) for changing the password request.
+// ClientIP is the IP address of the client, set by the server rather than decoded from the request.
+type PasswordChangeDTO struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	ClientIP        string `json:"-"`
+}
+
+// EmailChangeDTO represents a data transfer object (DTO) for changing the email address request.
+// ClientIP is the IP address of the client, set by the server rather than decoded from the request.
+type EmailChangeDTO struct {
+	NewEmail string `json:"new_email"`
+	Password string `json:"password"`
+	ClientIP string `json:"-"`
+}
+
+// EmailChangeConfirmDTO represents a data transfer object (DTO) for confirming the change of the email address request.
+type EmailChangeConfirmDTO struct {
+	Token string `json:"token"`
+}
+
+// PasswordForgotDTO represents a data transfer object (DTO) for requesting a password reset.
+type PasswordForgotDTO struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetDTO represents a data transfer object (DTO) for resetting a forgotten password request.
+type PasswordResetDTO struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// VerificationResendDTO represents a data transfer object (DTO) for requesting another verification email.
+type VerificationResendDTO struct {
+	Email string `json:"email"`
+}
+
 // UserLoginDTO represents a data transfer object (DTO) for user login request.
+// ClientIP and UserAgent are the IP address and the user agent of the client, set by the server rather than decoded from the request.
 type UserLoginDTO struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	ClientIP  string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 // TokenDTO represents a data transfer object (DTO) for a token.
+// If the user has two-factor authentication enabled, logging in with the password returns only a challenge token,
+// which has to be exchanged together with the second factor for the tokens.
 type TokenDTO struct {
-	Token string `json:"token"`
+	Token             string `json:"token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// TwoFactorLoginDTO represents a data transfer object (DTO) for completing the login with the second factor request.
+// The code is either a TOTP code or a recovery code.
+// ClientIP and UserAgent are the IP address and the user agent of the client, set by the server rather than decoded from the request.
+type TwoFactorLoginDTO struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	ClientIP       string `json:"-"`
+	UserAgent      string `json:"-"`
+}
+
+// TwoFactorEnrollmentDTO represents a data transfer object (DTO) for a started enrollment in two-factor authentication.
+// The URI is an otpauth URI, which authenticator apps can import.
+type TwoFactorEnrollmentDTO struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorConfirmDTO represents a data transfer object (DTO) for confirming the enrollment in two-factor authentication request.
+type TwoFactorConfirmDTO struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorDisableDTO represents a data transfer object (DTO) for disabling two-factor authentication request.
+// The code is either a TOTP code or a recovery code.
+// ClientIP is the IP address of the client, set by the server rather than decoded from the request.
+type TwoFactorDisableDTO struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	ClientIP string `json:"-"`
+}
+
+// RecoveryCodesDTO represents a data transfer object (DTO) for recovery codes.
+type RecoveryCodesDTO struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// RefreshTokenDTO represents a data transfer object (DTO) for a refresh token request.
+// ClientIP and UserAgent are the IP address and the user agent of the client, set by the server rather than decoded from the request.
+type RefreshTokenDTO struct {
+	RefreshToken string `json:"refresh_token"`
+	ClientIP     string `json:"-"`
+	UserAgent    string `json:"-"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// APIKey represents a model for a long-lived key a user can authenticate scripts and integrations with.
+// Only a hash of the key is stored, together with its prefix, which identifies the key to the user.
+// The key grants only the permissions listed in Scopes which are also granted by the current role of the user.
+type APIKey struct {
+	ID         int          `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UserID     int          `json:"user_id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	KeyHash    string       `json:"key_hash"`
+	Scopes     []Permission `json:"scopes"`
+	ExpiresAt  *time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+	RevokedAt  *time.Time   `json:"revoked_at"`
+}
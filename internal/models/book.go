@@ -3,10 +3,105 @@ package models
 import "time"
 
 // Book represents a model for a book.
+// ISBN is a normalized ISBN-13 and Language a canonical BCP 47 language tag.
+// The metadata following the title is optional, and empty strings and zeros mean it is unknown.
+// CreatedBy is 0 if the user who has created the book has been deleted.
 type Book struct {
-	ID        int       `json:"id"`
-	CreatedBy int       `json:"created_by"`
-	CreatedAt time.Time `json:"created_at"`
-	Author    string    `json:"author"`
-	Title     string    `json:"title"`
+	ID              int       `json:"id"`
+	CreatedBy       int       `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	Author          string    `json:"author"`
+	Title           string    `json:"title"`
+	ISBN            string    `json:"isbn"`
+	Publisher       string    `json:"publisher"`
+	PublicationYear int       `json:"publication_year"`
+	Language        string    `json:"language"`
+	PageCount       int       `json:"page_count"`
+	Description     string    `json:"description"`
+}
+
+// BookSortField is a field books can be sorted by.
+type BookSortField string
+
+const (
+	// BookSortFieldAuthor sorts books by the author.
+	BookSortFieldAuthor BookSortField = "author"
+	// BookSortFieldTitle sorts books by the title.
+	BookSortFieldTitle BookSortField = "title"
+	// BookSortFieldCreatedBy sorts books by the ID of the user who has created them.
+	BookSortFieldCreatedBy BookSortField = "created_by"
+	// BookSortFieldCreatedAt sorts books by the creation time.
+	BookSortFieldCreatedAt BookSortField = "created_at"
+)
+
+// IsValid reports whether the field is one of the fields books can be sorted by.
+func (f BookSortField) IsValid() bool {
+	switch f {
+	case BookSortFieldAuthor, BookSortFieldTitle, BookSortFieldCreatedBy, BookSortFieldCreatedAt:
+		return true
+	}
+
+	return false
+}
+
+// BookFilter selects books matching all of its fields which are set.
+// Author and Title match books whose author and title contain them, ignoring case.
+// If Similarity is positive, they also match books whose author and title have a word similarity to them of at least Similarity,
+// which is the greatest share of trigrams they have in common with a continuous extent of the author or the title, as in pg_trgm.
+// CreatedFrom and CreatedTo match books created at or after and before the given times.
+type BookFilter struct {
+	Author      string
+	Title       string
+	Similarity  float64
+	CreatedBy   int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// BookSort sorts books by the field, with books which have the same value of the field sorted by the ID in the same direction.
+type BookSort struct {
+	Field      BookSortField
+	Descending bool
+}
+
+// BookCursor is a position of a book in a sorted list of books.
+type BookCursor struct {
+	Author    string
+	Title     string
+	CreatedBy int
+	CreatedAt time.Time
+	ID        int
+}
+
+// BookPage selects a page of at most Limit books from a sorted list of books.
+// The page holds the books following After or preceding Before, if one of them is set, or otherwise the books following the first Offset ones.
+type BookPage struct {
+	Limit  int
+	Offset int
+	After  *BookCursor
+	Before *BookCursor
+}
+
+// BookQuery selects a page of the books matching the filter, sorted by the sort.
+type BookQuery struct {
+	Filter BookFilter
+	Sort   BookSort
+	Page   BookPage
+}
+
+// BookSearch selects a page of at most Limit books matching all of the terms, following the first Offset ones.
+// Terms match the words of the title and the author which start with them, after both are reduced to their stems.
+type BookSearch struct {
+	Terms  []string
+	Limit  int
+	Offset int
+}
+
+// BookSearchResult is a book matching a search, with its relevance to the search and its title and author with the matching words highlighted.
+// The highlights are HTML escaped, except for the <mark> tags around the matching words.
+type BookSearchResult struct {
+	Book            *Book
+	Rank            float64
+	TitleHighlight  string
+	AuthorHighlight string
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt represents a model for failed login attempts tracked for a key, such as an email address or an IP address.
+// Pending is the number of attempts which have been reserved, but have neither failed nor been released yet.
+type LoginAttempt struct {
+	Key            string    `json:"key"`
+	Failures       int       `json:"failures"`
+	LastFailureAt  time.Time `json:"last_failure_at"`
+	Pending        int       `json:"pending"`
+	LastReservedAt time.Time `json:"last_reserved_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// OIDCLoginRequest represents a model for a started login with an OpenID Connect provider.
+// It is identified by a hash of the state sent to the provider and holds the nonce and the PKCE code verifier,
+// which are required to complete the login once the provider redirects back.
+type OIDCLoginRequest struct {
+	ID           int       `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	StateHash    string    `json:"state_hash"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
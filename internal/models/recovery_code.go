@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RecoveryCode represents a model for a single use code allowing a user to log in without the second factor.
+// Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        int        `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int        `json:"user_id"`
+	CodeHash  string     `json:"code_hash"`
+	UsedAt    *time.Time `json:"used_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RefreshToken represents a model for a refresh token.
+// Only a hash of the token is stored. Tokens created by rotating each other share the same FamilyID.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	TokenHash string     `json:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// RevokedToken represents a model for an entry of the token revocation list.
+// An entry with an empty TokenID revokes all tokens issued to the user before RevokedAt.
+// An entry can be removed once ExpiresAt has passed, as the tokens it revokes are expired by then.
+type RevokedToken struct {
+	ID        int       `json:"id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	UserID    int       `json:"user_id"`
+	TokenID   string    `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,64 @@
+package models
+
+// Role represents a role of a user, which determines the permissions granted to the user.
+type Role string
+
+const (
+	// RoleReader is a role of a user who can only browse books.
+	RoleReader Role = "reader"
+	// RoleEditor is a role of a user who can browse and manage books.
+	RoleEditor Role = "editor"
+	// RoleAdmin is a role of a user who can do everything, including managing other users and reading metrics.
+	RoleAdmin Role = "admin"
+)
+
+// Permission represents a permission to perform an operation.
+type Permission string
+
+const (
+	// PermissionReadBooks is a permission to browse books.
+	PermissionReadBooks Permission = "books:read"
+	// PermissionWriteBooks is a permission to create, update and delete books.
+	PermissionWriteBooks Permission = "books:write"
+	// PermissionManageUsers is a permission to manage other users.
+	PermissionManageUsers Permission = "users:manage"
+	// PermissionReadMetrics is a permission to read the metrics of the server.
+	PermissionReadMetrics Permission = "metrics:read"
+)
+
+// rolePermissions maps roles to the permissions granted to them.
+var rolePermissions = map[Role][]Permission{
+	RoleReader: {PermissionReadBooks},
+	RoleEditor: {PermissionReadBooks, PermissionWriteBooks},
+	RoleAdmin:  {PermissionReadBooks, PermissionWriteBooks, PermissionManageUsers, PermissionReadMetrics},
+}
+
+// permissions is a set of all known permissions.
+var permissions = map[Permission]bool{
+	PermissionReadBooks:   true,
+	PermissionWriteBooks:  true,
+	PermissionManageUsers: true,
+	PermissionReadMetrics: true,
+}
+
+// IsValid reports whether the permission is one of the known permissions.
+func (p Permission) IsValid() bool {
+	return permissions[p]
+}
+
+// IsValid reports whether the role is one of the known roles.
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// HasPermission reports whether the role grants the given permission.
+func (r Role) HasPermission(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session represents a model for a session of a user on a device, started by logging in.
+// It is backed by the refresh token family with the same FamilyID and lasts as long as its refresh tokens.
+// IPAddress and UserAgent are of the client which has last logged in or refreshed tokens in the session.
+type Session struct {
+	ID           int        `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       int        `json:"user_id"`
+	FamilyID     string     `json:"family_id"`
+	IPAddress    string     `json:"ip_address"`
+	UserAgent    string     `json:"user_agent"`
+	LastUsedAt   time.Time  `json:"last_used_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	TerminatedAt *time.Time `json:"terminated_at"`
+}
@@ -3,12 +3,23 @@ package models
 import "time"
 
 // User represents a model for a user.
+// Age is 0 if it is unknown, e.g. for a user created with OpenID Connect who has not set it yet.
 type User struct {
-	ID        int       `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Age       int       `json:"age"`
+	ID                  int        `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	Email               string     `json:"email"`
+	Password            string     `json:"password"`
+	FirstName           string     `json:"first_name"`
+	LastName            string     `json:"last_name"`
+	Age                 int        `json:"age"`
+	Role                Role       `json:"role"`
+	VerifiedAt          *time.Time `json:"verified_at"`
+	VerificationSentAt  *time.Time `json:"verification_sent_at"`
+	PasswordResetSentAt *time.Time `json:"password_reset_sent_at"`
+	TOTPSecret          string     `json:"totp_secret"`
+	TOTPEnabledAt       *time.Time `json:"totp_enabled_at"`
+	TOTPLastUsedStep    int64      `json:"totp_last_used_step"`
+	OIDCIssuer          string     `json:"oidc_issuer"`
+	OIDCSubject         string     `json:"oidc_subject"`
+	DisabledAt          *time.Time `json:"disabled_at"`
 }
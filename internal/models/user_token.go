@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	// UserTokenPurposeEmailChange is a purpose of a token confirming a change of the email address.
+	// The payload of the token is the new email address.
+	UserTokenPurposeEmailChange = "email_change"
+	// UserTokenPurposePasswordReset is a purpose of a token allowing to reset a forgotten password.
+	UserTokenPurposePasswordReset = "password_reset"
+	// UserTokenPurposeEmailVerification is a purpose of a token verifying the email address of a newly registered user.
+	UserTokenPurposeEmailVerification = "email_verification"
+	// UserTokenPurposeTwoFactorChallenge is a purpose of a token proving that a user with two-factor authentication enabled
+	// has provided a valid password, so only the second factor is required to complete the login.
+	UserTokenPurposeTwoFactorChallenge = "two_factor_challenge"
+)
+
+// UserToken represents a model for a single use token sent to a user to confirm an operation.
+// Only a hash of the token is stored. The payload holds data of the operation, depending on the purpose.
+type UserToken struct {
+	ID        int        `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int        `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	TokenHash string     `json:"token_hash"`
+	Payload   string     `json:"payload"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
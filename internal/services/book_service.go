@@ -1,12 +1,20 @@
 package services
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/database"
 	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
 	"github.com/MSSkowron/BookRESTAPI/internal/models"
+	"golang.org/x/text/language"
 )
 
 var (
@@ -14,53 +22,301 @@ var (
 	ErrInvalidID = errors.New("id must be a positive integer")
 	// ErrInvalidCreatedByID is returned when the given createdByID is not a positive integer.
 	ErrInvalidCreatedByID = errors.New("createdByID must be a positive integer")
-	// ErrInvalidAuthor is returned when the given author is empty.
-	ErrInvalidAuthor = errors.New("author must not be empty")
-	// ErrInvalidTitle is returned when the given title is empty.
-	ErrInvalidTitle = errors.New("title must not be empty")
+	// ErrInvalidAuthor is returned when the given author is empty or too long.
+	ErrInvalidAuthor = errors.New("author must not be empty or longer than 100 characters")
+	// ErrInvalidTitle is returned when the given title is empty or too long.
+	ErrInvalidTitle = errors.New("title must not be empty or longer than 100 characters")
 	// ErrInvalidAuthorOrTitle is returned when the given author or title is empty.
 	ErrInvalidAuthorOrTitle = errors.New("invalid author or title")
+	// ErrInvalidISBN is returned when the given ISBN is neither a valid ISBN-10 nor a valid ISBN-13.
+	ErrInvalidISBN = errors.New("isbn must be a valid ISBN-10 or ISBN-13")
+	// ErrInvalidPublisher is returned when the given publisher is too long.
+	ErrInvalidPublisher = errors.New("publisher must not be longer than 100 characters")
+	// ErrInvalidPublicationYear is returned when the given publication year is negative or in the future.
+	ErrInvalidPublicationYear = errors.New("publication_year must not be negative or later than the next year")
+	// ErrInvalidLanguage is returned when the given language is not a valid BCP 47 language tag or it is too long.
+	ErrInvalidLanguage = errors.New("language must be a valid BCP 47 language tag not longer than 35 characters")
+	// ErrInvalidPageCount is returned when the given page count is negative or too high.
+	ErrInvalidPageCount = errors.New("page_count must not be negative or greater than 100000")
+	// ErrInvalidDescription is returned when the given description is too long.
+	ErrInvalidDescription = errors.New("description must not be longer than 5000 characters")
 	// ErrBookNotFound is returned when the book with the given id does not exist in the database.
 	ErrBookNotFound = errors.New("book not found")
+	// ErrForbidden is returned when the user is not allowed to modify the book with the given id.
+	// Only the user who has created the book or an admin can modify it.
+	ErrForbidden = errors.New("only the owner of the book or an admin can modify it")
+	// ErrInvalidCursor is returned when a page of books is requested with a cursor which has not been returned with another page,
+	// or with both a cursor and an offset.
+	ErrInvalidCursor = errors.New("cursor must be returned with another page of books and must not be combined with an offset")
+	// ErrInvalidBookSort is returned when books are sorted by an unknown field or in an unknown order.
+	ErrInvalidBookSort = errors.New("sort must be one of author, title, created_by and created_at and order must be asc or desc")
+	// ErrInvalidCreatedByFilter is returned when books are filtered by a user ID which is not a positive integer.
+	ErrInvalidCreatedByFilter = errors.New("created_by must be a positive integer")
+	// ErrInvalidCreatedRange is returned when books are filtered by a range of creation times which ends before it starts.
+	ErrInvalidCreatedRange = errors.New("created_from must be before created_to")
+	// ErrInvalidSearchQuery is returned when books are searched by a query without words or with too many of them.
+	ErrInvalidSearchQuery = errors.New("search query must contain between 1 and 10 words")
 )
 
+const (
+	// DefaultBookPageLimit is the default number of books on a page.
+	DefaultBookPageLimit = 20
+	// MaxBookPageLimit is the maximum number of books on a page.
+	MaxBookPageLimit = 100
+	// DefaultBookSortField is the default field books are sorted by.
+	DefaultBookSortField = models.BookSortFieldCreatedAt
+	// MaxSearchTerms is the maximum number of words of a search query.
+	MaxSearchTerms = 10
+	// DefaultFuzzyMatchThreshold is the default minimum word similarity of an author or a title to the one books are filtered by
+	// for the book to match it.
+	DefaultFuzzyMatchThreshold = 0.4
+	// MaxBookSuggestions is the maximum number of suggested authors and titles.
+	MaxBookSuggestions = 3
+	// MaxAuthorLength is the maximum number of characters of an author.
+	MaxAuthorLength = 100
+	// MaxTitleLength is the maximum number of characters of a title.
+	MaxTitleLength = 100
+	// MaxLanguageLength is the maximum number of characters of a canonical language tag.
+	MaxLanguageLength = 35
+	// MaxPublisherLength is the maximum number of characters of a publisher.
+	MaxPublisherLength = 100
+	// MaxPageCount is the maximum page count of a book.
+	MaxPageCount = 100000
+	// MaxDescriptionLength is the maximum number of characters of a description.
+	MaxDescriptionLength = 5000
+
+	// bookSortOrderAscending is the order of books sorted ascending.
+	bookSortOrderAscending = "asc"
+	// bookSortOrderDescending is the order of books sorted descending.
+	bookSortOrderDescending = "desc"
+)
+
+// BookValidationError is returned when fields of a book are invalid.
+// It maps every invalid field, named as in JSON, to the error describing it, such as ErrInvalidAuthor or ErrInvalidISBN, and wraps these errors.
+type BookValidationError struct {
+	Fields map[string]error
+}
+
+// Error returns a message listing the errors of the invalid fields, ordered by the names of the fields.
+func (e *BookValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, err := range e.Unwrap() {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// Unwrap returns the errors of the invalid fields, ordered by the names of the fields.
+func (e *BookValidationError) Unwrap() []error {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	errs := make([]error, 0, len(fields))
+	for _, field := range fields {
+		errs = append(errs, e.Fields[field])
+	}
+
+	return errs
+}
+
 // BookService is an interface that defines the methods that the BookService struct must implement.
 type BookService interface {
-	GetBooks() ([]*dtos.BookDTO, error)
+	GetBooks(*dtos.BookQueryDTO) (*dtos.BookPageDTO, error)
+	SearchBooks(*dtos.BookSearchDTO) (*dtos.BookSearchPageDTO, error)
 	GetBook(int) (*dtos.BookDTO, error)
 	AddBook(int, *dtos.BookCreateDTO) (*dtos.BookDTO, error)
-	UpdateBook(int, *dtos.BookDTO) (*dtos.BookDTO, error)
-	DeleteBook(int) error
+	UpdateBook(int, int, *dtos.BookDTO) (*dtos.BookDTO, error)
+	DeleteBook(int, int) error
 }
 
 // BookServiceImpl is a struct that implements the BookService interface.
 type BookServiceImpl struct {
-	db database.Database
+	db                  database.Database
+	fuzzyMatchThreshold float64
 }
 
 // NewBookService creates a new BookServiceImpl.
-func NewBookService(db database.Database) *BookServiceImpl {
-	return &BookServiceImpl{db: db}
+func NewBookService(db database.Database, opts ...BookServiceOption) *BookServiceImpl {
+	bookService := &BookServiceImpl{
+		db:                  db,
+		fuzzyMatchThreshold: DefaultFuzzyMatchThreshold,
+	}
+
+	for _, opt := range opts {
+		opt(bookService)
+	}
+
+	return bookService
+}
+
+// BookServiceOption is a function signature for providing options to configure the BookServiceImpl.
+type BookServiceOption func(*BookServiceImpl)
+
+// WithFuzzyMatchThreshold is an option to set the minimum word similarity, from 0 to 1, of an author or a title to the one books are filtered by
+// for the book to match it, even though it does not contain it. Fuzzy matching and suggestions are disabled if it is 0.
+func WithFuzzyMatchThreshold(threshold float64) BookServiceOption {
+	return func(bs *BookServiceImpl) {
+		if threshold >= 0 && threshold <= 1 {
+			bs.fuzzyMatchThreshold = threshold
+		}
+	}
 }
 
-// GetBooks returns all books from the database.
-func (bs *BookServiceImpl) GetBooks() ([]*dtos.BookDTO, error) {
-	books, err := bs.db.SelectAllBooks()
+// GetBooks returns a page of the books matching the query, sorted by the creation time unless the query sets another field.
+// The page follows the first books skipped by the offset or the position the cursor points to, which is kept stable by new and deleted books.
+// The returned page holds the cursors of the next and the previous page, if there are such pages.
+// Unless fuzzy matching is disabled, the author and the title of the query also match similar ones, which the page suggests.
+func (bs *BookServiceImpl) GetBooks(dto *dtos.BookQueryDTO) (*dtos.BookPageDTO, error) {
+	limit, offset := int(dto.Limit), int(dto.Offset)
+	if limit < 1 || limit > MaxBookPageLimit || offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	query, err := bs.bookQuery(dto)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more book than requested is selected to find out whether there are more books past the page.
+	page := &query.Page
+	page.Limit, page.Offset = limit+1, offset
+	if dto.Cursor != "" {
+		if offset != 0 {
+			return nil, ErrInvalidCursor
+		}
+
+		cursor, err := bs.decodeCursor(dto.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != bs.sortKey(query.Sort) {
+			return nil, ErrInvalidCursor
+		}
+
+		position := &models.BookCursor{
+			Author:    cursor.Author,
+			Title:     cursor.Title,
+			CreatedBy: cursor.CreatedBy,
+			CreatedAt: cursor.CreatedAt,
+			ID:        cursor.ID,
+		}
+		if cursor.Before {
+			page.Before = position
+		} else {
+			page.After = position
+		}
+	}
+
+	books, err := bs.db.SelectBooks(query)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := bs.db.CountBooks(&query.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions, err := bs.suggest(&query.Filter)
 	if err != nil {
 		return nil, err
 	}
+	more := len(books) > limit
+	hasPrev, hasNext := offset > 0 || page.After != nil, more
+	if page.Before != nil {
+		// The selection goes backwards, so the additional book precedes the page.
+		books = books[max(len(books)-limit, 0):]
+		hasPrev, hasNext = more, true
+	} else {
+		books = books[:min(limit, len(books))]
+	}
 
-	booksDTO := []*dtos.BookDTO{}
+	bookPageDTO := &dtos.BookPageDTO{
+		Books:      make([]*dtos.BookDTO, 0, len(books)),
+		Total:      int64(total),
+		TotalPages: int64((total + limit - 1) / limit),
+		Limit:      int64(limit),
+		DidYouMean: suggestions,
+	}
 	for _, book := range books {
-		booksDTO = append(booksDTO, &dtos.BookDTO{
-			ID:        int64(book.ID),
-			Author:    book.Author,
-			Title:     book.Title,
-			CreatedAt: book.CreatedAt,
+		bookPageDTO.Books = append(bookPageDTO.Books, bs.bookDTO(book))
+	}
+
+	if len(books) == 0 {
+		return bookPageDTO, nil
+	}
+
+	if hasNext {
+		if bookPageDTO.NextCursor, err = bs.encodeCursor(books[len(books)-1], query.Sort, false); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if bookPageDTO.PrevCursor, err = bs.encodeCursor(books[0], query.Sort, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return bookPageDTO, nil
+}
+
+// SearchBooks returns a page of the books whose title or author has words starting with all of the words of the query, the most relevant first.
+// Words are compared by their stems, so the search matches other forms of them as well.
+func (bs *BookServiceImpl) SearchBooks(dto *dtos.BookSearchDTO) (*dtos.BookSearchPageDTO, error) {
+	limit, offset := int(dto.Limit), int(dto.Offset)
+	if limit < 1 || limit > MaxBookPageLimit || offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	terms := bs.searchTerms(dto.Query)
+	if len(terms) == 0 || len(terms) > MaxSearchTerms {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	results, err := bs.db.SearchBooks(&models.BookSearch{Terms: terms, Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := bs.db.CountSearchedBooks(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	bookSearchPageDTO := &dtos.BookSearchPageDTO{
+		Results: make([]*dtos.BookSearchResultDTO, 0, len(results)),
+		Total:   int64(total),
+		Limit:   int64(limit),
+		Offset:  int64(offset),
+	}
+	for _, result := range results {
+		bookSearchPageDTO.Results = append(bookSearchPageDTO.Results, &dtos.BookSearchResultDTO{
+			Book:            bs.bookDTO(result.Book),
+			Rank:            result.Rank,
+			TitleHighlight:  result.TitleHighlight,
+			AuthorHighlight: result.AuthorHighlight,
 		})
 	}
 
-	return booksDTO, nil
+	return bookSearchPageDTO, nil
+}
+
+// searchTerms splits the search query into distinct lowercase words of letters and digits.
+func (bs *BookServiceImpl) searchTerms(query string) []string {
+	terms := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !slices.Contains(terms, word) {
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
 }
 
 // GetBook returns a book with the given id from the database.
@@ -74,59 +330,65 @@ func (bs *BookServiceImpl) GetBook(id int) (*dtos.BookDTO, error) {
 		return nil, ErrBookNotFound
 	}
 
-	return &dtos.BookDTO{
-		ID:        int64(book.ID),
-		Author:    book.Author,
-		Title:     book.Title,
-		CreatedAt: book.CreatedAt,
-	}, nil
+	return bs.bookDTO(book), nil
 }
 
 // AddBook adds a book.
+// It returns a BookValidationError if fields of the book are invalid.
 func (bs *BookServiceImpl) AddBook(createdByID int, dto *dtos.BookCreateDTO) (*dtos.BookDTO, error) {
 	if !bs.validateID(createdByID) {
 		return nil, ErrInvalidCreatedByID
 	}
-	if !bs.validateAuthor(dto.Author) {
-		return nil, ErrInvalidAuthor
+
+	book := &models.Book{
+		CreatedBy:       createdByID,
+		CreatedAt:       time.Now(),
+		Author:          dto.Author,
+		Title:           dto.Title,
+		ISBN:            dto.ISBN,
+		Publisher:       dto.Publisher,
+		PublicationYear: int(dto.PublicationYear),
+		Language:        dto.Language,
+		PageCount:       int(dto.PageCount),
+		Description:     dto.Description,
 	}
-	if !bs.validateTitle(dto.Title) {
-		return nil, ErrInvalidTitle
+	if err := bs.validateBook(book); err != nil {
+		return nil, err
 	}
 
-	id, err := bs.db.InsertBook(&models.Book{
-		CreatedBy: createdByID,
-		CreatedAt: time.Now(),
-		Author:    dto.Author,
-		Title:     dto.Title,
-	})
+	id, err := bs.db.InsertBook(book)
 	if err != nil {
 		return nil, err
 	}
 
-	book, err := bs.db.SelectBookByID(id)
+	book, err = bs.db.SelectBookByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &dtos.BookDTO{
-		ID:        int64(book.ID),
-		CreatedAt: book.CreatedAt,
-		Author:    book.Author,
-		Title:     book.Title,
-	}, nil
+	return bs.bookDTO(book), nil
 }
 
-// UpdateBook updates a book with the given id.
-func (bs *BookServiceImpl) UpdateBook(id int, dto *dtos.BookDTO) (*dtos.BookDTO, error) {
+// UpdateBook updates a book with the given id on behalf of the user with the given userID.
+// All fields of the book are replaced, so the metadata missing from the dto is cleared.
+// It returns a BookValidationError if fields of the book are invalid.
+func (bs *BookServiceImpl) UpdateBook(userID, id int, dto *dtos.BookDTO) (*dtos.BookDTO, error) {
 	if !bs.validateID(id) {
 		return nil, ErrInvalidID
 	}
-	if !bs.validateAuthor(dto.Author) {
-		return nil, ErrInvalidAuthor
+
+	update := &models.Book{
+		Author:          dto.Author,
+		Title:           dto.Title,
+		ISBN:            dto.ISBN,
+		Publisher:       dto.Publisher,
+		PublicationYear: int(dto.PublicationYear),
+		Language:        dto.Language,
+		PageCount:       int(dto.PageCount),
+		Description:     dto.Description,
 	}
-	if !bs.validateTitle(dto.Title) {
-		return nil, ErrInvalidTitle
+	if err := bs.validateBook(update); err != nil {
+		return nil, err
 	}
 
 	book, err := bs.db.SelectBookByID(id)
@@ -134,33 +396,204 @@ func (bs *BookServiceImpl) UpdateBook(id int, dto *dtos.BookDTO) (*dtos.BookDTO,
 		return nil, ErrBookNotFound
 	}
 
-	book.Author = dto.Author
-	book.Title = dto.Title
+	if err := bs.authorizeModification(userID, book); err != nil {
+		return nil, err
+	}
+
+	book.Author = update.Author
+	book.Title = update.Title
+	book.ISBN = update.ISBN
+	book.Publisher = update.Publisher
+	book.PublicationYear = update.PublicationYear
+	book.Language = update.Language
+	book.PageCount = update.PageCount
+	book.Description = update.Description
 	if err := bs.db.UpdateBook(id, book); err != nil {
 		return nil, err
 	}
 
-	return &dtos.BookDTO{
-		ID:        int64(book.ID),
-		Author:    book.Author,
-		Title:     book.Title,
-		CreatedAt: book.CreatedAt,
-	}, nil
+	return bs.bookDTO(book), nil
 }
 
-// DeleteBook deletes a book with the given id.
-func (bs *BookServiceImpl) DeleteBook(id int) error {
+// DeleteBook deletes a book with the given id on behalf of the user with the given userID.
+func (bs *BookServiceImpl) DeleteBook(userID, id int) error {
 	if !bs.validateID(id) {
 		return ErrInvalidID
 	}
 
-	if book, err := bs.db.SelectBookByID(id); err != nil || book == nil {
+	book, err := bs.db.SelectBookByID(id)
+	if err != nil || book == nil {
 		return ErrBookNotFound
 	}
 
+	if err := bs.authorizeModification(userID, book); err != nil {
+		return err
+	}
+
 	return bs.db.DeleteBook(id)
 }
 
+// bookDTO converts the book to a BookDTO.
+func (bs *BookServiceImpl) bookDTO(book *models.Book) *dtos.BookDTO {
+	return &dtos.BookDTO{
+		ID:              int64(book.ID),
+		CreatedAt:       book.CreatedAt,
+		Author:          book.Author,
+		Title:           book.Title,
+		ISBN:            book.ISBN,
+		Publisher:       book.Publisher,
+		PublicationYear: int64(book.PublicationYear),
+		Language:        book.Language,
+		PageCount:       int64(book.PageCount),
+		Description:     book.Description,
+	}
+}
+
+// authorizeModification checks whether the user with the given userID is allowed to modify the book.
+// The user who has created the book can modify it, other users can only if they are admins.
+func (bs *BookServiceImpl) authorizeModification(userID int, book *models.Book) error {
+	if book.CreatedBy == userID {
+		return nil
+	}
+
+	user, err := bs.db.SelectUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Role != models.RoleAdmin {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// bookQuery converts the filter and the sort of the query to a BookQuery, validating them.
+func (bs *BookServiceImpl) bookQuery(dto *dtos.BookQueryDTO) (*models.BookQuery, error) {
+	query := &models.BookQuery{
+		Filter: models.BookFilter{
+			Author:      dto.Author,
+			Title:       dto.Title,
+			Similarity:  bs.fuzzyMatchThreshold,
+			CreatedBy:   int(dto.CreatedBy),
+			CreatedFrom: dto.CreatedFrom,
+			CreatedTo:   dto.CreatedTo,
+		},
+		Sort: models.BookSort{
+			Field: DefaultBookSortField,
+		},
+	}
+
+	if dto.CreatedBy != 0 && !bs.validateID(int(dto.CreatedBy)) {
+		return nil, ErrInvalidCreatedByFilter
+	}
+	if dto.CreatedFrom != nil && dto.CreatedTo != nil && !dto.CreatedFrom.Before(*dto.CreatedTo) {
+		return nil, ErrInvalidCreatedRange
+	}
+
+	if dto.Sort != "" {
+		query.Sort.Field = models.BookSortField(dto.Sort)
+		if !query.Sort.Field.IsValid() {
+			return nil, ErrInvalidBookSort
+		}
+	}
+
+	switch dto.Order {
+	case "", bookSortOrderAscending:
+	case bookSortOrderDescending:
+		query.Sort.Descending = true
+	default:
+		return nil, ErrInvalidBookSort
+	}
+
+	return query, nil
+}
+
+// suggest returns the authors and titles similar to the ones books are filtered by, or nil if there are none.
+func (bs *BookServiceImpl) suggest(filter *models.BookFilter) (*dtos.BookSuggestionsDTO, error) {
+	if filter.Similarity <= 0 {
+		return nil, nil
+	}
+
+	suggestions := &dtos.BookSuggestionsDTO{}
+	if filter.Author != "" {
+		authors, err := bs.db.SelectSimilarAuthors(filter.Author, filter.Similarity, MaxBookSuggestions)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions.Author = authors
+	}
+	if filter.Title != "" {
+		titles, err := bs.db.SelectSimilarTitles(filter.Title, filter.Similarity, MaxBookSuggestions)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions.Title = titles
+	}
+
+	if len(suggestions.Author) == 0 && len(suggestions.Title) == 0 {
+		return nil, nil
+	}
+
+	return suggestions, nil
+}
+
+// sortKey returns a key identifying the sort, which a cursor is bound to.
+func (bs *BookServiceImpl) sortKey(sort models.BookSort) string {
+	if sort.Descending {
+		return string(sort.Field) + ":" + bookSortOrderDescending
+	}
+
+	return string(sort.Field) + ":" + bookSortOrderAscending
+}
+
+// bookCursor is a cursor of a page of books, which points to the last book of the previous page or the first book of the next page.
+// It is bound to the sort of the pages, as the position of a book depends on it.
+type bookCursor struct {
+	Before    bool      `json:"before,omitempty"`
+	Sort      string    `json:"sort"`
+	Author    string    `json:"author"`
+	Title     string    `json:"title"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ID        int       `json:"id"`
+}
+
+// encodeCursor encodes a cursor pointing to the book in the list of books sorted by the sort as an opaque string.
+// The cursor points to the pages preceding the book if before is set, and to the pages following it otherwise.
+func (bs *BookServiceImpl) encodeCursor(book *models.Book, sort models.BookSort, before bool) (string, error) {
+	cursorJSON, err := json.Marshal(&bookCursor{
+		Before:    before,
+		Sort:      bs.sortKey(sort),
+		Author:    book.Author,
+		Title:     book.Title,
+		CreatedBy: book.CreatedBy,
+		CreatedAt: book.CreatedAt,
+		ID:        book.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursorJSON), nil
+}
+
+// decodeCursor decodes the cursor encoded by encodeCursor.
+func (bs *BookServiceImpl) decodeCursor(s string) (*bookCursor, error) {
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &bookCursor{}
+	if err := json.Unmarshal(cursorJSON, cursor); err != nil || !bs.validateID(cursor.ID) {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
 // validateID validates the given id.
 func (bs *BookServiceImpl) validateID(id int) bool {
 	return id > 0
@@ -168,10 +601,144 @@ func (bs *BookServiceImpl) validateID(id int) bool {
 
 // validateAuthor validates the given author.
 func (bs *BookServiceImpl) validateAuthor(author string) bool {
-	return author != ""
+	return author != "" && utf8.RuneCountInString(author) <= MaxAuthorLength
 }
 
 // validateTitle validates the given title.
 func (bs *BookServiceImpl) validateTitle(title string) bool {
-	return title != ""
+	return title != "" && utf8.RuneCountInString(title) <= MaxTitleLength
+}
+
+// validateBook validates all fields of the book, normalizing its ISBN and language.
+// It returns a BookValidationError mapping every invalid field to the error describing it.
+func (bs *BookServiceImpl) validateBook(book *models.Book) error {
+	fields := map[string]error{}
+
+	if !bs.validateAuthor(book.Author) {
+		fields["author"] = ErrInvalidAuthor
+	}
+	if !bs.validateTitle(book.Title) {
+		fields["title"] = ErrInvalidTitle
+	}
+	if book.ISBN != "" {
+		isbn, ok := bs.normalizeISBN(book.ISBN)
+		if !ok {
+			fields["isbn"] = ErrInvalidISBN
+		}
+		book.ISBN = isbn
+	}
+	if !bs.validatePublisher(book.Publisher) {
+		fields["publisher"] = ErrInvalidPublisher
+	}
+	if !bs.validatePublicationYear(book.PublicationYear) {
+		fields["publication_year"] = ErrInvalidPublicationYear
+	}
+	if book.Language != "" {
+		tag, ok := bs.normalizeLanguage(book.Language)
+		if !ok {
+			fields["language"] = ErrInvalidLanguage
+		}
+		book.Language = tag
+	}
+	if !bs.validatePageCount(book.PageCount) {
+		fields["page_count"] = ErrInvalidPageCount
+	}
+	if !bs.validateDescription(book.Description) {
+		fields["description"] = ErrInvalidDescription
+	}
+
+	if len(fields) > 0 {
+		return &BookValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
+// normalizeISBN validates the checksum of the given ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and converts it to an ISBN-13 of digits only.
+func (bs *BookServiceImpl) normalizeISBN(isbn string) (string, bool) {
+	compact := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+
+	switch len(compact) {
+	case 10:
+		sum := 0
+		for i, r := range compact {
+			digit := int(r - '0')
+			if r == 'X' && i == 9 {
+				digit = 10
+			} else if r < '0' || r > '9' {
+				return "", false
+			}
+
+			sum += (10 - i) * digit
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+
+		isbn13 := "978" + compact[:9]
+		return isbn13 + bs.isbn13CheckDigit(isbn13), true
+	case 13:
+		for _, r := range compact {
+			if r < '0' || r > '9' {
+				return "", false
+			}
+		}
+		if !strings.HasPrefix(compact, "978") && !strings.HasPrefix(compact, "979") {
+			return "", false
+		}
+		if bs.isbn13CheckDigit(compact[:12]) != compact[12:] {
+			return "", false
+		}
+
+		return compact, true
+	}
+
+	return "", false
+}
+
+// isbn13CheckDigit computes the check digit of an ISBN-13 from its first 12 digits.
+func (bs *BookServiceImpl) isbn13CheckDigit(digits string) string {
+	sum := 0
+	for i, r := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+
+		sum += weight * int(r-'0')
+	}
+
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// normalizeLanguage validates the given BCP 47 language tag and converts it to its canonical form.
+func (bs *BookServiceImpl) normalizeLanguage(tag string) (string, bool) {
+	parsed, err := language.Parse(tag)
+	if err != nil || utf8.RuneCountInString(parsed.String()) > MaxLanguageLength {
+		return "", false
+	}
+
+	return parsed.String(), true
+}
+
+// validatePublisher validates the given publisher.
+func (bs *BookServiceImpl) validatePublisher(publisher string) bool {
+	return utf8.RuneCountInString(publisher) <= MaxPublisherLength
+}
+
+// validatePublicationYear validates the given publication year, where 0 means it is unknown.
+// Books may be registered before they are published, so the next year is allowed.
+func (bs *BookServiceImpl) validatePublicationYear(year int) bool {
+	return year >= 0 && year <= time.Now().Year()+1
+}
+
+// validatePageCount validates the given page count, where 0 means it is unknown.
+func (bs *BookServiceImpl) validatePageCount(pageCount int) bool {
+	return pageCount >= 0 && pageCount <= MaxPageCount
+}
+
+// validateDescription validates the given description.
+func (bs *BookServiceImpl) validateDescription(description string) bool {
+	return utf8.RuneCountInString(description) <= MaxDescriptionLength
 }
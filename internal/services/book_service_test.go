@@ -1,6 +1,8 @@
 package services
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,9 +16,15 @@ func TestGetBooks(t *testing.T) {
 
 	bs := NewBookService(mockDB)
 
-	books, err := bs.GetBooks()
+	bookPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: DefaultBookPageLimit})
 	require.Nil(t, err)
-	require.NotNil(t, books)
+	require.NotNil(t, bookPage)
+	require.Equal(t, int64(3), bookPage.Total)
+	require.Equal(t, int64(1), bookPage.TotalPages)
+	require.Empty(t, bookPage.NextCursor)
+	require.Empty(t, bookPage.PrevCursor)
+
+	books := bookPage.Books
 	require.Equal(t, 3, len(books))
 
 	require.Equal(t, int64(1), books[0].ID)
@@ -35,6 +43,440 @@ func TestGetBooks(t *testing.T) {
 	require.Equal(t, "The Shining", books[2].Title)
 }
 
+func TestGetBooksPagination(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	bs := NewBookService(mockDB)
+
+	for i := 0; i < 4; i++ {
+		_, err := bs.AddBook(1, &dtos.BookCreateDTO{Author: "Author", Title: fmt.Sprintf("Title %d", i)})
+		require.NoError(t, err)
+	}
+
+	bookIDs := func(bookPage *dtos.BookPageDTO) []int64 {
+		ids := []int64{}
+		for _, book := range bookPage.Books {
+			ids = append(ids, book.ID)
+		}
+
+		return ids
+	}
+
+	// Pages are followed forwards with the next cursors
+	firstPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3}, bookIDs(firstPage))
+	require.Equal(t, int64(7), firstPage.Total)
+	require.Equal(t, int64(3), firstPage.TotalPages)
+	require.Empty(t, firstPage.PrevCursor)
+	require.NotEmpty(t, firstPage.NextCursor)
+
+	secondPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 3, Cursor: firstPage.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 5, 6}, bookIDs(secondPage))
+	require.NotEmpty(t, secondPage.PrevCursor)
+	require.NotEmpty(t, secondPage.NextCursor)
+
+	// Deleting a book does not shift the following pages
+	require.NoError(t, bs.DeleteBook(1, 2))
+
+	lastPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 3, Cursor: secondPage.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{7}, bookIDs(lastPage))
+	require.Equal(t, int64(6), lastPage.Total)
+	require.Empty(t, lastPage.NextCursor)
+
+	// and backwards with the previous cursors
+	previousPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 3, Cursor: lastPage.PrevCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 5, 6}, bookIDs(previousPage))
+	require.NotEmpty(t, previousPage.PrevCursor)
+	require.NotEmpty(t, previousPage.NextCursor)
+
+	previousPage, err = bs.GetBooks(&dtos.BookQueryDTO{Limit: 3, Cursor: previousPage.PrevCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 3}, bookIDs(previousPage))
+	require.Empty(t, previousPage.PrevCursor)
+	require.NotEmpty(t, previousPage.NextCursor)
+
+	// Pages can be selected by an offset as well
+	offsetPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 5}, bookIDs(offsetPage))
+	require.NotEmpty(t, offsetPage.PrevCursor)
+	require.NotEmpty(t, offsetPage.NextCursor)
+
+	offsetPage, err = bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Offset: 10})
+	require.NoError(t, err)
+	require.Empty(t, offsetPage.Books)
+	require.Empty(t, offsetPage.PrevCursor)
+	require.Empty(t, offsetPage.NextCursor)
+
+	data := []struct {
+		name          string
+		input         *dtos.BookQueryDTO
+		expectedError error
+	}{
+		{
+			name:          "zero limit",
+			input:         &dtos.BookQueryDTO{},
+			expectedError: ErrInvalidPagination,
+		},
+		{
+			name:          "too high limit",
+			input:         &dtos.BookQueryDTO{Limit: MaxBookPageLimit + 1},
+			expectedError: ErrInvalidPagination,
+		},
+		{
+			name:          "negative offset",
+			input:         &dtos.BookQueryDTO{Limit: 1, Offset: -1},
+			expectedError: ErrInvalidPagination,
+		},
+		{
+			name:          "invalid cursor",
+			input:         &dtos.BookQueryDTO{Limit: 1, Cursor: "invalid cursor"},
+			expectedError: ErrInvalidCursor,
+		},
+		{
+			name:          "cursor without a book",
+			input:         &dtos.BookQueryDTO{Limit: 1, Cursor: "e30"},
+			expectedError: ErrInvalidCursor,
+		},
+		{
+			name:          "cursor with an offset",
+			input:         &dtos.BookQueryDTO{Limit: 1, Offset: 1, Cursor: firstPage.NextCursor},
+			expectedError: ErrInvalidCursor,
+		},
+		{
+			name:          "cursor of another sort",
+			input:         &dtos.BookQueryDTO{Limit: 1, Sort: "title", Cursor: firstPage.NextCursor},
+			expectedError: ErrInvalidCursor,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := bs.GetBooks(d.input)
+			require.Equal(t, d.expectedError, err)
+		})
+	}
+}
+
+func TestGetBooksFilterAndSort(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	bs := NewBookService(mockDB)
+
+	_, err := bs.AddBook(2, &dtos.BookCreateDTO{Author: "J.R.R. Tolkien", Title: "The Hobbit"})
+	require.NoError(t, err)
+
+	bookIDs := func(bookPage *dtos.BookPageDTO) []int64 {
+		ids := []int64{}
+		for _, book := range bookPage.Books {
+			ids = append(ids, book.ID)
+		}
+
+		return ids
+	}
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	data := []struct {
+		name          string
+		input         *dtos.BookQueryDTO
+		expectedIDs   []int64
+		expectedError error
+	}{
+		{
+			name:        "author substring",
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "tolkien"},
+			expectedIDs: []int64{1, 4},
+		},
+		{
+			name:        "title substring",
+			input:       &dtos.BookQueryDTO{Limit: 10, Title: "THE"},
+			expectedIDs: []int64{1, 3, 4},
+		},
+		{
+			name:        "author and title",
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "Tolkien", Title: "Hobbit"},
+			expectedIDs: []int64{4},
+		},
+		{
+			name:        "created by",
+			input:       &dtos.BookQueryDTO{Limit: 10, CreatedBy: 2},
+			expectedIDs: []int64{2, 4},
+		},
+		{
+			name:        "created range",
+			input:       &dtos.BookQueryDTO{Limit: 10, CreatedFrom: &past, CreatedTo: &future},
+			expectedIDs: []int64{1, 2, 3, 4},
+		},
+		{
+			name:        "created in the future",
+			input:       &dtos.BookQueryDTO{Limit: 10, CreatedFrom: &future},
+			expectedIDs: []int64{},
+		},
+		{
+			name:        "sort by author",
+			input:       &dtos.BookQueryDTO{Limit: 10, Sort: "author"},
+			expectedIDs: []int64{2, 1, 4, 3},
+		},
+		{
+			name:        "sort by title descending",
+			input:       &dtos.BookQueryDTO{Limit: 10, Sort: "title", Order: "desc"},
+			expectedIDs: []int64{3, 1, 4, 2},
+		},
+		{
+			name:        "sort by creator descending",
+			input:       &dtos.BookQueryDTO{Limit: 10, Sort: "created_by", Order: "desc"},
+			expectedIDs: []int64{3, 4, 2, 1},
+		},
+		{
+			name:          "unknown sort field",
+			input:         &dtos.BookQueryDTO{Limit: 10, Sort: "id"},
+			expectedError: ErrInvalidBookSort,
+		},
+		{
+			name:          "unknown order",
+			input:         &dtos.BookQueryDTO{Limit: 10, Order: "up"},
+			expectedError: ErrInvalidBookSort,
+		},
+		{
+			name:          "invalid creator",
+			input:         &dtos.BookQueryDTO{Limit: 10, CreatedBy: -1},
+			expectedError: ErrInvalidCreatedByFilter,
+		},
+		{
+			name:          "empty created range",
+			input:         &dtos.BookQueryDTO{Limit: 10, CreatedFrom: &future, CreatedTo: &past},
+			expectedError: ErrInvalidCreatedRange,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			bookPage, err := bs.GetBooks(d.input)
+			require.Equal(t, d.expectedError, err)
+			if d.expectedError == nil {
+				require.Equal(t, d.expectedIDs, bookIDs(bookPage))
+				require.Equal(t, int64(len(d.expectedIDs)), bookPage.Total)
+			}
+		})
+	}
+
+	// Cursors follow the sort of the pages
+	firstPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Sort: "title", Order: "desc"})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 1}, bookIDs(firstPage))
+
+	secondPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Sort: "title", Order: "desc", Cursor: firstPage.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 2}, bookIDs(secondPage))
+	require.Empty(t, secondPage.NextCursor)
+
+	previousPage, err := bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Sort: "title", Order: "desc", Cursor: secondPage.PrevCursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 1}, bookIDs(previousPage))
+	require.Empty(t, previousPage.PrevCursor)
+
+	_, err = bs.GetBooks(&dtos.BookQueryDTO{Limit: 2, Sort: "title", Cursor: firstPage.NextCursor})
+	require.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestGetBooksFuzzy(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	bs := NewBookService(mockDB)
+
+	_, err := bs.AddBook(2, &dtos.BookCreateDTO{Author: "J.R.R. Tolkien", Title: "The Hobbit"})
+	require.NoError(t, err)
+
+	bookIDs := func(bookPage *dtos.BookPageDTO) []int64 {
+		ids := []int64{}
+		for _, book := range bookPage.Books {
+			ids = append(ids, book.ID)
+		}
+
+		return ids
+	}
+
+	data := []struct {
+		name                string
+		opts                []BookServiceOption
+		input               *dtos.BookQueryDTO
+		expectedIDs         []int64
+		expectedSuggestions *dtos.BookSuggestionsDTO
+	}{
+		{
+			name:                "misspelled author",
+			input:               &dtos.BookQueryDTO{Limit: 10, Author: "Tolkein"},
+			expectedIDs:         []int64{1, 4},
+			expectedSuggestions: &dtos.BookSuggestionsDTO{Author: []string{"J.R.R. Tolkien"}},
+		},
+		{
+			name:                "misspelled title",
+			input:               &dtos.BookQueryDTO{Limit: 10, Title: "Hary Poter"},
+			expectedIDs:         []int64{2},
+			expectedSuggestions: &dtos.BookSuggestionsDTO{Title: []string{"Harry Potter"}},
+		},
+		{
+			name:        "exact author",
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "Tolkien"},
+			expectedIDs: []int64{1, 4},
+		},
+		{
+			name:        "dissimilar author",
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "Herbert"},
+			expectedIDs: []int64{},
+		},
+		{
+			name:        "higher threshold",
+			opts:        []BookServiceOption{WithFuzzyMatchThreshold(0.9)},
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "Tolkein"},
+			expectedIDs: []int64{},
+		},
+		{
+			name:        "disabled",
+			opts:        []BookServiceOption{WithFuzzyMatchThreshold(0)},
+			input:       &dtos.BookQueryDTO{Limit: 10, Author: "Tolkein"},
+			expectedIDs: []int64{},
+		},
+		{
+			name:                "invalid threshold",
+			opts:                []BookServiceOption{WithFuzzyMatchThreshold(2)},
+			input:               &dtos.BookQueryDTO{Limit: 10, Author: "Tolkein"},
+			expectedIDs:         []int64{1, 4},
+			expectedSuggestions: &dtos.BookSuggestionsDTO{Author: []string{"J.R.R. Tolkien"}},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			bookPage, err := NewBookService(mockDB, d.opts...).GetBooks(d.input)
+			require.NoError(t, err)
+			require.Equal(t, d.expectedIDs, bookIDs(bookPage))
+			require.Equal(t, d.expectedSuggestions, bookPage.DidYouMean)
+		})
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	bs := NewBookService(mockDB)
+
+	_, err := bs.AddBook(2, &dtos.BookCreateDTO{Author: "J.R.R. Tolkien", Title: "The Hobbit"})
+	require.NoError(t, err)
+	_, err = bs.AddBook(2, &dtos.BookCreateDTO{Author: "Christopher Tolkien", Title: "The Lost Road"})
+	require.NoError(t, err)
+
+	resultIDs := func(bookSearchPageDTO *dtos.BookSearchPageDTO) []int64 {
+		ids := []int64{}
+		for _, result := range bookSearchPageDTO.Results {
+			ids = append(ids, result.Book.ID)
+		}
+
+		return ids
+	}
+
+	data := []struct {
+		name          string
+		input         *dtos.BookSearchDTO
+		expectedIDs   []int64
+		expectedTotal int64
+		expectedError error
+	}{
+		{
+			name:          "prefix",
+			input:         &dtos.BookSearchDTO{Query: "hob", Limit: 10},
+			expectedIDs:   []int64{4},
+			expectedTotal: 1,
+		},
+		{
+			name:          "stem",
+			input:         &dtos.BookSearchDTO{Query: "Hobbits", Limit: 10},
+			expectedIDs:   []int64{4},
+			expectedTotal: 1,
+		},
+		{
+			name:          "all words",
+			input:         &dtos.BookSearchDTO{Query: "tolkien, road!", Limit: 10},
+			expectedIDs:   []int64{5},
+			expectedTotal: 1,
+		},
+		{
+			name:          "title word",
+			input:         &dtos.BookSearchDTO{Query: "lord", Limit: 10},
+			expectedIDs:   []int64{1},
+			expectedTotal: 1,
+		},
+		{
+			name:          "ties ordered by ID",
+			input:         &dtos.BookSearchDTO{Query: "tolkien", Limit: 10},
+			expectedIDs:   []int64{1, 4, 5},
+			expectedTotal: 3,
+		},
+		{
+			name:          "page",
+			input:         &dtos.BookSearchDTO{Query: "tolkien", Limit: 1, Offset: 1},
+			expectedIDs:   []int64{4},
+			expectedTotal: 3,
+		},
+		{
+			name:          "no match",
+			input:         &dtos.BookSearchDTO{Query: "dune", Limit: 10},
+			expectedIDs:   []int64{},
+			expectedTotal: 0,
+		},
+		{
+			name:          "no words",
+			input:         &dtos.BookSearchDTO{Query: " ,.!", Limit: 10},
+			expectedError: ErrInvalidSearchQuery,
+		},
+		{
+			name:          "too many words",
+			input:         &dtos.BookSearchDTO{Query: "a b c d e f g h i j k", Limit: 10},
+			expectedError: ErrInvalidSearchQuery,
+		},
+		{
+			name:          "zero limit",
+			input:         &dtos.BookSearchDTO{Query: "tolkien"},
+			expectedError: ErrInvalidPagination,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			bookSearchPageDTO, err := bs.SearchBooks(d.input)
+			require.Equal(t, d.expectedError, err)
+			if d.expectedError == nil {
+				require.Equal(t, d.expectedIDs, resultIDs(bookSearchPageDTO))
+				require.Equal(t, d.expectedTotal, bookSearchPageDTO.Total)
+			}
+		})
+	}
+
+	// Matching words are highlighted and weigh more in the title than in the author
+	bookSearchPageDTO, err := bs.SearchBooks(&dtos.BookSearchDTO{Query: "road tolkien", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, bookSearchPageDTO.Results, 1)
+	require.Equal(t, "The Lost <mark>Road</mark>", bookSearchPageDTO.Results[0].TitleHighlight)
+	require.Equal(t, "Christopher <mark>Tolkien</mark>", bookSearchPageDTO.Results[0].AuthorHighlight)
+	require.InDelta(t, 1.4, bookSearchPageDTO.Results[0].Rank, 0.001)
+
+	// The highlights are HTML escaped, so only the marks are tags
+	_, err = bs.AddBook(2, &dtos.BookCreateDTO{Author: "Mallory & Co", Title: "<script>alert('xss')</script> Sketches"})
+	require.NoError(t, err)
+
+	bookSearchPageDTO, err = bs.SearchBooks(&dtos.BookSearchDTO{Query: "sketches", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, bookSearchPageDTO.Results, 1)
+	require.Equal(t, "&lt;script&gt;alert(&#39;xss&#39;)&lt;/script&gt; <mark>Sketches</mark>", bookSearchPageDTO.Results[0].TitleHighlight)
+	require.Equal(t, "Mallory &amp; Co", bookSearchPageDTO.Results[0].AuthorHighlight)
+	require.Equal(t, "<script>alert('xss')</script> Sketches", bookSearchPageDTO.Results[0].Book.Title)
+}
+
 func TestGetBook(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
@@ -140,12 +582,102 @@ func TestAddBook(t *testing.T) {
 			expectedErr:  ErrInvalidTitle,
 			expectedBook: nil,
 		},
+		{
+			name:             "invalid author - too long author",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author: strings.Repeat("ż", MaxAuthorLength+1),
+				Title:  "The Lord of the Rings - The Fellowship of the Ring",
+			},
+			expectedErr:  ErrInvalidAuthor,
+			expectedBook: nil,
+		},
+		{
+			name:             "invalid title - too long title",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author: "J.R.R. Tolkien",
+				Title:  strings.Repeat("ż", MaxTitleLength+1),
+			},
+			expectedErr:  ErrInvalidTitle,
+			expectedBook: nil,
+		},
+		{
+			name:             "valid - with metadata",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author:          "Ursula K. Le Guin",
+				Title:           "A Wizard of Earthsea",
+				ISBN:            "0-306-40615-2",
+				Publisher:       "Parnassus Press",
+				PublicationYear: 1968,
+				Language:        "en-us",
+				PageCount:       205,
+				Description:     "A young wizard learns the cost of power.",
+			},
+			expectedErr: nil,
+			expectedBook: &dtos.BookDTO{
+				ID:              5,
+				Author:          "Ursula K. Le Guin",
+				Title:           "A Wizard of Earthsea",
+				ISBN:            "9780306406157",
+				Publisher:       "Parnassus Press",
+				PublicationYear: 1968,
+				Language:        "en-US",
+				PageCount:       205,
+				Description:     "A young wizard learns the cost of power.",
+			},
+		},
+		{
+			name:             "invalid isbn - wrong check digit",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author: "Ursula K. Le Guin",
+				Title:  "A Wizard of Earthsea",
+				ISBN:   "978-0-306-40615-8",
+			},
+			expectedErr:  ErrInvalidISBN,
+			expectedBook: nil,
+		},
+		{
+			name:             "invalid publication year - in the future",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author:          "Ursula K. Le Guin",
+				Title:           "A Wizard of Earthsea",
+				PublicationYear: int64(time.Now().Year() + 2),
+			},
+			expectedErr:  ErrInvalidPublicationYear,
+			expectedBook: nil,
+		},
+		{
+			name:             "invalid publisher - too long",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author:    "Ursula K. Le Guin",
+				Title:     "A Wizard of Earthsea",
+				Publisher: strings.Repeat("p", MaxPublisherLength+1),
+			},
+			expectedErr:  ErrInvalidPublisher,
+			expectedBook: nil,
+		},
+		{
+			name:             "invalid description - too long",
+			inputCreatedByID: 1,
+			inputBook: &dtos.BookCreateDTO{
+				Author:      "Ursula K. Le Guin",
+				Title:       "A Wizard of Earthsea",
+				Description: strings.Repeat("d", MaxDescriptionLength+1),
+			},
+			expectedErr:  ErrInvalidDescription,
+			expectedBook: nil,
+		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
 			book, err := bs.AddBook(d.inputCreatedByID, d.inputBook)
-			require.Equal(t, d.expectedErr, err)
+			require.ErrorIs(t, err, d.expectedErr)
 
 			if d.expectedErr != nil {
 				require.Nil(t, book)
@@ -155,9 +687,39 @@ func TestAddBook(t *testing.T) {
 				require.LessOrEqual(t, book.CreatedAt, time.Now())
 				require.Equal(t, d.expectedBook.Author, book.Author)
 				require.Equal(t, d.expectedBook.Title, book.Title)
+				require.Equal(t, d.expectedBook.ISBN, book.ISBN)
+				require.Equal(t, d.expectedBook.Publisher, book.Publisher)
+				require.Equal(t, d.expectedBook.PublicationYear, book.PublicationYear)
+				require.Equal(t, d.expectedBook.Language, book.Language)
+				require.Equal(t, d.expectedBook.PageCount, book.PageCount)
+				require.Equal(t, d.expectedBook.Description, book.Description)
 			}
 		})
 	}
+
+	// Every invalid field is reported
+	_, err := bs.AddBook(1, &dtos.BookCreateDTO{ISBN: "123", Language: "xx-invalid-tag", PageCount: MaxPageCount + 1})
+
+	bookValidationErr := &BookValidationError{}
+	require.ErrorAs(t, err, &bookValidationErr)
+	require.Equal(t, map[string]error{
+		"author":     ErrInvalidAuthor,
+		"title":      ErrInvalidTitle,
+		"isbn":       ErrInvalidISBN,
+		"language":   ErrInvalidLanguage,
+		"page_count": ErrInvalidPageCount,
+	}, bookValidationErr.Fields)
+	require.Equal(t, "author must not be empty or longer than 100 characters, isbn must be a valid ISBN-10 or ISBN-13, "+
+		"language must be a valid BCP 47 language tag not longer than 35 characters, page_count must not be negative or greater than 100000, "+
+		"title must not be empty or longer than 100 characters", err.Error())
+
+	// Lengths are counted in characters, the language after canonicalization
+	_, err = bs.AddBook(1, &dtos.BookCreateDTO{Author: strings.Repeat("ż", MaxAuthorLength), Title: strings.Repeat("ż", MaxTitleLength+1), Language: "en-us-u-nu-latn-co-phonebk-ca-gregory"})
+	require.ErrorAs(t, err, &bookValidationErr)
+	require.Equal(t, map[string]error{
+		"title":    ErrInvalidTitle,
+		"language": ErrInvalidLanguage,
+	}, bookValidationErr.Fields)
 }
 
 func TestUpdateBook(t *testing.T) {
@@ -168,14 +730,16 @@ func TestUpdateBook(t *testing.T) {
 
 	data := []struct {
 		name         string
+		userID       int
 		id           int
 		inputBook    *dtos.BookDTO
 		expectedErr  error
 		expectedBook *dtos.BookDTO
 	}{
 		{
-			name: "valid",
-			id:   1,
+			name:   "valid",
+			userID: 1,
+			id:     1,
 			inputBook: &dtos.BookDTO{
 				Author: "J.R.R. Tolkien",
 				Title:  "The Lord of the Rings - The Fellowship of the Ring",
@@ -189,25 +753,29 @@ func TestUpdateBook(t *testing.T) {
 		},
 		{
 			name:        "invalid id - negative id",
+			userID:      1,
 			id:          -1,
 			expectedErr: ErrInvalidID,
 		},
 		{
 			name:        "invalid id - zero id",
+			userID:      1,
 			id:          0,
 			expectedErr: ErrInvalidID,
 		},
 		{
-			name: "invalid author - empty author",
-			id:   1,
+			name:   "invalid author - empty author",
+			userID: 1,
+			id:     1,
 			inputBook: &dtos.BookDTO{
 				Author: "",
 			},
 			expectedErr: ErrInvalidAuthor,
 		},
 		{
-			name: "invalid title - empty title",
-			id:   1,
+			name:   "invalid title - empty title",
+			userID: 1,
+			id:     1,
 			inputBook: &dtos.BookDTO{
 				Author: "J.R.R. Tolkien",
 				Title:  "",
@@ -215,20 +783,46 @@ func TestUpdateBook(t *testing.T) {
 			expectedErr: ErrInvalidTitle,
 		},
 		{
-			name: "invalid id - non-existent id",
-			id:   100,
+			name:   "invalid id - non-existent id",
+			userID: 1,
+			id:     100,
 			inputBook: &dtos.BookDTO{
 				Author: "J.R.R. Tolkien",
 				Title:  "The Lord of the Rings - The Fellowship of the Ring",
 			},
 			expectedErr: ErrBookNotFound,
 		},
+		{
+			name:   "not the owner",
+			userID: 2,
+			id:     1,
+			inputBook: &dtos.BookDTO{
+				Author: "J.R.R. Tolkien",
+				Title:  "The Lord of the Rings - The Two Towers",
+			},
+			expectedErr: ErrForbidden,
+		},
+		{
+			name:   "admin - not the owner",
+			userID: 1,
+			id:     2,
+			inputBook: &dtos.BookDTO{
+				Author: "J.K. Rowling",
+				Title:  "Harry Potter and the Chamber of Secrets",
+			},
+			expectedErr: nil,
+			expectedBook: &dtos.BookDTO{
+				ID:     2,
+				Author: "J.K. Rowling",
+				Title:  "Harry Potter and the Chamber of Secrets",
+			},
+		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			book, err := bs.UpdateBook(d.id, d.inputBook)
-			require.Equal(t, d.expectedErr, err)
+			book, err := bs.UpdateBook(d.userID, d.id, d.inputBook)
+			require.ErrorIs(t, err, d.expectedErr)
 
 			if d.expectedErr != nil {
 				require.Nil(t, book)
@@ -250,26 +844,43 @@ func TestDeleteBook(t *testing.T) {
 
 	data := []struct {
 		name     string
+		userID   int
 		id       int
 		expected error
 	}{
+		{
+			name:     "not the owner",
+			userID:   2,
+			id:       3,
+			expected: ErrForbidden,
+		},
 		{
 			name:     "valid id",
+			userID:   3,
 			id:       3,
 			expected: nil,
 		},
+		{
+			name:     "admin - not the owner",
+			userID:   1,
+			id:       2,
+			expected: nil,
+		},
 		{
 			name:     "invalid id - negative id",
+			userID:   1,
 			id:       -1,
 			expected: ErrInvalidID,
 		},
 		{
 			name:     "invalid id - zero id",
+			userID:   1,
 			id:       0,
 			expected: ErrInvalidID,
 		},
 		{
 			name:     "invalid id - non-existent id",
+			userID:   1,
 			id:       100,
 			expected: ErrBookNotFound,
 		},
@@ -277,7 +888,7 @@ func TestDeleteBook(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			require.Equal(t, d.expected, bs.DeleteBook(d.id))
+			require.Equal(t, d.expected, bs.DeleteBook(d.userID, d.id))
 		})
 	}
 }
@@ -367,3 +978,136 @@ func TestValidateTitle(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeISBN(t *testing.T) {
+	bs := NewBookService(nil)
+
+	data := []struct {
+		name         string
+		isbn         string
+		expectedISBN string
+		expectedOK   bool
+	}{
+		{
+			name:         "valid isbn-13",
+			isbn:         "9780306406157",
+			expectedISBN: "9780306406157",
+			expectedOK:   true,
+		},
+		{
+			name:         "valid isbn-13 - with hyphens",
+			isbn:         "978-0-306-40615-7",
+			expectedISBN: "9780306406157",
+			expectedOK:   true,
+		},
+		{
+			name:         "valid isbn-10 - converted to isbn-13",
+			isbn:         "0 306 40615 2",
+			expectedISBN: "9780306406157",
+			expectedOK:   true,
+		},
+		{
+			name:         "valid isbn-10 - check digit x",
+			isbn:         "0-8044-2957-x",
+			expectedISBN: "9780804429573",
+			expectedOK:   true,
+		},
+		{
+			name:       "invalid isbn-13 - wrong check digit",
+			isbn:       "9780306406158",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid isbn-13 - wrong prefix",
+			isbn:       "1230306406157",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid isbn-10 - wrong check digit",
+			isbn:       "0306406153",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid isbn-10 - x not at the end",
+			isbn:       "X306406152",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid isbn - wrong length",
+			isbn:       "978030640615",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid isbn - letters",
+			isbn:       "97803064061AB",
+			expectedOK: false,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			isbn, ok := bs.normalizeISBN(d.isbn)
+			require.Equal(t, d.expectedOK, ok)
+			require.Equal(t, d.expectedISBN, isbn)
+		})
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	bs := NewBookService(nil)
+
+	data := []struct {
+		name             string
+		language         string
+		expectedLanguage string
+		expectedOK       bool
+	}{
+		{
+			name:             "valid language",
+			language:         "en",
+			expectedLanguage: "en",
+			expectedOK:       true,
+		},
+		{
+			name:             "valid language - with region",
+			language:         "pt_br",
+			expectedLanguage: "pt-BR",
+			expectedOK:       true,
+		},
+		{
+			name:             "valid language - with script",
+			language:         "zh-hant-tw",
+			expectedLanguage: "zh-Hant-TW",
+			expectedOK:       true,
+		},
+		{
+			name:       "invalid language - unknown subtag",
+			language:   "en-xyzxyzxyz",
+			expectedOK: false,
+		},
+		{
+			name:             "valid language - at most 35 characters",
+			language:         "en-us-x-private1-private2-private3",
+			expectedLanguage: "en-US-x-private1-private2-private3",
+			expectedOK:       true,
+		},
+		{
+			name:       "invalid language - longer than 35 characters",
+			language:   "en-us-u-nu-latn-co-phonebk-ca-gregory",
+			expectedOK: false,
+		},
+		{
+			name:       "invalid language - not a tag",
+			language:   "English",
+			expectedOK: false,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			language, ok := bs.normalizeLanguage(d.language)
+			require.Equal(t, d.expectedOK, ok)
+			require.Equal(t, d.expectedLanguage, language)
+		})
+	}
+}
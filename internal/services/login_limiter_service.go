@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
+	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
+)
+
+var (
+	// ErrTooManyLoginAttempts is returned when logging in too soon after failed login attempts.
+	// It is wrapped in a RetryAfterError.
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
+	// ErrAccountLocked is returned when logging in to an account temporarily locked after too many failed login attempts.
+	// It is wrapped in a RetryAfterError.
+	ErrAccountLocked = errors.New("account is temporarily locked due to too many failed login attempts")
+)
+
+const (
+	// DefaultLoginBackoff is the default delay required after the first failed login attempt.
+	// It doubles with every subsequent failed attempt.
+	DefaultLoginBackoff = time.Second
+	// DefaultMaxLoginBackoff is the default maximum delay required after a failed login attempt, unless the lockout threshold is reached.
+	DefaultMaxLoginBackoff = time.Minute
+	// DefaultAccountLockoutThreshold is the default number of failed login attempts to an account after which it is locked.
+	DefaultAccountLockoutThreshold = 10
+	// DefaultIPLockoutThreshold is the default number of failed login attempts from an IP address after which it is locked.
+	// It is higher than the account threshold, as many users may share an IP address.
+	DefaultIPLockoutThreshold = 100
+	// DefaultLockoutDuration is the default duration of a lockout. Failed login attempts older than it are forgotten.
+	DefaultLockoutDuration = 15 * time.Minute
+	// DefaultLoginAttemptCleanupInterval is the default interval between removals of forgotten failed login attempts.
+	DefaultLoginAttemptCleanupInterval = time.Hour
+
+	// reservationTimeout is the time after which reserved attempts, which have neither failed nor been released, are considered abandoned.
+	reservationTimeout = time.Minute
+
+	// accountKeyPrefix is a prefix of keys failed login attempts to an account are tracked with.
+	accountKeyPrefix = "account:"
+	// ipKeyPrefix is a prefix of keys failed login attempts from an IP address are tracked with.
+	ipKeyPrefix = "ip:"
+)
+
+// LoginLimiterService is an interface that defines the methods that the LoginLimiterService must implement.
+type LoginLimiterService interface {
+	Reserve(string, string) error
+	Release(string, string) error
+	RecordFailure(string, string) error
+	RecordSuccess(string, string) error
+	Unlock(string) error
+}
+
+// LoginLimiterServiceImpl implements the LoginLimiterService interface.
+// It tracks failed login attempts per account and per IP address. After each failed attempt logging in is refused
+// for a delay growing exponentially with the number of failures, and after a threshold is reached it is refused for
+// the lockout duration. Attempts are reserved before the credentials are checked, so concurrent attempts
+// cannot get past the limits, and then either recorded as failed or released. A reservation does not count as a failure,
+// so it does not delay later attempts.
+type LoginLimiterServiceImpl struct {
+	store                   database.LoginAttemptStore
+	backoff                 time.Duration
+	maxBackoff              time.Duration
+	accountLockoutThreshold int
+	ipLockoutThreshold      int
+	lockoutDuration         time.Duration
+}
+
+// NewLoginLimiterService creates a new LoginLimiterServiceImpl storing failed login attempts in the given store.
+func NewLoginLimiterService(store database.LoginAttemptStore, opts ...LoginLimiterServiceOption) *LoginLimiterServiceImpl {
+	loginLimiterService := &LoginLimiterServiceImpl{
+		store:                   store,
+		backoff:                 DefaultLoginBackoff,
+		maxBackoff:              DefaultMaxLoginBackoff,
+		accountLockoutThreshold: DefaultAccountLockoutThreshold,
+		ipLockoutThreshold:      DefaultIPLockoutThreshold,
+		lockoutDuration:         DefaultLockoutDuration,
+	}
+
+	for _, opt := range opts {
+		opt(loginLimiterService)
+	}
+
+	return loginLimiterService
+}
+
+// LoginLimiterServiceOption is a function signature for providing options to configure the LoginLimiterServiceImpl.
+type LoginLimiterServiceOption func(*LoginLimiterServiceImpl)
+
+// WithLoginBackoff is an option to set the delay required after the first failed login attempt and the maximum delay.
+func WithLoginBackoff(backoff, maxBackoff time.Duration) LoginLimiterServiceOption {
+	return func(ls *LoginLimiterServiceImpl) {
+		if backoff > 0 {
+			ls.backoff = backoff
+		}
+		if maxBackoff > 0 {
+			ls.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithAccountLockoutThreshold is an option to set the number of failed login attempts to an account after which it is locked.
+func WithAccountLockoutThreshold(threshold int) LoginLimiterServiceOption {
+	return func(ls *LoginLimiterServiceImpl) {
+		if threshold > 0 {
+			ls.accountLockoutThreshold = threshold
+		}
+	}
+}
+
+// WithIPLockoutThreshold is an option to set the number of failed login attempts from an IP address after which it is locked.
+func WithIPLockoutThreshold(threshold int) LoginLimiterServiceOption {
+	return func(ls *LoginLimiterServiceImpl) {
+		if threshold > 0 {
+			ls.ipLockoutThreshold = threshold
+		}
+	}
+}
+
+// WithLockoutDuration is an option to set the duration of a lockout.
+func WithLockoutDuration(duration time.Duration) LoginLimiterServiceOption {
+	return func(ls *LoginLimiterServiceImpl) {
+		if duration > 0 {
+			ls.lockoutDuration = duration
+		}
+	}
+}
+
+// Reserve reserves an attempt to log in to the account with the given email address from the given IP address,
+// which has to be either recorded as failed or succeeded, or released. If logging in is not allowed now, nothing is reserved
+// and it returns a RetryAfterError wrapping ErrAccountLocked if the account is locked, or ErrTooManyLoginAttempts
+// if a delay after failed attempts has not passed yet, the IP address is locked or another attempt to the account is in progress.
+func (ls *LoginLimiterServiceImpl) Reserve(email, ip string) error {
+	now := time.Now()
+	accountKey, ipKey := ls.accountKey(email), ls.ipKey(ip)
+
+	// Attempts which are refused anyway are not reserved, so they do not write to the store.
+	accountAttempt, err := ls.selectAttempt(accountKey)
+	if err != nil {
+		return err
+	}
+
+	ipAttempt, err := ls.selectAttempt(ipKey)
+	if err != nil {
+		return err
+	}
+
+	if err := ls.check(accountAttempt, ipAttempt, now); err != nil {
+		return err
+	}
+
+	if accountAttempt, err = ls.reserve(accountKey, now); err != nil {
+		return err
+	}
+
+	if ipAttempt, err = ls.reserve(ipKey, now); err != nil {
+		return errors.Join(err, ls.store.ReleaseLoginAttempt(accountKey))
+	}
+
+	// The attempts returned by the store account for attempts reserved or failed concurrently since they have been checked above.
+	// Only one attempt to an account may be in progress, and attempts from an IP address only until its threshold.
+	err = ls.check(accountAttempt, ipAttempt, now)
+	if err == nil && (accountAttempt.Pending > 1 || ipAttempt != nil && ipAttempt.Failures+ipAttempt.Pending > ls.ipLockoutThreshold) {
+		err = &RetryAfterError{Err: ErrTooManyLoginAttempts, RetryAfter: ls.backoff}
+	}
+	if err != nil {
+		return errors.Join(err, ls.Release(email, ip))
+	}
+
+	return nil
+}
+
+// Release releases an attempt reserved with Reserve which has not failed, e.g. because the second factor is still required
+// or the credentials could not be checked, so it is not counted as a failed attempt.
+func (ls *LoginLimiterServiceImpl) Release(email, ip string) error {
+	for _, key := range []string{ls.accountKey(email), ls.ipKey(ip)} {
+		if key == "" {
+			continue
+		}
+
+		if err := ls.store.ReleaseLoginAttempt(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure records an attempt reserved with Reserve as failed, both for the account and for the IP address.
+func (ls *LoginLimiterServiceImpl) RecordFailure(email, ip string) error {
+	now := time.Now()
+
+	if err := ls.fail(ls.accountKey(email), ls.accountLockoutThreshold, now); err != nil {
+		return err
+	}
+
+	return ls.fail(ls.ipKey(ip), ls.ipLockoutThreshold, now)
+}
+
+// RecordSuccess forgets failed login attempts to the account with the given email address after a successful login
+// and releases the attempt reserved from the IP address. Failed attempts from the IP address are kept,
+// so logging in to an own account does not allow guessing passwords of others, and the delay after them is not extended.
+func (ls *LoginLimiterServiceImpl) RecordSuccess(email, ip string) error {
+	if err := ls.store.DeleteLoginAttempt(ls.accountKey(email)); err != nil {
+		return err
+	}
+
+	if ipKey := ls.ipKey(ip); ipKey != "" {
+		return ls.store.ReleaseLoginAttempt(ipKey)
+	}
+
+	return nil
+}
+
+// Unlock forgets failed login attempts to the account with the given email address, which unlocks it.
+func (ls *LoginLimiterServiceImpl) Unlock(email string) error {
+	return ls.store.DeleteLoginAttempt(ls.accountKey(email))
+}
+
+// RunCleanup periodically removes failed login attempts which are forgotten already.
+// It blocks until the given context is done.
+func (ls *LoginLimiterServiceImpl) RunCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultLoginAttemptCleanupInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := ls.store.DeleteExpiredLoginAttempts(time.Now().Add(-ls.lockoutDuration))
+			if err != nil {
+				logger.Errorf("Error (%s) while removing expired failed login attempts", err)
+				continue
+			}
+
+			logger.Infof("Removed %d expired failed login attempts", deleted)
+		}
+	}
+}
+
+// selectAttempt returns the attempts tracked for the given key, or nil if the key is empty.
+func (ls *LoginLimiterServiceImpl) selectAttempt(key string) (*models.LoginAttempt, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	return ls.store.SelectLoginAttempt(key)
+}
+
+// reserve records a pending attempt for the given key and returns the updated attempts, or nil if the key is empty.
+func (ls *LoginLimiterServiceImpl) reserve(key string, now time.Time) (*models.LoginAttempt, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	return ls.store.ReserveLoginAttempt(key, now, now.Add(-ls.lockoutDuration), now.Add(-reservationTimeout))
+}
+
+// fail records a pending attempt for the given key as failed, unless the key is empty.
+func (ls *LoginLimiterServiceImpl) fail(key string, threshold int, now time.Time) error {
+	if key == "" {
+		return nil
+	}
+
+	attempt, err := ls.store.FailLoginAttempt(key, now, now.Add(-ls.lockoutDuration))
+	if err != nil {
+		return err
+	}
+
+	if attempt.Failures == threshold {
+		logger.Infof("Locked %s for %s after %d failed login attempts", key, ls.lockoutDuration, attempt.Failures)
+	}
+
+	return nil
+}
+
+// check returns a RetryAfterError if logging in is not allowed now after the failed attempts to the account and from the IP address.
+func (ls *LoginLimiterServiceImpl) check(accountAttempt, ipAttempt *models.LoginAttempt, now time.Time) error {
+	accountRetryAfter, accountLocked := ls.retryAfter(accountAttempt, ls.accountLockoutThreshold, now)
+	if accountLocked {
+		return &RetryAfterError{Err: ErrAccountLocked, RetryAfter: accountRetryAfter}
+	}
+
+	ipRetryAfter, _ := ls.retryAfter(ipAttempt, ls.ipLockoutThreshold, now)
+
+	if retryAfter := max(accountRetryAfter, ipRetryAfter); retryAfter > 0 {
+		return &RetryAfterError{Err: ErrTooManyLoginAttempts, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// retryAfter returns the duration after which logging in is allowed again after the given failed attempts
+// and whether the key is locked, because the number of failed attempts has reached the threshold.
+func (ls *LoginLimiterServiceImpl) retryAfter(attempt *models.LoginAttempt, threshold int, now time.Time) (time.Duration, bool) {
+	if attempt == nil || attempt.Failures == 0 || now.Sub(attempt.LastFailureAt) >= ls.lockoutDuration {
+		return 0, false
+	}
+
+	locked := attempt.Failures >= threshold
+
+	delay := ls.lockoutDuration
+	if !locked {
+		delay = ls.backoff
+		for i := 1; i < attempt.Failures && delay < ls.maxBackoff; i++ {
+			delay *= 2
+		}
+		delay = min(delay, ls.maxBackoff)
+	}
+
+	retryAfter := attempt.LastFailureAt.Add(delay).Sub(now)
+	if retryAfter <= 0 {
+		return 0, false
+	}
+
+	return retryAfter, locked
+}
+
+// accountKey returns the key failed login attempts to the account with the given email address are tracked with.
+func (ls *LoginLimiterServiceImpl) accountKey(email string) string {
+	return accountKeyPrefix + strings.ToLower(email)
+}
+
+// ipKey returns the key failed login attempts from the given IP address are tracked with, or an empty string if it is unknown.
+func (ls *LoginLimiterServiceImpl) ipKey(ip string) string {
+	if ip == "" {
+		return ""
+	}
+
+	return ipKeyPrefix + ip
+}
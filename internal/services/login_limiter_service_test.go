@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
+	"github.com/MSSkowron/BookRESTAPI/pkg/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLimiterService(t *testing.T) {
+	ls := NewLoginLimiterService(database.NewMemoryLoginAttemptStore(),
+		WithLoginBackoff(50*time.Millisecond, 100*time.Millisecond),
+		WithAccountLockoutThreshold(3),
+		WithIPLockoutThreshold(5),
+		WithLockoutDuration(time.Hour),
+	)
+
+	// fail reserves an attempt and records it as failed
+	fail := func(email, ip string) {
+		t.Helper()
+
+		require.NoError(t, ls.Reserve(email, ip))
+		require.NoError(t, ls.RecordFailure(email, ip))
+	}
+
+	// A failed attempt requires waiting for the backoff
+	fail("john@net.eu", "127.0.0.1")
+	err := ls.Reserve("John@net.eu", "")
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+	requireRetryAfter(t, err, 50*time.Millisecond)
+
+	// Other accounts are not affected, unless logging in from the same IP address
+	require.ErrorIs(t, ls.Reserve("jane@net.eu", "127.0.0.1"), ErrTooManyLoginAttempts)
+	require.NoError(t, ls.Reserve("jane@net.eu", "127.0.0.2"))
+
+	// Only one attempt to an account may be in progress, but a released attempt does not delay the next one
+	require.ErrorIs(t, ls.Reserve("jane@net.eu", "127.0.0.3"), ErrTooManyLoginAttempts)
+	require.NoError(t, ls.Release("jane@net.eu", "127.0.0.2"))
+	require.NoError(t, ls.Reserve("jane@net.eu", "127.0.0.2"))
+	require.NoError(t, ls.Release("jane@net.eu", "127.0.0.2"))
+
+	// The backoff doubles, but does not exceed the maximum
+	time.Sleep(50 * time.Millisecond)
+	fail("john@net.eu", "127.0.0.1")
+	err = ls.Reserve("john@net.eu", "127.0.0.1")
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+	requireRetryAfter(t, err, 100*time.Millisecond)
+
+	// Reaching the threshold locks the account for the lockout duration
+	time.Sleep(100 * time.Millisecond)
+	fail("john@net.eu", "127.0.0.1")
+	err = ls.Reserve("john@net.eu", "127.0.0.2")
+	require.ErrorIs(t, err, ErrAccountLocked)
+	requireRetryAfter(t, err, time.Hour)
+
+	require.NoError(t, ls.Unlock("JOHN@net.eu"))
+	time.Sleep(100 * time.Millisecond)
+
+	// A success forgets failures of the account, but not of the IP address, and does not delay other attempts from it
+	require.NoError(t, ls.Reserve("john@net.eu", "127.0.0.1"))
+	require.NoError(t, ls.RecordSuccess("john@net.eu", "127.0.0.1"))
+	require.NoError(t, ls.Reserve("john@net.eu", "127.0.0.2"))
+	require.NoError(t, ls.Release("john@net.eu", "127.0.0.2"))
+	require.NoError(t, ls.Reserve("jane@net.eu", "127.0.0.1"))
+	require.NoError(t, ls.Release("jane@net.eu", "127.0.0.1"))
+
+	// Failures from an IP address are counted across accounts
+	for _, email := range []string{"a@net.eu", "b@net.eu"} {
+		time.Sleep(100 * time.Millisecond)
+		fail(email, "127.0.0.1")
+	}
+	err = ls.Reserve("jane@net.eu", "127.0.0.1")
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+	requireRetryAfter(t, err, time.Hour)
+	require.NoError(t, ls.Reserve("jane@net.eu", "127.0.0.3"))
+
+	// Failures older than the lockout duration are forgotten
+	ls = NewLoginLimiterService(database.NewMemoryLoginAttemptStore(), WithAccountLockoutThreshold(1), WithLockoutDuration(time.Millisecond))
+	fail("john@net.eu", "")
+	require.ErrorIs(t, ls.Reserve("john@net.eu", ""), ErrAccountLocked)
+	time.Sleep(time.Millisecond)
+	require.NoError(t, ls.Reserve("john@net.eu", ""))
+}
+
+func TestLoginLimiterServiceConcurrentAttempts(t *testing.T) {
+	ls := NewLoginLimiterService(database.NewMemoryLoginAttemptStore(), WithLoginBackoff(time.Hour, time.Hour), WithIPLockoutThreshold(5))
+
+	// reserve reserves attempts to log in to the accounts concurrently and returns the number of reserved ones
+	reserve := func(emails []string, ip string) int {
+		errs := make(chan error, len(emails))
+		for _, email := range emails {
+			go func(email string) {
+				errs <- ls.Reserve(email, ip)
+			}(email)
+		}
+
+		reserved := 0
+		for range emails {
+			if err := <-errs; err == nil {
+				reserved++
+			} else {
+				require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+			}
+		}
+
+		return reserved
+	}
+
+	// Only one of concurrent attempts to an account is allowed
+	emails := make([]string, 20)
+	for i := range emails {
+		emails[i] = "john@net.eu"
+	}
+	require.Equal(t, 1, reserve(emails, ""))
+
+	// Concurrent attempts from an IP address are allowed at most until its threshold
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@net.eu", i)
+	}
+	reserved := reserve(emails, "127.0.0.1")
+	require.GreaterOrEqual(t, reserved, 1)
+	require.LessOrEqual(t, reserved, 5)
+}
+
+func TestUserServiceLoginLimiter(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	ls := NewLoginLimiterService(mockDB, WithLoginBackoff(time.Millisecond, time.Millisecond), WithAccountLockoutThreshold(3))
+	us := NewUserService(mockDB, ts, WithLoginLimiter(ls))
+
+	userDTO, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+	id := int(userDTO.ID)
+
+	// Failures are recorded for unknown accounts as well, so they cannot be told apart
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "unknown@net.eu", Password: "Password1", ClientIP: "127.0.0.2"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "unknown@net.eu", Password: "Password1", ClientIP: "127.0.0.2"})
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+
+	// A success forgets previous failures
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "invalid", ClientIP: "127.0.0.1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	time.Sleep(time.Millisecond)
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "invalid", ClientIP: "127.0.0.1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	time.Sleep(time.Millisecond)
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.1"})
+	require.NoError(t, err)
+
+	// Invalid second factors are counted as failures
+	enrollmentDTO, err := us.EnrollTwoFactor(id)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(enrollmentDTO.Secret, time.Now())
+	require.NoError(t, err)
+	_, err = us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: code})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.1"})
+		require.NoError(t, err)
+		require.True(t, tokens.TwoFactorRequired)
+
+		_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: "000000", ClientIP: "127.0.0.1"})
+		require.Equal(t, ErrInvalidTwoFactorCode, err)
+	}
+
+	// The account is locked even with a valid password
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.3"})
+	require.ErrorIs(t, err, ErrAccountLocked)
+
+	require.Equal(t, ErrUserNotFound, us.UnlockUser(100))
+	require.NoError(t, us.UnlockUser(id))
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.3"})
+	require.NoError(t, err)
+	require.True(t, tokens.TwoFactorRequired)
+}
+
+func TestUserServiceLoginLimiterReservations(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	ls := NewLoginLimiterService(mockDB, WithLoginBackoff(100*time.Millisecond, 100*time.Millisecond), WithAccountLockoutThreshold(3))
+	us := NewUserService(mockDB, ts, WithLoginLimiter(ls))
+
+	userDTO, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+	id := int(userDTO.ID)
+
+	enrollmentDTO, err := us.EnrollTwoFactor(id)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(enrollmentDTO.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodesDTO, err := us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: code})
+	require.NoError(t, err)
+
+	// The second factor can be provided right after the password, also after a failed attempt
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "invalid", ClientIP: "127.0.0.1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	time.Sleep(100 * time.Millisecond)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.1"})
+	require.NoError(t, err)
+	require.True(t, tokens.TwoFactorRequired)
+
+	code, err = totp.GenerateCode(enrollmentDTO.Secret, time.Now().Add(totp.Period))
+	require.NoError(t, err)
+	tokens, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: code, ClientIP: "127.0.0.1"})
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.Token)
+
+	// A successful login does not delay attempts of others from the same IP address after its earlier failures
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "unknown@net.eu", Password: "Password1", ClientIP: "127.0.0.2"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "Password1", Code: recoveryCodesDTO.RecoveryCodes[0], ClientIP: "127.0.0.2"}))
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.2"})
+	require.NoError(t, err)
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "janetestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.2"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	// Checks of the current password are limited like logging in
+	err = us.ChangePassword(context.Background(), id, &dtos.PasswordChangeDTO{CurrentPassword: "invalid", NewPassword: "Password2", ClientIP: "127.0.0.3"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	err = us.ChangePassword(context.Background(), id, &dtos.PasswordChangeDTO{CurrentPassword: "Password1", NewPassword: "Password2", ClientIP: "127.0.0.3"})
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+
+	time.Sleep(100 * time.Millisecond)
+	err = us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "johnnew@net.eu", Password: "invalid", ClientIP: "127.0.0.3"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	err = us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "johnnew@net.eu", Password: "Password1", ClientIP: "127.0.0.3"})
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+
+	// The account is locked after reaching the threshold
+	time.Sleep(100 * time.Millisecond)
+	err = us.ChangePassword(context.Background(), id, &dtos.PasswordChangeDTO{CurrentPassword: "invalid", NewPassword: "Password2", ClientIP: "127.0.0.3"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1", ClientIP: "127.0.0.4"})
+	require.ErrorIs(t, err, ErrAccountLocked)
+}
+
+func requireRetryAfter(t *testing.T, err error, max time.Duration) {
+	t.Helper()
+
+	var retryAfterErr *RetryAfterError
+	require.True(t, errors.As(err, &retryAfterErr))
+	require.Greater(t, retryAfterErr.RetryAfter, time.Duration(0))
+	require.LessOrEqual(t, retryAfterErr.RetryAfter, max)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
+)
+
+const (
+	// DefaultPasswordMinLength is the default minimum number of characters of a password.
+	DefaultPasswordMinLength = 6
+	// DefaultPasswordMaxLength is the default maximum number of characters of a password.
+	DefaultPasswordMaxLength = 72
+	// DefaultPasswordMaxBytes is the default maximum number of bytes of a password.
+	// Bcrypt, the default password hashing algorithm, does not hash passwords longer than 72 bytes,
+	// which may be fewer than 72 characters if they are not ASCII.
+	DefaultPasswordMaxBytes = crypto.BcryptMaxPasswordLength
+
+	// minPersonalInfoLength is the minimum number of characters of a personal detail a password must not contain.
+	// Shorter ones, e.g. a two-letter name, are too likely to appear in a password by chance.
+	minPersonalInfoLength = 3
+)
+
+// DefaultPasswordPolicy is the default policy passwords of users have to comply with.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:        DefaultPasswordMinLength,
+	MaxLength:        DefaultPasswordMaxLength,
+	MaxBytes:         DefaultPasswordMaxBytes,
+	RequireUppercase: true,
+	RequireLowercase: true,
+	RequireDigit:     true,
+}
+
+// BreachedPasswordList is a list of passwords exposed in data breaches.
+type BreachedPasswordList interface {
+	Contains(string) (bool, error)
+}
+
+// PasswordPolicy is a policy passwords of users have to comply with.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters of a password.
+	MinLength int
+	// MaxLength is the maximum number of characters of a password. It is not limited if it is 0.
+	MaxLength int
+	// MaxBytes is the maximum number of bytes of a password, e.g. the most the password hasher accepts. It is not limited if it is 0.
+	MaxBytes int
+	// RequireUppercase requires a password to contain an uppercase letter.
+	RequireUppercase bool
+	// RequireLowercase requires a password to contain a lowercase letter.
+	RequireLowercase bool
+	// RequireDigit requires a password to contain a digit.
+	RequireDigit bool
+	// RequireSymbol requires a password to contain a character which is neither a letter nor a digit.
+	RequireSymbol bool
+	// DisallowPersonalInfo disallows a password to contain the email address or a name of the user.
+	DisallowPersonalInfo bool
+	// BreachedPasswords is a list of passwords exposed in data breaches, which are disallowed. No password is disallowed if it is nil.
+	BreachedPasswords BreachedPasswordList
+}
+
+// PasswordPolicyError is returned when a password does not comply with the password policy.
+// It lists every violated rule and wraps ErrInvalidPassword.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+// Error returns a message listing the violated rules.
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Unwrap returns ErrInvalidPassword.
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrInvalidPassword
+}
+
+// Check checks whether a password complies with the policy.
+// The personal details of the user, i.e. the email address and the names, are disallowed if the policy says so.
+// It returns a PasswordPolicyError listing every violated rule, or an error if the breached passwords cannot be looked up.
+func (p PasswordPolicy) Check(password string, personalInfo ...string) error {
+	violations := []string{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must have at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must have at most %d characters", p.MaxLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, fmt.Sprintf("must have at most %d bytes", p.MaxBytes))
+	}
+
+	if p.RequireUppercase && !strings.ContainsFunc(password, unicode.IsUpper) {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !strings.ContainsFunc(password, unicode.IsLower) {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !strings.ContainsFunc(password, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.DisallowPersonalInfo && p.containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, "must not contain your email address or name")
+	}
+
+	if p.BreachedPasswords != nil && password != "" {
+		breached, err := p.BreachedPasswords.Contains(password)
+		if err != nil {
+			return fmt.Errorf("look up breached passwords: %w", err)
+		}
+		if breached {
+			violations = append(violations, "must not be a password exposed in a data breach")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// containsPersonalInfo reports whether a password contains, case-insensitively, any of the given personal details.
+// Email addresses are checked by their local part, which is contained in any password containing the whole address.
+func (p PasswordPolicy) containsPersonalInfo(password string, personalInfo []string) bool {
+	password = strings.ToLower(password)
+
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if localPart, _, ok := strings.Cut(info, "@"); ok {
+			info = localPart
+		}
+
+		if utf8.RuneCountInString(info) >= minPersonalInfoLength && strings.Contains(password, info) {
+			return true
+		}
+	}
+
+	return false
+}
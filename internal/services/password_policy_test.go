@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	strictPolicy := PasswordPolicy{
+		MinLength:            12,
+		MaxLength:            20,
+		RequireUppercase:     true,
+		RequireLowercase:     true,
+		RequireDigit:         true,
+		RequireSymbol:        true,
+		DisallowPersonalInfo: true,
+		BreachedPasswords:    breachedPasswordList{"Password123!"},
+	}
+
+	data := []struct {
+		name               string
+		policy             PasswordPolicy
+		password           string
+		expectedViolations []string
+	}{
+		{
+			name:     "default policy - valid password",
+			policy:   DefaultPasswordPolicy,
+			password: "Password1",
+		},
+		{
+			name:               "default policy - empty",
+			policy:             DefaultPasswordPolicy,
+			password:           "",
+			expectedViolations: []string{"must have at least 6 characters", "must contain an uppercase letter", "must contain a lowercase letter", "must contain a digit"},
+		},
+		{
+			name:               "default policy - too short",
+			policy:             DefaultPasswordPolicy,
+			password:           "Pass1",
+			expectedViolations: []string{"must have at least 6 characters"},
+		},
+		{
+			name:               "default policy - too long",
+			policy:             DefaultPasswordPolicy,
+			password:           "Password1" + strings.Repeat("x", 64),
+			expectedViolations: []string{"must have at most 72 characters", "must have at most 72 bytes"},
+		},
+		{
+			name:               "default policy - too many bytes",
+			policy:             DefaultPasswordPolicy,
+			password:           "Пароль1" + strings.Repeat("ж", 31),
+			expectedViolations: []string{"must have at most 72 bytes"},
+		},
+		{
+			name:               "default policy - no uppercase letter",
+			policy:             DefaultPasswordPolicy,
+			password:           "password1",
+			expectedViolations: []string{"must contain an uppercase letter"},
+		},
+		{
+			name:               "default policy - no lowercase letter",
+			policy:             DefaultPasswordPolicy,
+			password:           "PASSWORD1",
+			expectedViolations: []string{"must contain a lowercase letter"},
+		},
+		{
+			name:               "default policy - no digit",
+			policy:             DefaultPasswordPolicy,
+			password:           "Password",
+			expectedViolations: []string{"must contain a digit"},
+		},
+		{
+			name:     "default policy - personal info allowed",
+			policy:   DefaultPasswordPolicy,
+			password: "JohnDoe1",
+		},
+		{
+			name:     "default policy - unicode letters",
+			policy:   DefaultPasswordPolicy,
+			password: "Żółw2024",
+		},
+		{
+			name:     "strict policy - valid password",
+			policy:   strictPolicy,
+			password: "Correct-Horse-7",
+		},
+		{
+			name:               "strict policy - no symbol",
+			policy:             strictPolicy,
+			password:           "CorrectHorse77",
+			expectedViolations: []string{"must contain a symbol"},
+		},
+		{
+			name:               "strict policy - too long",
+			policy:             strictPolicy,
+			password:           "Correct-Horse-Battery-7",
+			expectedViolations: []string{"must have at most 20 characters"},
+		},
+		{
+			name:     "strict policy - length counted in characters",
+			policy:   strictPolicy,
+			password: "Źdźbło-Trawy-1",
+		},
+		{
+			name:               "strict policy - email address",
+			policy:             strictPolicy,
+			password:           "my-JohnDoe-77",
+			expectedViolations: []string{"must not contain your email address or name"},
+		},
+		{
+			name:               "strict policy - first name",
+			policy:             strictPolicy,
+			password:           "Jonathan-1234",
+			expectedViolations: []string{"must not contain your email address or name"},
+		},
+		{
+			name:               "strict policy - last name",
+			policy:             strictPolicy,
+			password:           "1234-SMITH-abc",
+			expectedViolations: []string{"must not contain your email address or name"},
+		},
+		{
+			name:               "strict policy - breached password",
+			policy:             strictPolicy,
+			password:           "Password123!",
+			expectedViolations: []string{"must not be a password exposed in a data breach"},
+		},
+		{
+			name:               "strict policy - every violation",
+			policy:             strictPolicy,
+			password:           "johndoe",
+			expectedViolations: []string{"must have at least 12 characters", "must contain an uppercase letter", "must contain a digit", "must contain a symbol", "must not contain your email address or name"},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			err := d.policy.Check(d.password, "johndoe@net.eu", "Jonathan", "Smith")
+			if d.expectedViolations == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrInvalidPassword)
+
+			policyErr := &PasswordPolicyError{}
+			require.ErrorAs(t, err, &policyErr)
+			require.Equal(t, d.expectedViolations, policyErr.Violations)
+			require.Equal(t, "password "+strings.Join(d.expectedViolations, ", "), err.Error())
+		})
+	}
+}
+
+func TestRegisterUserPasswordPolicy(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing(""), 0)
+	us := NewUserService(mockDB, ts, WithPasswordPolicy(PasswordPolicy{MinLength: 10, DisallowPersonalInfo: true, BreachedPasswords: breachedPasswordList{"qwertyuiop"}}))
+
+	input := &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	}
+
+	input.Password = "johntestdoe"
+	_, err := us.RegisterUser(context.Background(), input)
+	require.EqualError(t, err, "password must not contain your email address or name")
+
+	input.Password = "qwertyuiop"
+	_, err = us.RegisterUser(context.Background(), input)
+	require.EqualError(t, err, "password must not be a password exposed in a data breach")
+
+	// A password the hasher does not accept violates the policy, even if its length is not limited
+	input.Password = strings.Repeat("ж", 37)
+	_, err = us.RegisterUser(context.Background(), input)
+	require.ErrorIs(t, err, ErrInvalidPassword)
+	require.EqualError(t, err, "password is too long")
+
+	input.Password = "correct horse"
+	_, err = us.RegisterUser(context.Background(), input)
+	require.NoError(t, err)
+}
+
+func TestPasswordPolicyShortPersonalInfo(t *testing.T) {
+	policy := PasswordPolicy{DisallowPersonalInfo: true}
+
+	// Personal details shorter than 3 characters are too likely to appear by chance
+	require.NoError(t, policy.Check("Bob-Jo-Password1", "jo@net.eu", "Jo", ""))
+	require.Error(t, policy.Check("Bob-Jo-Password1", "bob@net.eu", "Jo", ""))
+}
+
+func TestPasswordPolicyBreachedPasswordsError(t *testing.T) {
+	policy := PasswordPolicy{BreachedPasswords: failingBreachedPasswordList{}}
+
+	err := policy.Check("Password1")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrInvalidPassword)
+}
+
+// breachedPasswordList is a BreachedPasswordList of the given passwords.
+type breachedPasswordList []string
+
+func (l breachedPasswordList) Contains(password string) (bool, error) {
+	for _, breached := range l {
+		if breached == password {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// failingBreachedPasswordList is a BreachedPasswordList which cannot be looked up.
+type failingBreachedPasswordList struct{}
+
+func (failingBreachedPasswordList) Contains(string) (bool, error) {
+	return false, errors.New("breached passwords not available")
+}
@@ -1,9 +1,15 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
+	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
 	"github.com/MSSkowron/BookRESTAPI/pkg/token"
 )
 
@@ -12,53 +18,274 @@ var (
 	ErrInvalidToken = errors.New("invalid token")
 	// ErrExpiredToken is returned when an expired token is provided.
 	ErrExpiredToken = errors.New("token is expired")
+	// ErrRevokedToken is returned when a revoked token is provided.
+	ErrRevokedToken = errors.New("token is revoked")
+	// ErrTerminatedSession is returned when a token issued in a session, which has been terminated, is provided.
+	ErrTerminatedSession = errors.New("session is terminated")
 )
 
+const (
+	// DefaultRevocationListCleanupInterval is the default interval between removals of expired entries from the token revocation list.
+	DefaultRevocationListCleanupInterval = time.Hour
+
+	// sessionLastUsedPrecision is the precision the time of the last use of a session is recorded with.
+	sessionLastUsedPrecision = time.Minute
+)
+
+// TokenClaims represents the claims of a validated token.
+type TokenClaims struct {
+	// UserID is the ID of the user the token has been issued to.
+	UserID int
+	// Role is the role of the user the token has been issued to.
+	Role models.Role
+	// SessionID is the ID of the session the token has been issued in. It is 0 for tokens issued without a session.
+	SessionID int
+}
+
 // TokenService is an interface that defines the methods that the TokenService must implement.
 type TokenService interface {
-	GenerateToken(int, string) (string, error)
-	ValidateToken(string) error
-	GetUserIDFromToken(string) (int, error)
+	GenerateToken(int, string, models.Role, int) (string, error)
+	ValidateToken(string) (*TokenClaims, error)
+	RevokeToken(string) error
+	RevokeUserTokens(int) error
+	GetJWKS() *dtos.JWKSDTO
 }
 
 // TokenServiceImpl implements the TokenService interface.
 type TokenServiceImpl struct {
-	tokenSecret   string
+	db            database.Database
+	keyRing       *token.KeyRing
 	tokenDuration time.Duration
+	leeway        time.Duration
+	tokenOptions  []token.Option
 }
 
 // NewTokenService creates a new TokenServiceImpl.
-func NewTokenService(tokenSecret string, tokenDuration time.Duration) *TokenServiceImpl {
-	return &TokenServiceImpl{
-		tokenSecret:   tokenSecret,
+// Tokens are signed with the signing key of the given key ring and verified with any of its keys.
+func NewTokenService(db database.Database, keyRing *token.KeyRing, tokenDuration time.Duration, opts ...TokenServiceOption) *TokenServiceImpl {
+	tokenService := &TokenServiceImpl{
+		db:            db,
+		keyRing:       keyRing,
 		tokenDuration: tokenDuration,
 	}
+
+	for _, opt := range opts {
+		opt(tokenService)
+	}
+
+	return tokenService
+}
+
+// TokenServiceOption is a function signature for providing options to configure the TokenServiceImpl.
+type TokenServiceOption func(*TokenServiceImpl)
+
+// WithTokenIssuer is an option to set the issuer (iss) of generated tokens, which validated tokens must match.
+func WithTokenIssuer(issuer string) TokenServiceOption {
+	return func(ts *TokenServiceImpl) {
+		ts.tokenOptions = append(ts.tokenOptions, token.WithIssuer(issuer))
+	}
+}
+
+// WithTokenAudience is an option to set the audience (aud) of generated tokens.
+// Validated tokens must be intended for at least one of the given recipients.
+func WithTokenAudience(audience ...string) TokenServiceOption {
+	return func(ts *TokenServiceImpl) {
+		ts.tokenOptions = append(ts.tokenOptions, token.WithAudience(audience...))
+	}
+}
+
+// WithTokenLeeway is an option to set the leeway allowed when validating token times to account for clock skew.
+func WithTokenLeeway(leeway time.Duration) TokenServiceOption {
+	return func(ts *TokenServiceImpl) {
+		ts.leeway = leeway
+		ts.tokenOptions = append(ts.tokenOptions, token.WithLeeway(leeway))
+	}
+}
+
+// WithLegacyTokenFormat is an option to accept tokens issued in the legacy format, before registered claims were adopted.
+func WithLegacyTokenFormat(accept bool) TokenServiceOption {
+	return func(ts *TokenServiceImpl) {
+		ts.tokenOptions = append(ts.tokenOptions, token.WithLegacyFormat(accept))
+	}
 }
 
-// GenerateToken generates a token.
-func (ts *TokenServiceImpl) GenerateToken(userID int, userEmail string) (string, error) {
-	return token.Generate(userID, userEmail, ts.tokenSecret, ts.tokenDuration)
+// GenerateToken generates a token for a user with the given role in the session with the given ID.
+// The token is issued without a session if the session ID is 0.
+func (ts *TokenServiceImpl) GenerateToken(userID int, userEmail string, role models.Role, sessionID int) (string, error) {
+	opts := make([]token.Option, 0, len(ts.tokenOptions)+2)
+	opts = append(opts, ts.tokenOptions...)
+	opts = append(opts, token.WithRole(string(role)))
+	if sessionID != 0 {
+		opts = append(opts, token.WithSessionID(strconv.Itoa(sessionID)))
+	}
+
+	return token.Generate(userID, userEmail, ts.keyRing, ts.tokenDuration, opts...)
 }
 
-// ValidateToken validates a token.
-func (ts *TokenServiceImpl) ValidateToken(tokenString string) error {
-	if err := token.Validate(tokenString, ts.tokenSecret); err != nil {
+// ValidateToken validates a token and returns its claims.
+// Besides checking the signature and expiration time, it checks the token against the revocation list
+// and checks that the session the token has been issued in has not been terminated. The use of the session is recorded.
+func (ts *TokenServiceImpl) ValidateToken(tokenString string) (*TokenClaims, error) {
+	claims, err := token.GetValidatedClaims(tokenString, ts.keyRing, ts.tokenOptions...)
+	if err != nil {
 		if errors.Is(err, token.ErrExpiredToken) {
-			return ErrExpiredToken
+			return nil, ErrExpiredToken
+		}
+
+		return nil, ErrInvalidToken
+	}
+
+	role, err := roleFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := sessionIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := ts.db.IsTokenRevoked(claims.ID, claims.UserID, claims.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrRevokedToken
+	}
+
+	tokenClaims := &TokenClaims{
+		UserID:    claims.UserID,
+		Role:      role,
+		SessionID: sessionID,
+	}
+
+	if sessionID == 0 {
+		return tokenClaims, nil
+	}
+
+	session, err := ts.db.SelectSessionByID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != claims.UserID || session.TerminatedAt != nil {
+		return nil, ErrTerminatedSession
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) >= sessionLastUsedPrecision {
+		// The use is recorded with a limited precision, so not every request writes to the database.
+		if err := ts.db.UpdateSessionLastUsedAt(session.ID, now); err != nil {
+			return nil, err
 		}
+	}
 
+	return tokenClaims, nil
+}
+
+// RevokeToken adds a token to the revocation list, so it is no longer accepted by ValidateToken.
+// The entry is kept until the token expires, including the leeway it is still accepted within.
+func (ts *TokenServiceImpl) RevokeToken(tokenString string) error {
+	claims, err := token.GetClaims(tokenString, ts.keyRing, ts.tokenOptions...)
+	if err != nil {
 		return ErrInvalidToken
 	}
 
-	return nil
+	if claims.ID == "" {
+		// Tokens without an ID cannot be revoked one by one, so all tokens of the user are revoked instead.
+		return ts.RevokeUserTokens(claims.UserID)
+	}
+
+	return ts.db.InsertRevokedToken(&models.RevokedToken{
+		RevokedAt: time.Now(),
+		UserID:    claims.UserID,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Add(ts.leeway),
+	})
+}
+
+// RevokeUserTokens revokes all tokens issued to the user with the given ID until now.
+// The entry is kept until all of them expire, including the leeway they are still accepted within.
+func (ts *TokenServiceImpl) RevokeUserTokens(userID int) error {
+	now := time.Now()
+
+	return ts.db.InsertRevokedToken(&models.RevokedToken{
+		RevokedAt: now,
+		UserID:    userID,
+		ExpiresAt: now.Add(ts.tokenDuration + ts.leeway),
+	})
+}
+
+// GetJWKS returns the public keys tokens can be verified with as a JSON Web Key Set.
+func (ts *TokenServiceImpl) GetJWKS() *dtos.JWKSDTO {
+	jwks := ts.keyRing.JWKS()
+
+	jwksDTO := &dtos.JWKSDTO{Keys: []*dtos.JWKDTO{}}
+	for _, jwk := range jwks.Keys {
+		jwksDTO.Keys = append(jwksDTO.Keys, &dtos.JWKDTO{
+			KeyType:   jwk.KeyType,
+			Use:       jwk.Use,
+			KeyID:     jwk.KeyID,
+			Algorithm: jwk.Algorithm,
+			N:         jwk.N,
+			E:         jwk.E,
+			Curve:     jwk.Curve,
+			X:         jwk.X,
+		})
+	}
+
+	return jwksDTO
 }
 
-// GetUserIDFromToken retrieves the user ID from a token.
-func (ts *TokenServiceImpl) GetUserIDFromToken(tokenString string) (int, error) {
-	id, err := token.GetUserID(tokenString, ts.tokenSecret)
+// RunRevocationListCleanup periodically removes expired entries from the token revocation list.
+// It blocks until the given context is done.
+func (ts *TokenServiceImpl) RunRevocationListCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRevocationListCleanupInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := ts.db.DeleteExpiredRevokedTokens(time.Now())
+			if err != nil {
+				logger.Errorf("Error (%s) while removing expired entries from the token revocation list", err)
+				continue
+			}
+
+			logger.Infof("Removed %d expired entries from the token revocation list", deleted)
+		}
+	}
+}
+
+// roleFromClaims returns the role of the user from the claims of a token.
+// Tokens issued before roles have been introduced carry no role and are granted the reader role only.
+func roleFromClaims(claims *token.Claims) (models.Role, error) {
+	if claims.Role == "" {
+		return models.RoleReader, nil
+	}
+
+	role := models.Role(claims.Role)
+	if !role.IsValid() {
+		return "", ErrInvalidToken
+	}
+
+	return role, nil
+}
+
+// sessionIDFromClaims returns the ID of the session from the claims of a token. It is 0 for tokens issued without a session.
+func sessionIDFromClaims(claims *token.Claims) (int, error) {
+	if claims.SessionID == "" {
+		return 0, nil
+	}
+
+	sessionID, err := strconv.Atoi(claims.SessionID)
 	if err != nil {
-		return 0, err
+		return 0, ErrInvalidToken
 	}
 
-	return id, nil
+	return sessionID, nil
 }
@@ -1,35 +1,194 @@
 package services
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 	"time"
 
+	"github.com/MSSkowron/BookRESTAPI/internal/database"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
 	"github.com/stretchr/testify/require"
 )
 
 func TestTokenService(t *testing.T) {
-	ts := NewTokenService("secret12345", 3*time.Second)
+	ts := NewTokenService(database.NewMockDatabase(), token.NewHMACKeyRing("secret12345"), 3*time.Second)
 
 	// Generate Token
-	token, err := ts.GenerateToken(1, "email@net.com")
+	token, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
 	require.NoError(t, err)
 	require.NotEmpty(t, token)
 
-	require.NoError(t, ts.ValidateToken(token))
+	claims, err := ts.ValidateToken(token)
+	require.NoError(t, err)
+	require.Equal(t, &TokenClaims{UserID: 1, Role: models.RoleEditor}, claims)
 
 	// Validate Token
 	time.Sleep(4 * time.Second)
-	require.Equal(t, ts.ValidateToken(token), ErrExpiredToken)
+	_, err = ts.ValidateToken(token)
+	require.Equal(t, ErrExpiredToken, err)
+
+	_, err = ts.ValidateToken("invalid token")
+	require.Equal(t, ErrInvalidToken, err)
+	_, err = ts.ValidateToken("")
+	require.Equal(t, ErrInvalidToken, err)
+}
+
+func TestTokenServiceRole(t *testing.T) {
+	keyRing := token.NewHMACKeyRing("secret12345")
+	ts := NewTokenService(database.NewMockDatabase(), keyRing, time.Minute)
+
+	for _, role := range []models.Role{models.RoleReader, models.RoleEditor, models.RoleAdmin} {
+		tokenString, err := ts.GenerateToken(1, "email@net.com", role, 0)
+		require.NoError(t, err)
+
+		claims, err := ts.ValidateToken(tokenString)
+		require.NoError(t, err)
+		require.Equal(t, role, claims.Role)
+	}
+
+	// tokens without a role are granted the reader role only
+	tokenString, err := token.Generate(1, "email@net.com", keyRing, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := ts.ValidateToken(tokenString)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleReader, claims.Role)
+
+	// tokens with an unknown role are rejected
+	tokenString, err = token.Generate(1, "email@net.com", keyRing, time.Minute, token.WithRole("superuser"))
+	require.NoError(t, err)
+
+	_, err = ts.ValidateToken(tokenString)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenServiceRevocation(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+
+	first, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+	second, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+	other, err := ts.GenerateToken(2, "other@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+
+	// Revoke a single token
+	require.NoError(t, ts.RevokeToken(first))
+	_, err = ts.ValidateToken(first)
+	require.Equal(t, ErrRevokedToken, err)
+	_, err = ts.ValidateToken(second)
+	require.NoError(t, err)
+	_, err = ts.ValidateToken(other)
+	require.NoError(t, err)
+
+	require.Equal(t, ErrInvalidToken, ts.RevokeToken("invalid token"))
+
+	// Revoke all tokens of a user
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, ts.RevokeUserTokens(1))
+	_, err = ts.ValidateToken(second)
+	require.Equal(t, ErrRevokedToken, err)
+	_, err = ts.ValidateToken(other)
+	require.NoError(t, err)
+
+	// Tokens issued after the revocation are valid
+	time.Sleep(2 * time.Millisecond)
+	third, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+	_, err = ts.ValidateToken(third)
+	require.NoError(t, err)
+
+	// Expired entries are removed from the revocation list
+	deleted, err := mockDB.DeleteExpiredRevokedTokens(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	_, err = ts.ValidateToken(second)
+	require.NoError(t, err)
+
+	// Cleanup stops when the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ts.RunRevocationListCleanup(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestTokenServiceRevocationLeeway(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute, WithTokenLeeway(time.Minute))
+
+	tokenString, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, ts.RevokeToken(tokenString))
+	require.NoError(t, ts.RevokeUserTokens(2))
+
+	// Entries are kept as long as the tokens are accepted within the leeway
+	deleted, err := mockDB.DeleteExpiredRevokedTokens(time.Now().Add(90 * time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+	_, err = ts.ValidateToken(tokenString)
+	require.Equal(t, ErrRevokedToken, err)
+
+	deleted, err = mockDB.DeleteExpiredRevokedTokens(time.Now().Add(3 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+}
+
+func TestTokenServiceIssuerAndAudience(t *testing.T) {
+	mockDB := database.NewMockDatabase()
 
-	require.Equal(t, ts.ValidateToken("invalid token"), ErrInvalidToken)
-	require.Equal(t, ts.ValidateToken(""), ErrInvalidToken)
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute, WithTokenIssuer("bookrestapi"), WithTokenAudience("bookrestapi"), WithTokenLeeway(time.Second))
+	otherIssuer := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute, WithTokenIssuer("other"), WithTokenAudience("bookrestapi"))
+	otherAudience := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute, WithTokenIssuer("bookrestapi"), WithTokenAudience("other"))
 
-	// Get userID from Token
-	id, err := ts.GetUserIDFromToken(token)
+	token, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+
+	_, err = ts.ValidateToken(token)
+	require.NoError(t, err)
+	_, err = otherIssuer.ValidateToken(token)
+	require.Equal(t, ErrInvalidToken, err)
+	_, err = otherAudience.ValidateToken(token)
+	require.Equal(t, ErrInvalidToken, err)
+}
+
+func TestTokenServiceJWKS(t *testing.T) {
+	require.Empty(t, NewTokenService(nil, token.NewHMACKeyRing("secret12345"), time.Minute).GetJWKS().Keys)
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	key, err := token.NewKeyFromPEM("key-1", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	ts := NewTokenService(database.NewMockDatabase(), token.NewKeyRing(key), time.Minute)
+
+	tokenString, err := ts.GenerateToken(1, "email@net.com", models.RoleEditor, 0)
+	require.NoError(t, err)
+	_, err = ts.ValidateToken(tokenString)
 	require.NoError(t, err)
-	require.Equal(t, 1, id)
 
-	id, err = ts.GetUserIDFromToken("invalid token")
-	require.Equal(t, err, ErrInvalidToken)
-	require.Equal(t, 0, id)
+	jwks := ts.GetJWKS()
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, "key-1", jwks.Keys[0].KeyID)
+	require.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	require.Equal(t, "Ed25519", jwks.Keys[0].Curve)
+	require.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+	require.Equal(t, "sig", jwks.Keys[0].Use)
+	require.NotEmpty(t, jwks.Keys[0].X)
 }
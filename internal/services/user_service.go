@@ -1,32 +1,44 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
 	"errors"
+	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/database"
 	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
 	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
+	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
+	"github.com/MSSkowron/BookRESTAPI/pkg/mailer"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc"
+	"github.com/MSSkowron/BookRESTAPI/pkg/totp"
+	"golang.org/x/text/unicode/norm"
 )
 
 var (
 	// ErrInvalidEmail is returned when an invalid email address is provided.
 	ErrInvalidEmail = errors.New("email must not be empty and must be a valid email address")
-	// ErrInvalidPassword is returned when an invalid password is provided.
-	// Password must have at least 6 characters, including 1 uppercase letter,
-	// 1 lowercase letter, and 1 digit.
-	ErrInvalidPassword = errors.New("password must not be empty and must be have at least 6 characters, including 1 uppercase letter, 1 lowercase letter, and 1 digit")
+	// ErrInvalidPassword is returned when a password which does not comply with the password policy is provided.
+	// It is wrapped in a PasswordPolicyError listing the violated rules.
+	ErrInvalidPassword = errors.New("password does not comply with the password policy")
 	// ErrEmptyPassword is returned when an empty password is provided.
 	ErrEmptyPassword = errors.New("password must not be empty")
 	// ErrInvalidFirstName is returned when an invalid first name is provided.
-	// First name must consist of alphabetic characters and spaces, with at least 2 characters.
-	ErrInvalidFirstName = errors.New("first name must must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters")
+	// First name must have from 2 to 50 characters and consist of words of letters separated by spaces, hyphens or apostrophes.
+	ErrInvalidFirstName = errors.New("first name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes")
 	// ErrInvalidLastName is returned when an invalid last name is provided.
-	// Last name must consist of alphabetic characters and spaces, with at least 2 characters.
-	ErrInvalidLastName = errors.New("last name must not be empty and must consists of alphabetic characters and spaces, with at least 2 characters")
+	// Last name must have from 2 to 50 characters and consist of words of letters separated by spaces, hyphens or apostrophes.
+	ErrInvalidLastName = errors.New("last name must have from 2 to 50 characters and consist of letters separated by single spaces, hyphens or apostrophes")
 	// ErrInvalidAge is returned when an invalid age is provided.
 	// Age must be between 18 and 120.
 	ErrInvalidAge = errors.New("age must must not be empty and must be between 18 and 120")
@@ -34,40 +46,402 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	// ErrUserAlreadyExists is returned when a user with the same details already exists.
 	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrUserNotFound is returned when the user with the given id does not exist in the database.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidRefreshToken is returned when an unknown, revoked or reused refresh token is provided.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrExpiredRefreshToken is returned when an expired refresh token is provided.
+	ErrExpiredRefreshToken = errors.New("refresh token is expired")
+	// ErrInvalidEmailChangeToken is returned when an unknown, used or expired email change token is provided.
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+	// ErrInvalidPasswordResetToken is returned when an unknown, used or expired password reset token is provided.
+	ErrInvalidPasswordResetToken = errors.New("invalid or expired password reset token")
+	// ErrInvalidEmailVerificationToken is returned when an unknown, used or expired email verification token is provided.
+	ErrInvalidEmailVerificationToken = errors.New("invalid or expired email verification token")
+	// ErrEmailNotVerified is returned when a user who has not verified the email address logs in while the verification is required.
+	ErrEmailNotVerified = errors.New("email address is not verified")
+	// ErrTwoFactorAlreadyEnabled is returned when enrolling in two-factor authentication, which is already enabled.
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnrolled is returned when confirming the enrollment in two-factor authentication, which has not been started.
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication enrollment has not been started")
+	// ErrTwoFactorNotEnabled is returned when disabling two-factor authentication, which is not enabled.
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrInvalidTwoFactorCode is returned when an invalid, already used TOTP code or recovery code is provided.
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor authentication code")
+	// ErrInvalidChallengeToken is returned when an unknown, used or expired two-factor authentication challenge token is provided.
+	ErrInvalidChallengeToken = errors.New("invalid or expired challenge token")
+	// ErrInvalidAPIKeyName is returned when an invalid API key name is provided.
+	ErrInvalidAPIKeyName = errors.New("api key name must not be empty and must have at most 100 characters")
+	// ErrInvalidAPIKeyScopes is returned when invalid API key scopes are provided.
+	ErrInvalidAPIKeyScopes = errors.New("api key scopes must not be empty and must be permissions granted to the role of the user")
+	// ErrInvalidAPIKeyExpiration is returned when an API key expiration time which is not in the future is provided.
+	ErrInvalidAPIKeyExpiration = errors.New("api key expiration time must be in the future")
+	// ErrAPIKeyNotFound is returned when an API key is not found.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidAPIKey is returned when an unknown or revoked API key is provided.
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrExpiredAPIKey is returned when an expired API key is provided.
+	ErrExpiredAPIKey = errors.New("api key is expired")
+	// ErrOIDCNotConfigured is returned when logging in with an OpenID Connect provider, which has not been configured.
+	ErrOIDCNotConfigured = errors.New("oidc login is not configured")
+	// ErrInvalidOIDCState is returned when an unknown, used or expired OpenID Connect login state is provided.
+	ErrInvalidOIDCState = errors.New("invalid or expired oidc login state")
+	// ErrOIDCLoginFailed is returned when the OpenID Connect provider does not authenticate the user,
+	// e.g. the code cannot be exchanged or the ID token is invalid.
+	ErrOIDCLoginFailed = errors.New("oidc login failed")
+	// ErrOIDCEmailNotVerified is returned when the OpenID Connect provider has not verified the email address of a user,
+	// who is not linked to an account yet, so it cannot be trusted to link or create one.
+	ErrOIDCEmailNotVerified = errors.New("email address is not verified by the oidc provider")
+	// ErrOIDCAccountLinked is returned when the account with the email address of a user is linked to another OpenID Connect identity.
+	ErrOIDCAccountLinked = errors.New("account is linked to another oidc identity")
+	// ErrSessionNotFound is returned when a session is not found.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrPasswordHashingBusy is returned when a password cannot be hashed or checked now, because too many passwords are being hashed.
+	// It is wrapped in a RetryAfterError.
+	ErrPasswordHashingBusy = errors.New("too many passwords are being hashed, try again later")
+	// ErrAccountDisabled is returned when a user whose account has been disabled by an administrator tries to log in.
+	ErrAccountDisabled = errors.New("account is disabled")
+	// ErrInvalidRole is returned when a role is not one of the known roles.
+	ErrInvalidRole = errors.New("role must be one of reader, editor and admin")
+	// ErrInvalidPagination is returned when a page of users or books is requested with an invalid limit or offset.
+	ErrInvalidPagination = errors.New("limit must be between 1 and 100 and offset must not be negative")
+	// ErrOwnAccount is returned when an administrator tries to disable, demote or delete their own account.
+	ErrOwnAccount = errors.New("administrators cannot disable, demote or delete their own account")
+)
+
+// RetryAfterError wraps an error of an operation that has been refused for now, but can be retried after the given duration.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error returns the message of the wrapped error.
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// DefaultRefreshTokenDuration is the default duration for which a refresh token is valid.
+	DefaultRefreshTokenDuration = 7 * 24 * time.Hour
+	// DefaultRole is the default role assigned to newly registered users.
+	DefaultRole = models.RoleEditor
+	// DefaultEmailChangeTokenDuration is the default duration for which an email change token is valid.
+	DefaultEmailChangeTokenDuration = 24 * time.Hour
+	// DefaultPasswordResetTokenDuration is the default duration for which a password reset token is valid.
+	DefaultPasswordResetTokenDuration = time.Hour
+	// DefaultPasswordResetResendInterval is the default minimum interval between password reset emails sent to a user.
+	DefaultPasswordResetResendInterval = 5 * time.Minute
+	// DefaultEmailVerificationTokenDuration is the default duration for which an email verification token is valid.
+	DefaultEmailVerificationTokenDuration = 24 * time.Hour
+	// DefaultEmailVerificationResendInterval is the default minimum interval between verification emails sent to a user.
+	DefaultEmailVerificationResendInterval = 5 * time.Minute
+	// DefaultEmailVerificationURL is the default URL of the email verification endpoint put into verification emails.
+	DefaultEmailVerificationURL = "http://localhost:8080/verify-email"
+	// DefaultTwoFactorChallengeDuration is the default duration for which a two-factor authentication challenge token is valid.
+	DefaultTwoFactorChallengeDuration = 5 * time.Minute
+	// DefaultTwoFactorIssuer is the default issuer shown by authenticator apps next to TOTP codes.
+	DefaultTwoFactorIssuer = "BookRESTAPI"
+	// DefaultOIDCLoginDuration is the default duration within which a login with an OpenID Connect provider has to be completed.
+	DefaultOIDCLoginDuration = 10 * time.Minute
+	// DefaultUserPageLimit is the default number of users on a page.
+	DefaultUserPageLimit = 20
+	// MaxUserPageLimit is the maximum number of users on a page.
+	MaxUserPageLimit = 100
+
+	// refreshTokenSize is the number of random bytes a refresh token is generated from.
+	refreshTokenSize = 32
+	// refreshTokenFamilyIDSize is the number of random bytes a refresh token family ID is generated from.
+	refreshTokenFamilyIDSize = 16
+	// userTokenSize is the number of random bytes a user token is generated from.
+	userTokenSize = 32
+	// recoveryCodeCount is the number of recovery codes generated when enabling two-factor authentication.
+	recoveryCodeCount = 10
+	// recoveryCodeSize is the number of random bytes a recovery code is generated from.
+	recoveryCodeSize = 10
+	// apiKeyPrefix is a prefix of all API keys, which makes them recognizable, e.g. by secret scanners.
+	apiKeyPrefix = "bra_"
+	// apiKeySize is the number of random bytes an API key is generated from.
+	apiKeySize = 32
+	// apiKeyVisiblePrefixLength is the number of random characters of an API key stored in its prefix, which identifies it.
+	apiKeyVisiblePrefixLength = 8
+	// maxAPIKeyNameLength is the maximum number of characters of an API key name.
+	maxAPIKeyNameLength = 100
+	// apiKeyLastUsedPrecision is the precision the time of the last use of an API key is recorded with.
+	apiKeyLastUsedPrecision = time.Minute
+	// oidcStateSize is the number of random bytes an OpenID Connect login state is generated from.
+	oidcStateSize = 32
+	// oidcNonceSize is the number of random bytes an OpenID Connect nonce is generated from.
+	oidcNonceSize = 16
+	// maxNameLength is the maximum number of characters of the first and last name of a user.
+	maxNameLength = 50
+	// oidcNamePlaceholder is the first or last name of a user created with OpenID Connect whose name provided by the
+	// identity provider is missing or not valid.
+	oidcNamePlaceholder = "Unknown"
+	// maxUserAgentLength is the maximum number of characters of a user agent recorded in a session.
+	maxUserAgentLength = 512
+	// passwordHashingRetryAfter is the duration after which an operation refused because too many passwords are being hashed can be retried.
+	passwordHashingRetryAfter = time.Second
 )
 
 // UserService is an interface that defines the methods that the UserService must implement.
 type UserService interface {
-	RegisterUser(*dtos.AccountCreateDTO) (*dtos.UserDTO, error)
-	LoginUser(*dtos.UserLoginDTO) (*dtos.TokenDTO, error)
+	RegisterUser(context.Context, *dtos.AccountCreateDTO) (*dtos.UserDTO, error)
+	LoginUser(context.Context, *dtos.UserLoginDTO) (*dtos.TokenDTO, error)
+	RefreshToken(*dtos.RefreshTokenDTO) (*dtos.TokenDTO, error)
+	LogoutUser(int, string, int, *dtos.RefreshTokenDTO) error
+	LogoutUserEverywhere(int) error
+	GetUser(int) (*dtos.UserDTO, error)
+	UpdateUser(int, *dtos.UserUpdateDTO) (*dtos.UserDTO, error)
+	DeleteUser(int) error
+	ChangePassword(context.Context, int, *dtos.PasswordChangeDTO) error
+	RequestEmailChange(context.Context, int, *dtos.EmailChangeDTO) error
+	ConfirmEmailChange(*dtos.EmailChangeConfirmDTO) error
+	RequestPasswordReset(*dtos.PasswordForgotDTO) error
+	ResetPassword(context.Context, *dtos.PasswordResetDTO) error
+	VerifyEmail(string) error
+	ResendVerificationEmail(*dtos.VerificationResendDTO) error
+	LoginUserTwoFactor(*dtos.TwoFactorLoginDTO) (*dtos.TokenDTO, error)
+	EnrollTwoFactor(int) (*dtos.TwoFactorEnrollmentDTO, error)
+	ConfirmTwoFactor(int, *dtos.TwoFactorConfirmDTO) (*dtos.RecoveryCodesDTO, error)
+	DisableTwoFactor(context.Context, int, *dtos.TwoFactorDisableDTO) error
+	UnlockUser(int) error
+	CreateAPIKey(int, *dtos.APIKeyCreateDTO) (*dtos.APIKeyCreatedDTO, error)
+	GetAPIKeys(int) ([]*dtos.APIKeyDTO, error)
+	RevokeAPIKey(int, int) error
+	AuthenticateAPIKey(string) (*models.APIKey, models.Role, error)
+	StartOIDCLogin() (*dtos.OIDCLoginDTO, error)
+	LoginUserOIDC(context.Context, *dtos.OIDCCallbackDTO) (*dtos.TokenDTO, error)
+	GetSessions(int, int) ([]*dtos.SessionDTO, error)
+	TerminateSession(int, int) error
+	GetUsers(string, int, int) (*dtos.UserPageDTO, error)
+	DisableUser(int, int) error
+	EnableUser(int) error
+	ForcePasswordReset(int) error
+	ChangeUserRole(int, int, *dtos.UserRoleUpdateDTO) (*dtos.UserDTO, error)
+	DeleteUserByAdmin(int, int) error
 }
 
 // UserServiceImpl implements the UserService interface.
 type UserServiceImpl struct {
-	db           database.Database
-	tokenService TokenService
+	db                              database.Database
+	tokenService                    TokenService
+	refreshTokenDuration            time.Duration
+	defaultRole                     models.Role
+	mailer                          mailer.Mailer
+	emailChangeTokenDuration        time.Duration
+	passwordResetTokenDuration      time.Duration
+	passwordResetResendInterval     time.Duration
+	emailVerificationRequired       bool
+	emailVerificationURL            string
+	emailVerificationTokenDuration  time.Duration
+	emailVerificationResendInterval time.Duration
+	twoFactorChallengeDuration      time.Duration
+	twoFactorIssuer                 string
+	loginLimiter                    LoginLimiterService
+	oidcProvider                    *oidc.Provider
+	oidcLoginDuration               time.Duration
+	passwordHasher                  crypto.PasswordHasher
+	passwordPolicy                  PasswordPolicy
 }
 
 // NewUserService creates a new UserServiceImpl.
-func NewUserService(db database.Database, tokenService TokenService) *UserServiceImpl {
-	return &UserServiceImpl{
-		db:           db,
-		tokenService: tokenService,
+func NewUserService(db database.Database, tokenService TokenService, opts ...UserServiceOption) *UserServiceImpl {
+	userService := &UserServiceImpl{
+		db:                              db,
+		tokenService:                    tokenService,
+		refreshTokenDuration:            DefaultRefreshTokenDuration,
+		defaultRole:                     DefaultRole,
+		mailer:                          mailer.NewLogMailer(),
+		emailChangeTokenDuration:        DefaultEmailChangeTokenDuration,
+		passwordResetTokenDuration:      DefaultPasswordResetTokenDuration,
+		passwordResetResendInterval:     DefaultPasswordResetResendInterval,
+		emailVerificationURL:            DefaultEmailVerificationURL,
+		emailVerificationTokenDuration:  DefaultEmailVerificationTokenDuration,
+		emailVerificationResendInterval: DefaultEmailVerificationResendInterval,
+		twoFactorChallengeDuration:      DefaultTwoFactorChallengeDuration,
+		twoFactorIssuer:                 DefaultTwoFactorIssuer,
+		oidcLoginDuration:               DefaultOIDCLoginDuration,
+		passwordHasher:                  crypto.NewBcryptHasher(crypto.DefaultBcryptCost),
+		passwordPolicy:                  DefaultPasswordPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(userService)
+	}
+
+	return userService
+}
+
+// UserServiceOption is a function signature for providing options to configure the UserServiceImpl.
+type UserServiceOption func(*UserServiceImpl)
+
+// WithRefreshTokenDuration is an option to set the duration for which refresh tokens are valid.
+func WithRefreshTokenDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.refreshTokenDuration = duration
+		}
+	}
+}
+
+// WithDefaultRole is an option to set the role assigned to newly registered users.
+func WithDefaultRole(role models.Role) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if role.IsValid() {
+			us.defaultRole = role
+		}
+	}
+}
+
+// WithMailer is an option to set the mailer used to send emails to users.
+func WithMailer(m mailer.Mailer) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if m != nil {
+			us.mailer = m
+		}
+	}
+}
+
+// WithEmailChangeTokenDuration is an option to set the duration for which email change tokens are valid.
+func WithEmailChangeTokenDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.emailChangeTokenDuration = duration
+		}
+	}
+}
+
+// WithPasswordResetTokenDuration is an option to set the duration for which password reset tokens are valid.
+func WithPasswordResetTokenDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.passwordResetTokenDuration = duration
+		}
+	}
+}
+
+// WithEmailVerificationRequired is an option to refuse logging in users who have not verified the email address.
+func WithEmailVerificationRequired(required bool) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		us.emailVerificationRequired = required
+	}
+}
+
+// WithEmailVerificationURL is an option to set the URL of the email verification endpoint put into verification emails.
+// The verification token is added to it as the token query parameter.
+func WithEmailVerificationURL(verificationURL string) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if verificationURL != "" {
+			us.emailVerificationURL = verificationURL
+		}
+	}
+}
+
+// WithEmailVerificationTokenDuration is an option to set the duration for which email verification tokens are valid.
+func WithEmailVerificationTokenDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.emailVerificationTokenDuration = duration
+		}
+	}
+}
+
+// WithPasswordResetResendInterval is an option to set the minimum interval between password reset emails sent to a user.
+func WithPasswordResetResendInterval(interval time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if interval > 0 {
+			us.passwordResetResendInterval = interval
+		}
+	}
+}
+
+// WithEmailVerificationResendInterval is an option to set the minimum interval between verification emails sent to a user.
+func WithEmailVerificationResendInterval(interval time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if interval > 0 {
+			us.emailVerificationResendInterval = interval
+		}
+	}
+}
+
+// WithTwoFactorChallengeDuration is an option to set the duration for which two-factor authentication challenge tokens are valid.
+func WithTwoFactorChallengeDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.twoFactorChallengeDuration = duration
+		}
+	}
+}
+
+// WithTwoFactorIssuer is an option to set the issuer shown by authenticator apps next to TOTP codes.
+func WithTwoFactorIssuer(issuer string) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if issuer != "" {
+			us.twoFactorIssuer = issuer
+		}
+	}
+}
+
+// WithLoginLimiter is an option to set the limiter of failed login attempts. Without it, login attempts are not limited.
+func WithLoginLimiter(loginLimiter LoginLimiterService) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		us.loginLimiter = loginLimiter
+	}
+}
+
+// WithOIDCProvider is an option to set the OpenID Connect provider users can log in with. Without it, such login is not available.
+func WithOIDCProvider(provider *oidc.Provider) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		us.oidcProvider = provider
+	}
+}
+
+// WithOIDCLoginDuration is an option to set the duration within which a login with an OpenID Connect provider has to be completed.
+func WithOIDCLoginDuration(duration time.Duration) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if duration > 0 {
+			us.oidcLoginDuration = duration
+		}
+	}
+}
+
+// WithPasswordHasher is an option to set the password hasher new passwords are hashed with.
+// Passwords hashed with other algorithms or parameters are rehashed when their users log in.
+func WithPasswordHasher(hasher crypto.PasswordHasher) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		if hasher != nil {
+			us.passwordHasher = hasher
+		}
+	}
+}
+
+// WithPasswordPolicy is an option to set the policy passwords of users have to comply with.
+func WithPasswordPolicy(policy PasswordPolicy) UserServiceOption {
+	return func(us *UserServiceImpl) {
+		us.passwordPolicy = policy
 	}
 }
 
-// RegisterUser registers a user.
-func (us *UserServiceImpl) RegisterUser(dto *dtos.AccountCreateDTO) (*dtos.UserDTO, error) {
+// RegisterUser registers a user with the default role and sends a verification email to the user.
+func (us *UserServiceImpl) RegisterUser(ctx context.Context, dto *dtos.AccountCreateDTO) (*dtos.UserDTO, error) {
+	firstName, firstNameValid := us.normalizeName(dto.FirstName)
+	lastName, lastNameValid := us.normalizeName(dto.LastName)
+
 	if !us.validateEmail(dto.Email) {
 		return nil, ErrInvalidEmail
 	}
-	if !us.validatePassword(dto.Password) {
-		return nil, ErrInvalidPassword
+	if err := us.passwordPolicy.Check(dto.Password, dto.Email, firstName, lastName); err != nil {
+		return nil, err
 	}
-	if !us.validateFirstName(dto.FirstName) {
+	if !firstNameValid {
 		return nil, ErrInvalidFirstName
 	}
-	if !us.validateLastName(dto.LastName) {
+	if !lastNameValid {
 		return nil, ErrInvalidLastName
 	}
 	if !us.validateAge(int(dto.Age)) {
@@ -78,18 +452,21 @@ func (us *UserServiceImpl) RegisterUser(dto *dtos.AccountCreateDTO) (*dtos.UserD
 		return nil, ErrUserAlreadyExists
 	}
 
-	hashedPassword, err := crypto.HashPassword(dto.Password)
+	hashedPassword, err := us.hashPassword(ctx, dto.Password)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	id, err := us.db.InsertUser(&models.User{
-		CreatedAt: time.Now(),
-		Email:     dto.Email,
-		Password:  hashedPassword,
-		FirstName: dto.FirstName,
-		LastName:  dto.LastName,
-		Age:       int(dto.Age),
+		CreatedAt:          now,
+		Email:              dto.Email,
+		Password:           hashedPassword,
+		FirstName:          firstName,
+		LastName:           lastName,
+		Age:                int(dto.Age),
+		Role:               us.defaultRole,
+		VerificationSentAt: &now,
 	})
 	if err != nil {
 		return nil, err
@@ -100,19 +477,19 @@ func (us *UserServiceImpl) RegisterUser(dto *dtos.AccountCreateDTO) (*dtos.UserD
 		return nil, err
 	}
 
-	return &dtos.UserDTO{
-		ID:        int64(id),
-		CreatedAt: user.CreatedAt,
-		Email:     user.Email,
-		Password:  user.Password,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Age:       int64(user.Age),
-	}, nil
+	if err := us.sendVerificationEmail(user); err != nil {
+		// The user is already registered and can request the verification email again, so it must not fail the request.
+		logger.Errorf("Error (%s) while sending verification email to user with ID: %d", err, id)
+	}
+
+	return us.userDTO(user), nil
 }
 
 // LoginUser logs a user in and returns a token.
-func (us *UserServiceImpl) LoginUser(dto *dtos.UserLoginDTO) (*dtos.TokenDTO, error) {
+// If the user has two-factor authentication enabled, only a challenge token is returned,
+// which has to be exchanged for the tokens with LoginUserTwoFactor.
+// Failed attempts are limited by the login limiter, if set.
+func (us *UserServiceImpl) LoginUser(ctx context.Context, dto *dtos.UserLoginDTO) (*dtos.TokenDTO, error) {
 	if !us.validateEmail(dto.Email) {
 		return nil, ErrInvalidEmail
 	}
@@ -120,50 +497,1735 @@ func (us *UserServiceImpl) LoginUser(dto *dtos.UserLoginDTO) (*dtos.TokenDTO, er
 		return nil, ErrEmptyPassword
 	}
 
+	if err := us.reserveLoginAttempt(dto.Email, dto.ClientIP); err != nil {
+		return nil, err
+	}
+
 	user, err := us.db.SelectUserByEmail(dto.Email)
-	if err != nil || user == nil {
+	if err != nil {
+		us.releaseLoginAttempt(dto.Email, dto.ClientIP)
+		return nil, err
+	}
+	if user == nil {
+		// Failures are recorded for unknown accounts as well, so they cannot be told apart.
+		us.endLoginAttempt(dto.Email, dto.ClientIP, ErrInvalidCredentials)
 		return nil, ErrInvalidCredentials
 	}
 
-	if err := crypto.CheckPassword(dto.Password, user.Password); err != nil {
-		if errors.Is(err, crypto.ErrInvalidCredentials) {
-			return nil, ErrInvalidCredentials
+	if err := us.checkPassword(ctx, dto.Password, user.Password); err != nil {
+		us.endLoginAttempt(dto.Email, dto.ClientIP, err)
+		return nil, err
+	}
+
+	us.rehashPassword(ctx, user, dto.Password)
+
+	if user.DisabledAt != nil {
+		us.releaseLoginAttempt(dto.Email, dto.ClientIP)
+		return nil, ErrAccountDisabled
+	}
+
+	if us.emailVerificationRequired && user.VerifiedAt == nil {
+		us.releaseLoginAttempt(dto.Email, dto.ClientIP)
+		return nil, ErrEmailNotVerified
+	}
+
+	if user.TOTPEnabledAt != nil {
+		// Failed attempts are forgotten only once the second factor is provided as well.
+		us.releaseLoginAttempt(dto.Email, dto.ClientIP)
+		return us.issueChallengeToken(user)
+	}
+
+	if err := us.recordLoginSuccess(user.Email, dto.ClientIP); err != nil {
+		return nil, err
+	}
+
+	return us.startSession(user, dto.ClientIP, dto.UserAgent)
+}
+
+// RefreshToken rotates a refresh token and returns a new access token together with a new refresh token.
+// Presenting a refresh token that has already been rotated revokes the whole token family,
+// as it means the token has been stolen either by the presenting client or by the one that rotated it earlier.
+func (us *UserServiceImpl) RefreshToken(dto *dtos.RefreshTokenDTO) (*dtos.TokenDTO, error) {
+	if dto.RefreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	refreshToken, err := us.db.SelectRefreshTokenByHash(crypto.HashToken(dto.RefreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if refreshToken.RevokedAt != nil {
+		if err := us.endSession(refreshToken.FamilyID); err != nil {
+			return nil, err
 		}
 
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if time.Now().After(refreshToken.ExpiresAt) {
+		return nil, ErrExpiredRefreshToken
+	}
+
+	revoked, err := us.db.RevokeRefreshToken(refreshToken.ID)
+	if err != nil {
 		return nil, err
 	}
+	if !revoked {
+		// The token has been rotated concurrently, which is a reuse as well.
+		if err := us.endSession(refreshToken.FamilyID); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidRefreshToken
+	}
 
-	token, err := us.tokenService.GenerateToken(user.ID, user.Email)
+	user, err := us.db.SelectUserByID(refreshToken.UserID)
 	if err != nil {
 		return nil, err
 	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
 
-	return &dtos.TokenDTO{
-		Token: token,
-	}, nil
+	session, err := us.db.SelectSessionByFamilyID(refreshToken.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		// The family has been started before sessions have been tracked, so it becomes one now.
+		return us.insertSession(user, refreshToken.FamilyID, dto.ClientIP, dto.UserAgent)
+	}
+	if session.TerminatedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	session.LastUsedAt = now
+	session.IPAddress = dto.ClientIP
+	session.UserAgent = us.truncate(dto.UserAgent, maxUserAgentLength)
+	session.ExpiresAt = now.Add(us.refreshTokenDuration)
+
+	if err := us.db.UpdateSessionActivity(session.ID, session.LastUsedAt, session.IPAddress, session.UserAgent, session.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return us.issueTokens(user, session)
 }
 
-// validateEmail validates an email address.
-func (us *UserServiceImpl) validateEmail(email string) bool {
-	return regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,4}$`).MatchString(email)
+// LogoutUser logs a user out by revoking the given access token and terminating the session with the given ID it has been issued in,
+// which is 0 for tokens issued without a session. If a refresh token is provided, the session it belongs to is terminated as well.
+func (us *UserServiceImpl) LogoutUser(userID int, accessToken string, sessionID int, dto *dtos.RefreshTokenDTO) error {
+	var refreshToken *models.RefreshToken
+	if dto != nil && dto.RefreshToken != "" {
+		var err error
+		if refreshToken, err = us.db.SelectRefreshTokenByHash(crypto.HashToken(dto.RefreshToken)); err != nil {
+			return err
+		}
+		if refreshToken == nil || refreshToken.UserID != userID {
+			return ErrInvalidRefreshToken
+		}
+	}
+
+	if err := us.tokenService.RevokeToken(accessToken); err != nil {
+		return err
+	}
+
+	if sessionID != 0 {
+		session, err := us.db.SelectSessionByID(sessionID)
+		if err != nil {
+			return err
+		}
+		if session != nil && session.UserID == userID {
+			if err := us.endSession(session.FamilyID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if refreshToken == nil {
+		return nil
+	}
+
+	return us.endSession(refreshToken.FamilyID)
+}
+
+// LogoutUserEverywhere logs a user out of all devices by revoking all access and refresh tokens issued to the user
+// and terminating all sessions of the user.
+func (us *UserServiceImpl) LogoutUserEverywhere(userID int) error {
+	if err := us.tokenService.RevokeUserTokens(userID); err != nil {
+		return err
+	}
+
+	if err := us.db.RevokeUserRefreshTokens(userID); err != nil {
+		return err
+	}
+
+	return us.db.TerminateUserSessions(userID)
+}
+
+// GetUser returns the user with the given id.
+func (us *UserServiceImpl) GetUser(id int) (*dtos.UserDTO, error) {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	return us.userDTO(user), nil
+}
+
+// UpdateUser updates the first name, last name and age of the user with the given id.
+// Only the fields set in the dto are updated and they are validated the same way as during registration.
+func (us *UserServiceImpl) UpdateUser(id int, dto *dtos.UserUpdateDTO) (*dtos.UserDTO, error) {
+	var firstName, lastName string
+	if dto.FirstName != nil {
+		normalizedFirstName, valid := us.normalizeName(*dto.FirstName)
+		if !valid {
+			return nil, ErrInvalidFirstName
+		}
+		firstName = normalizedFirstName
+	}
+	if dto.LastName != nil {
+		normalizedLastName, valid := us.normalizeName(*dto.LastName)
+		if !valid {
+			return nil, ErrInvalidLastName
+		}
+		lastName = normalizedLastName
+	}
+	if dto.Age != nil && !us.validateAge(int(*dto.Age)) {
+		return nil, ErrInvalidAge
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	updatedUser := *user
+	if dto.FirstName != nil {
+		updatedUser.FirstName = firstName
+	}
+	if dto.LastName != nil {
+		updatedUser.LastName = lastName
+	}
+	if dto.Age != nil {
+		updatedUser.Age = int(*dto.Age)
+	}
+
+	if err := us.db.UpdateUser(id, &updatedUser); err != nil {
+		return nil, err
+	}
+
+	return us.userDTO(&updatedUser), nil
+}
+
+// DeleteUser deletes the user with the given id. The books the user has created are kept without a creator.
+// All tokens issued to the user are revoked, so they cannot be used anymore.
+func (us *UserServiceImpl) DeleteUser(id int) error {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := us.tokenService.RevokeUserTokens(id); err != nil {
+		return err
+	}
+
+	return us.db.DeleteUser(id)
 }
 
-// validatePassword validates a password for at least 6 characters, at least 1 uppercase letter, 1 lowercase letter, and 1 digit.
-func (us *UserServiceImpl) validatePassword(password string) bool {
-	return len(password) >= 6 &&
-		strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
-		strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") &&
-		strings.ContainsAny(password, "0123456789")
+// ChangePassword changes the password of the user with the given id.
+// The current password must be provided, failed attempts are limited by the login limiter, if set,
+// and the new one is validated the same way as during registration.
+// Pending password reset tokens are invalidated and all access and refresh tokens issued to the user are revoked afterwards.
+func (us *UserServiceImpl) ChangePassword(ctx context.Context, id int, dto *dtos.PasswordChangeDTO) error {
+	if dto.CurrentPassword == "" {
+		return ErrEmptyPassword
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := us.passwordPolicy.Check(dto.NewPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
+
+	if err := us.checkCurrentPassword(ctx, user, dto.CurrentPassword, dto.ClientIP); err != nil {
+		return err
+	}
+
+	hashedPassword, err := us.hashPassword(ctx, dto.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := us.db.UpdateUserPassword(id, hashedPassword); err != nil {
+		return err
+	}
+
+	if err := us.db.InvalidateUserTokens(id, models.UserTokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	return us.LogoutUserEverywhere(id)
 }
 
-// validateFirstName validates a name field for alphabetic characters and spaces with a minimum length.
-func (us *UserServiceImpl) validateFirstName(firstName string) bool {
-	return len(firstName) >= 2 && regexp.MustCompile(`^[a-zA-Z ]+$`).MatchString(firstName)
+// RequestEmailChange starts the change of the email address of the user with the given id.
+// The current password must be provided and failed attempts are limited by the login limiter, if set.
+// A single use token is sent to the new email address,
+// which has to be confirmed with ConfirmEmailChange. Tokens sent by previous requests are invalidated.
+func (us *UserServiceImpl) RequestEmailChange(ctx context.Context, id int, dto *dtos.EmailChangeDTO) error {
+	if !us.validateEmail(dto.NewEmail) {
+		return ErrInvalidEmail
+	}
+	if dto.Password == "" {
+		return ErrEmptyPassword
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := us.checkCurrentPassword(ctx, user, dto.Password, dto.ClientIP); err != nil {
+		return err
+	}
+
+	if existingUser, _ := us.db.SelectUserByEmail(dto.NewEmail); existingUser != nil {
+		return ErrUserAlreadyExists
+	}
+
+	if err := us.db.InvalidateUserTokens(id, models.UserTokenPurposeEmailChange); err != nil {
+		return err
+	}
+
+	emailChangeToken, err := crypto.GenerateRandomString(userTokenSize)
+	if err != nil {
+		return err
+	}
+
+	if _, err := us.db.InsertUserToken(&models.UserToken{
+		CreatedAt: time.Now(),
+		UserID:    id,
+		Purpose:   models.UserTokenPurposeEmailChange,
+		TokenHash: crypto.HashToken(emailChangeToken),
+		Payload:   dto.NewEmail,
+		ExpiresAt: time.Now().Add(us.emailChangeTokenDuration),
+	}); err != nil {
+		return err
+	}
+
+	return us.mailer.Send(&mailer.Message{
+		To:      dto.NewEmail,
+		Subject: "Confirm your new email address",
+		Body:    fmt.Sprintf("Use the following token to confirm your new email address: %s\nThe token expires in %s.", emailChangeToken, us.emailChangeTokenDuration),
+	})
 }
 
-// validateLastName validates a name field for alphabetic characters and spaces with a minimum length.
-func (us *UserServiceImpl) validateLastName(lastName string) bool {
-	return len(lastName) >= 2 && regexp.MustCompile(`^[a-zA-Z ]+$`).MatchString(lastName)
+// ConfirmEmailChange completes the change of the email address with the token sent by RequestEmailChange.
+// The new email address is verified by the token as well.
+// All access and refresh tokens issued to the user are revoked afterwards and the previous email address is notified.
+func (us *UserServiceImpl) ConfirmEmailChange(dto *dtos.EmailChangeConfirmDTO) error {
+	if dto.Token == "" {
+		return ErrInvalidEmailChangeToken
+	}
+
+	emailChangeToken, err := us.db.SelectUserTokenByHash(crypto.HashToken(dto.Token))
+	if err != nil {
+		return err
+	}
+	if emailChangeToken == nil || emailChangeToken.Purpose != models.UserTokenPurposeEmailChange ||
+		emailChangeToken.UsedAt != nil || time.Now().After(emailChangeToken.ExpiresAt) {
+		return ErrInvalidEmailChangeToken
+	}
+
+	used, err := us.db.UseUserToken(emailChangeToken.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidEmailChangeToken
+	}
+
+	user, err := us.db.SelectUserByID(emailChangeToken.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidEmailChangeToken
+	}
+
+	if existingUser, _ := us.db.SelectUserByEmail(emailChangeToken.Payload); existingUser != nil {
+		return ErrUserAlreadyExists
+	}
+
+	previousEmail := user.Email
+	if err := us.db.UpdateUserEmail(user.ID, emailChangeToken.Payload); err != nil {
+		return err
+	}
+
+	// The token has been sent to the new email address, so it verifies the address as well.
+	if err := us.db.InvalidateUserTokens(user.ID, models.UserTokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	if user.VerifiedAt == nil {
+		if err := us.db.UpdateUserVerifiedAt(user.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if err := us.LogoutUserEverywhere(user.ID); err != nil {
+		return err
+	}
+
+	if err := us.mailer.Send(&mailer.Message{
+		To:      previousEmail,
+		Subject: "Your email address has been changed",
+		Body:    fmt.Sprintf("The email address of your account has been changed to %s.", emailChangeToken.Payload),
+	}); err != nil {
+		// The change is already done, so failing to notify about it must not fail the request.
+		logger.Errorf("Error (%s) while notifying user with ID: %d about the email change", err, user.ID)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset sends a single use password reset token to the given email address.
+// Tokens sent by previous requests are invalidated. A password reset email is sent at most once per the resend interval.
+// To not reveal which email addresses are registered, it succeeds without sending anything if there is no user
+// with the given email address or an email has been sent to the user recently, and errors while sending the email are only logged.
+func (us *UserServiceImpl) RequestPasswordReset(dto *dtos.PasswordForgotDTO) error {
+	if !us.validateEmail(dto.Email) {
+		return ErrInvalidEmail
+	}
+
+	user, err := us.db.SelectUserByEmail(dto.Email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	now := time.Now()
+	sent, err := us.db.UpdateUserPasswordResetSentAt(user.ID, now, now.Add(-us.passwordResetResendInterval))
+	if err != nil {
+		logger.Errorf("Error (%s) while requesting password reset of user with ID: %d", err, user.ID)
+		return nil
+	}
+	if !sent {
+		return nil
+	}
+
+	if err := us.sendPasswordResetEmail(user, "If you have not requested a password reset, ignore this message."); err != nil {
+		logger.Errorf("Error (%s) while sending password reset email to user with ID: %d", err, user.ID)
+	}
+
+	return nil
+}
+
+// sendPasswordResetEmail sends a single use password reset token to the user, invalidating the ones sent before.
+// The note is appended to the message.
+func (us *UserServiceImpl) sendPasswordResetEmail(user *models.User, note string) error {
+	if err := us.db.InvalidateUserTokens(user.ID, models.UserTokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	passwordResetToken, err := crypto.GenerateRandomString(userTokenSize)
+	if err != nil {
+		return err
+	}
+
+	if _, err := us.db.InsertUserToken(&models.UserToken{
+		CreatedAt: time.Now(),
+		UserID:    user.ID,
+		Purpose:   models.UserTokenPurposePasswordReset,
+		TokenHash: crypto.HashToken(passwordResetToken),
+		ExpiresAt: time.Now().Add(us.passwordResetTokenDuration),
+	}); err != nil {
+		return err
+	}
+
+	return us.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use the following token to reset your password: %s\nThe token expires in %s. %s", passwordResetToken, us.passwordResetTokenDuration, note),
+	})
+}
+
+// ResetPassword sets a new password with the token sent by RequestPasswordReset.
+// The new password is validated the same way as during registration.
+// All access and refresh tokens issued to the user are revoked afterwards.
+func (us *UserServiceImpl) ResetPassword(ctx context.Context, dto *dtos.PasswordResetDTO) error {
+	if dto.Token == "" {
+		return ErrInvalidPasswordResetToken
+	}
+
+	passwordResetToken, err := us.db.SelectUserTokenByHash(crypto.HashToken(dto.Token))
+	if err != nil {
+		return err
+	}
+	if passwordResetToken == nil || passwordResetToken.Purpose != models.UserTokenPurposePasswordReset ||
+		passwordResetToken.UsedAt != nil || time.Now().After(passwordResetToken.ExpiresAt) {
+		return ErrInvalidPasswordResetToken
+	}
+
+	user, err := us.db.SelectUserByID(passwordResetToken.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidPasswordResetToken
+	}
+
+	// The password is checked and hashed before the token is used,
+	// so the token can be used again if the password is rejected or cannot be hashed at the moment.
+	if err := us.passwordPolicy.Check(dto.NewPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
+
+	hashedPassword, err := us.hashPassword(ctx, dto.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	used, err := us.db.UseUserToken(passwordResetToken.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidPasswordResetToken
+	}
+
+	if err := us.db.UpdateUserPassword(user.ID, hashedPassword); err != nil {
+		return err
+	}
+
+	return us.LogoutUserEverywhere(user.ID)
+}
+
+// VerifyEmail verifies the email address of a user with the token sent in the verification email.
+func (us *UserServiceImpl) VerifyEmail(token string) error {
+	if token == "" {
+		return ErrInvalidEmailVerificationToken
+	}
+
+	verificationToken, err := us.db.SelectUserTokenByHash(crypto.HashToken(token))
+	if err != nil {
+		return err
+	}
+	if verificationToken == nil || verificationToken.Purpose != models.UserTokenPurposeEmailVerification ||
+		verificationToken.UsedAt != nil || time.Now().After(verificationToken.ExpiresAt) {
+		return ErrInvalidEmailVerificationToken
+	}
+
+	used, err := us.db.UseUserToken(verificationToken.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidEmailVerificationToken
+	}
+
+	user, err := us.db.SelectUserByID(verificationToken.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidEmailVerificationToken
+	}
+
+	// Changing the email address invalidates pending tokens, so the token has been sent to the current one.
+	if user.VerifiedAt == nil {
+		if err := us.db.UpdateUserVerifiedAt(user.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return us.db.InvalidateUserTokens(user.ID, models.UserTokenPurposeEmailVerification)
+}
+
+// ResendVerificationEmail sends a new verification email to the given email address and invalidates tokens sent previously.
+// A verification email is sent at most once per the resend interval. To not reveal which email addresses are registered
+// and verified, it succeeds without sending anything if there is no user with the given email address, the user has already
+// verified it or an email has been sent to the user recently, and errors while sending the email are only logged.
+func (us *UserServiceImpl) ResendVerificationEmail(dto *dtos.VerificationResendDTO) error {
+	if !us.validateEmail(dto.Email) {
+		return ErrInvalidEmail
+	}
+
+	user, err := us.db.SelectUserByEmail(dto.Email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.VerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	sent, err := us.db.UpdateUserVerificationSentAt(user.ID, now, now.Add(-us.emailVerificationResendInterval))
+	if err != nil {
+		logger.Errorf("Error (%s) while requesting verification email of user with ID: %d", err, user.ID)
+		return nil
+	}
+	if !sent {
+		return nil
+	}
+
+	if err := us.sendVerificationEmail(user); err != nil {
+		logger.Errorf("Error (%s) while sending verification email to user with ID: %d", err, user.ID)
+	}
+
+	return nil
+}
+
+// sendVerificationEmail sends an email with a link verifying the email address to a user.
+// Tokens sent previously are invalidated.
+func (us *UserServiceImpl) sendVerificationEmail(user *models.User) error {
+	verificationURL, err := url.Parse(us.emailVerificationURL)
+	if err != nil {
+		return err
+	}
+
+	if err := us.db.InvalidateUserTokens(user.ID, models.UserTokenPurposeEmailVerification); err != nil {
+		return err
+	}
+
+	verificationToken, err := crypto.GenerateRandomString(userTokenSize)
+	if err != nil {
+		return err
+	}
+
+	if _, err := us.db.InsertUserToken(&models.UserToken{
+		CreatedAt: time.Now(),
+		UserID:    user.ID,
+		Purpose:   models.UserTokenPurposeEmailVerification,
+		TokenHash: crypto.HashToken(verificationToken),
+		ExpiresAt: time.Now().Add(us.emailVerificationTokenDuration),
+	}); err != nil {
+		return err
+	}
+
+	query := verificationURL.Query()
+	query.Set("token", verificationToken)
+	verificationURL.RawQuery = query.Encode()
+
+	return us.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Open the following link to verify your email address: %s\nThe link expires in %s.", verificationURL, us.emailVerificationTokenDuration),
+	})
+}
+
+// LoginUserTwoFactor completes the login of a user with two-factor authentication enabled.
+// It exchanges the challenge token returned by LoginUser together with a TOTP code or a recovery code for the tokens.
+// The challenge token can be used only once, also if the code is invalid.
+// It is not used if the login attempt is refused by the login limiter.
+func (us *UserServiceImpl) LoginUserTwoFactor(dto *dtos.TwoFactorLoginDTO) (*dtos.TokenDTO, error) {
+	if dto.ChallengeToken == "" {
+		return nil, ErrInvalidChallengeToken
+	}
+
+	challengeToken, err := us.db.SelectUserTokenByHash(crypto.HashToken(dto.ChallengeToken))
+	if err != nil {
+		return nil, err
+	}
+	if challengeToken == nil || challengeToken.Purpose != models.UserTokenPurposeTwoFactorChallenge ||
+		challengeToken.UsedAt != nil || time.Now().After(challengeToken.ExpiresAt) {
+		return nil, ErrInvalidChallengeToken
+	}
+
+	user, err := us.db.SelectUserByID(challengeToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.TOTPEnabledAt == nil {
+		return nil, ErrInvalidChallengeToken
+	}
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	// The login attempt is reserved before the challenge token is used,
+	// so a refused attempt does not burn the challenge.
+	if err := us.reserveLoginAttempt(user.Email, dto.ClientIP); err != nil {
+		return nil, err
+	}
+
+	used, err := us.db.UseUserToken(challengeToken.ID)
+	if err != nil {
+		us.releaseLoginAttempt(user.Email, dto.ClientIP)
+		return nil, err
+	}
+	if !used {
+		us.releaseLoginAttempt(user.Email, dto.ClientIP)
+		return nil, ErrInvalidChallengeToken
+	}
+
+	if err := us.checkSecondFactor(user, dto.Code); err != nil {
+		us.endLoginAttempt(user.Email, dto.ClientIP, err)
+		return nil, err
+	}
+
+	if err := us.recordLoginSuccess(user.Email, dto.ClientIP); err != nil {
+		return nil, err
+	}
+
+	return us.startSession(user, dto.ClientIP, dto.UserAgent)
+}
+
+// EnrollTwoFactor starts the enrollment of the user with the given id in two-factor authentication.
+// It generates a new TOTP secret, which is not used to log in until the enrollment is confirmed with ConfirmTwoFactor.
+func (us *UserServiceImpl) EnrollTwoFactor(id int) (*dtos.TwoFactorEnrollmentDTO, error) {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.db.UpdateUserTOTP(id, secret, nil); err != nil {
+		return nil, err
+	}
+
+	return &dtos.TwoFactorEnrollmentDTO{
+		Secret: secret,
+		URI:    totp.URI(secret, us.twoFactorIssuer, user.Email),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the user with the given id with a TOTP code
+// generated for the secret returned by EnrollTwoFactor. It returns recovery codes, which can be used
+// instead of TOTP codes, each only once. Only hashes of the recovery codes are stored, so they cannot be shown again.
+func (us *UserServiceImpl) ConfirmTwoFactor(id int, dto *dtos.TwoFactorConfirmDTO) (*dtos.RecoveryCodesDTO, error) {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, strings.TrimSpace(dto.Code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	recoveryCodeModels := make([]*models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := us.generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		recoveryCodeModels = append(recoveryCodeModels, &models.RecoveryCode{
+			CreatedAt: time.Now(),
+			UserID:    id,
+			CodeHash:  crypto.HashToken(us.normalizeRecoveryCode(recoveryCode)),
+		})
+	}
+
+	if err := us.db.ReplaceRecoveryCodes(id, recoveryCodeModels); err != nil {
+		return nil, err
+	}
+
+	enabledAt := time.Now()
+	if err := us.db.UpdateUserTOTP(id, user.TOTPSecret, &enabledAt); err != nil {
+		return nil, err
+	}
+
+	// The code used to confirm the enrollment cannot be used to log in.
+	if _, err := us.db.UpdateUserTOTPLastUsedStep(id, step); err != nil {
+		return nil, err
+	}
+
+	return &dtos.RecoveryCodesDTO{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the user with the given id and removes the recovery codes.
+// The current password and a TOTP code or a recovery code must be provided and failed attempts are limited by the login limiter, if set.
+func (us *UserServiceImpl) DisableTwoFactor(ctx context.Context, id int, dto *dtos.TwoFactorDisableDTO) error {
+	if dto.Password == "" {
+		return ErrEmptyPassword
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.TOTPEnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := us.reserveLoginAttempt(user.Email, dto.ClientIP); err != nil {
+		return err
+	}
+
+	if err := us.checkPassword(ctx, dto.Password, user.Password); err != nil {
+		us.endLoginAttempt(user.Email, dto.ClientIP, err)
+		return err
+	}
+
+	if err := us.checkSecondFactor(user, dto.Code); err != nil {
+		us.endLoginAttempt(user.Email, dto.ClientIP, err)
+		return err
+	}
+
+	if err := us.recordLoginSuccess(user.Email, dto.ClientIP); err != nil {
+		return err
+	}
+
+	if err := us.db.UpdateUserTOTP(id, "", nil); err != nil {
+		return err
+	}
+
+	return us.db.ReplaceRecoveryCodes(id, nil)
+}
+
+// UnlockUser unlocks the account of the user with the given id locked after too many failed login attempts.
+func (us *UserServiceImpl) UnlockUser(id int) error {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if us.loginLimiter == nil {
+		return nil
+	}
+
+	return us.loginLimiter.Unlock(user.Email)
+}
+
+// GetUsers returns a page of at most limit users, skipping the first offset ones, ordered by ID.
+// If the query is not empty, only users whose email address, first name or last name contains it, ignoring case, are returned.
+func (us *UserServiceImpl) GetUsers(query string, limit, offset int) (*dtos.UserPageDTO, error) {
+	if limit < 1 || limit > MaxUserPageLimit || offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	query = strings.TrimSpace(query)
+
+	users, err := us.db.SelectUsers(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := us.db.CountUsers(query)
+	if err != nil {
+		return nil, err
+	}
+
+	userDTOs := make([]*dtos.UserDTO, 0, len(users))
+	for _, user := range users {
+		userDTOs = append(userDTOs, us.userDTO(user))
+	}
+
+	return &dtos.UserPageDTO{
+		Users:  userDTOs,
+		Total:  int64(total),
+		Limit:  int64(limit),
+		Offset: int64(offset),
+	}, nil
+}
+
+// DisableUser disables the account of the user with the given id on behalf of the administrator with the given admin id.
+// The user is logged out of all devices and cannot log in, refresh tokens or use API keys until the account is enabled again.
+func (us *UserServiceImpl) DisableUser(adminID, id int) error {
+	if adminID == id {
+		return ErrOwnAccount
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.DisabledAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := us.db.UpdateUserDisabledAt(id, &now); err != nil {
+		return err
+	}
+
+	return us.LogoutUserEverywhere(id)
+}
+
+// EnableUser enables the account of the user with the given id disabled by DisableUser.
+func (us *UserServiceImpl) EnableUser(id int) error {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	return us.db.UpdateUserDisabledAt(id, nil)
+}
+
+// ForcePasswordReset forces the user with the given id to reset the password.
+// The current password is removed, so it cannot be used to log in anymore, the user is logged out of all devices
+// and a password reset token is sent to the email address of the user.
+func (us *UserServiceImpl) ForcePasswordReset(id int) error {
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := us.db.UpdateUserPassword(id, ""); err != nil {
+		return err
+	}
+
+	if err := us.LogoutUserEverywhere(id); err != nil {
+		return err
+	}
+
+	return us.sendPasswordResetEmail(user, "An administrator has required you to reset your password, so you cannot log in with the current one anymore.")
+}
+
+// ChangeUserRole changes the role of the user with the given id on behalf of the administrator with the given admin id.
+// The user is logged out of all devices, as the role is included in the access tokens issued to the user.
+func (us *UserServiceImpl) ChangeUserRole(adminID, id int, dto *dtos.UserRoleUpdateDTO) (*dtos.UserDTO, error) {
+	role := models.Role(dto.Role)
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+
+	user, err := us.db.SelectUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if role == user.Role {
+		return us.userDTO(user), nil
+	}
+	if adminID == id {
+		return nil, ErrOwnAccount
+	}
+
+	updatedUser := *user
+	updatedUser.Role = role
+
+	if err := us.db.UpdateUserRole(id, role); err != nil {
+		return nil, err
+	}
+
+	if err := us.LogoutUserEverywhere(id); err != nil {
+		return nil, err
+	}
+
+	return us.userDTO(&updatedUser), nil
+}
+
+// DeleteUserByAdmin deletes the user with the given id on behalf of the administrator with the given admin id, the same as DeleteUser.
+func (us *UserServiceImpl) DeleteUserByAdmin(adminID, id int) error {
+	if adminID == id {
+		return ErrOwnAccount
+	}
+
+	return us.DeleteUser(id)
+}
+
+// CreateAPIKey creates an API key for the user with the given id. The scopes must be permissions granted to the role of the user.
+// The returned key cannot be shown again, as only its hash is stored.
+func (us *UserServiceImpl) CreateAPIKey(userID int, dto *dtos.APIKeyCreateDTO) (*dtos.APIKeyCreatedDTO, error) {
+	name := strings.TrimSpace(dto.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		return nil, ErrInvalidAPIKeyName
+	}
+	if dto.ExpiresAt != nil && !dto.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidAPIKeyExpiration
+	}
+
+	user, err := us.db.SelectUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	if len(dto.Scopes) == 0 {
+		return nil, ErrInvalidAPIKeyScopes
+	}
+
+	scopes := make([]models.Permission, 0, len(dto.Scopes))
+	for _, scope := range dto.Scopes {
+		permission := models.Permission(scope)
+		if !permission.IsValid() || !user.Role.HasPermission(permission) {
+			return nil, ErrInvalidAPIKeyScopes
+		}
+		if !slices.Contains(scopes, permission) {
+			scopes = append(scopes, permission)
+		}
+	}
+
+	randomPart, err := crypto.GenerateRandomString(apiKeySize)
+	if err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + randomPart
+
+	apiKey := &models.APIKey{
+		CreatedAt: time.Now(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:len(apiKeyPrefix)+apiKeyVisiblePrefixLength],
+		KeyHash:   crypto.HashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: dto.ExpiresAt,
+	}
+
+	id, err := us.db.InsertAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.ID = id
+
+	return &dtos.APIKeyCreatedDTO{
+		APIKeyDTO: *us.apiKeyDTO(apiKey),
+		Key:       key,
+	}, nil
+}
+
+// GetAPIKeys returns the API keys of the user with the given id, which have not been revoked.
+func (us *UserServiceImpl) GetAPIKeys(userID int) ([]*dtos.APIKeyDTO, error) {
+	apiKeys, err := us.db.SelectAPIKeysByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeyDTOs := make([]*dtos.APIKeyDTO, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		apiKeyDTOs = append(apiKeyDTOs, us.apiKeyDTO(apiKey))
+	}
+
+	return apiKeyDTOs, nil
+}
+
+// RevokeAPIKey revokes the API key with the given id of the user with the given id.
+func (us *UserServiceImpl) RevokeAPIKey(userID, id int) error {
+	revoked, err := us.db.RevokeAPIKey(id, userID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the API key matching the given key together with the current role of its owner.
+// The key grants only the permissions in its scopes which are also granted by the role.
+func (us *UserServiceImpl) AuthenticateAPIKey(key string) (*models.APIKey, models.Role, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, "", ErrInvalidAPIKey
+	}
+
+	apiKey, err := us.db.SelectAPIKeyByHash(crypto.HashToken(key))
+	if err != nil {
+		return nil, "", err
+	}
+	if apiKey == nil || apiKey.RevokedAt != nil {
+		return nil, "", ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt) {
+		return nil, "", ErrExpiredAPIKey
+	}
+
+	user, err := us.db.SelectUserByID(apiKey.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", ErrInvalidAPIKey
+	}
+	if user.DisabledAt != nil {
+		return nil, "", ErrAccountDisabled
+	}
+
+	// The time of the last use is recorded with a limited precision, so not every request results in a write.
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedPrecision {
+		if err := us.db.UpdateAPIKeyLastUsedAt(apiKey.ID, now); err != nil {
+			return nil, "", err
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey, user.Role, nil
+}
+
+// GetSessions returns the sessions of the user with the given id, which have been neither terminated nor expired.
+// The session with the given current session id is marked as the current one.
+func (us *UserServiceImpl) GetSessions(userID, currentSessionID int) ([]*dtos.SessionDTO, error) {
+	sessions, err := us.db.SelectSessionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	sessionDTOs := []*dtos.SessionDTO{}
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+
+		sessionDTOs = append(sessionDTOs, &dtos.SessionDTO{
+			ID:         int64(session.ID),
+			CreatedAt:  session.CreatedAt,
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+
+	return sessionDTOs, nil
+}
+
+// TerminateSession terminates the session with the given id of the user with the given id.
+// Its refresh tokens are revoked and access tokens issued in it are no longer accepted.
+func (us *UserServiceImpl) TerminateSession(userID, id int) error {
+	session, err := us.db.SelectSessionByID(id)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserID != userID || session.TerminatedAt != nil {
+		return ErrSessionNotFound
+	}
+
+	return us.endSession(session.FamilyID)
+}
+
+// StartOIDCLogin starts a login with the OpenID Connect provider.
+// It returns the URL of the authorization endpoint of the provider and the state, which identifies the login
+// when the provider redirects back. The login has to be completed with LoginUserOIDC within the login duration.
+func (us *UserServiceImpl) StartOIDCLogin() (*dtos.OIDCLoginDTO, error) {
+	if us.oidcProvider == nil {
+		return nil, ErrOIDCNotConfigured
+	}
+
+	if _, err := us.db.DeleteExpiredOIDCLoginRequests(time.Now()); err != nil {
+		// Expired requests cannot be completed anyway, so failing to delete them must not fail the request.
+		logger.Errorf("Error (%s) while deleting expired OIDC login requests", err)
+	}
+
+	state, err := crypto.GenerateRandomString(oidcStateSize)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := crypto.GenerateRandomString(oidcNonceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := us.db.InsertOIDCLoginRequest(&models.OIDCLoginRequest{
+		CreatedAt:    time.Now(),
+		StateHash:    crypto.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(us.oidcLoginDuration),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dtos.OIDCLoginDTO{
+		URL:   us.oidcProvider.AuthCodeURL(state, nonce, verifier),
+		State: state,
+	}, nil
+}
+
+// LoginUserOIDC completes a login started by StartOIDCLogin with the code the OpenID Connect provider has redirected back with.
+// The user is found by the identity at the provider. Otherwise, the identity is linked to the account with the same email address,
+// or a new account is created with the default role, but only if the provider has verified the email address.
+// If the user has two-factor authentication enabled, only a challenge token is returned, the same as by LoginUser.
+// Each state can be used only once, also if the login fails. The requests to the provider are canceled when the given context is done.
+func (us *UserServiceImpl) LoginUserOIDC(ctx context.Context, dto *dtos.OIDCCallbackDTO) (*dtos.TokenDTO, error) {
+	if us.oidcProvider == nil {
+		return nil, ErrOIDCNotConfigured
+	}
+	if dto.State == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	request, err := us.db.TakeOIDCLoginRequest(crypto.HashToken(dto.State))
+	if err != nil {
+		return nil, err
+	}
+	if request == nil || time.Now().After(request.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	if dto.Code == "" {
+		return nil, ErrOIDCLoginFailed
+	}
+
+	tokenResponse, err := us.oidcProvider.Exchange(ctx, dto.Code, request.CodeVerifier)
+	if err != nil {
+		logger.Errorf("Error (%s) while exchanging OIDC code from %s", err, dto.ClientIP)
+
+		return nil, ErrOIDCLoginFailed
+	}
+
+	claims, err := us.oidcProvider.VerifyIDToken(ctx, tokenResponse.IDToken, request.Nonce)
+	if err != nil {
+		logger.Errorf("Error (%s) while verifying OIDC ID token from %s", err, dto.ClientIP)
+
+		return nil, ErrOIDCLoginFailed
+	}
+
+	user, err := us.oidcUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	if us.emailVerificationRequired && user.VerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return us.issueChallengeToken(user)
+	}
+
+	return us.startSession(user, dto.ClientIP, dto.UserAgent)
+}
+
+// oidcUser returns the user linked to the OpenID Connect identity with the given claims.
+// If there is no such user yet, the identity is linked to the account with the verified email address or a new account is created.
+func (us *UserServiceImpl) oidcUser(claims *oidc.Claims) (*models.User, error) {
+	user, err := us.db.SelectUserByOIDCIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	if !us.validateEmail(claims.Email) {
+		logger.Errorf("OIDC identity %s has no valid email address", claims.Subject)
+
+		return nil, ErrOIDCLoginFailed
+	}
+	if !claims.EmailVerified {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	user, err = us.db.SelectUserByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		if user.OIDCSubject != "" {
+			return nil, ErrOIDCAccountLinked
+		}
+
+		if err := us.db.UpdateUserOIDCIdentity(user.ID, claims.Issuer, claims.Subject); err != nil {
+			return nil, err
+		}
+
+		if user.VerifiedAt == nil {
+			// The provider has verified that the user owns the email address.
+			if err := us.db.UpdateUserVerifiedAt(user.ID, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+
+		logger.Infof("Linked OIDC identity %s to user with ID: %d", claims.Subject, user.ID)
+
+		return us.db.SelectUserByID(user.ID)
+	}
+
+	firstName, lastName := us.oidcNames(claims)
+
+	// The user has no password, so logging in with the password is not possible until one is set with a password reset.
+	// The age is not provided by the identity provider, so it is left unknown until the user updates it.
+	now := time.Now()
+	id, err := us.db.InsertUser(&models.User{
+		CreatedAt:   now,
+		Email:       claims.Email,
+		FirstName:   firstName,
+		LastName:    lastName,
+		Role:        us.defaultRole,
+		VerifiedAt:  &now,
+		OIDCIssuer:  claims.Issuer,
+		OIDCSubject: claims.Subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Created user with ID: %d for OIDC identity %s", id, claims.Subject)
+
+	return us.db.SelectUserByID(id)
+}
+
+// oidcNames returns the first and last name of a user from the claims of the OpenID Connect identity.
+// The full name is split if the given and family names are not provided. The names are normalized and validated
+// like the names given at registration, and a name which is not valid is replaced with oidcNamePlaceholder.
+func (us *UserServiceImpl) oidcNames(claims *oidc.Claims) (string, string) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if strings.TrimSpace(firstName) == "" && strings.TrimSpace(lastName) == "" {
+		firstName, lastName, _ = strings.Cut(strings.Join(strings.Fields(claims.Name), " "), " ")
+	}
+
+	return us.oidcName(firstName), us.oidcName(lastName)
+}
+
+// oidcName normalizes and validates a name from the claims of an OpenID Connect identity. A name too long is truncated
+// to maxNameLength characters, and oidcNamePlaceholder is returned if the name is not valid.
+func (us *UserServiceImpl) oidcName(name string) string {
+	name = strings.Join(strings.Fields(norm.NFC.String(name)), " ")
+	name = strings.TrimRight(us.truncate(name, maxNameLength), " -'’")
+
+	name, ok := us.normalizeName(name)
+	if !ok {
+		return oidcNamePlaceholder
+	}
+
+	return name
+}
+
+// truncate trims a string and truncates it to the given maximum number of characters.
+func (us *UserServiceImpl) truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+// reserveLoginAttempt reserves a login attempt with the login limiter, if set, which has to be ended by endLoginAttempt,
+// releaseLoginAttempt or recordLoginSuccess.
+func (us *UserServiceImpl) reserveLoginAttempt(email, clientIP string) error {
+	if us.loginLimiter == nil {
+		return nil
+	}
+
+	return us.loginLimiter.Reserve(email, clientIP)
+}
+
+// releaseLoginAttempt releases a login attempt which has not failed with the login limiter, if set.
+// The login does not fail if it cannot be released, as the reservation is considered abandoned after a while then.
+func (us *UserServiceImpl) releaseLoginAttempt(email, clientIP string) {
+	if us.loginLimiter == nil {
+		return
+	}
+
+	if err := us.loginLimiter.Release(email, clientIP); err != nil {
+		logger.Errorf("Error (%s) while releasing login attempt to account: %s", err, email)
+	}
+}
+
+// endLoginAttempt ends a reserved login attempt which has not succeeded with the given error with the login limiter, if set.
+// It is recorded as failed if the credentials are invalid and released otherwise.
+// The login does not fail if it cannot be recorded, as the error is returned anyway.
+func (us *UserServiceImpl) endLoginAttempt(email, clientIP string, err error) {
+	if us.loginLimiter == nil {
+		return
+	}
+
+	if !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrInvalidTwoFactorCode) {
+		us.releaseLoginAttempt(email, clientIP)
+		return
+	}
+
+	if err := us.loginLimiter.RecordFailure(email, clientIP); err != nil {
+		logger.Errorf("Error (%s) while recording failed login attempt to account: %s", err, email)
+	}
+}
+
+// recordLoginSuccess records a successful login with the login limiter, if set.
+func (us *UserServiceImpl) recordLoginSuccess(email, clientIP string) error {
+	if us.loginLimiter == nil {
+		return nil
+	}
+
+	return us.loginLimiter.RecordSuccess(email, clientIP)
+}
+
+// issueChallengeToken generates a challenge token for a user who has provided a valid password,
+// but has to provide the second factor as well to log in.
+func (us *UserServiceImpl) issueChallengeToken(user *models.User) (*dtos.TokenDTO, error) {
+	challengeToken, err := crypto.GenerateRandomString(userTokenSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := us.db.InsertUserToken(&models.UserToken{
+		CreatedAt: time.Now(),
+		UserID:    user.ID,
+		Purpose:   models.UserTokenPurposeTwoFactorChallenge,
+		TokenHash: crypto.HashToken(challengeToken),
+		ExpiresAt: time.Now().Add(us.twoFactorChallengeDuration),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dtos.TokenDTO{
+		TwoFactorRequired: true,
+		ChallengeToken:    challengeToken,
+	}, nil
+}
+
+// checkSecondFactor checks a TOTP code or a recovery code of a user with two-factor authentication enabled.
+// Each TOTP code and recovery code is accepted only once.
+func (us *UserServiceImpl) checkSecondFactor(user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		updated, err := us.db.UpdateUserTOTPLastUsedStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrInvalidTwoFactorCode
+		}
+
+		return nil
+	}
+
+	used, err := us.db.UseRecoveryCode(user.ID, crypto.HashToken(us.normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// generateRecoveryCode generates a random recovery code formatted as groups of 4 characters, such as abcd-efgh-ijkl-mnop.
+func (us *UserServiceImpl) generateRecoveryCode() (string, error) {
+	bytes := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bytes))
+
+	groups := []string{}
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:min(i+4, len(code))])
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeRecoveryCode removes separators and the case from a recovery code, so it is accepted however it is typed.
+func (us *UserServiceImpl) normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkPassword checks the given password against the password hash of a user.
+// Users who have been created by logging in with an OpenID Connect provider have no password, so no password matches.
+func (us *UserServiceImpl) checkPassword(ctx context.Context, password, hash string) error {
+	if hash == "" {
+		return ErrInvalidCredentials
+	}
+
+	if err := us.passwordHasher.Check(ctx, password, hash); err != nil {
+		if errors.Is(err, crypto.ErrInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		if errors.Is(err, crypto.ErrHashingPoolSaturated) {
+			return &RetryAfterError{Err: ErrPasswordHashingBusy, RetryAfter: passwordHashingRetryAfter}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkCurrentPassword checks the password of a logged in user before a change of the account.
+// Failed attempts are limited by the login limiter, if set, like failed logins, so a stolen access token does not allow guessing the password.
+func (us *UserServiceImpl) checkCurrentPassword(ctx context.Context, user *models.User, password, clientIP string) error {
+	if err := us.reserveLoginAttempt(user.Email, clientIP); err != nil {
+		return err
+	}
+
+	if err := us.checkPassword(ctx, password, user.Password); err != nil {
+		us.endLoginAttempt(user.Email, clientIP, err)
+		return err
+	}
+
+	return us.recordLoginSuccess(user.Email, clientIP)
+}
+
+// hashPassword hashes the given password with the password hasher.
+// A password too long for the hasher violates the password policy.
+func (us *UserServiceImpl) hashPassword(ctx context.Context, password string) (string, error) {
+	hashedPassword, err := us.passwordHasher.Hash(ctx, password)
+	if err != nil {
+		if errors.Is(err, crypto.ErrHashingPoolSaturated) {
+			return "", &RetryAfterError{Err: ErrPasswordHashingBusy, RetryAfter: passwordHashingRetryAfter}
+		}
+		if errors.Is(err, crypto.ErrPasswordTooLong) {
+			return "", &PasswordPolicyError{Violations: []string{"is too long"}}
+		}
+
+		return "", err
+	}
+
+	return hashedPassword, nil
+}
+
+// rehashPassword rehashes the password of a user who has just provided it, if the hash is not up to date with the password hasher.
+// The login does not fail if the password cannot be rehashed, as the old hash is still valid.
+func (us *UserServiceImpl) rehashPassword(ctx context.Context, user *models.User, password string) {
+	if !us.passwordHasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := us.hashPassword(ctx, password)
+	if err != nil {
+		logger.Errorf("Error (%s) while rehashing password of user with ID: %d", err, user.ID)
+		return
+	}
+
+	if err := us.db.UpdateUserPassword(user.ID, hashedPassword); err != nil {
+		logger.Errorf("Error (%s) while updating rehashed password of user with ID: %d", err, user.ID)
+		return
+	}
+
+	user.Password = hashedPassword
+}
+
+// userDTO converts a user to a UserDTO without the password.
+func (us *UserServiceImpl) userDTO(user *models.User) *dtos.UserDTO {
+	return &dtos.UserDTO{
+		ID:               int64(user.ID),
+		CreatedAt:        user.CreatedAt,
+		Email:            user.Email,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		Age:              int64(user.Age),
+		Role:             string(user.Role),
+		VerifiedAt:       user.VerifiedAt,
+		TwoFactorEnabled: user.TOTPEnabledAt != nil,
+		DisabledAt:       user.DisabledAt,
+	}
+}
+
+// apiKeyDTO converts an API key model to a DTO.
+func (us *UserServiceImpl) apiKeyDTO(apiKey *models.APIKey) *dtos.APIKeyDTO {
+	scopes := make([]string, 0, len(apiKey.Scopes))
+	for _, scope := range apiKey.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	return &dtos.APIKeyDTO{
+		ID:         int64(apiKey.ID),
+		CreatedAt:  apiKey.CreatedAt,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     scopes,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+	}
+}
+
+// startSession starts a new session of a user, who has logged in from the client with the given IP address and user agent,
+// and issues the tokens in it.
+func (us *UserServiceImpl) startSession(user *models.User, clientIP, userAgent string) (*dtos.TokenDTO, error) {
+	familyID, err := crypto.GenerateRandomString(refreshTokenFamilyIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return us.insertSession(user, familyID, clientIP, userAgent)
+}
+
+// insertSession inserts a session of a user backed by the refresh token family with the given ID and issues the tokens in it.
+func (us *UserServiceImpl) insertSession(user *models.User, familyID, clientIP, userAgent string) (*dtos.TokenDTO, error) {
+	now := time.Now()
+	session := &models.Session{
+		CreatedAt:  now,
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		IPAddress:  clientIP,
+		UserAgent:  us.truncate(userAgent, maxUserAgentLength),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(us.refreshTokenDuration),
+	}
+
+	id, err := us.db.InsertSession(session)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = id
+
+	return us.issueTokens(user, session)
+}
+
+// endSession revokes the refresh tokens of the given family and terminates the session backed by it, if any.
+func (us *UserServiceImpl) endSession(familyID string) error {
+	if err := us.db.RevokeRefreshTokenFamily(familyID); err != nil {
+		return err
+	}
+
+	session, err := us.db.SelectSessionByFamilyID(familyID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return nil
+	}
+
+	_, err = us.db.TerminateSession(session.ID, session.UserID)
+
+	return err
+}
+
+// issueTokens generates an access token and a refresh token in the given session for a user.
+func (us *UserServiceImpl) issueTokens(user *models.User, session *models.Session) (*dtos.TokenDTO, error) {
+	token, err := us.tokenService.GenerateToken(user.ID, user.Email, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := crypto.GenerateRandomString(refreshTokenSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := us.db.InsertRefreshToken(&models.RefreshToken{
+		CreatedAt: time.Now(),
+		UserID:    user.ID,
+		FamilyID:  session.FamilyID,
+		TokenHash: crypto.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(us.refreshTokenDuration),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dtos.TokenDTO{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// validateEmail validates an email address.
+func (us *UserServiceImpl) validateEmail(email string) bool {
+	return regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,4}$`).MatchString(email)
+}
+
+// normalizeName normalizes a first or last name and validates it.
+// The name is normalized to NFC, trimmed, and runs of whitespace are replaced with single spaces.
+// A valid name has from 2 to 50 characters, and consists of words of letters of any script, possibly with combining marks,
+// separated by single spaces, hyphens or apostrophes, e.g. "Łukasz", "José", "O'Brien-Smith" or "María José".
+func (us *UserServiceImpl) normalizeName(name string) (string, bool) {
+	name = strings.Join(strings.Fields(norm.NFC.String(name)), " ")
+
+	length := utf8.RuneCountInString(name)
+	if length < 2 || length > maxNameLength {
+		return name, false
+	}
+
+	previous := ' '
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r):
+		case unicode.Is(unicode.M, r):
+			// Combining marks, e.g. vowel signs of Indic scripts, follow letters.
+			if !unicode.IsLetter(previous) && !unicode.Is(unicode.M, previous) {
+				return name, false
+			}
+		case r == ' ' || r == '-' || r == '\'' || r == '’':
+			// Separators join words, so they must follow a letter or a mark.
+			if !unicode.IsLetter(previous) && !unicode.Is(unicode.M, previous) {
+				return name, false
+			}
+		default:
+			return name, false
+		}
+
+		previous = r
+	}
+
+	if !unicode.IsLetter(previous) && !unicode.Is(unicode.M, previous) {
+		return name, false
+	}
+
+	return name, true
 }
 
 // validateAge validates an age to be between 18 and 120.
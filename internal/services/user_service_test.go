@@ -1,19 +1,30 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/MSSkowron/BookRESTAPI/internal/database"
 	"github.com/MSSkowron/BookRESTAPI/internal/dtos"
+	"github.com/MSSkowron/BookRESTAPI/internal/models"
 	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
+	"github.com/MSSkowron/BookRESTAPI/pkg/mailer"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc"
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc/oidctest"
+	"github.com/MSSkowron/BookRESTAPI/pkg/token"
+	"github.com/MSSkowron/BookRESTAPI/pkg/totp"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestRegisterUser(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	ts := NewTokenService("", 0)
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing(""), 0)
 	us := NewUserService(mockDB, ts)
 
 	hashedPassword, _ := crypto.HashPassword("Password1")
@@ -46,6 +57,7 @@ func TestRegisterUser(t *testing.T) {
 					FirstName: "John",
 					LastName:  "Doe",
 					Age:       20,
+					Role:      "editor",
 				},
 				err: nil,
 			},
@@ -156,20 +168,26 @@ func TestRegisterUser(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			user, err := us.RegisterUser(d.input)
+			user, err := us.RegisterUser(context.Background(), d.input)
 			if d.expected.user != nil {
 				require.NotNil(t, user)
 				require.Equal(t, d.expected.user.ID, user.ID)
 				require.LessOrEqual(t, user.CreatedAt, time.Now())
 				require.Equal(t, d.expected.user.Email, user.Email)
-				require.Nil(t, crypto.CheckPassword("Password1", user.Password))
+				require.Empty(t, user.Password)
+
+				storedUser, err := mockDB.SelectUserByID(int(user.ID))
+				require.NoError(t, err)
+				require.Nil(t, crypto.CheckPassword("Password1", storedUser.Password))
+
 				require.Equal(t, d.expected.user.FirstName, user.FirstName)
 				require.Equal(t, d.expected.user.LastName, user.LastName)
 				require.Equal(t, d.expected.user.Age, user.Age)
+				require.Equal(t, d.expected.user.Role, user.Role)
 			} else {
 				require.Nil(t, user)
 			}
-			require.Equal(t, d.expected.err, err)
+			require.ErrorIs(t, err, d.expected.err)
 		})
 	}
 }
@@ -177,10 +195,10 @@ func TestRegisterUser(t *testing.T) {
 func TestLoginUser(t *testing.T) {
 	mockDB := database.NewMockDatabase()
 
-	ts := NewTokenService("secret12345", 3*time.Second)
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), 3*time.Second)
 	us := NewUserService(mockDB, ts)
 
-	user, err := us.RegisterUser(&dtos.AccountCreateDTO{
+	user, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
 		Email:     "johntestdoe@net.eu",
 		Password:  "Password1",
 		FirstName: "John",
@@ -272,7 +290,7 @@ func TestLoginUser(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			token, err := us.LoginUser(d.input)
+			token, err := us.LoginUser(context.Background(), d.input)
 			if d.expected.token {
 				require.NotEmpty(t, token)
 			} else {
@@ -283,190 +301,1829 @@ func TestLoginUser(t *testing.T) {
 	}
 }
 
-func TestValidateEmail(t *testing.T) {
-	ts := NewTokenService("", 0)
-	us := NewUserService(nil, ts)
+func TestPasswordRehash(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), 3*time.Second)
+	us := NewUserService(mockDB, ts, WithPasswordHasher(crypto.NewBcryptHasher(bcrypt.MinCost)))
+
+	user, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	storedUser, err := mockDB.SelectUserByID(int(user.ID))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(storedUser.Password, "$2a$04$"))
+	hashedPassword := storedUser.Password
+
+	// A failed login does not rehash the password
+	us = NewUserService(mockDB, ts, WithPasswordHasher(crypto.NewArgon2idHasher(crypto.Argon2idParams{Memory: 1024, Time: 1, Threads: 1})))
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password2"})
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	storedUser, err = mockDB.SelectUserByID(int(user.ID))
+	require.NoError(t, err)
+	require.Equal(t, hashedPassword, storedUser.Password)
+
+	// A successful login rehashes the password with the new algorithm
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	storedUser, err = mockDB.SelectUserByID(int(user.ID))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(storedUser.Password, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	require.NoError(t, crypto.CheckPassword("Password1", storedUser.Password))
+
+	// An up to date hash is not rehashed
+	rehashedPassword := storedUser.Password
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	storedUser, err = mockDB.SelectUserByID(int(user.ID))
+	require.NoError(t, err)
+	require.Equal(t, rehashedPassword, storedUser.Password)
+
+	// Hashes of other algorithms keep working after a switch back
+	us = NewUserService(mockDB, ts, WithPasswordHasher(crypto.NewBcryptHasher(bcrypt.MinCost)))
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	storedUser, err = mockDB.SelectUserByID(int(user.ID))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(storedUser.Password, "$2a$04$"))
+}
+
+func TestRefreshToken(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	_, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	token, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+		Email:    "johntestdoe@net.eu",
+		Password: "Password1",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, token.Token)
+	require.NotEmpty(t, token.RefreshToken)
+
+	// Rotate the refresh token
+	rotated, err := us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+	require.NotEmpty(t, rotated.Token)
+	require.NotEmpty(t, rotated.RefreshToken)
+	require.NotEqual(t, token.RefreshToken, rotated.RefreshToken)
+
+	// Reusing the rotated refresh token revokes the whole family
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: token.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: rotated.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Invalid refresh tokens
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: ""})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: "invalid refresh token"})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Expired refresh token
+	us = NewUserService(mockDB, ts, WithRefreshTokenDuration(time.Nanosecond))
+
+	token, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+		Email:    "johntestdoe@net.eu",
+		Password: "Password1",
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: token.RefreshToken})
+	require.Equal(t, ErrExpiredRefreshToken, err)
+}
+
+func TestSessions(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	_, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	login := func(userAgent string) *dtos.TokenDTO {
+		tokenDTO, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+			Email:     "johntestdoe@net.eu",
+			Password:  "Password1",
+			ClientIP:  "192.0.2.1",
+			UserAgent: userAgent,
+		})
+		require.NoError(t, err)
+
+		return tokenDTO
+	}
+
+	laptop := login("Firefox")
+	phone := login("Safari")
+
+	laptopClaims, err := ts.ValidateToken(laptop.Token)
+	require.NoError(t, err)
+	laptopSessionID := laptopClaims.SessionID
+	require.NotZero(t, laptopSessionID)
+
+	// Each login starts a new session
+	sessionDTOs, err := us.GetSessions(4, laptopSessionID)
+	require.NoError(t, err)
+	require.Len(t, sessionDTOs, 2)
+	require.Equal(t, "Firefox", sessionDTOs[0].UserAgent)
+	require.Equal(t, "192.0.2.1", sessionDTOs[0].IPAddress)
+	require.True(t, sessionDTOs[0].Current)
+	require.Equal(t, "Safari", sessionDTOs[1].UserAgent)
+	require.False(t, sessionDTOs[1].Current)
+
+	// Refreshing tokens keeps the session and records the client
+	rotated, err := us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: phone.RefreshToken, ClientIP: "198.51.100.1", UserAgent: "Safari Mobile"})
+	require.NoError(t, err)
+
+	phoneClaims, err := ts.ValidateToken(rotated.Token)
+	require.NoError(t, err)
+	phoneSessionID := phoneClaims.SessionID
+	require.Equal(t, int(sessionDTOs[1].ID), phoneSessionID)
+
+	session, err := mockDB.SelectSessionByID(phoneSessionID)
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.1", session.IPAddress)
+	require.Equal(t, "Safari Mobile", session.UserAgent)
+
+	// Sessions can be terminated only by their owners
+	require.Equal(t, ErrSessionNotFound, us.TerminateSession(1, phoneSessionID))
+	require.Equal(t, ErrSessionNotFound, us.TerminateSession(4, 100))
+	require.NoError(t, us.TerminateSession(4, phoneSessionID))
+	require.Equal(t, ErrSessionNotFound, us.TerminateSession(4, phoneSessionID))
+
+	// Tokens of the terminated session are refused
+	_, err = ts.ValidateToken(phone.Token)
+	require.Equal(t, ErrTerminatedSession, err)
+	_, err = ts.ValidateToken(rotated.Token)
+	require.Equal(t, ErrTerminatedSession, err)
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: rotated.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	claims, err := ts.ValidateToken(laptop.Token)
+	require.NoError(t, err)
+	require.Equal(t, laptopSessionID, claims.SessionID)
+
+	sessionDTOs, err = us.GetSessions(4, laptopSessionID)
+	require.NoError(t, err)
+	require.Len(t, sessionDTOs, 1)
+	require.Equal(t, int64(laptopSessionID), sessionDTOs[0].ID)
+
+	// Reusing a rotated refresh token terminates the session
+	rotated, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: laptop.RefreshToken})
+	require.NoError(t, err)
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: laptop.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+	_, err = ts.ValidateToken(rotated.Token)
+	require.Equal(t, ErrTerminatedSession, err)
+
+	// Logging out terminates the session of the access token
+	tablet := login("Chrome")
+	tabletClaims, err := ts.ValidateToken(tablet.Token)
+	require.NoError(t, err)
+	require.NoError(t, us.LogoutUser(4, tablet.Token, tabletClaims.SessionID, nil))
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: tablet.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Logging out everywhere terminates all sessions
+	login("Firefox")
+	login("Safari")
+	require.NoError(t, us.LogoutUserEverywhere(4))
+
+	sessionDTOs, err = us.GetSessions(4, 0)
+	require.NoError(t, err)
+	require.Empty(t, sessionDTOs)
+}
+
+func TestGetUser(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	user, err := us.GetUser(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), user.ID)
+	require.Equal(t, "janedoe@net.eu", user.Email)
+	require.Empty(t, user.Password)
+	require.Equal(t, "Jane", user.FirstName)
+	require.Equal(t, "Doe", user.LastName)
+	require.Equal(t, int64(25), user.Age)
+	require.Equal(t, "editor", user.Role)
+
+	_, err = us.GetUser(100)
+	require.Equal(t, ErrUserNotFound, err)
+}
+
+func TestUpdateUser(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	firstName, lastName, age := "Johnny", "Smith", int64(40)
+	unnormalizedName := "  Lu\u0301cia  O'Brien-Smith "
+	invalidName, invalidAge := "J", int64(17)
 
 	data := []struct {
-		name     string
-		email    string
-		expected bool
+		name         string
+		id           int
+		input        *dtos.UserUpdateDTO
+		expectedErr  error
+		expectedUser *dtos.UserDTO
 	}{
 		{
-			name:     "valid email",
-			email:    "email@net.com",
-			expected: true,
+			name:  "valid - all fields",
+			id:    1,
+			input: &dtos.UserUpdateDTO{FirstName: &firstName, LastName: &lastName, Age: &age},
+			expectedUser: &dtos.UserDTO{
+				ID:        1,
+				Email:     "johndoe@net.eu",
+				FirstName: "Johnny",
+				LastName:  "Smith",
+				Age:       40,
+			},
 		},
 		{
-			name:     "invalid email - empty",
-			email:    "",
-			expected: false,
+			name:  "valid - single field",
+			id:    2,
+			input: &dtos.UserUpdateDTO{LastName: &lastName},
+			expectedUser: &dtos.UserDTO{
+				ID:        2,
+				Email:     "janedoe@net.eu",
+				FirstName: "Jane",
+				LastName:  "Smith",
+				Age:       25,
+			},
 		},
 		{
-			name:     "invalid email - no @",
-			email:    "email.net.com",
-			expected: false,
+			name:  "valid - normalized name",
+			id:    3,
+			input: &dtos.UserUpdateDTO{FirstName: &unnormalizedName},
+			expectedUser: &dtos.UserDTO{
+				ID:        3,
+				Email:     "jankowalski@net.pl",
+				FirstName: "Lúcia O'Brien-Smith",
+				LastName:  "Kowalski",
+				Age:       30,
+			},
 		},
 		{
-			name:     "invalid email - no domain",
-			email:    "email@net",
-			expected: false,
+			name:        "invalid first name",
+			id:          1,
+			input:       &dtos.UserUpdateDTO{FirstName: &invalidName},
+			expectedErr: ErrInvalidFirstName,
 		},
 		{
-			name:     "invalid email - no username",
-			email:    "@net.com",
-			expected: false,
+			name:        "invalid last name",
+			id:          1,
+			input:       &dtos.UserUpdateDTO{LastName: &invalidName},
+			expectedErr: ErrInvalidLastName,
 		},
 		{
-			name:     "invalid email - no extension",
-			email:    "email@net.",
-			expected: false,
+			name:        "invalid age",
+			id:          1,
+			input:       &dtos.UserUpdateDTO{Age: &invalidAge},
+			expectedErr: ErrInvalidAge,
+		},
+		{
+			name:        "non-existent user",
+			id:          100,
+			input:       &dtos.UserUpdateDTO{FirstName: &firstName},
+			expectedErr: ErrUserNotFound,
 		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			require.Equal(t, d.expected, us.validateEmail(d.email))
+			user, err := us.UpdateUser(d.id, d.input)
+			require.Equal(t, d.expectedErr, err)
+
+			if d.expectedErr != nil {
+				require.Nil(t, user)
+				return
+			}
+
+			require.Equal(t, d.expectedUser.ID, user.ID)
+			require.Equal(t, d.expectedUser.Email, user.Email)
+			require.Equal(t, d.expectedUser.FirstName, user.FirstName)
+			require.Equal(t, d.expectedUser.LastName, user.LastName)
+			require.Equal(t, d.expectedUser.Age, user.Age)
+
+			stored, err := us.GetUser(d.id)
+			require.NoError(t, err)
+			require.Equal(t, user, stored)
 		})
 	}
 }
 
-func TestValidatePassword(t *testing.T) {
-	ts := NewTokenService("", 0)
-	us := NewUserService(nil, ts)
+func TestDeleteUser(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	tokenString, err := ts.GenerateToken(2, "janedoe@net.eu", models.RoleEditor, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, us.DeleteUser(2))
+
+	_, err = us.GetUser(2)
+	require.Equal(t, ErrUserNotFound, err)
+
+	// books created by the user are kept without a creator
+	book, err := mockDB.SelectBookByID(2)
+	require.NoError(t, err)
+	require.NotNil(t, book)
+	require.Equal(t, 0, book.CreatedBy)
+
+	// tokens issued to the user are revoked
+	_, err = ts.ValidateToken(tokenString)
+	require.Equal(t, ErrRevokedToken, err)
+
+	require.Equal(t, ErrUserNotFound, us.DeleteUser(2))
+}
+
+func TestGetUsers(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
 
 	data := []struct {
-		name     string
-		password string
-		expected bool
+		name          string
+		query         string
+		limit         int
+		offset        int
+		expectedIDs   []int64
+		expectedTotal int64
+		expectedError error
 	}{
 		{
-			name:     "valid password",
-			password: "Password1",
-			expected: true,
+			name:          "all users",
+			limit:         DefaultUserPageLimit,
+			expectedIDs:   []int64{1, 2, 3},
+			expectedTotal: 3,
 		},
 		{
-			name:     "invalid password - empty",
-			password: "",
-			expected: false,
+			name:          "first page",
+			limit:         2,
+			expectedIDs:   []int64{1, 2},
+			expectedTotal: 3,
 		},
 		{
-			name:     "invalid password - too short",
-			password: "Pass1",
-			expected: false,
+			name:          "second page",
+			limit:         2,
+			offset:        2,
+			expectedIDs:   []int64{3},
+			expectedTotal: 3,
 		},
 		{
-			name:     "invalid password - no uppercase letter",
-			password: "password1",
-			expected: false,
+			name:          "offset past the last user",
+			limit:         2,
+			offset:        5,
+			expectedIDs:   []int64{},
+			expectedTotal: 3,
 		},
 		{
-			name:     "invalid password - no lowercase letter",
-			password: "PASSWORD1",
-			expected: false,
+			name:          "query matching last name ignoring case",
+			query:         " doe ",
+			limit:         DefaultUserPageLimit,
+			expectedIDs:   []int64{1, 2},
+			expectedTotal: 2,
 		},
 		{
-			name:     "invalid password - no digit",
-			password: "Password",
-			expected: false,
+			name:          "query matching email address",
+			query:         "net.pl",
+			limit:         DefaultUserPageLimit,
+			expectedIDs:   []int64{3},
+			expectedTotal: 1,
+		},
+		{
+			name:          "query matching first name on the second page",
+			query:         "ja",
+			limit:         1,
+			offset:        1,
+			expectedIDs:   []int64{3},
+			expectedTotal: 2,
+		},
+		{
+			name:          "query matching no user",
+			query:         "unknown",
+			limit:         DefaultUserPageLimit,
+			expectedIDs:   []int64{},
+			expectedTotal: 0,
+		},
+		{
+			name:          "zero limit",
+			limit:         0,
+			expectedError: ErrInvalidPagination,
+		},
+		{
+			name:          "too high limit",
+			limit:         MaxUserPageLimit + 1,
+			expectedError: ErrInvalidPagination,
+		},
+		{
+			name:          "negative offset",
+			limit:         DefaultUserPageLimit,
+			offset:        -1,
+			expectedError: ErrInvalidPagination,
 		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			require.Equal(t, d.expected, us.validatePassword(d.password))
+			userPage, err := us.GetUsers(d.query, d.limit, d.offset)
+			require.Equal(t, d.expectedError, err)
+			if d.expectedError != nil {
+				return
+			}
+
+			ids := []int64{}
+			for _, user := range userPage.Users {
+				require.Empty(t, user.Password)
+				ids = append(ids, user.ID)
+			}
+
+			require.Equal(t, d.expectedIDs, ids)
+			require.Equal(t, d.expectedTotal, userPage.Total)
+			require.Equal(t, int64(d.limit), userPage.Limit)
+			require.Equal(t, int64(d.offset), userPage.Offset)
 		})
 	}
 }
 
-func TestValidateFirstName(t *testing.T) {
-	ts := NewTokenService("", 0)
-	us := NewUserService(nil, ts)
+func TestDisableUser(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	_, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	apiKey, err := us.CreateAPIKey(4, &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read"}})
+	require.NoError(t, err)
+
+	require.Equal(t, ErrOwnAccount, us.DisableUser(1, 1))
+	require.Equal(t, ErrUserNotFound, us.DisableUser(1, 100))
+	require.Equal(t, ErrUserNotFound, us.EnableUser(100))
+
+	require.NoError(t, us.DisableUser(1, 4))
+
+	userDTO, err := us.GetUser(4)
+	require.NoError(t, err)
+	require.NotNil(t, userDTO.DisabledAt)
+
+	// Disabling again is a no-op
+	require.NoError(t, us.DisableUser(1, 4))
+
+	// The user is logged out and can neither log in nor use API keys
+	_, err = ts.ValidateToken(tokens.Token)
+	require.Equal(t, ErrRevokedToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: tokens.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.Equal(t, ErrAccountDisabled, err)
+
+	_, _, err = us.AuthenticateAPIKey(apiKey.Key)
+	require.Equal(t, ErrAccountDisabled, err)
+
+	// The account being disabled is not revealed without the password
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password2"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	require.NoError(t, us.EnableUser(4))
+
+	userDTO, err = us.GetUser(4)
+	require.NoError(t, err)
+	require.Nil(t, userDTO.DisabledAt)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	_, _, err = us.AuthenticateAPIKey(apiKey.Key)
+	require.NoError(t, err)
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts, WithMailer(memoryMailer))
+
+	_, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	require.Equal(t, ErrUserNotFound, us.ForcePasswordReset(100))
+
+	require.NoError(t, us.ForcePasswordReset(4))
+
+	// The user is logged out and the current password is not accepted anymore
+	_, err = ts.ValidateToken(tokens.Token)
+	require.Equal(t, ErrRevokedToken, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	// A password reset token is sent to the user
+	message := memoryMailer.LastMessage("johntestdoe@net.eu")
+	require.Contains(t, message.Body, "An administrator has required you to reset your password")
+
+	require.NoError(t, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: mailedToken(t, message, passwordResetTokenPattern), NewPassword: "NewPassword1"}))
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "NewPassword1"})
+	require.NoError(t, err)
+}
+
+func TestChangeUserRole(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
 
 	data := []struct {
-		name      string
-		firstName string
-		expected  bool
+		name          string
+		adminID       int
+		userID        int
+		role          string
+		expectedRole  string
+		expectedError error
 	}{
 		{
-			name:      "valid first name",
-			firstName: "John",
-			expected:  true,
+			name:          "invalid role",
+			adminID:       1,
+			userID:        3,
+			role:          "owner",
+			expectedError: ErrInvalidRole,
 		},
 		{
-			name:      "invalid first name - empty",
-			firstName: "",
-			expected:  false,
+			name:          "user not found",
+			adminID:       1,
+			userID:        100,
+			role:          "editor",
+			expectedError: ErrUserNotFound,
 		},
 		{
-			name:      "invalid first name - too short",
-			firstName: "J",
-			expected:  false,
+			name:          "demoting own account",
+			adminID:       1,
+			userID:        1,
+			role:          "reader",
+			expectedError: ErrOwnAccount,
 		},
 		{
-			name:      "invalid first name - contains numbers",
-			firstName: "John1",
-			expected:  false,
+			name:         "keeping own role",
+			adminID:      1,
+			userID:       1,
+			role:         "admin",
+			expectedRole: "admin",
 		},
 		{
-			name:      "invalid first name - contains special characters",
-			firstName: "John@",
-			expected:  false,
+			name:         "promoting a reader",
+			adminID:      1,
+			userID:       3,
+			role:         "editor",
+			expectedRole: "editor",
 		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			require.Equal(t, d.expected, us.validateFirstName(d.firstName))
+			userDTO, err := us.ChangeUserRole(d.adminID, d.userID, &dtos.UserRoleUpdateDTO{Role: d.role})
+			require.Equal(t, d.expectedError, err)
+			if d.expectedError != nil {
+				return
+			}
+
+			require.Equal(t, d.expectedRole, userDTO.Role)
+
+			user, err := mockDB.SelectUserByID(d.userID)
+			require.NoError(t, err)
+			require.Equal(t, models.Role(d.expectedRole), user.Role)
 		})
 	}
+
+	// The user is logged out, as access tokens carry the previous role
+	tokenString, err := ts.GenerateToken(2, "janedoe@net.eu", models.RoleEditor, 0)
+	require.NoError(t, err)
+
+	_, err = us.ChangeUserRole(1, 2, &dtos.UserRoleUpdateDTO{Role: "reader"})
+	require.NoError(t, err)
+	_, err = ts.ValidateToken(tokenString)
+	require.Equal(t, ErrRevokedToken, err)
 }
 
-func TestValidateLastName(t *testing.T) {
-	ts := NewTokenService("", 0)
-	us := NewUserService(nil, ts)
+func TestDeleteUserByAdmin(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	require.Equal(t, ErrOwnAccount, us.DeleteUserByAdmin(1, 1))
+	require.Equal(t, ErrUserNotFound, us.DeleteUserByAdmin(1, 100))
+
+	require.NoError(t, us.DeleteUserByAdmin(1, 3))
+
+	_, err := us.GetUser(3)
+	require.Equal(t, ErrUserNotFound, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	user, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+		Email:    "johntestdoe@net.eu",
+		Password: "Password1",
+	})
+	require.NoError(t, err)
 
 	data := []struct {
 		name     string
-		lastName string
-		expected bool
+		id       int
+		input    *dtos.PasswordChangeDTO
+		expected error
 	}{
 		{
-			name:     "valid first name",
-			lastName: "Doe",
-			expected: true,
+			name:     "empty current password",
+			id:       int(user.ID),
+			input:    &dtos.PasswordChangeDTO{CurrentPassword: "", NewPassword: "NewPassword1"},
+			expected: ErrEmptyPassword,
 		},
 		{
-			name:     "invalid first name - empty",
-			lastName: "",
-			expected: false,
+			name:     "invalid new password",
+			id:       int(user.ID),
+			input:    &dtos.PasswordChangeDTO{CurrentPassword: "Password1", NewPassword: "weak"},
+			expected: ErrInvalidPassword,
 		},
 		{
-			name:     "invalid first name - too short",
-			lastName: "D",
-			expected: false,
+			name:     "wrong current password",
+			id:       int(user.ID),
+			input:    &dtos.PasswordChangeDTO{CurrentPassword: "WrongPassword1", NewPassword: "NewPassword1"},
+			expected: ErrInvalidCredentials,
 		},
 		{
-			name:     "invalid first name - contains numbers",
-			lastName: "Doe1",
-			expected: false,
+			name:     "non-existent user",
+			id:       100,
+			input:    &dtos.PasswordChangeDTO{CurrentPassword: "Password1", NewPassword: "NewPassword1"},
+			expected: ErrUserNotFound,
 		},
 		{
-			name:     "invalid first name - contains special characters",
-			lastName: "Doe@",
-			expected: false,
+			name:     "valid",
+			id:       int(user.ID),
+			input:    &dtos.PasswordChangeDTO{CurrentPassword: "Password1", NewPassword: "NewPassword1"},
+			expected: nil,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			require.ErrorIs(t, us.ChangePassword(context.Background(), d.id, d.input), d.expected)
+		})
+	}
+
+	// existing tokens are revoked
+	_, err = ts.ValidateToken(tokens.Token)
+	require.Equal(t, ErrRevokedToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: tokens.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// only the new password is accepted
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "NewPassword1"})
+	require.NoError(t, err)
+}
+
+func TestChangeEmail(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts, WithMailer(memoryMailer))
+
+	user, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	id := int(user.ID)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+		Email:    "johntestdoe@net.eu",
+		Password: "Password1",
+	})
+	require.NoError(t, err)
+
+	// Invalid requests
+	require.Equal(t, ErrInvalidEmail, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "invalid email", Password: "Password1"}))
+	require.Equal(t, ErrEmptyPassword, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: ""}))
+	require.Equal(t, ErrInvalidCredentials, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: "WrongPassword1"}))
+	require.Equal(t, ErrUserAlreadyExists, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "janedoe@net.eu", Password: "Password1"}))
+	require.Equal(t, ErrUserNotFound, us.RequestEmailChange(context.Background(), 100, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: "Password1"}))
+	require.Nil(t, memoryMailer.LastMessage("john@net.eu"))
+
+	// A token is sent to the new email address, requesting again invalidates the previous token
+	require.NoError(t, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: "Password1"}))
+	previousToken := mailedToken(t, memoryMailer.LastMessage("john@net.eu"), emailChangeTokenPattern)
+
+	require.NoError(t, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: "Password1"}))
+	confirmationToken := mailedToken(t, memoryMailer.LastMessage("john@net.eu"), emailChangeTokenPattern)
+	require.NotEqual(t, previousToken, confirmationToken)
+
+	require.Equal(t, ErrInvalidEmailChangeToken, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: previousToken}))
+	require.Equal(t, ErrInvalidEmailChangeToken, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: ""}))
+	require.Equal(t, ErrInvalidEmailChangeToken, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: "invalid token"}))
+
+	// The email address is changed only after the confirmation
+	stored, err := us.GetUser(id)
+	require.NoError(t, err)
+	require.Equal(t, "johntestdoe@net.eu", stored.Email)
+
+	require.NoError(t, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: confirmationToken}))
+
+	stored, err = us.GetUser(id)
+	require.NoError(t, err)
+	require.Equal(t, "john@net.eu", stored.Email)
+
+	// The previous email address is notified
+	require.NotNil(t, memoryMailer.LastMessage("johntestdoe@net.eu"))
+
+	// The token can be used only once
+	require.Equal(t, ErrInvalidEmailChangeToken, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: confirmationToken}))
+
+	// Existing tokens are revoked
+	_, err = ts.ValidateToken(tokens.Token)
+	require.Equal(t, ErrRevokedToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: tokens.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Expired token
+	us = NewUserService(mockDB, ts, WithMailer(memoryMailer), WithEmailChangeTokenDuration(time.Nanosecond))
+
+	require.NoError(t, us.RequestEmailChange(context.Background(), id, &dtos.EmailChangeDTO{NewEmail: "johnny@net.eu", Password: "Password1"}))
+	time.Sleep(time.Millisecond)
+
+	require.Equal(t, ErrInvalidEmailChangeToken, us.ConfirmEmailChange(&dtos.EmailChangeConfirmDTO{Token: mailedToken(t, memoryMailer.LastMessage("johnny@net.eu"), emailChangeTokenPattern)}))
+}
+
+// mailedToken extracts the token from the message, captured by the first group of the pattern.
+func mailedToken(t *testing.T, message *mailer.Message, pattern string) string {
+	require.NotNil(t, message)
+
+	matches := regexp.MustCompile(pattern).FindStringSubmatch(message.Body)
+	require.Len(t, matches, 2)
+
+	return matches[1]
+}
+
+// Patterns of the tokens in the messages sent by RequestEmailChange, RequestPasswordReset and on registration.
+const (
+	emailChangeTokenPattern   = `email address: (\S+)`
+	passwordResetTokenPattern = `reset your password: (\S+)`
+	verificationTokenPattern  = `verify your email address: \S+[?&]token=([\w-]+)`
+)
+
+func TestResetPassword(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts, WithMailer(memoryMailer), WithPasswordResetResendInterval(time.Nanosecond))
+
+	_, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+
+	tokens, err := us.LoginUser(context.Background(), &dtos.UserLoginDTO{
+		Email:    "johntestdoe@net.eu",
+		Password: "Password1",
+	})
+	require.NoError(t, err)
+
+	// Invalid requests
+	require.Equal(t, ErrInvalidEmail, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "invalid email"}))
+
+	// Unknown email addresses are not revealed
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "unknown@net.eu"}))
+	require.Len(t, memoryMailer.Messages(), 1) // the verification email sent on registration
+
+	// A token is sent to the user, requesting again invalidates the previous token
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johntestdoe@net.eu"}))
+	previousToken := mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), passwordResetTokenPattern)
+
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johntestdoe@net.eu"}))
+	resetToken := mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), passwordResetTokenPattern)
+	require.NotEqual(t, previousToken, resetToken)
+
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: previousToken, NewPassword: "NewPassword1"}))
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: "", NewPassword: "NewPassword1"}))
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: "invalid token", NewPassword: "NewPassword1"}))
+
+	// An invalid password does not use up the token
+	require.ErrorIs(t, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: resetToken, NewPassword: "weak"}), ErrInvalidPassword)
+
+	// Email change tokens cannot be used to reset the password
+	require.NoError(t, us.RequestEmailChange(context.Background(), 4, &dtos.EmailChangeDTO{NewEmail: "john@net.eu", Password: "Password1"}))
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: mailedToken(t, memoryMailer.LastMessage("john@net.eu"), emailChangeTokenPattern), NewPassword: "NewPassword1"}))
+
+	require.NoError(t, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: resetToken, NewPassword: "NewPassword1"}))
+
+	// The token can be used only once
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: resetToken, NewPassword: "NewPassword2"}))
+
+	// Existing tokens are revoked and only the new password is accepted
+	_, err = ts.ValidateToken(tokens.Token)
+	require.Equal(t, ErrRevokedToken, err)
+
+	_, err = us.RefreshToken(&dtos.RefreshTokenDTO{RefreshToken: tokens.RefreshToken})
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "NewPassword1"})
+	require.NoError(t, err)
+
+	// Changing the password invalidates pending reset tokens
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johntestdoe@net.eu"}))
+	resetToken = mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), passwordResetTokenPattern)
+
+	require.NoError(t, us.ChangePassword(context.Background(), 4, &dtos.PasswordChangeDTO{CurrentPassword: "NewPassword1", NewPassword: "NewPassword2"}))
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: resetToken, NewPassword: "NewPassword3"}))
+
+	// Expired token
+	us = NewUserService(mockDB, ts, WithMailer(memoryMailer), WithPasswordResetTokenDuration(time.Nanosecond), WithPasswordResetResendInterval(time.Nanosecond))
+
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johntestdoe@net.eu"}))
+	time.Sleep(time.Millisecond)
+
+	require.Equal(t, ErrInvalidPasswordResetToken, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), passwordResetTokenPattern), NewPassword: "NewPassword3"}))
+}
+
+func TestRequestPasswordResetResendInterval(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts, WithMailer(memoryMailer))
+
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johndoe@net.eu"}))
+	resetToken := mailedToken(t, memoryMailer.LastMessage("johndoe@net.eu"), passwordResetTokenPattern)
+
+	// Another email is not sent within the resend interval, but the request succeeds the same way
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johndoe@net.eu"}))
+	require.Len(t, memoryMailer.Messages(), 1)
+
+	// The token sent before keeps working
+	require.NoError(t, us.ResetPassword(context.Background(), &dtos.PasswordResetDTO{Token: resetToken, NewPassword: "NewPassword1"}))
+
+	// Errors while sending the email are not revealed
+	us = NewUserService(mockDB, ts, WithMailer(failingMailer{}))
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "janedoe@net.eu"}))
+}
+
+// failingMailer is a mailer.Mailer which cannot send any message.
+type failingMailer struct{}
+
+func (failingMailer) Send(*mailer.Message) error {
+	return errors.New("mailer not available")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+	memoryMailer := mailer.NewMemoryMailer()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts,
+		WithMailer(memoryMailer),
+		WithEmailVerificationRequired(true),
+		WithEmailVerificationURL("https://test.com/verify-email?source=email"),
+	)
+
+	userDTO, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+	require.Nil(t, userDTO.VerifiedAt)
+
+	// The verification link is sent on registration
+	require.Contains(t, memoryMailer.LastMessage("johntestdoe@net.eu").Body, "https://test.com/verify-email?source=email&token=")
+	previousToken := mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), verificationTokenPattern)
+
+	// Unverified users cannot log in, but invalid credentials are reported first
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "WrongPassword1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.Equal(t, ErrEmailNotVerified, err)
+
+	// Resending is rate limited, but it is not revealed to tell registered and unverified email addresses apart
+	require.NoError(t, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "johntestdoe@net.eu"}))
+	require.Len(t, memoryMailer.Messages(), 1)
+
+	// Invalid requests
+	require.Equal(t, ErrInvalidEmail, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "invalid email"}))
+	require.NoError(t, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "unknown@net.eu"}))
+	require.NoError(t, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "janedoe@net.eu"}))
+	require.Len(t, memoryMailer.Messages(), 1)
+
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail(""))
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail("invalid token"))
+
+	// Resending after the interval invalidates the previous token
+	us = NewUserService(mockDB, ts,
+		WithMailer(memoryMailer),
+		WithEmailVerificationRequired(true),
+		WithEmailVerificationResendInterval(time.Nanosecond),
+	)
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "johntestdoe@net.eu"}))
+	currentToken := mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), verificationTokenPattern)
+	require.NotEqual(t, previousToken, currentToken)
+
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail(previousToken))
+
+	// Password reset tokens cannot be used to verify the email address
+	require.NoError(t, us.RequestPasswordReset(&dtos.PasswordForgotDTO{Email: "johntestdoe@net.eu"}))
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail(mailedToken(t, memoryMailer.LastMessage("johntestdoe@net.eu"), passwordResetTokenPattern)))
+
+	require.NoError(t, us.VerifyEmail(currentToken))
+
+	// The token can be used only once
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail(currentToken))
+
+	userDTO, err = us.GetUser(int(userDTO.ID))
+	require.NoError(t, err)
+	require.NotNil(t, userDTO.VerifiedAt)
+
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+
+	// Nothing is sent to verified users
+	messages := len(memoryMailer.Messages())
+	require.NoError(t, us.ResendVerificationEmail(&dtos.VerificationResendDTO{Email: "johntestdoe@net.eu"}))
+	require.Len(t, memoryMailer.Messages(), messages)
+
+	// Expired token
+	us = NewUserService(mockDB, ts, WithMailer(memoryMailer), WithEmailVerificationTokenDuration(time.Nanosecond))
+
+	_, err = us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "janetestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	require.Equal(t, ErrInvalidEmailVerificationToken, us.VerifyEmail(mailedToken(t, memoryMailer.LastMessage("janetestdoe@net.eu"), verificationTokenPattern)))
+
+	// Unverified users can log in unless the verification is required
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "janetestdoe@net.eu", Password: "Password1"})
+	require.NoError(t, err)
+}
+
+func TestTwoFactor(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	userDTO, err := us.RegisterUser(context.Background(), &dtos.AccountCreateDTO{
+		Email:     "johntestdoe@net.eu",
+		Password:  "Password1",
+		FirstName: "John",
+		LastName:  "Doe",
+		Age:       20,
+	})
+	require.NoError(t, err)
+	id := int(userDTO.ID)
+
+	loginDTO := &dtos.UserLoginDTO{Email: "johntestdoe@net.eu", Password: "Password1"}
+
+	// Two-factor authentication has to be enrolled in first
+	_, err = us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: "123456"})
+	require.Equal(t, ErrTwoFactorNotEnrolled, err)
+	require.Equal(t, ErrTwoFactorNotEnabled, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "Password1", Code: "123456"}))
+
+	_, err = us.EnrollTwoFactor(100)
+	require.Equal(t, ErrUserNotFound, err)
+
+	enrollmentDTO, err := us.EnrollTwoFactor(id)
+	require.NoError(t, err)
+	require.NotEmpty(t, enrollmentDTO.Secret)
+	require.Equal(t, totp.URI(enrollmentDTO.Secret, DefaultTwoFactorIssuer, "johntestdoe@net.eu"), enrollmentDTO.URI)
+
+	// Logging in does not require the second factor until the enrollment is confirmed
+	tokens, err := us.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.Token)
+	require.False(t, tokens.TwoFactorRequired)
+
+	_, err = us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: "invalid"})
+	require.Equal(t, ErrInvalidTwoFactorCode, err)
+
+	code, err := totp.GenerateCode(enrollmentDTO.Secret, time.Now())
+	require.NoError(t, err)
+
+	recoveryCodesDTO, err := us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: code})
+	require.NoError(t, err)
+	require.Len(t, recoveryCodesDTO.RecoveryCodes, recoveryCodeCount)
+
+	uniqueRecoveryCodes := map[string]bool{}
+	for _, recoveryCode := range recoveryCodesDTO.RecoveryCodes {
+		require.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, recoveryCode)
+		uniqueRecoveryCodes[recoveryCode] = true
+	}
+	require.Len(t, uniqueRecoveryCodes, recoveryCodeCount)
+
+	userDTO, err = us.GetUser(id)
+	require.NoError(t, err)
+	require.True(t, userDTO.TwoFactorEnabled)
+
+	_, err = us.EnrollTwoFactor(id)
+	require.Equal(t, ErrTwoFactorAlreadyEnabled, err)
+
+	_, err = us.ConfirmTwoFactor(id, &dtos.TwoFactorConfirmDTO{Code: code})
+	require.Equal(t, ErrTwoFactorAlreadyEnabled, err)
+
+	// Logging in with the password returns only a challenge token
+	tokens, err = us.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+	require.Empty(t, tokens.Token)
+	require.Empty(t, tokens.RefreshToken)
+	require.True(t, tokens.TwoFactorRequired)
+	require.NotEmpty(t, tokens.ChallengeToken)
+
+	// The code used to confirm the enrollment cannot be used again and the challenge token is used up by the attempt
+	_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: code})
+	require.Equal(t, ErrInvalidTwoFactorCode, err)
+
+	nextCode, err := totp.GenerateCode(enrollmentDTO.Secret, time.Now().Add(totp.Period))
+	require.NoError(t, err)
+
+	_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: nextCode})
+	require.Equal(t, ErrInvalidChallengeToken, err)
+
+	_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: "", Code: nextCode})
+	require.Equal(t, ErrInvalidChallengeToken, err)
+
+	_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: "invalid token", Code: nextCode})
+	require.Equal(t, ErrInvalidChallengeToken, err)
+
+	// The challenge token is not used up by an attempt refused by the login limiter
+	loginLimiter := NewLoginLimiterService(mockDB)
+	limitedUS := NewUserService(mockDB, ts, WithLoginLimiter(loginLimiter))
+
+	tokens, err = limitedUS.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+
+	require.NoError(t, loginLimiter.Reserve("johntestdoe@net.eu", ""))
+	_, err = limitedUS.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: nextCode})
+	require.ErrorIs(t, err, ErrTooManyLoginAttempts)
+	require.NoError(t, loginLimiter.Release("johntestdoe@net.eu", ""))
+
+	// TOTP code
+	tokens, err = limitedUS.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: nextCode})
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.Token)
+	require.NotEmpty(t, tokens.RefreshToken)
+	require.False(t, tokens.TwoFactorRequired)
+
+	claims, err := ts.ValidateToken(tokens.Token)
+	require.NoError(t, err)
+	require.Equal(t, int(userDTO.ID), claims.UserID)
+
+	// Recovery code, however it is typed, can be used only once
+	recoveryCode := recoveryCodesDTO.RecoveryCodes[0]
+
+	tokens, err = us.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+
+	tokens, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: strings.ToUpper(strings.ReplaceAll(recoveryCode, "-", ""))})
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.Token)
+
+	tokens, err = us.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+
+	_, err = us.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: recoveryCode})
+	require.Equal(t, ErrInvalidTwoFactorCode, err)
+
+	// Expired challenge token
+	expiringUS := NewUserService(mockDB, ts, WithTwoFactorChallengeDuration(time.Nanosecond))
+
+	tokens, err = expiringUS.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	_, err = expiringUS.LoginUserTwoFactor(&dtos.TwoFactorLoginDTO{ChallengeToken: tokens.ChallengeToken, Code: recoveryCodesDTO.RecoveryCodes[1]})
+	require.Equal(t, ErrInvalidChallengeToken, err)
+
+	// Disabling requires the password and the second factor
+	require.Equal(t, ErrEmptyPassword, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "", Code: recoveryCodesDTO.RecoveryCodes[1]}))
+	require.Equal(t, ErrInvalidCredentials, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "WrongPassword1", Code: recoveryCodesDTO.RecoveryCodes[1]}))
+	require.Equal(t, ErrInvalidTwoFactorCode, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "Password1", Code: recoveryCode}))
+	require.NoError(t, us.DisableTwoFactor(context.Background(), id, &dtos.TwoFactorDisableDTO{Password: "Password1", Code: recoveryCodesDTO.RecoveryCodes[1]}))
+
+	userDTO, err = us.GetUser(id)
+	require.NoError(t, err)
+	require.False(t, userDTO.TwoFactorEnabled)
+
+	tokens, err = us.LoginUser(context.Background(), loginDTO)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.Token)
+	require.False(t, tokens.TwoFactorRequired)
+}
+
+func TestValidateEmail(t *testing.T) {
+	ts := NewTokenService(nil, token.NewHMACKeyRing(""), 0)
+	us := NewUserService(nil, ts)
+
+	data := []struct {
+		name     string
+		email    string
+		expected bool
+	}{
+		{
+			name:     "valid email",
+			email:    "email@net.com",
+			expected: true,
+		},
+		{
+			name:     "invalid email - empty",
+			email:    "",
+			expected: false,
+		},
+		{
+			name:     "invalid email - no @",
+			email:    "email.net.com",
+			expected: false,
+		},
+		{
+			name:     "invalid email - no domain",
+			email:    "email@net",
+			expected: false,
+		},
+		{
+			name:     "invalid email - no username",
+			email:    "@net.com",
+			expected: false,
+		},
+		{
+			name:     "invalid email - no extension",
+			email:    "email@net.",
+			expected: false,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			require.Equal(t, d.expected, us.validateEmail(d.email))
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+	us := NewUserService(mockDB, ts)
+
+	past := time.Now().Add(-time.Minute)
+
+	data := []struct {
+		name          string
+		userID        int
+		input         *dtos.APIKeyCreateDTO
+		expectedError error
+	}{
+		{
+			name:          "empty name",
+			userID:        2,
+			input:         &dtos.APIKeyCreateDTO{Name: " ", Scopes: []string{"books:read"}},
+			expectedError: ErrInvalidAPIKeyName,
+		},
+		{
+			name:          "too long name",
+			userID:        2,
+			input:         &dtos.APIKeyCreateDTO{Name: strings.Repeat("a", 101), Scopes: []string{"books:read"}},
+			expectedError: ErrInvalidAPIKeyName,
+		},
+		{
+			name:          "no scopes",
+			userID:        2,
+			input:         &dtos.APIKeyCreateDTO{Name: "batch"},
+			expectedError: ErrInvalidAPIKeyScopes,
+		},
+		{
+			name:          "unknown scope",
+			userID:        2,
+			input:         &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:delete"}},
+			expectedError: ErrInvalidAPIKeyScopes,
+		},
+		{
+			name:          "scope not granted to the role",
+			userID:        3,
+			input:         &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read", "books:write"}},
+			expectedError: ErrInvalidAPIKeyScopes,
+		},
+		{
+			name:          "expiration time in the past",
+			userID:        2,
+			input:         &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read"}, ExpiresAt: &past},
+			expectedError: ErrInvalidAPIKeyExpiration,
+		},
+		{
+			name:          "user not found",
+			userID:        100,
+			input:         &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read"}},
+			expectedError: ErrUserNotFound,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := us.CreateAPIKey(d.userID, d.input)
+			require.Equal(t, d.expectedError, err)
+		})
+	}
+
+	apiKeyCreatedDTO, err := us.CreateAPIKey(2, &dtos.APIKeyCreateDTO{Name: " batch ", Scopes: []string{"books:read", "books:read"}})
+	require.NoError(t, err)
+	require.Equal(t, "batch", apiKeyCreatedDTO.Name)
+	require.Equal(t, []string{"books:read"}, apiKeyCreatedDTO.Scopes)
+	require.True(t, strings.HasPrefix(apiKeyCreatedDTO.Key, apiKeyCreatedDTO.Prefix))
+	require.True(t, strings.HasPrefix(apiKeyCreatedDTO.Key, apiKeyPrefix))
+
+	// Only a hash of the key is stored
+	apiKey, err := mockDB.SelectAPIKeyByHash(crypto.HashToken(apiKeyCreatedDTO.Key))
+	require.NoError(t, err)
+	require.NotNil(t, apiKey)
+	require.Equal(t, 2, apiKey.UserID)
+
+	// Authentication returns the current role of the owner and records the use
+	apiKey, role, err := us.AuthenticateAPIKey(apiKeyCreatedDTO.Key)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleEditor, role)
+	require.Equal(t, []models.Permission{models.PermissionReadBooks}, apiKey.Scopes)
+	require.NotNil(t, apiKey.LastUsedAt)
+
+	_, _, err = us.AuthenticateAPIKey("invalid")
+	require.Equal(t, ErrInvalidAPIKey, err)
+	_, _, err = us.AuthenticateAPIKey(apiKeyPrefix + "invalid")
+	require.Equal(t, ErrInvalidAPIKey, err)
+
+	apiKeyDTOs, err := us.GetAPIKeys(2)
+	require.NoError(t, err)
+	require.Len(t, apiKeyDTOs, 1)
+	require.Equal(t, apiKeyCreatedDTO.ID, apiKeyDTOs[0].ID)
+	require.NotNil(t, apiKeyDTOs[0].LastUsedAt)
+
+	// Keys can be revoked only by their owners
+	require.Equal(t, ErrAPIKeyNotFound, us.RevokeAPIKey(3, int(apiKeyCreatedDTO.ID)))
+	require.NoError(t, us.RevokeAPIKey(2, int(apiKeyCreatedDTO.ID)))
+	require.Equal(t, ErrAPIKeyNotFound, us.RevokeAPIKey(2, int(apiKeyCreatedDTO.ID)))
+
+	_, _, err = us.AuthenticateAPIKey(apiKeyCreatedDTO.Key)
+	require.Equal(t, ErrInvalidAPIKey, err)
+
+	apiKeyDTOs, err = us.GetAPIKeys(2)
+	require.NoError(t, err)
+	require.Empty(t, apiKeyDTOs)
+
+	// Expired keys are refused
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	apiKeyCreatedDTO, err = us.CreateAPIKey(2, &dtos.APIKeyCreateDTO{Name: "short", Scopes: []string{"books:write"}, ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	_, _, err = us.AuthenticateAPIKey(apiKeyCreatedDTO.Key)
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, _, err = us.AuthenticateAPIKey(apiKeyCreatedDTO.Key)
+	require.Equal(t, ErrExpiredAPIKey, err)
+
+	// Keys are deleted together with their owners
+	apiKeyCreatedDTO, err = us.CreateAPIKey(2, &dtos.APIKeyCreateDTO{Name: "batch", Scopes: []string{"books:read"}})
+	require.NoError(t, err)
+	require.NoError(t, us.DeleteUser(2))
+
+	_, _, err = us.AuthenticateAPIKey(apiKeyCreatedDTO.Key)
+	require.Equal(t, ErrInvalidAPIKey, err)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	stub, err := oidctest.NewProvider("bookrestapi", "secret")
+	require.NoError(t, err)
+	defer stub.Close()
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		IssuerURL:    stub.Issuer(),
+		ClientID:     "bookrestapi",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+	})
+	require.NoError(t, err)
+
+	mockDB := database.NewMockDatabase()
+
+	ts := NewTokenService(mockDB, token.NewHMACKeyRing("secret12345"), time.Minute)
+
+	_, err = NewUserService(mockDB, ts).StartOIDCLogin()
+	require.Equal(t, ErrOIDCNotConfigured, err)
+
+	us := NewUserService(mockDB, ts, WithOIDCProvider(provider))
+
+	login := func(user oidctest.User) (*dtos.TokenDTO, error) {
+		oidcLoginDTO, err := us.StartOIDCLogin()
+		require.NoError(t, err)
+
+		redirectURL, err := stub.Login(oidcLoginDTO.URL, user)
+		require.NoError(t, err)
+		require.Equal(t, oidcLoginDTO.State, redirectURL.Query().Get("state"))
+
+		return us.LoginUserOIDC(context.Background(), &dtos.OIDCCallbackDTO{Code: redirectURL.Query().Get("code"), State: oidcLoginDTO.State})
+	}
+
+	// A new user is created with the default role
+	alice := oidctest.User{Subject: "alice", Email: "alice@corp.example.com", EmailVerified: true, Name: "Alice Smith"}
+
+	tokenDTO, err := login(alice)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokenDTO.Token)
+	require.NotEmpty(t, tokenDTO.RefreshToken)
+
+	user, err := mockDB.SelectUserByOIDCIdentity(stub.Issuer(), "alice")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	require.Equal(t, "alice@corp.example.com", user.Email)
+	require.Equal(t, "Alice", user.FirstName)
+	require.Equal(t, "Smith", user.LastName)
+	require.Zero(t, user.Age)
+	require.Equal(t, DefaultRole, user.Role)
+	require.NotNil(t, user.VerifiedAt)
+
+	// The user has no password to log in with
+	_, err = us.LoginUser(context.Background(), &dtos.UserLoginDTO{Email: "alice@corp.example.com", Password: "Password1"})
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	// The user is found by the identity, even if the email address has changed at the provider
+	alice.Email = "alice.smith@corp.example.com"
+
+	_, err = login(alice)
+	require.NoError(t, err)
+
+	linkedUser, err := mockDB.SelectUserByOIDCIdentity(stub.Issuer(), "alice")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, linkedUser.ID)
+
+	// An existing account is linked by the verified email address
+	_, err = login(oidctest.User{Subject: "john", Email: "johndoe@net.eu", EmailVerified: true})
+	require.NoError(t, err)
+
+	user, err = mockDB.SelectUserByID(1)
+	require.NoError(t, err)
+	require.Equal(t, stub.Issuer(), user.OIDCIssuer)
+	require.Equal(t, "john", user.OIDCSubject)
+
+	// Two-factor authentication is still required
+	user, err = mockDB.SelectUserByID(2)
+	require.NoError(t, err)
+	enabledAt := time.Now()
+	user.TOTPEnabledAt = &enabledAt
+
+	tokenDTO, err = login(oidctest.User{Subject: "jane", Email: "janedoe@net.eu", EmailVerified: true})
+	require.NoError(t, err)
+	require.True(t, tokenDTO.TwoFactorRequired)
+	require.NotEmpty(t, tokenDTO.ChallengeToken)
+	require.Empty(t, tokenDTO.Token)
+
+	data := []struct {
+		name          string
+		user          oidctest.User
+		expectedError error
+	}{
+		{
+			name:          "account linked to another identity",
+			user:          oidctest.User{Subject: "other", Email: "johndoe@net.eu", EmailVerified: true},
+			expectedError: ErrOIDCAccountLinked,
+		},
+		{
+			name:          "email address not verified",
+			user:          oidctest.User{Subject: "jan", Email: "jandoe@net.eu"},
+			expectedError: ErrOIDCEmailNotVerified,
+		},
+		{
+			name:          "invalid email address",
+			user:          oidctest.User{Subject: "bob", Email: "bob", EmailVerified: true},
+			expectedError: ErrOIDCLoginFailed,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := login(d.user)
+			require.Equal(t, d.expectedError, err)
+		})
+	}
+
+	// The state can be used only once
+	oidcLoginDTO, err := us.StartOIDCLogin()
+	require.NoError(t, err)
+
+	redirectURL, err := stub.Login(oidcLoginDTO.URL, alice)
+	require.NoError(t, err)
+
+	_, err = us.LoginUserOIDC(context.Background(), &dtos.OIDCCallbackDTO{Code: "invalid", State: oidcLoginDTO.State})
+	require.Equal(t, ErrOIDCLoginFailed, err)
+
+	_, err = us.LoginUserOIDC(context.Background(), &dtos.OIDCCallbackDTO{Code: redirectURL.Query().Get("code"), State: oidcLoginDTO.State})
+	require.Equal(t, ErrInvalidOIDCState, err)
+
+	_, err = us.LoginUserOIDC(context.Background(), &dtos.OIDCCallbackDTO{Code: redirectURL.Query().Get("code"), State: "invalid"})
+	require.Equal(t, ErrInvalidOIDCState, err)
+
+	// Requests to the provider are canceled with the context
+	oidcLoginDTO, err = us.StartOIDCLogin()
+	require.NoError(t, err)
+
+	redirectURL, err = stub.Login(oidcLoginDTO.URL, alice)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = us.LoginUserOIDC(ctx, &dtos.OIDCCallbackDTO{Code: redirectURL.Query().Get("code"), State: oidcLoginDTO.State})
+	require.Equal(t, ErrOIDCLoginFailed, err)
+
+	// The login has to be completed within the login duration
+	us = NewUserService(mockDB, ts, WithOIDCProvider(provider), WithOIDCLoginDuration(50*time.Millisecond))
+
+	oidcLoginDTO, err = us.StartOIDCLogin()
+	require.NoError(t, err)
+
+	redirectURL, err = stub.Login(oidcLoginDTO.URL, alice)
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = us.LoginUserOIDC(context.Background(), &dtos.OIDCCallbackDTO{Code: redirectURL.Query().Get("code"), State: oidcLoginDTO.State})
+	require.Equal(t, ErrInvalidOIDCState, err)
+}
+
+func TestNormalizeName(t *testing.T) {
+	ts := NewTokenService(nil, token.NewHMACKeyRing(""), 0)
+	us := NewUserService(nil, ts)
+
+	data := []struct {
+		name           string
+		input          string
+		expectedName   string
+		expectedResult bool
+	}{
+		{
+			name:           "valid name - ascii",
+			input:          "John",
+			expectedName:   "John",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - polish",
+			input:          "Łukasz",
+			expectedName:   "Łukasz",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - spanish with spaces",
+			input:          "María José",
+			expectedName:   "María José",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - apostrophe and hyphen",
+			input:          "O'Brien-Smith",
+			expectedName:   "O'Brien-Smith",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - typographic apostrophe",
+			input:          "D’Angelo",
+			expectedName:   "D’Angelo",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - german",
+			input:          "Müller-Lüdenscheidt",
+			expectedName:   "Müller-Lüdenscheidt",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - greek",
+			input:          "Αλέξανδρος",
+			expectedName:   "Αλέξανδρος",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - cyrillic",
+			input:          "Дмитрий",
+			expectedName:   "Дмитрий",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - arabic",
+			input:          "محمد",
+			expectedName:   "محمد",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - hebrew",
+			input:          "אברהם",
+			expectedName:   "אברהם",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - devanagari with combining vowel signs",
+			input:          "प्रिया",
+			expectedName:   "प्रिया",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - thai",
+			input:          "สมชาย",
+			expectedName:   "สมชาย",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - chinese",
+			input:          "王小明",
+			expectedName:   "王小明",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - japanese",
+			input:          "さくら",
+			expectedName:   "さくら",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - korean",
+			input:          "김민준",
+			expectedName:   "김민준",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - decomposed characters are composed",
+			input:          "Jose\u0301",
+			expectedName:   "José",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - whitespace is trimmed and collapsed",
+			input:          "  Jan \t  Maria\n",
+			expectedName:   "Jan Maria",
+			expectedResult: true,
+		},
+		{
+			name:           "valid name - 50 characters",
+			input:          strings.Repeat("Ż", 50),
+			expectedName:   strings.Repeat("Ż", 50),
+			expectedResult: true,
+		},
+		{
+			name:           "invalid name - empty",
+			input:          "",
+			expectedName:   "",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - whitespace only",
+			input:          "   ",
+			expectedName:   "",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - too short",
+			input:          " J ",
+			expectedName:   "J",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - too long",
+			input:          strings.Repeat("Ż", 51),
+			expectedName:   strings.Repeat("Ż", 51),
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - contains numbers",
+			input:          "John1",
+			expectedName:   "John1",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - contains special characters",
+			input:          "John@",
+			expectedName:   "John@",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - starts with a hyphen",
+			input:          "-John",
+			expectedName:   "-John",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - ends with an apostrophe",
+			input:          "John'",
+			expectedName:   "John'",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - consecutive separators",
+			input:          "Smith--Jones",
+			expectedName:   "Smith--Jones",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - separator next to a space",
+			input:          "Smith - Jones",
+			expectedName:   "Smith - Jones",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - leading combining mark",
+			input:          "\u0301John",
+			expectedName:   "\u0301John",
+			expectedResult: false,
+		},
+		{
+			name:           "invalid name - emoji",
+			input:          "John😀",
+			expectedName:   "John😀",
+			expectedResult: false,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			name, valid := us.normalizeName(d.input)
+			require.Equal(t, d.expectedResult, valid)
+			require.Equal(t, d.expectedName, name)
+		})
+	}
+}
+
+func TestOIDCNames(t *testing.T) {
+	ts := NewTokenService(nil, token.NewHMACKeyRing(""), 0)
+	us := NewUserService(nil, ts)
+
+	data := []struct {
+		name              string
+		claims            *oidc.Claims
+		expectedFirstName string
+		expectedLastName  string
+	}{
+		{
+			name:              "given and family names",
+			claims:            &oidc.Claims{Name: "Ally Smith", GivenName: "Alice", FamilyName: "Smith-Jones"},
+			expectedFirstName: "Alice",
+			expectedLastName:  "Smith-Jones",
+		},
+		{
+			name:              "full name split at the first space",
+			claims:            &oidc.Claims{Name: "  María   José  García "},
+			expectedFirstName: "María",
+			expectedLastName:  "José García",
+		},
+		{
+			name:              "names normalized to NFC",
+			claims:            &oidc.Claims{GivenName: "Jose\u0301", FamilyName: "Nun\u0303ez"},
+			expectedFirstName: "José",
+			expectedLastName:  "Nuñez",
+		},
+		{
+			name:              "name too long truncated",
+			claims:            &oidc.Claims{GivenName: strings.Repeat("Ż", 49) + "-Anna", FamilyName: strings.Repeat("Ż", 60)},
+			expectedFirstName: strings.Repeat("Ż", 49),
+			expectedLastName:  strings.Repeat("Ż", 50),
+		},
+		{
+			name:              "missing names",
+			claims:            &oidc.Claims{},
+			expectedFirstName: oidcNamePlaceholder,
+			expectedLastName:  oidcNamePlaceholder,
+		},
+		{
+			name:              "missing last name",
+			claims:            &oidc.Claims{Name: "Alice"},
+			expectedFirstName: "Alice",
+			expectedLastName:  oidcNamePlaceholder,
+		},
+		{
+			name:              "invalid names",
+			claims:            &oidc.Claims{GivenName: "<script>", FamilyName: "J"},
+			expectedFirstName: oidcNamePlaceholder,
+			expectedLastName:  oidcNamePlaceholder,
 		},
 	}
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			require.Equal(t, d.expected, us.validateLastName(d.lastName))
+			firstName, lastName := us.oidcNames(d.claims)
+			require.Equal(t, d.expectedFirstName, firstName)
+			require.Equal(t, d.expectedLastName, lastName)
 		})
 	}
 }
 
 func TestValidateAge(t *testing.T) {
-	ts := NewTokenService("", 0)
+	ts := NewTokenService(nil, token.NewHMACKeyRing(""), 0)
 	us := NewUserService(nil, ts)
 
 	data := []struct {
@@ -0,0 +1,153 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// PrefixLength is the number of hex characters of the hash of a password a range of hashes is looked up by.
+const PrefixLength = 5
+
+// ErrInvalidPrefix is returned when a range of hashes is looked up by a prefix which is not 5 hex characters.
+var ErrInvalidPrefix = errors.New("prefix must be 5 hex characters")
+
+// List is a local list of passwords exposed in data breaches, in the format of the Pwned Passwords dataset.
+// It is a file of hex SHA-1 hashes of passwords ordered by hash, one per line, each optionally followed by a colon and a count.
+// Hashes are looked up k-anonymity style, by the range of hashes sharing the prefix of the hash of a password,
+// with a binary search on the file, so the list is never loaded into memory.
+type List struct {
+	file *os.File
+	size int64
+}
+
+// Open opens the list in the given file. It must be closed when no longer needed.
+func Open(path string) (*List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &List{file: file, size: info.Size()}, nil
+}
+
+// Close closes the file of the list.
+func (l *List) Close() error {
+	return l.file.Close()
+}
+
+// Contains reports whether the given password has been exposed in a data breach.
+func (l *List) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := l.Range(hash[:PrefixLength])
+	if err != nil {
+		return false, err
+	}
+
+	for _, suffix := range suffixes {
+		if suffix == hash[PrefixLength:] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Range returns the uppercase suffixes of all hashes in the list starting with the given prefix of 5 hex characters.
+func (l *List) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != PrefixLength {
+		return nil, ErrInvalidPrefix
+	}
+	if _, err := hex.DecodeString(prefix + "0"); err != nil {
+		return nil, ErrInvalidPrefix
+	}
+
+	// Find the smallest offset the first line after which has a hash not lower than the prefix.
+	low, high := int64(0), l.size
+	for low < high {
+		middle := low + (high-low)/2
+
+		reader, err := l.readerAt(middle)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := readHash(reader)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if errors.Is(err, io.EOF) || hash[:min(len(hash), PrefixLength)] >= prefix {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+
+	reader, err := l.readerAt(low)
+	if err != nil {
+		return nil, err
+	}
+
+	suffixes := []string{}
+	for {
+		hash, err := readHash(reader)
+		if errors.Is(err, io.EOF) {
+			return suffixes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			return suffixes, nil
+		}
+
+		suffixes = append(suffixes, hash[PrefixLength:])
+	}
+}
+
+// readerAt returns a reader of the file positioned at the start of the first line starting at or after the given offset.
+func (l *List) readerAt(offset int64) (*bufio.Reader, error) {
+	if offset == 0 {
+		return bufio.NewReader(io.NewSectionReader(l.file, 0, l.size)), nil
+	}
+
+	// The line is skipped unless the previous byte ends a line.
+	reader := bufio.NewReader(io.NewSectionReader(l.file, offset-1, l.size-offset+1))
+	if _, err := reader.ReadString('\n'); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// readHash reads the next non-empty line of the list and returns its uppercase hash without the count.
+func readHash(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			return "", err
+		}
+
+		hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if hash != "" {
+			return strings.ToUpper(hash), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
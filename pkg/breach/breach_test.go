@@ -0,0 +1,97 @@
+package breach
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "Password1", "letmein"}
+	for i := 0; i < 1000; i++ {
+		breached = append(breached, fmt.Sprintf("breached%d", i))
+	}
+
+	list := openList(t, breached, "\n")
+
+	for _, password := range breached {
+		contains, err := list.Contains(password)
+		require.NoError(t, err)
+		require.True(t, contains, password)
+	}
+
+	for _, password := range []string{"Tr0ub4dor&3", "correct horse battery staple", "breached1000", ""} {
+		contains, err := list.Contains(password)
+		require.NoError(t, err)
+		require.False(t, contains, password)
+	}
+
+	// The hash of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	suffixes, err := list.Range("5baa6")
+	require.NoError(t, err)
+	require.Contains(t, suffixes, "1E4C9B93F3F0682250B6CF8331B7EE68FD8")
+
+	suffixes, err = list.Range("00000")
+	require.NoError(t, err)
+	require.Empty(t, suffixes)
+
+	suffixes, err = list.Range("FFFFF")
+	require.NoError(t, err)
+	require.Empty(t, suffixes)
+
+	for _, prefix := range []string{"", "5BAA", "5BAA61", "XXXXX"} {
+		_, err := list.Range(prefix)
+		require.ErrorIs(t, err, ErrInvalidPrefix)
+	}
+}
+
+func TestListLineEndings(t *testing.T) {
+	list := openList(t, []string{"password", "qwerty"}, "\r\n")
+
+	contains, err := list.Contains("qwerty")
+	require.NoError(t, err)
+	require.True(t, contains)
+
+	contains, err = list.Contains("123456")
+	require.NoError(t, err)
+	require.False(t, contains)
+}
+
+func TestEmptyList(t *testing.T) {
+	list := openList(t, nil, "\n")
+
+	contains, err := list.Contains("password")
+	require.NoError(t, err)
+	require.False(t, contains)
+}
+
+func TestOpenNotExistingList(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "not-existing.txt"))
+	require.Error(t, err)
+}
+
+// openList writes the hashes of the given passwords ordered by hash with counts to a file and opens it as a list.
+func openList(t *testing.T, passwords []string, lineEnding string) *List {
+	lines := []string{}
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	slices.Sort(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, lineEnding)), 0o600))
+
+	list, err := Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { list.Close() })
+
+	return list
+}
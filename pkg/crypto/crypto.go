@@ -1,36 +1,25 @@
 package crypto
 
 import (
-	"errors"
-
-	"golang.org/x/crypto/bcrypt"
-)
-
-var (
-	// ErrInvalidCredentials is returned when the credentials provided are invalid.
-	ErrInvalidCredentials = errors.New("invalid credentials")
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 )
 
-const (
-	// Cost is the cost used to hash passwords.
-	Cost = 10
-)
+// GenerateRandomString generates a URL-safe, base64 encoded string from n cryptographically secure random bytes.
+func GenerateRandomString(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
 
-// HashPassword hashes a password with bcrypt and the default cost of 10.
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
-	return string(bytes), err
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
-// CheckPassword checks if a password matches a hash with bcrypt.
-func CheckPassword(password, hash string) error {
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return ErrInvalidCredentials
-		}
-
-		return err
-	}
-
-	return nil
+// HashToken hashes a high-entropy token with SHA-256 and returns the hex encoded digest.
+// Unlike passwords, random tokens do not need a slow hashing function.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -1,7 +1,11 @@
 package crypto
 
 import (
+	"context"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
@@ -47,3 +51,230 @@ func TestCrypto(t *testing.T) {
 		})
 	}
 }
+
+func TestPasswordHashers(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	argon2idHasher := NewArgon2idHasher(Argon2idParams{Memory: 1024, Time: 1, Threads: 1})
+
+	bcryptHash, err := bcryptHasher.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(bcryptHash, "$2a$04$"))
+
+	argon2idHash, err := argon2idHasher.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(argon2idHash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	otherArgon2idHash, err := argon2idHasher.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	require.NotEqual(t, argon2idHash, otherArgon2idHash)
+
+	// bcrypt hashes at most 72 bytes, 37 two-byte characters are too long
+	_, err = bcryptHasher.Hash(context.Background(), strings.Repeat("ż", 37))
+	require.ErrorIs(t, err, ErrPasswordTooLong)
+
+	_, err = argon2idHasher.Hash(context.Background(), strings.Repeat("ż", 37))
+	require.NoError(t, err)
+
+	data := []struct {
+		name          string
+		hasher        PasswordHasher
+		inputPassword string
+		inputHash     string
+		expectedError error
+		needsRehash   bool
+	}{
+		{
+			name:          "bcrypt hasher, bcrypt hash",
+			hasher:        bcryptHasher,
+			inputPassword: "password123",
+			inputHash:     bcryptHash,
+		},
+		{
+			name:          "bcrypt hasher, wrong password",
+			hasher:        bcryptHasher,
+			inputPassword: "wrongPassword123@",
+			inputHash:     bcryptHash,
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "bcrypt hasher, bcrypt hash with another cost",
+			hasher:        NewBcryptHasher(0),
+			inputPassword: "password123",
+			inputHash:     bcryptHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "bcrypt hasher, argon2id hash",
+			hasher:        bcryptHasher,
+			inputPassword: "password123",
+			inputHash:     argon2idHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "argon2id hasher, argon2id hash",
+			hasher:        argon2idHasher,
+			inputPassword: "password123",
+			inputHash:     argon2idHash,
+		},
+		{
+			name:          "argon2id hasher, wrong password",
+			hasher:        argon2idHasher,
+			inputPassword: "wrongPassword123@",
+			inputHash:     argon2idHash,
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "argon2id hasher, argon2id hash with other parameters",
+			hasher:        NewArgon2idHasher(Argon2idParams{Memory: 2048, Time: 1, Threads: 1}),
+			inputPassword: "password123",
+			inputHash:     argon2idHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "argon2id hasher, bcrypt hash",
+			hasher:        argon2idHasher,
+			inputPassword: "password123",
+			inputHash:     bcryptHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "unsupported hash",
+			hasher:        argon2idHasher,
+			inputPassword: "password123",
+			inputHash:     "password123",
+			expectedError: ErrUnsupportedHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "malformed argon2id hash",
+			hasher:        argon2idHasher,
+			inputPassword: "password123",
+			inputHash:     "$argon2id$v=19$m=1024,t=1$c2FsdA$aGFzaA",
+			expectedError: ErrUnsupportedHash,
+			needsRehash:   true,
+		},
+		{
+			name:          "unsupported argon2 version",
+			hasher:        argon2idHasher,
+			inputPassword: "password123",
+			inputHash:     strings.Replace(argon2idHash, "v=19", "v=16", 1),
+			expectedError: ErrUnsupportedHash,
+			needsRehash:   true,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			err := d.hasher.Check(context.Background(), d.inputPassword, d.inputHash)
+			require.ErrorIs(t, err, d.expectedError)
+			require.Equal(t, d.needsRehash, d.hasher.NeedsRehash(d.inputHash))
+		})
+	}
+}
+
+func TestNewArgon2idHasher(t *testing.T) {
+	require.Equal(t, DefaultArgon2idParams, NewArgon2idHasher(Argon2idParams{}).params)
+	require.Equal(t, Argon2idParams{Memory: 1024, Time: 3, Threads: 4, SaltLength: 16, KeyLength: 32}, NewArgon2idHasher(Argon2idParams{Memory: 1024}).params)
+}
+
+func TestHashingPool(t *testing.T) {
+	hasher := &blockingHasher{started: make(chan struct{}), unblock: make(chan struct{})}
+	pool := NewHashingPool(hasher, 1, 1, 100*time.Millisecond)
+
+	// The only worker is busy until unblocked
+	busy := make(chan error)
+	go func() {
+		_, err := pool.Hash(context.Background(), "password123")
+		busy <- err
+	}()
+	<-hasher.started
+
+	require.Equal(t, HashingPoolStats{Workers: 1, Busy: 1, QueueSize: 1}, pool.Stats())
+
+	// A queued password waits until the maximum waiting time passes
+	_, err := pool.Hash(context.Background(), "password123")
+	require.ErrorIs(t, err, ErrHashingPoolSaturated)
+	require.Equal(t, 1, pool.Stats().Rejected)
+
+	// A queued password waits until its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+	go func() {
+		queued <- pool.Check(ctx, "password123", "hash")
+	}()
+	require.Eventually(t, func() bool { return pool.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	// A password is rejected at once if the queue is full
+	_, err = pool.Hash(context.Background(), "password123")
+	require.ErrorIs(t, err, ErrHashingPoolSaturated)
+	require.Equal(t, 2, pool.Stats().Rejected)
+
+	cancel()
+	require.ErrorIs(t, <-queued, context.Canceled)
+
+	// A queued password is hashed once the worker is free
+	queuedHash := make(chan error)
+	go func() {
+		_, err := pool.Hash(context.Background(), "password123")
+		queuedHash <- err
+	}()
+	require.Eventually(t, func() bool { return pool.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	hasher.unblock <- struct{}{}
+	require.NoError(t, <-busy)
+
+	<-hasher.started
+	hasher.unblock <- struct{}{}
+	require.NoError(t, <-queuedHash)
+
+	require.Equal(t, HashingPoolStats{Workers: 1, QueueSize: 1, Rejected: 2}, pool.Stats())
+}
+
+func TestNewHashingPool(t *testing.T) {
+	pool := NewHashingPool(NewBcryptHasher(0), 0, 0, 0)
+	require.Equal(t, runtime.NumCPU(), pool.Stats().Workers)
+	require.Equal(t, DefaultHashingQueueSize, pool.Stats().QueueSize)
+	require.Equal(t, DefaultHashingMaxWait, pool.maxWait)
+
+	hash, err := pool.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	require.NoError(t, pool.Check(context.Background(), "password123", hash))
+	require.False(t, pool.NeedsRehash(hash))
+}
+
+// blockingHasher is a PasswordHasher, which blocks hashing and checking until unblocked.
+type blockingHasher struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (h *blockingHasher) Hash(ctx context.Context, password string) (string, error) {
+	return password, h.Check(ctx, password, password)
+}
+
+func (h *blockingHasher) Check(context.Context, string, string) error {
+	h.started <- struct{}{}
+	<-h.unblock
+	return nil
+}
+
+func (h *blockingHasher) NeedsRehash(string) bool {
+	return false
+}
+
+func TestGenerateRandomString(t *testing.T) {
+	first, err := GenerateRandomString(32)
+	require.NoError(t, err)
+	require.Len(t, first, 43)
+
+	second, err := GenerateRandomString(32)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("token")
+	require.Len(t, hash, 64)
+	require.Equal(t, hash, HashToken("token"))
+	require.NotEqual(t, hash, HashToken("other token"))
+}
@@ -0,0 +1,250 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the credentials provided are invalid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnsupportedHash is returned when a password hash is not in any supported format.
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+	// ErrPasswordTooLong is returned when a password is too long to be hashed.
+	ErrPasswordTooLong = errors.New("password too long")
+)
+
+const (
+	// AlgorithmBcrypt is the name of the bcrypt password hashing algorithm.
+	AlgorithmBcrypt = "bcrypt"
+	// AlgorithmArgon2id is the name of the Argon2id password hashing algorithm.
+	AlgorithmArgon2id = "argon2id"
+
+	// DefaultBcryptCost is the default cost used to hash passwords with bcrypt.
+	DefaultBcryptCost = 10
+	// BcryptMaxPasswordLength is the maximum number of bytes of a password bcrypt hashes.
+	BcryptMaxPasswordLength = 72
+
+	// argon2idPrefix is the prefix of Argon2id hashes in the PHC string format.
+	argon2idPrefix = "$argon2id$"
+)
+
+// DefaultArgon2idParams are the default parameters used to hash passwords with Argon2id,
+// the second recommended option of RFC 9106.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:     64 * 1024,
+	Time:       3,
+	Threads:    4,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// PasswordHasher hashes passwords and checks them against hashes.
+// Hashes are self-describing, so a hasher checks passwords against hashes of any supported algorithm and parameters,
+// and reports which hashes have to be rehashed to use its own.
+// Hashing and checking are expensive, so they take a context, which hashers limiting their concurrency may wait with.
+type PasswordHasher interface {
+	Hash(context.Context, string) (string, error)
+	Check(context.Context, string, string) error
+	NeedsRehash(string) bool
+}
+
+// HashPassword hashes a password with bcrypt and the default cost.
+func HashPassword(password string) (string, error) {
+	return NewBcryptHasher(DefaultBcryptCost).Hash(context.Background(), password)
+}
+
+// CheckPassword checks if a password matches a hash of any supported algorithm.
+// Bcrypt hashes are in the modular crypt format and Argon2id hashes in the PHC string format.
+func CheckPassword(password, hash string) error {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return checkArgon2id(password, hash)
+	}
+	if strings.HasPrefix(hash, "$2") {
+		return checkBcrypt(password, hash)
+	}
+
+	return ErrUnsupportedHash
+}
+
+// BcryptHasher is a PasswordHasher hashing passwords with bcrypt.
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher creates a new BcryptHasher with the given cost. The default cost is used if it is 0.
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = DefaultBcryptCost
+	}
+
+	return &BcryptHasher{cost: cost}
+}
+
+// Hash hashes a password with bcrypt.
+// It returns ErrPasswordTooLong if the password is longer than BcryptMaxPasswordLength bytes.
+func (h *BcryptHasher) Hash(_ context.Context, password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", ErrPasswordTooLong
+	}
+
+	return string(bytes), err
+}
+
+// Check checks if a password matches a hash of any supported algorithm.
+func (h *BcryptHasher) Check(_ context.Context, password, hash string) error {
+	return CheckPassword(password, hash)
+}
+
+// NeedsRehash reports whether a hash is not a bcrypt hash with the cost of the hasher.
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// Argon2idParams are the parameters of the Argon2id algorithm.
+type Argon2idParams struct {
+	// Memory is the amount of memory used in KiB.
+	Memory uint32
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Threads is the number of threads used.
+	Threads uint8
+	// SaltLength is the length of the random salt in bytes.
+	SaltLength uint32
+	// KeyLength is the length of the hash in bytes.
+	KeyLength uint32
+}
+
+// Argon2idHasher is a PasswordHasher hashing passwords with Argon2id.
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher creates a new Argon2idHasher with the given parameters. Default parameters are used for those which are 0.
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2idParams.Memory
+	}
+	if params.Time == 0 {
+		params.Time = DefaultArgon2idParams.Time
+	}
+	if params.Threads == 0 {
+		params.Threads = DefaultArgon2idParams.Threads
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultArgon2idParams.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2idParams.KeyLength
+	}
+
+	return &Argon2idHasher{params: params}
+}
+
+// Hash hashes a password with Argon2id and encodes the hash in the PHC string format.
+func (h *Argon2idHasher) Hash(_ context.Context, password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check checks if a password matches a hash of any supported algorithm.
+func (h *Argon2idHasher) Check(_ context.Context, password, hash string) error {
+	return CheckPassword(password, hash)
+}
+
+// NeedsRehash reports whether a hash is not an Argon2id hash with the parameters of the hasher.
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.params.Memory ||
+		params.Time != h.params.Time ||
+		params.Threads != h.params.Threads ||
+		uint32(len(salt)) != h.params.SaltLength ||
+		uint32(len(key)) != h.params.KeyLength
+}
+
+// checkBcrypt checks if a password matches a bcrypt hash.
+func checkBcrypt(password, hash string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkArgon2id checks if a password matches an Argon2id hash in the PHC string format.
+func checkArgon2id(password, hash string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// decodeArgon2id decodes the parameters, the salt and the key from an Argon2id hash in the PHC string format,
+// e.g. $argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA.
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	params := Argon2idParams{}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+	if params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHashingPoolSaturated is returned when a password cannot be hashed or checked, because the hashing pool is saturated.
+var ErrHashingPoolSaturated = errors.New("password hashing pool saturated")
+
+const (
+	// DefaultHashingQueueSize is the default maximum number of passwords waiting to be hashed or checked.
+	DefaultHashingQueueSize = 64
+	// DefaultHashingMaxWait is the default maximum time a password waits to be hashed or checked.
+	DefaultHashingMaxWait = 5 * time.Second
+)
+
+// HashingPool is a PasswordHasher bounding the number of passwords hashed or checked concurrently by another PasswordHasher.
+// Passwords wait for a free worker in a queue of a limited size, so bursts of requests cannot saturate every CPU.
+// Hashing and checking fail with ErrHashingPoolSaturated if the queue is full or a password waits too long,
+// and with the error of the context if it is done while waiting.
+type HashingPool struct {
+	hasher    PasswordHasher
+	workers   chan struct{}
+	queueSize int
+	maxWait   time.Duration
+
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// HashingPoolStats are statistics of a HashingPool.
+type HashingPoolStats struct {
+	// Workers is the number of passwords which can be hashed or checked concurrently.
+	Workers int `json:"workers"`
+	// Busy is the number of passwords being hashed or checked.
+	Busy int `json:"busy"`
+	// QueueSize is the maximum number of passwords waiting to be hashed or checked.
+	QueueSize int `json:"queue_size"`
+	// Queued is the number of passwords waiting to be hashed or checked.
+	Queued int `json:"queued"`
+	// Rejected is the total number of passwords which have not been hashed or checked, because the pool has been saturated.
+	Rejected int `json:"rejected"`
+}
+
+// NewHashingPool creates a new HashingPool in front of the given PasswordHasher with the given number of workers,
+// maximum number of waiting passwords and maximum waiting time.
+// The number of CPUs is used if the number of workers is 0, and defaults are used for the other limits if they are 0.
+func NewHashingPool(hasher PasswordHasher, workers, queueSize int, maxWait time.Duration) *HashingPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultHashingQueueSize
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultHashingMaxWait
+	}
+
+	return &HashingPool{
+		hasher:    hasher,
+		workers:   make(chan struct{}, workers),
+		queueSize: queueSize,
+		maxWait:   maxWait,
+	}
+}
+
+// Hash hashes a password with the underlying PasswordHasher once a worker is free.
+func (p *HashingPool) Hash(ctx context.Context, password string) (string, error) {
+	if err := p.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer p.release()
+
+	return p.hasher.Hash(ctx, password)
+}
+
+// Check checks a password against a hash with the underlying PasswordHasher once a worker is free.
+func (p *HashingPool) Check(ctx context.Context, password, hash string) error {
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+	defer p.release()
+
+	return p.hasher.Check(ctx, password, hash)
+}
+
+// NeedsRehash reports whether a hash has to be rehashed to use the underlying PasswordHasher.
+func (p *HashingPool) NeedsRehash(hash string) bool {
+	return p.hasher.NeedsRehash(hash)
+}
+
+// Stats returns the current statistics of the pool.
+func (p *HashingPool) Stats() HashingPoolStats {
+	return HashingPoolStats{
+		Workers:   cap(p.workers),
+		Busy:      len(p.workers),
+		QueueSize: p.queueSize,
+		Queued:    int(p.queued.Load()),
+		Rejected:  int(p.rejected.Load()),
+	}
+}
+
+// acquire waits for a free worker, unless the queue is full.
+func (p *HashingPool) acquire(ctx context.Context) error {
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if p.queued.Add(1) > int64(p.queueSize) {
+		p.queued.Add(-1)
+		p.rejected.Add(1)
+		return ErrHashingPoolSaturated
+	}
+	defer p.queued.Add(-1)
+
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	case <-timer.C:
+		p.rejected.Add(1)
+		return ErrHashingPoolSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a worker.
+func (p *HashingPool) release() {
+	<-p.workers
+}
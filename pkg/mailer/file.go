@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileMailer is a Mailer that appends messages to a file instead of sending them.
+// It is meant for local development, as the messages may contain secrets such as confirmation tokens.
+// It is safe for concurrent use.
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileMailer creates a new FileMailer that appends messages to the file at the given path.
+// The file is created if it does not exist.
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+// Send appends the message to the file.
+func (m *FileMailer) Send(message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), message.To, message.Subject, message.Body); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/MSSkowron/BookRESTAPI/pkg/logger"
+)
+
+// Message represents an email message.
+type Message struct {
+	// To is the email address of the recipient.
+	To string
+	// Subject is the subject of the message.
+	Subject string
+	// Body is the plain text body of the message.
+	Body string
+}
+
+// Mailer is an interface that defines the methods that a Mailer must implement.
+type Mailer interface {
+	Send(*Message) error
+}
+
+// LogMailer is a Mailer that writes messages to the log instead of sending them.
+// It is meant for development only, as the messages may contain secrets such as confirmation tokens.
+type LogMailer struct{}
+
+// NewLogMailer creates a new LogMailer.
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send writes the message to the log.
+func (m *LogMailer) Send(message *Message) error {
+	logger.Infof("Sending email to: %s, subject: %s, body: %s", message.To, message.Subject, message.Body)
+
+	return nil
+}
+
+// MemoryMailer is a Mailer that keeps messages in memory instead of sending them.
+// It is useful in tests. It is safe for concurrent use.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewMemoryMailer creates a new MemoryMailer.
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send keeps the message in memory.
+func (m *MemoryMailer) Send(message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+
+	return nil
+}
+
+// Messages returns all messages sent so far, in the order they have been sent.
+func (m *MemoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Message{}, m.messages...)
+}
+
+// LastMessage returns the last message sent to the given recipient or nil if there is none.
+func (m *MemoryMailer) LastMessage(to string) *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	require.Empty(t, m.Messages())
+	require.Nil(t, m.LastMessage("john@net.com"))
+
+	first := &Message{To: "john@net.com", Subject: "first", Body: "first body"}
+	second := &Message{To: "jane@net.com", Subject: "second", Body: "second body"}
+	third := &Message{To: "john@net.com", Subject: "third", Body: "third body"}
+
+	for _, message := range []*Message{first, second, third} {
+		require.NoError(t, m.Send(message))
+	}
+
+	require.Equal(t, []*Message{first, second, third}, m.Messages())
+	require.Equal(t, third, m.LastMessage("john@net.com"))
+	require.Equal(t, second, m.LastMessage("jane@net.com"))
+	require.Nil(t, m.LastMessage("other@net.com"))
+}
+
+func TestLogMailer(t *testing.T) {
+	require.NoError(t, NewLogMailer().Send(&Message{To: "john@net.com", Subject: "subject", Body: "body"}))
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+
+	m := NewFileMailer(path)
+	require.NoError(t, m.Send(&Message{To: "john@net.com", Subject: "first", Body: "first body"}))
+	require.NoError(t, m.Send(&Message{To: "jane@net.com", Subject: "second", Body: "second body"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	content := string(data)
+	require.Contains(t, content, "To: john@net.com\nSubject: first\n\nfirst body\n")
+	require.Contains(t, content, "To: jane@net.com\nSubject: second\n\nsecond body\n")
+	require.Less(t, strings.Index(content, "first body"), strings.Index(content, "second body"))
+
+	require.Error(t, NewFileMailer(filepath.Join(t.TempDir(), "missing", "mail.txt")).Send(&Message{To: "john@net.com"}))
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan smtpTransaction, 1)
+	go serveSMTP(listener, received)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	m := NewSMTPMailer("127.0.0.1", addr.Port, "", "", "noreply@bookrestapi.com")
+
+	err = m.Send(&Message{To: "john@net.com", Subject: "Reset your password", Body: "first line\nsecond line"})
+	require.NoError(t, err)
+
+	transaction := <-received
+	require.Equal(t, "<noreply@bookrestapi.com>", transaction.from)
+	require.Equal(t, "<john@net.com>", transaction.to)
+	require.Contains(t, transaction.data, "From: noreply@bookrestapi.com\r\n")
+	require.Contains(t, transaction.data, "To: john@net.com\r\n")
+	require.Contains(t, transaction.data, "Subject: Reset your password\r\n")
+	require.Contains(t, transaction.data, "Content-Type: text/plain; charset=UTF-8\r\n")
+	require.Contains(t, transaction.data, "\r\n\r\nfirst line\r\nsecond line\r\n")
+
+	// line breaks in headers are rejected to prevent header injection
+	err = m.Send(&Message{To: "john@net.com\r\nBcc: jane@net.com", Subject: "subject", Body: "body"})
+	require.ErrorIs(t, err, ErrInvalidMessage)
+
+	err = m.Send(&Message{To: "john@net.com", Subject: "subject\nBcc: jane@net.com", Body: "body"})
+	require.ErrorIs(t, err, ErrInvalidMessage)
+}
+
+// smtpTransaction is a message received by the fake SMTP server.
+type smtpTransaction struct {
+	from string
+	to   string
+	data string
+}
+
+// serveSMTP serves a single connection with a minimal fake SMTP server, which accepts every message.
+func serveSMTP(listener net.Listener, received chan<- smtpTransaction) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	transaction := smtpTransaction{}
+
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "EHLO" || command == "HELO":
+			_ = tp.PrintfLine("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			transaction.from = line[len("MAIL FROM:"):]
+			_ = tp.PrintfLine("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			transaction.to = line[len("RCPT TO:"):]
+			_ = tp.PrintfLine("250 OK")
+		case command == "DATA":
+			_ = tp.PrintfLine("354 Start mail input")
+
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+
+			transaction.data = strings.Join(lines, "\r\n") + "\r\n"
+			_ = tp.PrintfLine("250 OK")
+
+			received <- transaction
+		case command == "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidMessage is returned when a message has an invalid recipient or subject,
+	// for example one containing line breaks, which could be used to inject headers.
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// SMTPMailer is a Mailer that sends messages through an SMTP server.
+// STARTTLS is used if the server supports it. Authentication is used only if the username is set.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a new SMTPMailer that sends messages from the given address
+// through the SMTP server listening on the given host and port.
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		auth: auth,
+		from: from,
+	}
+}
+
+// Send sends the message through the SMTP server.
+func (m *SMTPMailer) Send(message *Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return ErrInvalidMessage
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{message.To}, m.buildMessage(message))
+}
+
+// buildMessage builds a plain text message in the Internet Message Format (RFC 5322).
+func (m *SMTPMailer) buildMessage(message *Message) []byte {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(buf, "\r\n")
+
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,542 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrDiscoveryFailed is returned when the metadata of a provider cannot be discovered or is invalid.
+	ErrDiscoveryFailed = errors.New("oidc discovery failed")
+	// ErrExchangeFailed is returned when an authorization code cannot be exchanged for tokens.
+	ErrExchangeFailed = errors.New("oidc code exchange failed")
+	// ErrInvalidIDToken is returned when an ID token has an invalid signature or invalid claims.
+	ErrInvalidIDToken = errors.New("invalid oidc id token")
+)
+
+const (
+	// DefaultLeeway is the default leeway allowed when validating time based claims of ID tokens to account for clock skew.
+	DefaultLeeway = time.Minute
+	// DefaultHTTPTimeout is the default timeout of requests sent to a provider.
+	DefaultHTTPTimeout = 10 * time.Second
+
+	// discoveryPath is the path of the discovery document relative to the issuer URL, as defined in OpenID Connect Discovery.
+	discoveryPath = "/.well-known/openid-configuration"
+	// verifierSize is the number of random bytes a PKCE code verifier is generated from, as recommended by RFC 7636.
+	verifierSize = 32
+	// minKeyRefreshInterval is the minimum interval between fetches of the provider keys triggered by an unknown key ID,
+	// so tokens with made up key IDs cannot make the provider be flooded with requests.
+	minKeyRefreshInterval = time.Minute
+	// maxResponseSize is the maximum size of a response read from a provider.
+	maxResponseSize = 1 << 20
+)
+
+// DefaultScopes are the default scopes requested from a provider.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// supportedSigningMethods are the signing methods of ID tokens which are accepted.
+// Symmetric methods are not accepted, as the client secret would be used as the key.
+var supportedSigningMethods = []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Config is a configuration of a client registered with a provider.
+type Config struct {
+	// IssuerURL is the URL of the provider, which its metadata is discovered from.
+	IssuerURL string
+	// ClientID is the identifier of the client.
+	ClientID string
+	// ClientSecret is the secret of a confidential client. It is empty for public clients.
+	ClientSecret string
+	// RedirectURL is the URL the provider redirects to after the user has authenticated.
+	RedirectURL string
+	// Scopes are the scopes requested from the provider. DefaultScopes are requested if it is empty.
+	Scopes []string
+}
+
+// Metadata represents the metadata of a provider as defined in OpenID Connect Discovery.
+type Metadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// TokenResponse represents a successful response of the token endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Claims represents the claims of a verified ID token.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Audience      []string
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is an OpenID Connect provider a client is registered with.
+// It is safe for concurrent use.
+type Provider struct {
+	config   Config
+	metadata Metadata
+	client   *http.Client
+	leeway   time.Duration
+
+	mu            sync.Mutex
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// Option is a function signature for providing options to configure a Provider.
+type Option func(*Provider)
+
+// WithHTTPClient is an option to set the HTTP client requests are sent to the provider with.
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Provider) {
+		p.client = client
+	}
+}
+
+// WithLeeway is an option to set the leeway allowed when validating time based claims of ID tokens.
+func WithLeeway(leeway time.Duration) Option {
+	return func(p *Provider) {
+		p.leeway = leeway
+	}
+}
+
+// Discover fetches the metadata of the provider at the issuer URL of the given configuration and returns the provider.
+// The issuer in the metadata must match the issuer URL and the provider must support PKCE with the S256 method.
+func Discover(ctx context.Context, config Config, opts ...Option) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: &http.Client{Timeout: DefaultHTTPTimeout},
+		leeway: DefaultLeeway,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if len(p.config.Scopes) == 0 {
+		p.config.Scopes = DefaultScopes
+	}
+	if !slices.Contains(p.config.Scopes, "openid") {
+		p.config.Scopes = append([]string{"openid"}, p.config.Scopes...)
+	}
+
+	if err := p.getJSON(ctx, strings.TrimSuffix(config.IssuerURL, "/")+discoveryPath, &p.metadata); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscoveryFailed, err)
+	}
+
+	if strings.TrimSuffix(p.metadata.Issuer, "/") != strings.TrimSuffix(config.IssuerURL, "/") {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscoveryFailed, p.metadata.Issuer, config.IssuerURL)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscoveryFailed)
+	}
+	if len(p.metadata.CodeChallengeMethodsSupported) > 0 && !slices.Contains(p.metadata.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("%w: S256 code challenge method is not supported", ErrDiscoveryFailed)
+	}
+
+	return p, nil
+}
+
+// Metadata returns the discovered metadata of the provider.
+func (p *Provider) Metadata() Metadata {
+	return p.metadata
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint the user is redirected to in order to authenticate.
+// The state is returned to the redirect URL unchanged, the nonce is put into the ID token and the code challenge
+// is derived from the verifier, which must be provided when exchanging the authorization code.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange exchanges the authorization code for tokens at the token endpoint.
+// The verifier must be the one the code challenge of the authorization request has been derived from.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*TokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}{}
+		if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Error == "" {
+			return nil, fmt.Errorf("%w: unexpected status %d", ErrExchangeFailed, resp.StatusCode)
+		}
+
+		return nil, fmt.Errorf("%w: %s %s", ErrExchangeFailed, errorResponse.Error, errorResponse.ErrorDescription)
+	}
+
+	tokenResponse := &TokenResponse{}
+	if err := json.Unmarshal(body, tokenResponse); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token in response", ErrExchangeFailed)
+	}
+
+	return tokenResponse, nil
+}
+
+// VerifyIDToken verifies the signature of the ID token with the keys published by the provider and validates its claims:
+// the issuer, the audience, the time based claims with the configured leeway and the nonce of the authorization request.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	claims := &idTokenClaims{}
+
+	parser := &jwt.Parser{ValidMethods: supportedSigningMethods, SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		return p.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case claims.Issuer != p.metadata.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	case claims.AuthorizedParty != "" && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party", ErrInvalidIDToken)
+	case claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.toTime().Add(p.leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt == nil || claims.IssuedAt.toTime().After(now.Add(p.leeway)):
+		return nil, fmt.Errorf("%w: invalid issue time", ErrInvalidIDToken)
+	case nonce != "" && claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: unexpected nonce", ErrInvalidIDToken)
+	}
+
+	return &Claims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Audience:      claims.Audience,
+		IssuedAt:      claims.IssuedAt.toTime(),
+		ExpiresAt:     claims.ExpiresAt.toTime(),
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// GenerateVerifier generates a random PKCE code verifier.
+func GenerateVerifier() (string, error) {
+	bytes := make([]byte, verifierSize)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CodeChallenge derives the PKCE code challenge from the verifier with the S256 method, as defined in RFC 7636.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// key returns the public key of the provider with the given ID.
+// The keys are fetched again if the ID is unknown, as the provider may have rotated its keys.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if p.keys != nil && time.Since(p.keysFetchedAt) < minKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]any{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.KeyID] = key
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+// getJSON fetches the document at the given URL and decodes it into v.
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// jwk is a public JSON Web Key as defined in RFC 7517.
+type jwk struct {
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	KeyID   string `json:"kid"`
+	// N and E are the modulus and the exponent of an RSA key.
+	N string `json:"n"`
+	E string `json:"e"`
+	// Curve, X and Y are the curve and the coordinates of an elliptic curve key.
+	// An octet key pair (Ed25519) key has only the curve and X, which is the public key.
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// publicKey returns the public key represented by the JWK.
+func (k jwk) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid elliptic curve point")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("invalid base64url encoded integer")
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// idTokenClaims is the JSON representation of the ID token claims.
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	IssuedAt        *numericDate `json:"iat"`
+	ExpiresAt       *numericDate `json:"exp"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+	GivenName       string       `json:"given_name"`
+	FamilyName      string       `json:"family_name"`
+}
+
+// Valid implements the jwt.Claims interface.
+// Claims are validated by VerifyIDToken, which takes the leeway into account, so there is nothing to do here.
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// audience is the aud claim, which is either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+
+	return nil
+}
+
+// numericDate is a time based claim, which is the number of seconds since the Unix epoch.
+type numericDate float64
+
+func (d *numericDate) toTime() time.Time {
+	seconds := float64(*d)
+
+	return time.Unix(int64(seconds), int64((seconds-float64(int64(seconds)))*float64(time.Second)))
+}
+
+// flexibleBool is a boolean claim, which some providers send as a string.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(v == "true")
+	default:
+		*b = false
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/MSSkowron/BookRESTAPI/pkg/oidc/oidctest"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testClientID     = "bookrestapi"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8080/auth/oidc/callback"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// The test vector of RFC 7636, appendix B.
+	require.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	verifier, err := GenerateVerifier()
+	require.NoError(t, err)
+	require.Len(t, verifier, 43)
+
+	otherVerifier, err := GenerateVerifier()
+	require.NoError(t, err)
+	require.NotEqual(t, verifier, otherVerifier)
+}
+
+func TestDiscover(t *testing.T) {
+	stub := newStubProvider(t)
+
+	provider, err := Discover(context.Background(), testConfig(stub))
+	require.NoError(t, err)
+	require.Equal(t, stub.Issuer(), provider.Metadata().Issuer)
+	require.Equal(t, stub.Issuer()+"/token", provider.Metadata().TokenEndpoint)
+
+	mismatch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer":"https://other.example.com","authorization_endpoint":"a","token_endpoint":"t","jwks_uri":"j"}`))
+	}))
+	defer mismatch.Close()
+
+	noPKCE := httptest.NewServer(nil)
+	noPKCE.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer":"` + noPKCE.URL + `","authorization_endpoint":"a","token_endpoint":"t","jwks_uri":"j","code_challenge_methods_supported":["plain"]}`))
+	})
+	defer noPKCE.Close()
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	data := []struct {
+		name      string
+		issuerURL string
+	}{
+		{
+			name:      "issuer mismatch",
+			issuerURL: mismatch.URL,
+		},
+		{
+			name:      "s256 not supported",
+			issuerURL: noPKCE.URL,
+		},
+		{
+			name:      "not found",
+			issuerURL: notFound.URL,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := Discover(context.Background(), Config{IssuerURL: d.issuerURL, ClientID: testClientID})
+			require.ErrorIs(t, err, ErrDiscoveryFailed)
+		})
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	stub := newStubProvider(t)
+
+	provider, err := Discover(context.Background(), testConfig(stub))
+	require.NoError(t, err)
+
+	verifier, err := GenerateVerifier()
+	require.NoError(t, err)
+
+	authCodeURL := provider.AuthCodeURL("state", "nonce", verifier)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+	require.Equal(t, "openid email profile", u.Query().Get("scope"))
+	require.Equal(t, CodeChallenge(verifier), u.Query().Get("code_challenge"))
+	require.Equal(t, testRedirectURL, u.Query().Get("redirect_uri"))
+
+	user := oidctest.User{Subject: "123", Email: "john@corp.example.com", EmailVerified: true, GivenName: "John", FamilyName: "Doe"}
+
+	redirectURL, err := stub.Login(authCodeURL, user)
+	require.NoError(t, err)
+	require.Equal(t, "state", redirectURL.Query().Get("state"))
+
+	code := redirectURL.Query().Get("code")
+
+	tokenResponse, err := provider.Exchange(context.Background(), code, verifier)
+	require.NoError(t, err)
+
+	claims, err := provider.VerifyIDToken(context.Background(), tokenResponse.IDToken, "nonce")
+	require.NoError(t, err)
+	require.Equal(t, stub.Issuer(), claims.Issuer)
+	require.Equal(t, "123", claims.Subject)
+	require.Equal(t, "john@corp.example.com", claims.Email)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, "John", claims.GivenName)
+	require.Equal(t, "Doe", claims.FamilyName)
+
+	// Codes are single use
+	_, err = provider.Exchange(context.Background(), code, verifier)
+	require.ErrorIs(t, err, ErrExchangeFailed)
+
+	// The verifier must match the code challenge
+	redirectURL, err = stub.Login(provider.AuthCodeURL("state", "nonce", verifier), user)
+	require.NoError(t, err)
+
+	otherVerifier, err := GenerateVerifier()
+	require.NoError(t, err)
+
+	_, err = provider.Exchange(context.Background(), redirectURL.Query().Get("code"), otherVerifier)
+	require.ErrorIs(t, err, ErrExchangeFailed)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	stub := newStubProvider(t)
+
+	provider, err := Discover(context.Background(), testConfig(stub))
+	require.NoError(t, err)
+
+	otherStub := newStubProvider(t)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            stub.Issuer(),
+			"sub":            "123",
+			"aud":            []string{testClientID, "other"},
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          "nonce",
+			"email":          "john@corp.example.com",
+			"email_verified": "true",
+		}
+	}
+
+	data := []struct {
+		name          string
+		modify        func(jwt.MapClaims)
+		signer        *oidctest.Provider
+		expectedError error
+	}{
+		{
+			name:   "valid",
+			modify: func(jwt.MapClaims) {},
+		},
+		{
+			name:          "missing subject",
+			modify:        func(c jwt.MapClaims) { delete(c, "sub") },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "unexpected issuer",
+			modify:        func(c jwt.MapClaims) { c["iss"] = otherStub.Issuer() },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "unexpected audience",
+			modify:        func(c jwt.MapClaims) { c["aud"] = "other" },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "unexpected authorized party",
+			modify:        func(c jwt.MapClaims) { c["azp"] = "other" },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "expired",
+			modify:        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * DefaultLeeway).Unix() },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "issued in the future",
+			modify:        func(c jwt.MapClaims) { c["iat"] = time.Now().Add(2 * DefaultLeeway).Unix() },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "unexpected nonce",
+			modify:        func(c jwt.MapClaims) { c["nonce"] = "other" },
+			expectedError: ErrInvalidIDToken,
+		},
+		{
+			name:          "signed with another key",
+			modify:        func(jwt.MapClaims) {},
+			signer:        otherStub,
+			expectedError: ErrInvalidIDToken,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			claims := validClaims()
+			d.modify(claims)
+
+			signer := stub
+			if d.signer != nil {
+				signer = d.signer
+			}
+
+			idToken, err := signer.SignIDToken(claims)
+			require.NoError(t, err)
+
+			verifiedClaims, err := provider.VerifyIDToken(context.Background(), idToken, "nonce")
+			require.ErrorIs(t, err, d.expectedError)
+			if d.expectedError == nil {
+				require.Equal(t, "123", verifiedClaims.Subject)
+				require.True(t, verifiedClaims.EmailVerified)
+			}
+		})
+	}
+
+	// Symmetric signatures are not accepted
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte(testClientSecret))
+	require.NoError(t, err)
+
+	_, err = provider.VerifyIDToken(context.Background(), idToken, "nonce")
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func newStubProvider(t *testing.T) *oidctest.Provider {
+	stub, err := oidctest.NewProvider(testClientID, testClientSecret)
+	require.NoError(t, err)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func testConfig(stub *oidctest.Provider) Config {
+	return Config{
+		IssuerURL:    stub.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}
+}
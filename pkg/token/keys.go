@@ -0,0 +1,299 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrInvalidKey is returned when a key cannot be parsed or is of an unsupported type.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrUnknownKey is returned when a token is signed with a key that is not in the key ring or has expired.
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// Key is a key used to sign and verify tokens.
+type Key struct {
+	// ID is the key ID (kid) put into the header of tokens signed with the key.
+	ID string
+	// Method is the signing method the key is used with.
+	Method jwt.SigningMethod
+	// ExpiresAt is the time after which the key no longer verifies tokens. A zero time means the key never expires.
+	ExpiresAt time.Time
+
+	signingKey      any
+	verificationKey any
+}
+
+// NewHMACKey creates a new symmetric key for the HS256 signing method.
+// Symmetric keys are never published in the JWKS, as they are secret.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:              id,
+		Method:          jwt.SigningMethodHS256,
+		signingKey:      secret,
+		verificationKey: secret,
+	}
+}
+
+// NewKeyFromPEM creates a new asymmetric key from a PEM encoded private key.
+// RSA keys (PKCS #1 or PKCS #8) are used with RS256 and Ed25519 keys (PKCS #8) are used with EdDSA.
+// If id is empty, the RFC 7638 thumbprint of the public key is used as the key ID.
+func NewKeyFromPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+
+	var privateKey any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+
+		privateKey = rsaKey
+	case "PRIVATE KEY":
+		pkcs8Key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+
+		privateKey = pkcs8Key
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	key := &Key{ID: id}
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.signingKey = k
+		key.verificationKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.signingKey = k
+		key.verificationKey = k.Public().(ed25519.PublicKey)
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	if key.ID == "" {
+		jwk, _ := key.PublicJWK()
+		key.ID = jwk.thumbprint()
+	}
+
+	return key, nil
+}
+
+// LoadKeyFromFile creates a new asymmetric key from a PEM file. See NewKeyFromPEM for details.
+func LoadKeyFromFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyFromPEM(id, data)
+}
+
+// PublicJWK returns the public part of the key as a JSON Web Key.
+// It reports false for symmetric keys, which have no public part.
+func (k *Key) PublicJWK() (JWK, bool) {
+	switch pub := k.verificationKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			KeyID:     k.ID,
+			Algorithm: k.Method.Alg(),
+			N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			KeyID:     k.ID,
+			Algorithm: k.Method.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
+
+func (k *Key) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// JWK represents a public JSON Web Key as defined in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// N and E are the modulus and the exponent of an RSA key.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the curve and the public key of an octet key pair (Ed25519) key.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the key.
+func (j JWK) thumbprint() string {
+	var members any
+	switch j.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KeyType, j.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Curve, j.KeyType, j.X}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeyRing holds the key used to sign new tokens and the keys still accepted when verifying tokens.
+// It is safe for concurrent use.
+type KeyRing struct {
+	mu         sync.RWMutex
+	signingKey *Key
+	keys       map[string]*Key
+}
+
+// NewKeyRing creates a new KeyRing that signs tokens with the signing key
+// and verifies tokens signed with the signing key or any of the verification keys.
+func NewKeyRing(signingKey *Key, verificationKeys ...*Key) *KeyRing {
+	kr := &KeyRing{
+		signingKey: signingKey,
+		keys:       map[string]*Key{signingKey.ID: signingKey},
+	}
+
+	for _, key := range verificationKeys {
+		kr.keys[key.ID] = key
+	}
+
+	return kr
+}
+
+// NewHMACKeyRing creates a new KeyRing with a single symmetric key without a key ID.
+func NewHMACKeyRing(secret string) *KeyRing {
+	return NewKeyRing(NewHMACKey("", []byte(secret)))
+}
+
+// SigningKey returns the key used to sign new tokens.
+func (kr *KeyRing) SigningKey() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.signingKey
+}
+
+// VerificationKey returns the key with the given ID, as long as it has not expired.
+func (kr *KeyRing) VerificationKey(id string) (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[id]
+	if !ok || key.expired(time.Now()) {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// AddVerificationKey adds a key that is accepted when verifying tokens, but is not used to sign new tokens.
+func (kr *KeyRing) AddVerificationKey(key *Key) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if key.ID == kr.signingKey.ID {
+		return
+	}
+
+	kr.keys[key.ID] = key
+}
+
+// Rotate makes the given key the signing key.
+// The previous signing key keeps verifying tokens for the retention period, which should be
+// at least the lifetime of tokens, so the tokens it has signed stay valid until they expire.
+// Keys that have already expired are removed from the key ring.
+func (kr *KeyRing) Rotate(newKey *Key, retention time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := time.Now()
+	if kr.signingKey.ID != newKey.ID {
+		kr.signingKey.ExpiresAt = now.Add(retention)
+	}
+
+	kr.signingKey = newKey
+	kr.keys[newKey.ID] = newKey
+
+	for id, key := range kr.keys {
+		if key.expired(now) {
+			delete(kr.keys, id)
+		}
+	}
+}
+
+// JWKS returns the public keys of the key ring that have not expired as a JSON Web Key Set.
+// The signing key comes first, the remaining keys are ordered by ID.
+func (kr *KeyRing) JWKS() JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+
+	verificationKeys := []JWK{}
+	for _, key := range kr.keys {
+		if key == kr.signingKey || key.expired(now) {
+			continue
+		}
+
+		if jwk, ok := key.PublicJWK(); ok {
+			verificationKeys = append(verificationKeys, jwk)
+		}
+	}
+
+	sort.Slice(verificationKeys, func(i, j int) bool { return verificationKeys[i].KeyID < verificationKeys[j].KeyID })
+
+	jwks := JWKS{Keys: []JWK{}}
+	if jwk, ok := kr.signingKey.PublicJWK(); ok {
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	jwks.Keys = append(jwks.Keys, verificationKeys...)
+
+	return jwks
+}
+
+// JWKS represents a JSON Web Key Set as defined in RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
@@ -0,0 +1,197 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyFromPEM(t *testing.T) {
+	rsaKey, rsaPEM := generateRSAKey(t)
+	_, ed25519PEM := generateEd25519Key(t)
+
+	data := []struct {
+		name           string
+		pem            []byte
+		expectedMethod jwt.SigningMethod
+		expectedErr    error
+	}{
+		{
+			name:           "rsa pkcs1",
+			pem:            pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			expectedMethod: jwt.SigningMethodRS256,
+		},
+		{
+			name:           "rsa pkcs8",
+			pem:            rsaPEM,
+			expectedMethod: jwt.SigningMethodRS256,
+		},
+		{
+			name:           "ed25519",
+			pem:            ed25519PEM,
+			expectedMethod: jwt.SigningMethodEdDSA,
+		},
+		{
+			name:        "not pem",
+			pem:         []byte("not a pem"),
+			expectedErr: ErrInvalidKey,
+		},
+		{
+			name:        "public key",
+			pem:         pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("public")}),
+			expectedErr: ErrInvalidKey,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			key, err := NewKeyFromPEM("", d.pem)
+			require.Equal(t, d.expectedErr, err)
+
+			if err == nil {
+				require.Equal(t, d.expectedMethod, key.Method)
+				require.NotEmpty(t, key.ID)
+
+				tokenString, err := Generate(testUserID, testUserEmail, NewKeyRing(key), testExpirationTime)
+				require.NoError(t, err)
+				require.NoError(t, Validate(tokenString, NewKeyRing(key)))
+			}
+		})
+	}
+}
+
+func TestLoadKeyFromFile(t *testing.T) {
+	_, rsaPEM := generateRSAKey(t)
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, rsaPEM, 0o600))
+
+	key, err := LoadKeyFromFile("key-1", path)
+	require.NoError(t, err)
+	require.Equal(t, "key-1", key.ID)
+
+	_, err = LoadKeyFromFile("key-2", filepath.Join(t.TempDir(), "missing.pem"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestKeyRing(t *testing.T) {
+	_, rsaPEM := generateRSAKey(t)
+	_, ed25519PEM := generateEd25519Key(t)
+
+	oldKey, err := NewKeyFromPEM("", rsaPEM)
+	require.NoError(t, err)
+	newKey, err := NewKeyFromPEM("", ed25519PEM)
+	require.NoError(t, err)
+
+	keyRing := NewKeyRing(oldKey)
+
+	oldToken, err := Generate(testUserID, testUserEmail, keyRing, testExpirationTime)
+	require.NoError(t, err)
+
+	header, err := jwt.Parse(oldToken, nil)
+	require.Error(t, err)
+	require.Equal(t, oldKey.ID, header.Header["kid"])
+	require.Equal(t, "RS256", header.Header["alg"])
+
+	// After rotation, new tokens are signed with the new key and old tokens keep verifying.
+	keyRing.Rotate(newKey, time.Hour)
+
+	newToken, err := Generate(testUserID, testUserEmail, keyRing, testExpirationTime)
+	require.NoError(t, err)
+	require.NoError(t, Validate(newToken, keyRing))
+	require.NoError(t, Validate(oldToken, keyRing))
+
+	jwks := keyRing.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, newKey.ID, jwks.Keys[0].KeyID)
+	require.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	require.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+	require.Equal(t, oldKey.ID, jwks.Keys[1].KeyID)
+	require.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	require.Equal(t, "AQAB", jwks.Keys[1].E)
+
+	// Once the retention period is over, the old key no longer verifies tokens and is not published.
+	keyRing.mu.Lock()
+	oldKey.ExpiresAt = time.Now().Add(-time.Second)
+	keyRing.mu.Unlock()
+
+	require.ErrorIs(t, Validate(oldToken, keyRing), ErrInvalidToken)
+	require.NoError(t, Validate(newToken, keyRing))
+	require.Len(t, keyRing.JWKS().Keys, 1)
+
+	// Tokens signed with unknown keys are rejected.
+	require.ErrorIs(t, Validate(newToken, NewKeyRing(oldKey)), ErrInvalidToken)
+
+	// Symmetric keys are never published.
+	require.Empty(t, NewHMACKeyRing(testSecret).JWKS().Keys)
+}
+
+func TestKeyRingAlgorithmConfusion(t *testing.T) {
+	_, rsaPEM := generateRSAKey(t)
+
+	key, err := NewKeyFromPEM("", rsaPEM)
+	require.NoError(t, err)
+
+	keyRing := NewKeyRing(key)
+	jwk, ok := key.PublicJWK()
+	require.True(t, ok)
+
+	// A token signed with HS256, using the public key as the secret, must not be accepted.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = jwk.KeyID
+
+	tokenString, err := token.SignedString([]byte(jwk.N))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, Validate(tokenString, keyRing), ErrInvalidToken)
+}
+
+func TestAddVerificationKey(t *testing.T) {
+	_, rsaPEM := generateRSAKey(t)
+
+	key, err := NewKeyFromPEM("", rsaPEM)
+	require.NoError(t, err)
+
+	legacyKeyRing := NewHMACKeyRing(testSecret)
+	legacyToken, err := Generate(testUserID, testUserEmail, legacyKeyRing, testExpirationTime)
+	require.NoError(t, err)
+
+	keyRing := NewKeyRing(key)
+	require.ErrorIs(t, Validate(legacyToken, keyRing), ErrInvalidToken)
+
+	keyRing.AddVerificationKey(legacyKeyRing.SigningKey())
+	require.NoError(t, Validate(legacyToken, keyRing))
+	require.Len(t, keyRing.JWKS().Keys, 1)
+}
+
+func generateRSAKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func generateEd25519Key(t *testing.T) (ed25519.PrivateKey, []byte) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
@@ -1,9 +1,13 @@
 package token
 
 import (
+	"encoding/json"
 	"errors"
+	"math"
+	"strconv"
 	"time"
 
+	"github.com/MSSkowron/BookRESTAPI/pkg/crypto"
 	"github.com/golang-jwt/jwt"
 )
 
@@ -14,71 +18,360 @@ var (
 	ErrExpiredToken = errors.New("token is expired")
 	// ErrInvalidSignature is returned when the token signature is invalid.
 	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrTokenNotValidYet is returned when the token is used before its not before time.
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	// ErrInvalidIssuer is returned when the token has not been issued by the expected issuer.
+	ErrInvalidIssuer = errors.New("invalid issuer")
+	// ErrInvalidAudience is returned when the token is not intended for the expected audience.
+	ErrInvalidAudience = errors.New("invalid audience")
 )
 
+const (
+	// idSize is the number of random bytes a token ID is generated from.
+	idSize = 16
+)
+
+// Claims represents the claims contained in a JWT token.
+type Claims struct {
+	// ID is a unique identifier of the token (jti).
+	ID string
+	// UserID is the ID of the user the token has been issued to (sub).
+	UserID int
+	// Email is the email address of the user the token has been issued to.
+	Email string
+	// Role is the role of the user the token has been issued to. It is empty for tokens issued without a role.
+	Role string
+	// SessionID is the ID of the session the token has been issued in (sid). It is empty for tokens issued without a session.
+	SessionID string
+	// Issuer identifies the principal that issued the token (iss).
+	Issuer string
+	// Audience identifies the recipients the token is intended for (aud).
+	Audience []string
+	// IssuedAt is the time at which the token has been issued (iat).
+	IssuedAt time.Time
+	// NotBefore is the time before which the token must not be accepted (nbf).
+	NotBefore time.Time
+	// ExpiresAt is the expiration time of the token (exp).
+	ExpiresAt time.Time
+	// Legacy reports whether the token uses the custom id and expiresAt claims
+	// that have been used before registered claims were adopted.
+	Legacy bool
+}
+
+// Option is a function signature for providing options to configure token generation and validation.
+type Option func(*options)
+
+type options struct {
+	issuer       string
+	audience     []string
+	leeway       time.Duration
+	acceptLegacy bool
+	role         string
+	sessionID    string
+}
+
+// WithIssuer is an option to set the issuer put into generated tokens and required from validated tokens.
+func WithIssuer(issuer string) Option {
+	return func(o *options) {
+		o.issuer = issuer
+	}
+}
+
+// WithAudience is an option to set the audience put into generated tokens.
+// Validated tokens must be intended for at least one of the given recipients.
+func WithAudience(audience ...string) Option {
+	return func(o *options) {
+		o.audience = audience
+	}
+}
+
+// WithLeeway is an option to set the leeway allowed when validating time based claims to account for clock skew.
+func WithLeeway(leeway time.Duration) Option {
+	return func(o *options) {
+		o.leeway = leeway
+	}
+}
+
+// WithLegacyFormat is an option to accept tokens in the legacy format, with custom id and expiresAt claims.
+// It is meant to be enabled only during a migration window, until all legacy tokens have expired.
+func WithLegacyFormat(accept bool) Option {
+	return func(o *options) {
+		o.acceptLegacy = accept
+	}
+}
+
+// WithRole is an option to set the role of the user put into generated tokens.
+func WithRole(role string) Option {
+	return func(o *options) {
+		o.role = role
+	}
+}
+
+// WithSessionID is an option to set the ID of the session put into generated tokens.
+func WithSessionID(sessionID string) Option {
+	return func(o *options) {
+		o.sessionID = sessionID
+	}
+}
+
 // Generate generates a new JWT token.
-// The token is signed with the given secret.
-// The token contains the user ID, email address and expiration time.
-func Generate(userID int, userEmail, secret string, expirationTime time.Duration) (tokenString string, err error) {
-	claims := &jwt.MapClaims{
-		"id":        userID,
-		"email":     userEmail,
-		"expiresAt": time.Now().Add(expirationTime).Unix(),
+// The token is signed with the signing key of the given key ring and carries its key ID (kid) in the header.
+// The token contains registered claims: a unique token ID, the user ID as the subject, the issue, not before
+// and expiration times and, if configured, the issuer and audience. It contains the email address and the role of the user
+// and the ID of the session as well.
+func Generate(userID int, userEmail string, keys *KeyRing, expirationTime time.Duration, opts ...Option) (tokenString string, err error) {
+	o := newOptions(opts)
+
+	id, err := crypto.GenerateRandomString(idSize)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	now := time.Now()
 
-	return token.SignedString([]byte(secret))
+	claims := &jwtClaims{
+		ID:        id,
+		Subject:   strconv.Itoa(userID),
+		Issuer:    o.issuer,
+		Audience:  o.audience,
+		IssuedAt:  newNumericDate(now.Truncate(time.Millisecond)),
+		NotBefore: newNumericDate(now.Truncate(time.Second)),
+		ExpiresAt: newNumericDate(now.Add(expirationTime).Truncate(time.Second)),
+		Email:     userEmail,
+		Role:      o.role,
+		SessionID: o.sessionID,
+	}
+
+	key := keys.SigningKey()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	return token.SignedString(key.signingKey)
 }
 
 // Validate validates the given JWT token.
-func Validate(tokenString, secret string) error {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, ErrInvalidSignature
-		}
+// It verifies the signature, the time based claims with the configured leeway, the issuer and the audience.
+func Validate(tokenString string, keys *KeyRing, opts ...Option) error {
+	_, err := GetValidatedClaims(tokenString, keys, opts...)
+	return err
+}
 
-		return []byte(secret), nil
-	})
+// GetValidatedClaims validates the given JWT token like Validate and returns its claims, so it is parsed only once.
+func GetValidatedClaims(tokenString string, keys *KeyRing, opts ...Option) (*Claims, error) {
+	o := newOptions(opts)
+
+	claims, err := parse(tokenString, keys, o)
 	if err != nil {
-		return ErrInvalidToken
+		return nil, err
 	}
 
-	if !token.Valid {
-		return ErrInvalidToken
+	now := time.Now()
+
+	if now.After(claims.ExpiresAt.Add(o.leeway)) {
+		return nil, ErrExpiredToken
+	}
+	if !claims.NotBefore.IsZero() && now.Add(o.leeway).Before(claims.NotBefore) {
+		return nil, ErrTokenNotValidYet
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(o.leeway).Before(claims.IssuedAt) {
+		return nil, ErrTokenNotValidYet
 	}
 
-	expiresAt, ok := token.Claims.(jwt.MapClaims)["expiresAt"].(float64)
-	if !ok {
-		return ErrInvalidToken
+	if claims.Legacy {
+		return claims, nil
 	}
 
-	if int64(expiresAt) < time.Now().Local().Unix() {
-		return ErrExpiredToken
+	if o.issuer != "" && claims.Issuer != o.issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if len(o.audience) > 0 && !containsAny(claims.Audience, o.audience) {
+		return nil, ErrInvalidAudience
 	}
 
-	return nil
+	return claims, nil
 }
 
 // GetUserID retrieves the user ID from the given JWT token.
-func GetUserID(tokenString, secret string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
+func GetUserID(tokenString string, keys *KeyRing, opts ...Option) (int, error) {
+	claims, err := GetClaims(tokenString, keys, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	return claims.UserID, nil
+}
+
+// GetClaims retrieves the claims from the given JWT token.
+// It verifies the signature only, use Validate to validate the claims.
+// Legacy tokens have an empty ID and a zero issue time.
+func GetClaims(tokenString string, keys *KeyRing, opts ...Option) (*Claims, error) {
+	return parse(tokenString, keys, newOptions(opts))
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// parse parses the given JWT token, verifies its signature and returns its claims.
+// The verification key is selected by the key ID (kid) from the token header and must match the signing method.
+func parse(tokenString string, keys *KeyRing, o *options) (*Claims, error) {
+	claims := &jwtClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.Method.Alg() {
 			return nil, ErrInvalidSignature
 		}
 
-		return []byte(secret), nil
+		return key.verificationKey, nil
 	})
 	if err != nil {
-		return 0, ErrInvalidToken
+		return nil, ErrInvalidToken
+	}
+
+	if !token.Valid {
+		return nil, ErrInvalidToken
 	}
 
-	userID, ok := token.Claims.(jwt.MapClaims)["id"].(float64)
-	if !ok {
-		return 0, ErrInvalidToken
+	if claims.Subject == "" {
+		if !o.acceptLegacy || claims.LegacyUserID == nil || claims.LegacyExpiresAt == nil {
+			return nil, ErrInvalidToken
+		}
+
+		return &Claims{
+			UserID:    int(*claims.LegacyUserID),
+			Email:     claims.Email,
+			ExpiresAt: time.Unix(int64(*claims.LegacyExpiresAt), 0),
+			Legacy:    true,
+		}, nil
 	}
 
-	return int(userID), nil
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Claims{
+		ID:        claims.ID,
+		UserID:    userID,
+		Email:     claims.Email,
+		Role:      claims.Role,
+		SessionID: claims.SessionID,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		IssuedAt:  claims.IssuedAt.toTime(),
+		NotBefore: claims.NotBefore.toTime(),
+		ExpiresAt: claims.ExpiresAt.toTime(),
+	}, nil
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jwtClaims is the JSON representation of the token claims.
+type jwtClaims struct {
+	ID        string       `json:"jti,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Issuer    string       `json:"iss,omitempty"`
+	Audience  audience     `json:"aud,omitempty"`
+	IssuedAt  *numericDate `json:"iat,omitempty"`
+	NotBefore *numericDate `json:"nbf,omitempty"`
+	ExpiresAt *numericDate `json:"exp,omitempty"`
+	Email     string       `json:"email,omitempty"`
+	Role      string       `json:"role,omitempty"`
+	SessionID string       `json:"sid,omitempty"`
+
+	LegacyUserID    *float64 `json:"id,omitempty"`
+	LegacyExpiresAt *float64 `json:"expiresAt,omitempty"`
+}
+
+// Valid implements the jwt.Claims interface.
+// Claims are validated by Validate, which takes the leeway into account, so there is nothing to do here.
+func (c *jwtClaims) Valid() error {
+	return nil
+}
+
+// audience is the aud claim, which is either a single string or an array of strings.
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+
+	return nil
+}
+
+// numericDate is a JSON numeric value representing the number of seconds since the epoch.
+// It keeps millisecond precision.
+type numericDate struct {
+	time.Time
+}
+
+func newNumericDate(t time.Time) *numericDate {
+	return &numericDate{Time: t}
+}
+
+// toTime returns the time the date represents or a zero time if the date is nil.
+func (d *numericDate) toTime() time.Time {
+	if d == nil {
+		return time.Time{}
+	}
+
+	return d.Time
+}
+
+func (d numericDate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(d.UnixMilli())/1000, 'f', -1, 64)), nil
+}
+
+func (d *numericDate) UnmarshalJSON(data []byte) error {
+	seconds, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return err
+	}
+
+	d.Time = time.UnixMilli(int64(math.Round(seconds * 1000)))
+
+	return nil
 }
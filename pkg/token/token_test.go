@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,6 +15,8 @@ const (
 	testExpirationTime = time.Hour
 )
 
+var testKeys = NewHMACKeyRing(testSecret)
+
 func TestToken(t *testing.T) {
 	data := []struct {
 		name                   string
@@ -84,19 +87,173 @@ func TestToken(t *testing.T) {
 
 	for _, d := range data {
 		t.Run(d.name, func(t *testing.T) {
-			tokenString, err := Generate(d.tokenUserID, d.tokenUserEmail, d.generateSecret, d.tokenExpirationTime)
+			tokenString, err := Generate(d.tokenUserID, d.tokenUserEmail, NewHMACKeyRing(d.generateSecret), d.tokenExpirationTime)
 			require.Equal(t, d.expectedGenerateError, err)
 
-			err = Validate(tokenString, d.validateSecret)
+			err = Validate(tokenString, NewHMACKeyRing(d.validateSecret))
 			require.Equal(t, d.expectedValidateError, err)
 
 			if err == nil {
-				userID, err := GetUserID(tokenString, d.getUserIDSecret)
+				userID, err := GetUserID(tokenString, NewHMACKeyRing(d.getUserIDSecret))
 				require.Equal(t, d.expectedGetUserIDError, err)
 				require.Equal(t, d.expectedUserID, userID)
 			}
 		})
 	}
 
-	require.ErrorIs(t, Validate("123XDTOKEN", testSecret), ErrInvalidToken)
+	require.ErrorIs(t, Validate("123XDTOKEN", testKeys), ErrInvalidToken)
+}
+
+func TestGetClaims(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+
+	first, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime)
+	require.NoError(t, err)
+
+	second, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime)
+	require.NoError(t, err)
+
+	firstClaims, err := GetClaims(first, testKeys)
+	require.NoError(t, err)
+	require.NotEmpty(t, firstClaims.ID)
+	require.Equal(t, testUserID, firstClaims.UserID)
+	require.Equal(t, testUserEmail, firstClaims.Email)
+	require.Empty(t, firstClaims.Role)
+	require.Empty(t, firstClaims.SessionID)
+	require.False(t, firstClaims.IssuedAt.Before(before))
+	require.False(t, firstClaims.IssuedAt.After(time.Now()))
+	require.WithinDuration(t, time.Now().Add(testExpirationTime), firstClaims.ExpiresAt, 2*time.Second)
+
+	secondClaims, err := GetClaims(second, testKeys)
+	require.NoError(t, err)
+	require.NotEqual(t, firstClaims.ID, secondClaims.ID)
+
+	_, err = GetClaims(first, NewHMACKeyRing("invalidsecret321"))
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRoleClaim(t *testing.T) {
+	tokenString, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime, WithRole("admin"))
+	require.NoError(t, err)
+
+	claims, err := GetClaims(tokenString, testKeys)
+	require.NoError(t, err)
+	require.Equal(t, "admin", claims.Role)
+}
+
+func TestSessionIDClaim(t *testing.T) {
+	tokenString, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime, WithSessionID("42"))
+	require.NoError(t, err)
+
+	claims, err := GetClaims(tokenString, testKeys)
+	require.NoError(t, err)
+	require.Equal(t, "42", claims.SessionID)
+}
+
+func TestRegisteredClaims(t *testing.T) {
+	tokenString, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime, WithIssuer("issuer"), WithAudience("audience"))
+	require.NoError(t, err)
+
+	// The token can be validated by a standard library, which knows nothing about this package.
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		return []byte(testSecret), nil
+	})
+	require.NoError(t, err)
+	require.True(t, token.Valid)
+
+	claims := token.Claims.(jwt.MapClaims)
+	require.Equal(t, "1", claims["sub"])
+	require.Equal(t, "issuer", claims["iss"])
+	require.Equal(t, "audience", claims["aud"])
+	require.Equal(t, testUserEmail, claims["email"])
+	require.NotEmpty(t, claims["jti"])
+	require.True(t, claims.VerifyIssuer("issuer", true))
+	require.True(t, claims.VerifyAudience("audience", true))
+	require.True(t, claims.VerifyExpiresAt(time.Now().Unix(), true))
+	require.True(t, claims.VerifyIssuedAt(time.Now().Unix(), true))
+	require.True(t, claims.VerifyNotBefore(time.Now().Unix(), true))
+	require.NotContains(t, claims, "id")
+	require.NotContains(t, claims, "expiresAt")
+
+	// Multiple audiences are encoded as an array.
+	tokenString, err = Generate(testUserID, testUserEmail, testKeys, testExpirationTime, WithAudience("first", "second"))
+	require.NoError(t, err)
+
+	result, err := GetClaims(tokenString, testKeys)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, result.Audience)
+	require.False(t, result.Legacy)
+}
+
+func TestValidateIssuerAndAudience(t *testing.T) {
+	tokenString, err := Generate(testUserID, testUserEmail, testKeys, testExpirationTime, WithIssuer("issuer"), WithAudience("first", "second"))
+	require.NoError(t, err)
+
+	require.NoError(t, Validate(tokenString, testKeys))
+	require.NoError(t, Validate(tokenString, testKeys, WithIssuer("issuer"), WithAudience("second")))
+	require.NoError(t, Validate(tokenString, testKeys, WithAudience("third", "first")))
+	require.ErrorIs(t, Validate(tokenString, testKeys, WithIssuer("other")), ErrInvalidIssuer)
+	require.ErrorIs(t, Validate(tokenString, testKeys, WithAudience("third")), ErrInvalidAudience)
+
+	claims, err := GetValidatedClaims(tokenString, testKeys, WithIssuer("issuer"), WithAudience("second"))
+	require.NoError(t, err)
+	require.Equal(t, testUserID, claims.UserID)
+	require.Equal(t, []string{"first", "second"}, claims.Audience)
+
+	_, err = GetValidatedClaims(tokenString, testKeys, WithIssuer("other"))
+	require.ErrorIs(t, err, ErrInvalidIssuer)
+}
+
+func TestValidateLeeway(t *testing.T) {
+	tokenString, err := Generate(testUserID, testUserEmail, testKeys, -10*time.Second)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, Validate(tokenString, testKeys), ErrExpiredToken)
+	require.NoError(t, Validate(tokenString, testKeys, WithLeeway(time.Minute)))
+
+	// A token issued by a server with a clock running ahead.
+	notYetValid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "1",
+		"iat": time.Now().Add(10 * time.Second).Unix(),
+		"nbf": time.Now().Add(10 * time.Second).Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, Validate(notYetValid, testKeys), ErrTokenNotValidYet)
+	require.NoError(t, Validate(notYetValid, testKeys, WithLeeway(time.Minute)))
+}
+
+func TestLegacyFormat(t *testing.T) {
+	legacyToken := func(expiresAt time.Time) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":        testUserID,
+			"email":     testUserEmail,
+			"expiresAt": expiresAt.Unix(),
+		}).SignedString([]byte(testSecret))
+		require.NoError(t, err)
+
+		return tokenString
+	}
+
+	valid := legacyToken(time.Now().Add(time.Hour))
+	expired := legacyToken(time.Now().Add(-time.Hour))
+
+	// Legacy tokens are rejected unless explicitly accepted.
+	require.ErrorIs(t, Validate(valid, testKeys), ErrInvalidToken)
+
+	_, err := GetUserID(valid, testKeys)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// Legacy tokens are accepted during the migration window, without issuer and audience checks.
+	require.NoError(t, Validate(valid, testKeys, WithLegacyFormat(true), WithIssuer("issuer"), WithAudience("audience")))
+	require.ErrorIs(t, Validate(expired, testKeys, WithLegacyFormat(true)), ErrExpiredToken)
+
+	claims, err := GetClaims(valid, testKeys, WithLegacyFormat(true))
+	require.NoError(t, err)
+	require.True(t, claims.Legacy)
+	require.Equal(t, testUserID, claims.UserID)
+	require.Equal(t, testUserEmail, claims.Email)
+	require.Empty(t, claims.ID)
+	require.True(t, claims.IssuedAt.IsZero())
 }
@@ -0,0 +1,125 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	// The RFC 6238 test vectors are 8 digits long, the codes are their last 6 digits.
+	data := []struct {
+		name         string
+		time         int64
+		expectedCode string
+	}{
+		{
+			name:         "59",
+			time:         59,
+			expectedCode: "287082",
+		},
+		{
+			name:         "1111111109",
+			time:         1111111109,
+			expectedCode: "081804",
+		},
+		{
+			name:         "1111111111",
+			time:         1111111111,
+			expectedCode: "050471",
+		},
+		{
+			name:         "1234567890",
+			time:         1234567890,
+			expectedCode: "005924",
+		},
+		{
+			name:         "2000000000",
+			time:         2000000000,
+			expectedCode: "279037",
+		},
+		{
+			name:         "20000000000",
+			time:         20000000000,
+			expectedCode: "353130",
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			code, err := GenerateCode(rfcSecret, time.Unix(d.time, 0))
+			require.NoError(t, err)
+			require.Equal(t, d.expectedCode, code)
+		})
+	}
+
+	_, err := GenerateCode("invalid secret!", time.Now())
+	require.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	require.Len(t, secret, 32)
+
+	now := time.Unix(1700000000, 0)
+
+	code, err := GenerateCode(secret, now)
+	require.NoError(t, err)
+
+	step, ok := Validate(secret, code, now)
+	require.True(t, ok)
+	require.Equal(t, Step(now), step)
+
+	// Codes of the adjacent periods are accepted
+	step, ok = Validate(secret, code, now.Add(Period))
+	require.True(t, ok)
+	require.Equal(t, Step(now), step)
+
+	step, ok = Validate(secret, code, now.Add(-Period))
+	require.True(t, ok)
+	require.Equal(t, Step(now), step)
+
+	// Codes of other periods are rejected
+	_, ok = Validate(secret, code, now.Add(2*Period))
+	require.False(t, ok)
+
+	_, ok = Validate(secret, code, now.Add(-2*Period))
+	require.False(t, ok)
+
+	// Invalid input
+	_, ok = Validate(secret, "", now)
+	require.False(t, ok)
+
+	_, ok = Validate(secret, code+"0", now)
+	require.False(t, ok)
+
+	_, ok = Validate("invalid secret!", code, now)
+	require.False(t, ok)
+
+	// Secrets are case insensitive
+	lowerCaseCode, err := GenerateCode(rfcSecret, time.Unix(59, 0))
+	require.NoError(t, err)
+
+	_, ok = Validate("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", lowerCaseCode, time.Unix(59, 0))
+	require.True(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("JBSWY3DPEHPK3PXP", "BookRESTAPI", "john@doe.com"))
+	require.NoError(t, err)
+
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/BookRESTAPI:john@doe.com", uri.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	require.Equal(t, "BookRESTAPI", uri.Query().Get("issuer"))
+	require.Equal(t, "6", uri.Query().Get("digits"))
+	require.Equal(t, "30", uri.Query().Get("period"))
+}